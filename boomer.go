@@ -1,11 +1,14 @@
 package boomer
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
 	"os"
 	"os/signal"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -29,9 +32,14 @@ const (
 
 // A Boomer is used to run tasks.
 // This type is exposed, so users can create and control a Boomer instance programmatically.
+// NewBoomer creates a Boomer connecting to a locust master, NewStandaloneBoomer creates one
+// running without master, the mode can be changed by SetMode. After it's configured by the
+// SetXXX and EnableXXX methods and AddOutput, Run starts the test, and Quit stops it.
+// In standalone mode, Run blocks until Quit is called.
 type Boomer struct {
 	masterHost string
 	masterPort int
+	nodeID     string
 
 	hatchType   string
 	mode        Mode
@@ -41,6 +49,56 @@ type Boomer struct {
 	localRunner *localRunner
 	hatchCount  int
 	hatchRate   int
+	stopTimeout time.Duration
+	runTime     time.Duration
+	maxRequests int64
+	minWait     time.Duration
+	maxWait     time.Duration
+	warmup      time.Duration
+	failureRate float64
+
+	masterTimeout   time.Duration
+	connectRetries  int
+	failoverMasters []masterAddr
+	transport       string
+	curve           *curveOptions
+	bindAddr        string
+	sendBufferSize  int
+	sendPolicy      string
+	drainTimeout    time.Duration
+
+	metadata          map[string]interface{}
+	heartbeatMetadata bool
+	heartbeatJitter   time.Duration
+
+	cpuWarningThreshold float64
+
+	userCountMultiplier int
+	userCountOverride   int
+
+	maxLabelSets int
+
+	errorClassifier ErrorClassifier
+
+	sla *SLA
+
+	hatchCompleteFunc func(count int)
+
+	hatchSetupFunc    func() (interface{}, error)
+	hatchTeardownFunc func(shared interface{})
+
+	messageHandlers map[string]func(data map[string]interface{})
+
+	hatchInterval time.Duration
+	stepSize      int
+	stepDuration  time.Duration
+	spikeCount    int
+	spikeDuration time.Duration
+
+	randSeed       int64
+	taskSelection  string
+	singleUser     bool
+	weightSchedule WeightSchedule
 
 	cpuProfile         string
 	cpuProfileDuration time.Duration
@@ -48,7 +106,12 @@ type Boomer struct {
 	memoryProfile         string
 	memoryProfileDuration time.Duration
 
-	outputs []Output
+	outputs              []Output
+	disableConsoleOutput bool
+
+	disabledTasks map[string]bool
+
+	percentageWeights bool
 }
 
 // NewBoomer returns a new Boomer.
@@ -77,17 +140,362 @@ func (b *Boomer) SetRateLimiter(rateLimiter RateLimiter) {
 	b.rateLimiter = rateLimiter
 }
 
-// SetHatchType only accepts "asap" or "smooth".
+// EnableRateLimiter limits the RPS like the -max-rps and -request-increase-rate flags, with the
+// built-in rate limiters. The max RPS grows by requestIncreaseRate, like "10/1s", until maxRPS,
+// "-1" means no ramp-up, maxRPS <= 0 means no limit but the ramp-up.
+// It must be called before the test is started.
+func (b *Boomer) EnableRateLimiter(maxRPS int64, requestIncreaseRate string) error {
+	if maxRPS <= 0 && requestIncreaseRate == "-1" {
+		err := errors.New("either the max RPS or the request increase rate is required to limit the RPS")
+		logger.Errorf("Failed to enable the rate limiter, %v", err)
+		return err
+	}
+	rateLimiter, err := createRateLimiter(maxRPS, requestIncreaseRate)
+	if err != nil {
+		logger.Errorf("Failed to enable the rate limiter, %v", err)
+		return err
+	}
+	b.rateLimiter = rateLimiter
+	return nil
+}
+
+// SetHatchType only accepts "asap", "smooth", "step" or "spike".
 // "asap" means spawning goroutines as soon as possible when the test is started.
 // "smooth" means a constant pace.
+// "step" means spawning a batch of goroutines at a time, see SetStepHatch.
+// "spike" means spawning all the goroutines at once with some extra ones for a while, see SetSpikeHatch.
 func (b *Boomer) SetHatchType(hatchType string) {
-	if hatchType != "asap" && hatchType != "smooth" {
-		log.Printf("Wrong hatch-type, expected asap or smooth, was %s\n", hatchType)
+	if hatchType != "asap" && hatchType != "smooth" && hatchType != "step" && hatchType != "spike" {
+		logger.Errorf("Wrong hatch-type, expected asap, smooth, step or spike, was %s", hatchType)
 		return
 	}
 	b.hatchType = hatchType
 }
 
+// SetHatchInterval makes the asap and smooth hatch types spawn one goroutine every interval,
+// instead of hatch rate goroutines per second, so slow ramp-ups like one goroutine every 2 seconds are possible.
+// It overrides the hatch rate, 0 means using the hatch rate.
+func (b *Boomer) SetHatchInterval(interval time.Duration) {
+	b.hatchInterval = interval
+}
+
+// SetStepHatch sets the hatch type to "step", which spawns stepSize goroutines, waits stepDuration and repeats.
+// If stepSize is 0, the hatch rate is used. If stepDuration is 0, 1 second is used.
+func (b *Boomer) SetStepHatch(stepSize int, stepDuration time.Duration) {
+	b.hatchType = "step"
+	b.stepSize = stepSize
+	b.stepDuration = stepDuration
+}
+
+// SetSpikeHatch sets the hatch type to "spike", which spawns all the goroutines at once,
+// plus spikeCount extra goroutines which quit after spikeDuration.
+func (b *Boomer) SetSpikeHatch(spikeCount int, spikeDuration time.Duration) {
+	b.hatchType = "spike"
+	b.spikeCount = spikeCount
+	b.spikeDuration = spikeDuration
+}
+
+// SetStopTimeout makes the runner wait at most timeout for the running tasks to return when it stops.
+// By default, the runner doesn't wait.
+// It must be called before the test is started.
+func (b *Boomer) SetStopTimeout(timeout time.Duration) {
+	b.stopTimeout = timeout
+}
+
+// SetRunTime makes the test stop and quit after runTime since the test is started.
+// In distributed mode, a new hatch message from master resets the timer.
+// It must be called before the test is started.
+func (b *Boomer) SetRunTime(runTime time.Duration) {
+	b.runTime = runTime
+}
+
+// SetMaxRequests makes the test stop and quit after maxRequests task executions in total.
+// In distributed mode, a new hatch message from master resets the counter.
+// It must be called before the test is started.
+func (b *Boomer) SetMaxRequests(maxRequests int64) {
+	b.maxRequests = maxRequests
+}
+
+// SetWaitTime makes every goroutine sleep a random duration between minWait and maxWait
+// after each task execution, which simulates the think time of real users.
+// It must be called before the test is started.
+func (b *Boomer) SetWaitTime(minWait, maxWait time.Duration) {
+	b.minWait = minWait
+	b.maxWait = maxWait
+}
+
+// SetWarmup makes the requests recorded in warmup since every hatch not counted in the stats,
+// so the first report isn't skewed by cold caches and connection setup.
+// It must be called before the test is started.
+func (b *Boomer) SetWarmup(warmup time.Duration) {
+	if warmup < 0 {
+		logger.Errorf("Wrong warmup, expected 0 or a positive duration, was %v", warmup)
+		return
+	}
+	b.warmup = warmup
+}
+
+// SetFailureRate makes boomer record the fraction rate of the successful requests as failures
+// in the "injected" category, without changing the tasks, to validate the alerting end to end.
+// It must be called before the test is started.
+func (b *Boomer) SetFailureRate(rate float64) {
+	if rate < 0 || rate > 1 {
+		logger.Errorf("Wrong failure rate, expected a fraction between 0 and 1, was %v", rate)
+		return
+	}
+	b.failureRate = rate
+}
+
+// SetMasterTimeout makes boomer reconnect to master if no message is received from master
+// in timeout, which only works with the versions of locust sending heartbeats to slaves.
+// The default timeout is 0, which means boomer never reconnects.
+func (b *Boomer) SetMasterTimeout(timeout time.Duration) {
+	b.masterTimeout = timeout
+}
+
+// SetNodeID overrides the ID of the slave, which is generated like "hostname_uuid" by default.
+// It identifies the slave in master and outputs, so it should be unique, like the name of a pod.
+// It's ignored after the test is started.
+func (b *Boomer) SetNodeID(nodeID string) {
+	b.nodeID = nodeID
+}
+
+// NodeID returns the ID of the slave, which is generated on the first call if SetNodeID is not called.
+func (b *Boomer) NodeID() string {
+	if b.nodeID == "" {
+		b.nodeID = getNodeID()
+	}
+	return b.nodeID
+}
+
+// SetFailoverMasters adds masters, like "10.0.0.2:5557", which boomer fails over to in order if the master
+// given to NewBoomer is unreachable, or lost when SetMasterTimeout is set. Boomer registers to the new master
+// with a client_ready message. Invalid addresses are ignored.
+func (b *Boomer) SetFailoverMasters(masters ...string) {
+	b.failoverMasters = nil
+	for _, master := range masters {
+		addr, err := parseMasterAddr(master)
+		if err != nil {
+			logger.Errorf("Wrong address of master, expected host:port, was %s, %v", master, err)
+			continue
+		}
+		b.failoverMasters = append(b.failoverMasters, addr)
+	}
+}
+
+// SetConnectRetries makes boomer retry connecting to master with backoff if it fails when the test is started,
+// so boomer can be started before master. Run blocks while retrying, a negative retries means retrying
+// until connected or Quit is called. The default is 0, which means boomer gives up after the first failure.
+func (b *Boomer) SetConnectRetries(retries int) {
+	b.connectRetries = retries
+}
+
+// SetTransport chooses how to talk to master, "zmq" is the default, "tcp" sends the same messages
+// prefixed with their length over a plain TCP socket, which doesn't need libzmq but needs a master
+// speaking the same framing.
+func (b *Boomer) SetTransport(transport string) {
+	if transport != transportZMQ && transport != transportTCP {
+		logger.Errorf("Wrong transport, expected zmq or tcp, was %s", transport)
+		return
+	}
+	b.transport = transport
+}
+
+// SetCurveKeys enables CurveZMQ encryption for the connection to master, it's only supported
+// when boomer is built with goczmq. All the keys are Z85-encoded, serverKey is the public key of master.
+func (b *Boomer) SetCurveKeys(serverKey, publicKey, secretKey string) {
+	b.curve = &curveOptions{
+		serverKey: serverKey,
+		publicKey: publicKey,
+		secretKey: secretKey,
+	}
+}
+
+// SetBindAddress makes boomer connect to master from a local IP address, or the first address of
+// a network interface, like "eth1", which is useful on a multi-homed box. Connecting to master fails
+// if the address isn't assigned to any local interface. The OS chooses it by default.
+func (b *Boomer) SetBindAddress(bindAddr string) {
+	b.bindAddr = bindAddr
+}
+
+// SetSendBuffer sets the capacity of the channel of messages to master, 0 keeps the default of 100,
+// and the policy when it's full, which only accepts "block", "drop-oldest" or "drop-newest".
+// "block" is the default, the reporting goroutine waits until there is room for the stats message.
+// With "drop-oldest" or "drop-newest", up to size stats messages wait in a separate queue for room in the
+// channel, "drop-oldest" drops the oldest one in the queue if it's full, "drop-newest" drops the new one.
+// Other messages, like hatch_complete and client_ready, are never dropped.
+func (b *Boomer) SetSendBuffer(size int, policy string) {
+	if size < 0 {
+		logger.Errorf("Wrong send buffer size, expected 0 or a positive number, was %d", size)
+		return
+	}
+	if policy != "block" && policy != "drop-oldest" && policy != "drop-newest" {
+		logger.Errorf("Wrong send policy, expected block, drop-oldest or drop-newest, was %s", policy)
+		return
+	}
+	b.sendBufferSize = size
+	b.sendPolicy = policy
+}
+
+// SetDrainTimeout makes boomer wait up to timeout for the queued messages, like the last stats,
+// to be sent to master when it's closed, rather than dropping them. It doesn't wait by default.
+func (b *Boomer) SetDrainTimeout(timeout time.Duration) {
+	if timeout < 0 {
+		logger.Errorf("Wrong drain timeout, expected 0 or a positive duration, was %v", timeout)
+		return
+	}
+	b.drainTimeout = timeout
+}
+
+// SetMetadata attaches static metadata to the slave, like hostname, version and tags, which
+// is sent to master in the client_ready message, and in every heartbeat if withHeartbeat is true.
+func (b *Boomer) SetMetadata(metadata map[string]interface{}, withHeartbeat bool) {
+	b.metadata = make(map[string]interface{}, len(metadata))
+	for k, v := range metadata {
+		b.metadata[k] = v
+	}
+	b.heartbeatMetadata = withHeartbeat
+}
+
+// SetHeartbeatJitter shifts every heartbeat to master randomly by up to jitter, so the heartbeats
+// of many slaves started at the same time don't hit the master at once. The average interval is
+// still 1 second, jitter must be less than it.
+func (b *Boomer) SetHeartbeatJitter(jitter time.Duration) {
+	if jitter < 0 || jitter >= heartbeatInterval {
+		logger.Errorf("Wrong heartbeat jitter, expected 0 to %v, was %v", heartbeatInterval, jitter)
+		return
+	}
+	b.heartbeatJitter = jitter
+}
+
+// SetCPUWarningThreshold makes boomer publish a "boomer:cpu_warning" event with the cpu usage,
+// and report the cpu usage to master with stats, when the cpu usage of the slave exceeds threshold
+// in percentage, like 90. It's disabled by default.
+func (b *Boomer) SetCPUWarningThreshold(threshold float64) {
+	b.cpuWarningThreshold = threshold
+}
+
+// SetHatchSetupFunc sets up the resources shared by all the goroutines, like a pooled http client, rather
+// than every goroutine creating its own. setup is called once per hatch before any goroutine is spawned, the
+// tasks get its result by SharedFromContext in Task.FnWithContext, or by Boomer.Shared. If setup returns an
+// error, no goroutines are spawned. teardown is optional, it's called with the result of setup once all the
+// goroutines of the hatch have returned.
+func (b *Boomer) SetHatchSetupFunc(setup func() (interface{}, error), teardown func(shared interface{})) {
+	b.hatchSetupFunc = setup
+	b.hatchTeardownFunc = teardown
+}
+
+// Shared returns the result of the setup function of SetHatchSetupFunc for the latest hatch,
+// or nil if the test is not started.
+func (b *Boomer) Shared() interface{} {
+	r := b.getRunner()
+	if r == nil {
+		return nil
+	}
+	return r.shared.get()
+}
+
+// SetUserCountMultiplier makes boomer report the number of goroutines times multiplier as the user count
+// in the stats and hatch_complete messages, when each goroutine represents multiple virtual users.
+func (b *Boomer) SetUserCountMultiplier(multiplier int) {
+	if multiplier < 1 {
+		logger.Errorf("Wrong user count multiplier, expected a positive number, was %d", multiplier)
+		return
+	}
+	b.userCountMultiplier = multiplier
+}
+
+// SetUserCountOverride makes boomer report count as the user count in the stats and hatch_complete messages,
+// whatever the number of goroutines is. It takes precedence over SetUserCountMultiplier, 0 disables it.
+func (b *Boomer) SetUserCountOverride(count int) {
+	if count < 0 {
+		logger.Errorf("Wrong user count override, expected 0 or a positive number, was %d", count)
+		return
+	}
+	b.userCountOverride = count
+}
+
+// SetMaxLabelSets limits the number of the unique label sets passed to RecordSuccessWithLabels and
+// RecordFailureWithLabels, 100 by default. Once it's reached, the requests with a new label set are
+// aggregated as if they have no labels, to bound the memory and the cardinality of the outputs.
+func (b *Boomer) SetMaxLabelSets(max int) {
+	if max <= 0 {
+		logger.Errorf("Wrong max label sets, expected a positive number, was %d", max)
+		return
+	}
+	b.maxLabelSets = max
+}
+
+// SetHatchCompleteFunc sets a callback in standalone mode, which is called with the number of users
+// once all of them are spawned, so timed assertions can start after the ramp-up. In distributed mode,
+// subscribe to the "boomer:spawn_complete" event instead.
+func (b *Boomer) SetHatchCompleteFunc(fn func(count int)) {
+	b.hatchCompleteFunc = fn
+}
+
+// SetSLA checks every report against sla, and publishes a "boomer:sla_violation" event
+// with a SLAViolation for each breached threshold. See SLA.FailOnViolation for failing the process.
+func (b *Boomer) SetSLA(sla *SLA) {
+	b.sla = sla
+}
+
+// ExitCode returns 1 if the SLA with FailOnViolation is violated since the test is started, otherwise 0.
+func (b *Boomer) ExitCode() int {
+	if r := b.getRunner(); r != nil && atomic.LoadInt32(&r.slaViolated) == 1 {
+		return 1
+	}
+	return 0
+}
+
+// SetErrorClassifier replaces DefaultErrorClassifier used by RecordError.
+// The classifier is called by multiple goroutines, so it must be safe for concurrent use.
+func (b *Boomer) SetErrorClassifier(classifier ErrorClassifier) {
+	b.errorClassifier = classifier
+}
+
+// SetRandSeed makes the task selection of goroutines reproducible, every goroutine
+// is seeded by seed plus its sequence number in the hatch. By default, a random seed is used.
+func (b *Boomer) SetRandSeed(seed int64) {
+	b.randSeed = seed
+}
+
+// SetTaskSelection only accepts "random" or "weighted-round-robin".
+// "random" is the default, which picks tasks randomly by their weights, so the task mix varies a lot
+// when there are only a few goroutines.
+// "weighted-round-robin" picks tasks in a deterministic order shared by all the goroutines, in every
+// cycle of the sum of weights picks, each task is picked exactly its weight times.
+func (b *Boomer) SetTaskSelection(selection string) {
+	if selection != "random" && selection != "weighted-round-robin" {
+		logger.Errorf("Wrong task selection, expected random or weighted-round-robin, was %s", selection)
+		return
+	}
+	b.taskSelection = selection
+}
+
+// SingleUser makes boomer spawn only 1 goroutine, which picks the tasks one at a time in the
+// deterministic order of "weighted-round-robin", whatever the spawn count and task selection are.
+// It's a debugging aid to make the logs of tasks readable, not for load testing.
+func (b *Boomer) SingleUser() {
+	b.singleUser = true
+}
+
+// SetWeightSchedule swaps the weights of the tasks by the time of day while running, see WeightSchedule.
+// An invalid schedule, like a window ending after 24h, is ignored.
+func (b *Boomer) SetWeightSchedule(schedule WeightSchedule) {
+	if err := schedule.validate(); err != nil {
+		logger.Errorf("Wrong weight schedule, %v", err)
+		return
+	}
+	b.weightSchedule = schedule
+}
+
+// SetPercentageWeights makes the weights of tasks percentages, Run and DryRun return an error
+// if they don't sum to 100, so the mix of tasks is self-documenting. Dynamic weights of
+// Task.WeightFn are not allowed then. The weights are arbitrary numbers by default.
+func (b *Boomer) SetPercentageWeights(enabled bool) {
+	b.percentageWeights = enabled
+}
+
 // SetMode only accepts boomer.DistributedMode and boomer.StandaloneMode.
 func (b *Boomer) SetMode(mode Mode) {
 	switch mode {
@@ -96,13 +504,71 @@ func (b *Boomer) SetMode(mode Mode) {
 	case StandaloneMode:
 		b.mode = StandaloneMode
 	default:
-		log.Println("Invalid mode, ignored!")
+		logger.Errorf("Invalid mode, ignored!")
 	}
 }
 
 // AddOutput accepts outputs which implements the boomer.Output interface.
+// If the test is started, o starts receiving events from the next report.
 func (b *Boomer) AddOutput(o Output) {
 	b.outputs = append(b.outputs, o)
+	if r := b.getRunner(); r != nil {
+		r.addOutput(o)
+	}
+}
+
+// RemoveOutput removes an output added by AddOutput, so it doesn't receive events any more.
+func (b *Boomer) RemoveOutput(o Output) {
+	for i, output := range b.outputs {
+		if output == o {
+			b.outputs = append(b.outputs[:i], b.outputs[i+1:]...)
+			break
+		}
+	}
+	if r := b.getRunner(); r != nil {
+		r.removeOutput(o)
+	}
+}
+
+// ClearOutputs removes all the outputs, including the default ConsoleOutput of standalone mode.
+func (b *Boomer) ClearOutputs() {
+	b.outputs = nil
+	if r := b.getRunner(); r != nil {
+		r.clearOutputs()
+	}
+}
+
+// DisableConsoleOutput stops standalone mode from adding the default ConsoleOutput,
+// so only the outputs added by AddOutput are used. It should be called before the test is started.
+func (b *Boomer) DisableConsoleOutput() {
+	b.disableConsoleOutput = true
+}
+
+// EnableTask makes the tasks named name participate in the task selection again,
+// after they are disabled by DisableTask. It takes effect immediately if the test is running.
+func (b *Boomer) EnableTask(name string) {
+	b.setTaskEnabled(name, true)
+}
+
+// DisableTask stops the goroutines from picking the tasks named name, without hatching again.
+// Running executions of the tasks are not interrupted, and their OnStart and OnStop are still called.
+// If all the tasks are disabled, the goroutines wait until one of them is enabled.
+func (b *Boomer) DisableTask(name string) {
+	b.setTaskEnabled(name, false)
+}
+
+func (b *Boomer) setTaskEnabled(name string, enabled bool) {
+	if enabled {
+		delete(b.disabledTasks, name)
+	} else {
+		if b.disabledTasks == nil {
+			b.disabledTasks = make(map[string]bool)
+		}
+		b.disabledTasks[name] = true
+	}
+	if r := b.getRunner(); r != nil && !r.setTaskEnabled(name, enabled) {
+		logger.Errorf("No task is named %s, ignored!", name)
+	}
 }
 
 // EnableCPUProfile will start cpu profiling after run.
@@ -118,86 +584,284 @@ func (b *Boomer) EnableMemoryProfile(memoryProfile string, duration time.Duratio
 }
 
 // Run accepts a slice of Task and connects to the locust master.
-func (b *Boomer) Run(tasks ...*Task) {
+// It returns an error without starting the test if the tasks are invalid, like an empty task list,
+// a task without Fn or with a negative weight.
+func (b *Boomer) Run(tasks ...*Task) error {
+	if err := validateTasks(tasks); err != nil {
+		logger.Errorf("Failed to run boomer, %v", err)
+		return err
+	}
+	if b.percentageWeights {
+		if err := validatePercentageWeights(tasks); err != nil {
+			logger.Errorf("Failed to run boomer, %v", err)
+			return err
+		}
+	}
+
 	if b.cpuProfile != "" {
 		err := StartCPUProfile(b.cpuProfile, b.cpuProfileDuration)
 		if err != nil {
-			log.Printf("Error starting cpu profiling, %v", err)
+			logger.Errorf("Error starting cpu profiling, %v", err)
 		}
 	}
 	if b.memoryProfile != "" {
 		err := StartMemoryProfile(b.memoryProfile, b.memoryProfileDuration)
 		if err != nil {
-			log.Printf("Error starting memory profiling, %v", err)
+			logger.Errorf("Error starting memory profiling, %v", err)
 		}
 	}
 
 	switch b.mode {
 	case DistributedMode:
-		b.slaveRunner = newSlaveRunner(b.masterHost, b.masterPort, tasks, b.rateLimiter, b.hatchType)
+		masters := append([]masterAddr{{host: b.masterHost, port: b.masterPort}}, b.failoverMasters...)
+		b.slaveRunner = newSlaveRunner(masters, tasks, b.rateLimiter, b.hatchType)
+		b.slaveRunner.nodeID = b.NodeID()
+		b.slaveRunner.hatchInterval = b.hatchInterval
+		b.slaveRunner.stopTimeout = b.stopTimeout
+		b.slaveRunner.sla = b.sla
+		b.slaveRunner.runTime = b.runTime
+		b.slaveRunner.maxRequests = b.maxRequests
+		b.slaveRunner.minWait = b.minWait
+		b.slaveRunner.maxWait = b.maxWait
+		b.slaveRunner.warmup = b.warmup
+		b.slaveRunner.failureRate = b.failureRate
+		b.slaveRunner.userCountMultiplier = b.userCountMultiplier
+		b.slaveRunner.userCountOverride = b.userCountOverride
+		b.slaveRunner.stats.maxLabelSets = b.maxLabelSets
+		b.slaveRunner.hatchSetupFunc = b.hatchSetupFunc
+		b.slaveRunner.hatchTeardownFunc = b.hatchTeardownFunc
+		b.slaveRunner.stepSize = b.stepSize
+		b.slaveRunner.stepDuration = b.stepDuration
+		b.slaveRunner.spikeCount = b.spikeCount
+		b.slaveRunner.spikeDuration = b.spikeDuration
+		b.slaveRunner.randSeed = b.randSeed
+		b.slaveRunner.taskSelection = b.taskSelection
+		b.slaveRunner.singleUser = b.singleUser
+		b.slaveRunner.weightSchedule = b.weightSchedule
+		b.slaveRunner.masterTimeout = b.masterTimeout
+		b.slaveRunner.connectRetries = b.connectRetries
+		b.slaveRunner.transport = b.transport
+		b.slaveRunner.curve = b.curve
+		b.slaveRunner.bindAddr = b.bindAddr
+		b.slaveRunner.sendBufferSize = b.sendBufferSize
+		b.slaveRunner.sendPolicy = b.sendPolicy
+		b.slaveRunner.drainTimeout = b.drainTimeout
+		b.slaveRunner.metadata = b.metadata
+		b.slaveRunner.heartbeatMetadata = b.heartbeatMetadata
+		b.slaveRunner.heartbeatJitter = b.heartbeatJitter
+		b.slaveRunner.cpuWarningThreshold = b.cpuWarningThreshold
+		for messageType, handler := range b.messageHandlers {
+			b.slaveRunner.registerMessageHandler(messageType, handler)
+		}
+		for name := range b.disabledTasks {
+			b.slaveRunner.setTaskEnabled(name, false)
+		}
 		for _, o := range b.outputs {
 			b.slaveRunner.addOutput(o)
 		}
 		b.slaveRunner.run()
 	case StandaloneMode:
 		b.localRunner = newLocalRunner(tasks, b.rateLimiter, b.hatchCount, b.hatchType, b.hatchRate)
+		b.localRunner.hatchInterval = b.hatchInterval
+		b.localRunner.stopTimeout = b.stopTimeout
+		b.localRunner.sla = b.sla
+		b.localRunner.hatchCompleteFunc = b.hatchCompleteFunc
+		b.localRunner.runTime = b.runTime
+		b.localRunner.maxRequests = b.maxRequests
+		b.localRunner.minWait = b.minWait
+		b.localRunner.maxWait = b.maxWait
+		b.localRunner.warmup = b.warmup
+		b.localRunner.failureRate = b.failureRate
+		b.localRunner.userCountMultiplier = b.userCountMultiplier
+		b.localRunner.userCountOverride = b.userCountOverride
+		b.localRunner.stats.maxLabelSets = b.maxLabelSets
+		b.localRunner.hatchSetupFunc = b.hatchSetupFunc
+		b.localRunner.hatchTeardownFunc = b.hatchTeardownFunc
+		b.localRunner.stepSize = b.stepSize
+		b.localRunner.stepDuration = b.stepDuration
+		b.localRunner.spikeCount = b.spikeCount
+		b.localRunner.spikeDuration = b.spikeDuration
+		b.localRunner.randSeed = b.randSeed
+		b.localRunner.taskSelection = b.taskSelection
+		b.localRunner.singleUser = b.singleUser
+		b.localRunner.weightSchedule = b.weightSchedule
+		if b.disableConsoleOutput {
+			b.localRunner.clearOutputs()
+		}
+		for name := range b.disabledTasks {
+			b.localRunner.setTaskEnabled(name, false)
+		}
 		for _, o := range b.outputs {
 			b.localRunner.addOutput(o)
 		}
 		b.localRunner.run()
 	default:
-		log.Println("Invalid mode, expected boomer.DistributedMode or boomer.StandaloneMode")
+		err := errors.New("invalid mode, expected boomer.DistributedMode or boomer.StandaloneMode")
+		logger.Errorf("Failed to run boomer, %v", err)
+		return err
 	}
+	return nil
 }
 
-// RecordSuccess reports a success.
-func (b *Boomer) RecordSuccess(requestType, name string, responseTime int64, responseLength int64) {
-	if b.localRunner == nil && b.slaveRunner == nil {
-		return
+// getRunner returns the runner of current mode, or nil if the test is not started.
+func (b *Boomer) getRunner() *runner {
+	if b == nil {
+		return nil
 	}
 	switch b.mode {
 	case DistributedMode:
-		b.slaveRunner.stats.requestSuccessChan <- &requestSuccess{
-			requestType:    requestType,
-			name:           name,
-			responseTime:   responseTime,
-			responseLength: responseLength,
+		if b.slaveRunner != nil {
+			return &b.slaveRunner.runner
 		}
 	case StandaloneMode:
-		b.localRunner.stats.requestSuccessChan <- &requestSuccess{
-			requestType:    requestType,
-			name:           name,
-			responseTime:   responseTime,
-			responseLength: responseLength,
+		if b.localRunner != nil {
+			return &b.localRunner.runner
 		}
 	}
+	return nil
 }
 
-// RecordFailure reports a failure.
+// RecordSuccess reports a success. requestType is the method or protocol of the request, like GET or tcp,
+// the stats are grouped by both requestType and name, so GET /foo and POST /foo are reported separately.
+// It's safe to be called by multiple goroutines, and it's a no-op if the test is not started.
+func (b *Boomer) RecordSuccess(requestType, name string, responseTime int64, responseLength int64) {
+	r := b.getRunner()
+	if r == nil {
+		return
+	}
+	r.recordSuccess(requestType, name, responseTime, responseLength)
+}
+
+// RecordFailure reports a failure, which is grouped by requestType and name like RecordSuccess.
+// It's safe to be called by multiple goroutines, and it's a no-op if the test is not started.
 func (b *Boomer) RecordFailure(requestType, name string, responseTime int64, exception string) {
-	if b.localRunner == nil && b.slaveRunner == nil {
+	r := b.getRunner()
+	if r == nil {
 		return
 	}
-	switch b.mode {
-	case DistributedMode:
-		b.slaveRunner.stats.requestFailureChan <- &requestFailure{
-			requestType:  requestType,
-			name:         name,
-			responseTime: responseTime,
-			error:        exception,
-		}
-	case StandaloneMode:
-		b.localRunner.stats.requestFailureChan <- &requestFailure{
-			requestType:  requestType,
-			name:         name,
-			responseTime: responseTime,
-			error:        exception,
-		}
+	r.recordFailure(requestType, name, responseTime, exception, "")
+}
+
+// RecordSuccessWithLabels is like RecordSuccess, but the success is aggregated by labels too, like
+// {"tenant": "foo"}, so the requests of different label sets are reported separately. The labels
+// are reported in the "labels" of the stats, see SetMaxLabelSets for the limit of the label sets.
+func (b *Boomer) RecordSuccessWithLabels(requestType, name string, responseTime int64, responseLength int64, labels map[string]string) {
+	r := b.getRunner()
+	if r == nil {
+		return
+	}
+	r.recordSuccessWithLabels(requestType, name, responseTime, responseLength, labels)
+}
+
+// RecordFailureWithLabels is like RecordFailure, but the failure is aggregated by labels too.
+func (b *Boomer) RecordFailureWithLabels(requestType, name string, responseTime int64, exception string, labels map[string]string) {
+	r := b.getRunner()
+	if r == nil {
+		return
+	}
+	r.recordFailureWithLabels(requestType, name, responseTime, exception, "", labels)
+}
+
+// RecordError reports a failure caused by err, which is classified by the ErrorClassifier,
+// DefaultErrorClassifier by default. The category is reported to master along with the error.
+// It's safe to be called by multiple goroutines, and it's a no-op if the test is not started.
+func (b *Boomer) RecordError(requestType, name string, responseTime int64, err error) {
+	r := b.getRunner()
+	if r == nil || err == nil {
+		return
+	}
+	classify := b.errorClassifier
+	if classify == nil {
+		classify = DefaultErrorClassifier
+	}
+	r.recordFailure(requestType, name, responseTime, err.Error(), classify(err))
+}
+
+// SendCustomMessage sends a message of messageType with data to master, which can be handled
+// by a custom event listener of locust. It's safe to be called in tasks, and does nothing in standalone mode.
+func (b *Boomer) SendCustomMessage(messageType string, data map[string]interface{}) {
+	if b.mode != DistributedMode || b.slaveRunner == nil {
+		return
 	}
+	b.slaveRunner.sendCustomMessage(messageType, data)
+}
+
+// RegisterMessageHandler registers a handler for the custom messages of messageType sent by master.
+// Handlers run in separated goroutines, and can't override the built-in messages like hatch, stop and quit.
+// It should be called before the test is started.
+func (b *Boomer) RegisterMessageHandler(messageType string, handler func(data map[string]interface{})) {
+	if b.messageHandlers == nil {
+		b.messageHandlers = make(map[string]func(data map[string]interface{}))
+	}
+	b.messageHandlers[messageType] = handler
+	if b.slaveRunner != nil {
+		b.slaveRunner.registerMessageHandler(messageType, handler)
+	}
+}
+
+// State returns the current state of the runner, which is one of
+// "ready", "hatching", "running", "paused", "stopped" and "quitting".
+// It returns "ready" if the test is not started yet.
+func (b *Boomer) State() string {
+	r := b.getRunner()
+	if r == nil {
+		return stateInit
+	}
+	return r.getState()
+}
+
+// Stats returns a snapshot of the aggregated stats of all the requests, which doesn't disturb
+// the reports to master or outputs. It's safe to be called by multiple goroutines,
+// and it returns an empty snapshot if the test is not started.
+func (b *Boomer) Stats() StatsSnapshot {
+	r := b.getRunner()
+	if r == nil {
+		return StatsSnapshot{}
+	}
+	return r.stats.getSnapshot()
+}
+
+// Pause makes all the goroutines block between task executions, without stopping them.
+// It returns false if the test is not hatching or running.
+func (b *Boomer) Pause() bool {
+	r := b.getRunner()
+	if r == nil {
+		return false
+	}
+	return r.pause()
+}
+
+// Resume continues the test paused by Pause, with the same goroutines.
+// It returns false if the test is not paused.
+func (b *Boomer) Resume() bool {
+	r := b.getRunner()
+	if r == nil {
+		return false
+	}
+	return r.resume()
+}
+
+// RampDown stops the goroutines one by one at the rate of rate goroutines per second,
+// rather than all at once, and then stops the test. It blocks until all the goroutines quit.
+func (b *Boomer) RampDown(rate int) {
+	r := b.getRunner()
+	if r == nil {
+		return
+	}
+	r.stopHatching(rate)
 }
 
 // Quit will send a quit message to the master.
 func (b *Boomer) Quit() {
+	b.QuitWithReason(StopReasonNormal)
+}
+
+// QuitWithReason is like Quit, but the quit message carries reason, like "sla violated",
+// so master can tell why the slave quits.
+func (b *Boomer) QuitWithReason(reason string) {
+	if r := b.getRunner(); r != nil {
+		r.setStopReason(reason)
+	}
 	Events.Publish("boomer:quit")
 	var ticker = time.NewTicker(3 * time.Second)
 
@@ -205,10 +869,10 @@ func (b *Boomer) Quit() {
 	case DistributedMode:
 		// wait for quit message is sent to master
 		select {
-		case <-b.slaveRunner.client.disconnectedChannel():
+		case <-b.slaveRunner.getClient().disconnectedChannel():
 			break
 		case <-ticker.C:
-			log.Println("Timeout waiting for sending quit message to master, boomer will quit any way.")
+			logger.Infof("Timeout waiting for sending quit message to master, boomer will quit any way.")
 			break
 		}
 		b.slaveRunner.close()
@@ -217,6 +881,41 @@ func (b *Boomer) Quit() {
 	}
 }
 
+// Quit sends a quit message with reason to master, and stops boomer.
+// It's a convenience function to use the defaultBoomer.
+func Quit(reason string) {
+	defaultBoomer.QuitWithReason(reason)
+}
+
+// RecordSuccessWithLabels reports a success aggregated by labels.
+// It's a convenience function to use the defaultBoomer.
+func RecordSuccessWithLabels(requestType, name string, responseTime int64, responseLength int64, labels map[string]string) {
+	defaultBoomer.RecordSuccessWithLabels(requestType, name, responseTime, responseLength, labels)
+}
+
+// RecordFailureWithLabels reports a failure aggregated by labels.
+// It's a convenience function to use the defaultBoomer.
+func RecordFailureWithLabels(requestType, name string, responseTime int64, exception string, labels map[string]string) {
+	defaultBoomer.RecordFailureWithLabels(requestType, name, responseTime, exception, labels)
+}
+
+// DryRun runs every task exactly once in current goroutine, with its OnStart and OnStop hooks,
+// and validates the weights, without connecting to master or generating any load.
+// It returns an error listing all the problems found, like panics and invalid weights, or nil if there is none.
+func (b *Boomer) DryRun(tasks ...*Task) error {
+	r := &runner{tasks: tasks}
+	problems := r.dryRun()
+	if b != nil && b.percentageWeights {
+		if err := validatePercentageWeights(tasks); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("dry run failed, %s", strings.Join(problems, "; "))
+}
+
 // Run tasks without connecting to the master.
 func runTasksForTest(tasks ...*Task) {
 	taskNames := strings.Split(runTasks, ",")
@@ -226,8 +925,8 @@ func runTasksForTest(tasks ...*Task) {
 		} else {
 			for _, name := range taskNames {
 				if name == task.Name {
-					log.Println("Running " + task.Name)
-					task.Fn()
+					logger.Infof("Running %s", task.Name)
+					task.run(context.Background())
 				}
 			}
 		}
@@ -237,6 +936,8 @@ func runTasksForTest(tasks ...*Task) {
 // Run accepts a slice of Task and connects to a locust master.
 // It's a convenience function to use the defaultBoomer.
 func Run(tasks ...*Task) {
+	// captured once, defaultBoomer may be replaced while it is running, like in tests
+	b := defaultBoomer
 	if !flag.Parsed() {
 		flag.Parse()
 	}
@@ -246,20 +947,28 @@ func Run(tasks ...*Task) {
 		return
 	}
 
+	SetVerbose(verboseLog)
 	initLegacyEventHandlers()
 
 	rateLimiter, err := createRateLimiter(maxRPS, requestIncreaseRate)
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		logger.Errorf("%v", err)
+		os.Exit(1)
 	}
-	defaultBoomer.SetRateLimiter(rateLimiter)
-	defaultBoomer.masterHost = masterHost
-	defaultBoomer.masterPort = masterPort
-	defaultBoomer.hatchType = hatchType
-	defaultBoomer.EnableMemoryProfile(memoryProfile, memoryProfileDuration)
-	defaultBoomer.EnableCPUProfile(cpuProfile, cpuProfileDuration)
+	b.SetRateLimiter(rateLimiter)
+	b.masterHost = masterHost
+	b.masterPort = masterPort
+	b.hatchType = hatchType
+	b.stepSize = stepSize
+	b.stepDuration = stepDuration
+	b.spikeCount = spikeCount
+	b.spikeDuration = spikeDuration
+	b.EnableMemoryProfile(memoryProfile, memoryProfileDuration)
+	b.EnableCPUProfile(cpuProfile, cpuProfileDuration)
 
-	defaultBoomer.Run(tasks...)
+	if err = b.Run(tasks...); err != nil {
+		os.Exit(1)
+	}
 
 	quitByMe := false
 	quitChan := make(chan bool)
@@ -270,17 +979,20 @@ func Run(tasks ...*Task) {
 		}
 	})
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
 	select {
 	case <-c:
 		quitByMe = true
-		defaultBoomer.Quit()
+		b.QuitWithReason(StopReasonSignal)
 	case <-quitChan:
 	}
 
-	log.Println("shut down")
+	logger.Infof("shut down")
+	if code := b.ExitCode(); code != 0 {
+		os.Exit(code)
+	}
 }
 
 // RecordSuccess reports a success.
@@ -294,3 +1006,51 @@ func RecordSuccess(requestType, name string, responseTime int64, responseLength
 func RecordFailure(requestType, name string, responseTime int64, exception string) {
 	defaultBoomer.RecordFailure(requestType, name, responseTime, exception)
 }
+
+// DryRun runs every task exactly once and validates the weights.
+// It's a convenience function to use the defaultBoomer.
+func DryRun(tasks ...*Task) error {
+	return defaultBoomer.DryRun(tasks...)
+}
+
+// EnableTask makes the tasks named name participate in the task selection again.
+// It's a convenience function to use the defaultBoomer.
+func EnableTask(name string) {
+	defaultBoomer.EnableTask(name)
+}
+
+// DisableTask stops the goroutines from picking the tasks named name.
+// It's a convenience function to use the defaultBoomer.
+func DisableTask(name string) {
+	defaultBoomer.DisableTask(name)
+}
+
+// ExitCode returns 1 if the SLA with FailOnViolation is violated, otherwise 0.
+// It's a convenience function to use the defaultBoomer.
+func ExitCode() int {
+	return defaultBoomer.ExitCode()
+}
+
+// NodeID returns the ID of the slave.
+// It's a convenience function to use the defaultBoomer.
+func NodeID() string {
+	return defaultBoomer.NodeID()
+}
+
+// RecordError reports a failure caused by err.
+// It's a convenience function to use the defaultBoomer.
+func RecordError(requestType, name string, responseTime int64, err error) {
+	defaultBoomer.RecordError(requestType, name, responseTime, err)
+}
+
+// SendCustomMessage sends a message of messageType with data to master.
+// It's a convenience function to use the defaultBoomer.
+func SendCustomMessage(messageType string, data map[string]interface{}) {
+	defaultBoomer.SendCustomMessage(messageType, data)
+}
+
+// RegisterMessageHandler registers a handler for the custom messages of messageType sent by master.
+// It's a convenience function to use the defaultBoomer.
+func RegisterMessageHandler(messageType string, handler func(data map[string]interface{})) {
+	defaultBoomer.RegisterMessageHandler(messageType, handler)
+}
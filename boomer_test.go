@@ -1,13 +1,17 @@
 package boomer
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"math"
 	"math/rand"
+	"net"
 	"os"
 	"runtime"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -81,6 +85,357 @@ func TestSetHatchType(t *testing.T) {
 	if b.hatchType != "smooth" {
 		t.Error("hatchType should be changed to \"smooth\"")
 	}
+
+	b.SetHatchType("step")
+
+	if b.hatchType != "step" {
+		t.Error("hatchType should be changed to \"step\"")
+	}
+}
+
+func TestSetStepHatch(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.SetStepHatch(10, time.Second)
+
+	if b.hatchType != "step" || b.stepSize != 10 || b.stepDuration != time.Second {
+		t.Error("Failed to set step hatch")
+	}
+}
+
+func TestSetSpikeHatch(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.SetSpikeHatch(10, time.Second)
+
+	if b.hatchType != "spike" || b.spikeCount != 10 || b.spikeDuration != time.Second {
+		t.Error("Failed to set spike hatch")
+	}
+}
+
+func TestSetStopTimeout(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	b.SetStopTimeout(time.Second)
+
+	if b.stopTimeout != time.Second {
+		t.Error("stopTimeout should be 1 second")
+	}
+}
+
+func TestSetRunTime(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	b.SetRunTime(time.Minute)
+
+	if b.runTime != time.Minute {
+		t.Error("runTime should be 1 minute")
+	}
+}
+
+func TestSetMaxRequests(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	b.SetMaxRequests(1000)
+
+	if b.maxRequests != 1000 {
+		t.Error("maxRequests should be 1000")
+	}
+}
+
+func TestSetWaitTime(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	b.SetWaitTime(time.Second, 2*time.Second)
+
+	if b.minWait != time.Second {
+		t.Error("minWait should be 1s")
+	}
+	if b.maxWait != 2*time.Second {
+		t.Error("maxWait should be 2s")
+	}
+}
+
+func TestSetWarmup(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	b.SetWarmup(time.Second)
+	if b.warmup != time.Second {
+		t.Error("warmup should be 1s")
+	}
+
+	b.SetWarmup(-time.Second)
+	if b.warmup != time.Second {
+		t.Error("warmup should not be changed to a negative one, got", b.warmup)
+	}
+}
+
+func TestSetFailureRate(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	b.SetFailureRate(0.1)
+	if b.failureRate != 0.1 {
+		t.Error("failureRate should be 0.1")
+	}
+
+	b.SetFailureRate(1.5)
+	if b.failureRate != 0.1 {
+		t.Error("failureRate should not be changed to one greater than 1, got", b.failureRate)
+	}
+}
+
+func TestSetRandSeed(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	b.SetRandSeed(42)
+
+	if b.randSeed != 42 {
+		t.Error("randSeed should be 42")
+	}
+}
+
+func TestSetTaskSelection(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	b.SetTaskSelection("weighted-round-robin")
+	if b.taskSelection != "weighted-round-robin" {
+		t.Error("taskSelection should be weighted-round-robin")
+	}
+
+	b.SetTaskSelection("unexpected")
+	if b.taskSelection != "weighted-round-robin" {
+		t.Error("taskSelection should not be changed to an invalid one")
+	}
+}
+
+func TestSetSingleUser(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	b.SingleUser()
+	if !b.singleUser {
+		t.Error("singleUser should be true")
+	}
+}
+
+func TestSetUserCountMultiplierAndOverride(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.SetUserCountMultiplier(10)
+	b.SetUserCountMultiplier(0)
+	if b.userCountMultiplier != 10 {
+		t.Error("userCountMultiplier should be 10, got", b.userCountMultiplier)
+	}
+
+	b.SetUserCountOverride(100)
+	b.SetUserCountOverride(-1)
+	if b.userCountOverride != 100 {
+		t.Error("userCountOverride should be 100, got", b.userCountOverride)
+	}
+}
+
+func TestQuitWithReason(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.slaveRunner = newSlaveRunner([]masterAddr{{"127.0.0.1", 5557}}, nil, nil, "asap")
+	c := newFakeClient()
+	// don't wait for the disconnection
+	close(c.disconnected)
+	b.slaveRunner.client = c
+	Events.Subscribe("boomer:quit", b.slaveRunner.onQuiting)
+	defer Events.Unsubscribe("boomer:quit", b.slaveRunner.onQuiting)
+
+	b.QuitWithReason("sla violated")
+
+	select {
+	case msg := <-c.toMaster:
+		if msg.Type != "quit" {
+			t.Fatal("Boomer should send a quit message, got", msg.Type)
+		}
+		if reason := msg.Data["reason"]; reason != "sla violated" {
+			t.Error("The quit message should carry the reason, got", reason)
+		}
+	default:
+		t.Fatal("Boomer should send a quit message")
+	}
+}
+
+func TestSetMaxLabelSets(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	b.SetMaxLabelSets(10)
+	b.SetMaxLabelSets(0)
+	if b.maxLabelSets != 10 {
+		t.Error("maxLabelSets should be 10, got", b.maxLabelSets)
+	}
+}
+
+func TestSetHatchSetupFunc(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	if shared := b.Shared(); shared != nil {
+		t.Error("There should be no shared resources before the test is started, got", shared)
+	}
+	b.SetHatchSetupFunc(func() (interface{}, error) {
+		return "pool", nil
+	}, nil)
+	if b.hatchSetupFunc == nil || b.hatchTeardownFunc != nil {
+		t.Error("The setup function should be set without teardown")
+	}
+}
+
+func TestSetWeightSchedule(t *testing.T) {
+	b := NewStandaloneBoomer(100, 10)
+	b.SetWeightSchedule(WeightSchedule{{Start: 9 * time.Hour, End: 17 * time.Hour, Weights: map[string]float64{"foo": 2}}})
+	if len(b.weightSchedule) != 1 {
+		t.Fatal("The weight schedule should be set")
+	}
+
+	b.SetWeightSchedule(WeightSchedule{{Start: 9 * time.Hour, End: 25 * time.Hour}})
+	if b.weightSchedule[0].End != 17*time.Hour {
+		t.Error("The weight schedule should not be changed to an invalid one")
+	}
+}
+
+func TestSetMetadata(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	metadata := map[string]interface{}{
+		"region": "us-east-1",
+	}
+	b.SetMetadata(metadata, true)
+	metadata["region"] = "eu-west-1"
+
+	if b.metadata["region"] != "us-east-1" {
+		t.Error("The metadata should be copied, got", b.metadata["region"])
+	}
+	if !b.heartbeatMetadata {
+		t.Error("heartbeatMetadata should be true")
+	}
+}
+
+func TestSetCPUWarningThreshold(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.SetCPUWarningThreshold(90)
+
+	if b.cpuWarningThreshold != 90 {
+		t.Error("cpuWarningThreshold should be 90")
+	}
+}
+
+func TestSetHeartbeatJitter(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.SetHeartbeatJitter(200 * time.Millisecond)
+	if b.heartbeatJitter != 200*time.Millisecond {
+		t.Error("heartbeatJitter should be 200ms")
+	}
+
+	b.SetHeartbeatJitter(heartbeatInterval)
+	b.SetHeartbeatJitter(-time.Second)
+	if b.heartbeatJitter != 200*time.Millisecond {
+		t.Error("heartbeatJitter should not be changed to an invalid one, got", b.heartbeatJitter)
+	}
+}
+
+func TestSetTransport(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.SetTransport("tcp")
+	if b.transport != "tcp" {
+		t.Error("transport should be tcp")
+	}
+
+	b.SetTransport("udp")
+	if b.transport != "tcp" {
+		t.Error("transport should not be changed to an invalid one")
+	}
+
+	runner := newSlaveRunner([]masterAddr{{"127.0.0.1", 5557}}, nil, nil, "asap")
+	runner.transport = b.transport
+	if _, ok := runner.newClient("127.0.0.1", 5557, "testing").(*tcpSocketClient); !ok {
+		t.Error("The slave runner should create a tcp client")
+	}
+}
+
+func TestSetBindAddress(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.SetBindAddress("127.0.0.1")
+	if b.bindAddr != "127.0.0.1" {
+		t.Error("bindAddr should be 127.0.0.1")
+	}
+
+	runner := newSlaveRunner([]masterAddr{{"127.0.0.1", 5557}}, nil, nil, "asap")
+	runner.transport = transportTCP
+	runner.bindAddr = b.bindAddr
+	if c := runner.newClient("127.0.0.1", 5557, "testing").(*tcpSocketClient); c.bindAddr != "127.0.0.1" {
+		t.Error("The client should be bound to 127.0.0.1, got", c.bindAddr)
+	}
+}
+
+func TestSetSendBuffer(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.SetSendBuffer(10, "drop-oldest")
+	if b.sendBufferSize != 10 || b.sendPolicy != "drop-oldest" {
+		t.Error("The send buffer should be 10 with drop-oldest, got", b.sendBufferSize, b.sendPolicy)
+	}
+
+	b.SetSendBuffer(-1, "drop-newest")
+	b.SetSendBuffer(20, "unexpected")
+	if b.sendBufferSize != 10 || b.sendPolicy != "drop-oldest" {
+		t.Error("The send buffer should not be changed by invalid arguments, got", b.sendBufferSize, b.sendPolicy)
+	}
+
+	runner := newSlaveRunner([]masterAddr{{"127.0.0.1", 5557}}, nil, nil, "asap")
+	runner.transport = transportTCP
+	runner.sendBufferSize = b.sendBufferSize
+	if c := runner.newClient("127.0.0.1", 5557, "testing").(*tcpSocketClient); cap(c.toMaster) != 10 {
+		t.Error("The capacity of the send channel should be 10, got", cap(c.toMaster))
+	}
+}
+
+func TestSetDrainTimeout(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.SetDrainTimeout(time.Second)
+	if b.drainTimeout != time.Second {
+		t.Error("drainTimeout should be 1s")
+	}
+
+	b.SetDrainTimeout(-time.Second)
+	if b.drainTimeout != time.Second {
+		t.Error("drainTimeout should not be changed to a negative one, got", b.drainTimeout)
+	}
+}
+
+func TestSetFailoverMasters(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.SetFailoverMasters("10.0.0.2:5557", "invalid", "[::1]:5558", "10.0.0.3:0")
+	if len(b.failoverMasters) != 2 {
+		t.Fatal("Invalid addresses should be ignored, got", b.failoverMasters)
+	}
+	if b.failoverMasters[0].String() != "10.0.0.2:5557" || b.failoverMasters[1].String() != "[::1]:5558" {
+		t.Error("Unexpected failover masters", b.failoverMasters)
+	}
+}
+
+func TestSetNodeID(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	nodeID := b.NodeID()
+	if nodeID == "" || b.NodeID() != nodeID {
+		t.Error("The generated node ID should not be changed, got", nodeID, b.NodeID())
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	b = NewBoomer("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	b.SetTransport("tcp")
+	b.SetNodeID("boomer-pod-1")
+	if b.NodeID() != "boomer-pod-1" {
+		t.Error("The node ID should be boomer-pod-1, got", b.NodeID())
+	}
+	b.Run(&Task{Name: "foo", Fn: func() {}})
+	defer b.slaveRunner.close()
+	defer Events.Unsubscribe("boomer:quit", b.slaveRunner.onQuiting)
+
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second))
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	body, err := readFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := newMessageFromBytes(body); msg == nil || msg.Type != "client_ready" || msg.NodeID != "boomer-pod-1" {
+		t.Error("The client_ready message should be sent with the supplied node ID, got", msg)
+	}
 }
 
 func TestSetMode(t *testing.T) {
@@ -112,6 +467,23 @@ func TestAddOutput(t *testing.T) {
 	}
 }
 
+func TestRemoveOutput(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	outputA, outputB := &countingOutput{}, &countingOutput{}
+	b.AddOutput(outputA)
+	b.AddOutput(outputB)
+
+	b.RemoveOutput(outputA)
+	if len(b.outputs) != 1 || b.outputs[0] != outputB {
+		t.Error("Only outputB should be kept")
+	}
+
+	b.ClearOutputs()
+	if len(b.outputs) != 0 {
+		t.Error("All the outputs should be removed")
+	}
+}
+
 func TestEnableCPUProfile(t *testing.T) {
 	b := NewStandaloneBoomer(100, 10)
 	b.EnableCPUProfile("cpu.prof", time.Second)
@@ -174,6 +546,150 @@ func TestStandaloneRun(t *testing.T) {
 	}
 }
 
+func TestEnableRateLimiter(t *testing.T) {
+	b := NewStandaloneBoomer(10, 10)
+	if err := b.EnableRateLimiter(100, "-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.rateLimiter.(*StableRateLimiter); !ok {
+		t.Error("The rate limiter should be a StableRateLimiter, got", b.rateLimiter)
+	}
+
+	if err := b.EnableRateLimiter(100, "10/1s"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.rateLimiter.(*RampUpRateLimiter); !ok {
+		t.Error("The rate limiter should be a RampUpRateLimiter, got", b.rateLimiter)
+	}
+
+	for _, rate := range []string{"-1", "invalid"} {
+		b.rateLimiter = nil
+		if err := b.EnableRateLimiter(0, rate); err == nil || b.rateLimiter != nil {
+			t.Error("The rate limiter should not be enabled by", rate)
+		}
+	}
+}
+
+func TestStandaloneRunAndQuit(t *testing.T) {
+	b := NewStandaloneBoomer(5, 10)
+	b.DisableConsoleOutput()
+	output := NewMemoryOutput()
+	b.AddOutput(output)
+
+	count := int64(0)
+	task := &Task{
+		Name: "foo",
+		Fn: func() {
+			atomic.AddInt64(&count, 1)
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	spawned := make(chan bool, 1)
+	Events.SubscribeOnce("boomer:spawn_complete", func(workers int) {
+		spawned <- true
+	})
+	returned := make(chan error)
+	go func() {
+		returned <- b.Run(task)
+	}()
+	<-spawned
+
+	select {
+	case <-returned:
+		t.Fatal("Run should block until Quit in standalone mode")
+	case <-time.After(time.Second):
+	}
+	b.Quit()
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Error("Run should return nil after quit, got", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run should return after quit")
+	}
+
+	if atomic.LoadInt64(&count) == 0 {
+		t.Error("The task should be executed")
+	}
+	if state := b.State(); state != stateStopped {
+		t.Error("The state should be stopped after quit, got", state)
+	}
+	if output.StartCount() != 1 || output.StopCount() != 1 {
+		t.Error("The output should be started and stopped once, got", output.StartCount(), output.StopCount())
+	}
+}
+
+func TestDisableConsoleOutput(t *testing.T) {
+	b := NewStandaloneBoomer(1, 1)
+	b.DisableConsoleOutput()
+	output := &countingOutput{}
+	b.AddOutput(output)
+
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	spawned := make(chan bool, 1)
+	Events.SubscribeOnce("boomer:spawn_complete", func(workers int) {
+		spawned <- true
+	})
+	go b.Run(taskA)
+	<-spawned
+	defer b.Quit()
+
+	outputs := b.localRunner.getOutputs()
+	if len(outputs) != 1 || outputs[0] != output {
+		t.Error("Only the custom output should be registered, got", outputs)
+	}
+	if atomic.LoadInt32(&output.starts) != 1 {
+		t.Error("The custom output should be started")
+	}
+}
+
+func TestEnableAndDisableTask(t *testing.T) {
+	b := NewStandaloneBoomer(1, 1)
+	b.DisableConsoleOutput()
+
+	var countA, countB int64
+	taskA := &Task{
+		Name: "A",
+		Fn: func() {
+			atomic.AddInt64(&countA, 1)
+			time.Sleep(time.Millisecond)
+		},
+	}
+	taskB := &Task{
+		Name: "B",
+		Fn: func() {
+			atomic.AddInt64(&countB, 1)
+			time.Sleep(time.Millisecond)
+		},
+	}
+
+	// disabled before the test is started
+	b.DisableTask("B")
+	spawned := make(chan bool, 1)
+	Events.SubscribeOnce("boomer:spawn_complete", func(workers int) {
+		spawned <- true
+	})
+	go b.Run(taskA, taskB)
+	<-spawned
+	defer b.Quit()
+
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt64(&countA) == 0 || atomic.LoadInt64(&countB) != 0 {
+		t.Error("Only task A should be executed, got", atomic.LoadInt64(&countA), atomic.LoadInt64(&countB))
+	}
+
+	b.EnableTask("B")
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt64(&countB) == 0 {
+		t.Error("Task B should be executed after it's enabled")
+	}
+}
+
 func TestDistributedRun(t *testing.T) {
 	masterHost := "0.0.0.0"
 	rand.Seed(Now())
@@ -340,7 +856,7 @@ func TestRecordSuccess(t *testing.T) {
 	masterHost := "127.0.0.1"
 	masterPort := 5557
 	defaultBoomer = NewBoomer(masterHost, masterPort)
-	defaultBoomer.slaveRunner = newSlaveRunner(masterHost, masterPort, nil, nil, "asap")
+	defaultBoomer.slaveRunner = newSlaveRunner([]masterAddr{{masterHost, masterPort}}, nil, nil, "asap")
 	RecordSuccess("http", "foo", int64(1), int64(10))
 
 	requestSuccessMsg := <-defaultBoomer.slaveRunner.stats.requestSuccessChan
@@ -357,7 +873,7 @@ func TestRecordFailure(t *testing.T) {
 	masterHost := "127.0.0.1"
 	masterPort := 5557
 	defaultBoomer = NewBoomer(masterHost, masterPort)
-	defaultBoomer.slaveRunner = newSlaveRunner(masterHost, masterPort, nil, nil, "asap")
+	defaultBoomer.slaveRunner = newSlaveRunner([]masterAddr{{masterHost, masterPort}}, nil, nil, "asap")
 	RecordFailure("udp", "bar", int64(2), "udp error")
 
 	requestFailureMsg := <-defaultBoomer.slaveRunner.stats.requestFailureChan
@@ -372,3 +888,291 @@ func TestRecordFailure(t *testing.T) {
 	}
 	defaultBoomer = nil
 }
+
+func TestRecordError(t *testing.T) {
+	masterHost := "127.0.0.1"
+	masterPort := 5557
+	defaultBoomer = NewBoomer(masterHost, masterPort)
+	defaultBoomer.slaveRunner = newSlaveRunner([]masterAddr{{masterHost, masterPort}}, nil, nil, "asap")
+	RecordError("http", "foo", int64(2), context.DeadlineExceeded)
+
+	requestFailureMsg := <-defaultBoomer.slaveRunner.stats.requestFailureChan
+	if requestFailureMsg.error != context.DeadlineExceeded.Error() {
+		t.Error("Expected:", context.DeadlineExceeded.Error(), "got:", requestFailureMsg.error)
+	}
+	if requestFailureMsg.category != ErrorCategoryTimeout {
+		t.Error("Expected: timeout, got:", requestFailureMsg.category)
+	}
+
+	defaultBoomer.SetErrorClassifier(func(err error) string {
+		return "custom"
+	})
+	RecordError("http", "foo", int64(2), errors.New("foo"))
+	requestFailureMsg = <-defaultBoomer.slaveRunner.stats.requestFailureChan
+	if requestFailureMsg.category != "custom" {
+		t.Error("Expected: custom, got:", requestFailureMsg.category)
+	}
+
+	// nil errors are ignored
+	RecordError("http", "foo", int64(2), nil)
+	select {
+	case requestFailureMsg = <-defaultBoomer.slaveRunner.stats.requestFailureChan:
+		t.Error("A nil error should not be recorded, got", requestFailureMsg.error)
+	default:
+	}
+	defaultBoomer = nil
+}
+
+func TestRunWithInvalidTasks(t *testing.T) {
+	b := NewStandaloneBoomer(10, 10)
+	if err := b.Run(); err == nil {
+		t.Error("Run should return an error without tasks")
+	}
+	if err := b.Run(&Task{Name: "foo"}); err == nil {
+		t.Error("Run should return an error for a task without Fn")
+	}
+	if b.localRunner != nil {
+		t.Error("The runner should not be started with invalid tasks")
+	}
+}
+
+func TestRunWithPercentageWeights(t *testing.T) {
+	fn := func() {}
+	b := NewStandaloneBoomer(10, 10)
+	b.SetPercentageWeights(true)
+	if err := b.Run(&Task{Name: "foo", Fn: fn, Weight: 60}, &Task{Name: "bar", Fn: fn, Weight: 30}); err == nil {
+		t.Error("Run should return an error if the percentages don't sum to 100")
+	}
+	if b.localRunner != nil {
+		t.Error("The runner should not be started with invalid percentages")
+	}
+	if err := b.DryRun(&Task{Name: "foo", Fn: fn, Weight: 60}, &Task{Name: "bar", Fn: fn, Weight: 40}); err != nil {
+		t.Error("The percentages summing to 100 should be valid, got", err)
+	}
+	if err := b.DryRun(&Task{Name: "foo", Fn: fn, Weight: 90}); err == nil {
+		t.Error("DryRun should return an error if the percentages don't sum to 100")
+	}
+	// it should not panic
+	if err := b.DryRun(&Task{Name: "foo", Fn: fn, Weight: 100}, nil); err == nil || !strings.Contains(err.Error(), "task #1 is nil") {
+		t.Error("DryRun should report the nil task, got", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	count := 0
+	good := &Task{
+		Name:   "good",
+		Weight: 10,
+		Fn: func() {
+			count++
+		},
+	}
+	b := NewBoomer("127.0.0.1", 5557)
+	if err := b.DryRun(good); err != nil {
+		t.Error("DryRun should succeed, got", err)
+	}
+	if count != 1 {
+		t.Error("The task should be executed exactly once, got", count)
+	}
+	if b.slaveRunner != nil || b.localRunner != nil {
+		t.Error("DryRun should not start a runner")
+	}
+
+	panicking := &Task{
+		Name: "panicking",
+		Fn: func() {
+			panic("something wrong")
+		},
+	}
+	failedOnStart := &Task{
+		Name: "failedOnStart",
+		OnStart: func() error {
+			return errors.New("not ready")
+		},
+		Fn: func() {},
+	}
+	negativeWeight := &Task{
+		Name:   "negativeWeight",
+		Weight: -1,
+		Fn:     func() {},
+	}
+	withoutFn := &Task{
+		Name: "withoutFn",
+	}
+	err := DryRun(good, panicking, failedOnStart, negativeWeight, withoutFn)
+	if err == nil {
+		t.Fatal("DryRun should report the problems")
+	}
+	for _, problem := range []string{"panicking panics, something wrong", "failedOnStart fails in OnStart, not ready",
+		"negativeWeight is invalid, invalid weight -1", "withoutFn is invalid, neither Fn nor FnWithContext is set"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("DryRun should report %q, got %v", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "good") {
+		t.Error("DryRun should not report the good task, got", err)
+	}
+
+	if err = b.DryRun(); err == nil {
+		t.Error("DryRun should report that there is no task")
+	}
+
+	// it should not panic
+	if err = b.DryRun(good, nil); err == nil || !strings.Contains(err.Error(), "task #1 is nil") {
+		t.Error("DryRun should report the nil task, got", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	b := NewStandaloneBoomer(10, 10)
+	if snapshot := b.Stats(); snapshot.NumRequests != 0 {
+		t.Error("An empty snapshot is expected before the test starts, got", snapshot)
+	}
+
+	b.localRunner = newLocalRunner(nil, nil, 10, "asap", 10)
+	b.localRunner.stats.start()
+	defer b.localRunner.stats.close()
+	b.RecordSuccess("http", "foo", int64(10), int64(10))
+	b.RecordSuccess("http", "foo", int64(20), int64(10))
+	b.RecordFailure("http", "foo", int64(30), "error")
+
+	snapshot := b.Stats()
+	if snapshot.NumRequests != 2 || snapshot.NumFailures != 1 {
+		t.Error("expected: 2 requests and 1 failure, got:", snapshot.NumRequests, snapshot.NumFailures)
+	}
+	if snapshot.AvgResponseTime != 15 {
+		t.Error("expected: 15 avg response time, got:", snapshot.AvgResponseTime)
+	}
+}
+
+func TestRecordWithoutRunner(t *testing.T) {
+	// it should not panic or block.
+	b := NewStandaloneBoomer(10, 10)
+	b.RecordSuccess("http", "foo", int64(1), int64(10))
+	b.RecordFailure("http", "foo", int64(1), "error")
+
+	// the runner of the other mode is ignored
+	b.slaveRunner = newSlaveRunner([]masterAddr{{"127.0.0.1", 5557}}, nil, nil, "asap")
+	b.RecordSuccess("http", "foo", int64(1), int64(10))
+
+	var nilBoomer *Boomer
+	nilBoomer.RecordSuccess("http", "foo", int64(1), int64(10))
+	nilBoomer.RecordFailure("http", "foo", int64(1), "error")
+
+	// records are dropped after the runner is closed
+	b.localRunner = newLocalRunner(nil, nil, 10, "asap", 10)
+	b.localRunner.close()
+	for i := 0; i < 200; i++ {
+		b.RecordSuccess("http", "foo", int64(1), int64(10))
+	}
+}
+
+func TestState(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	if b.State() != stateInit {
+		t.Error("State should be ready before the test is started, got", b.State())
+	}
+
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	runner := newSlaveRunner([]masterAddr{{"127.0.0.1", 5557}}, []*Task{taskA}, nil, "asap")
+	defer runner.close()
+	runner.client = newClient("127.0.0.1", 5557, runner.nodeID)
+	runner.setState(stateInit)
+	runner.stats.start()
+	b.slaveRunner = runner
+
+	runner.onMessage(newMessage("hatch", map[string]interface{}{
+		"hatch_rate":  float64(10),
+		"num_clients": int64(10),
+	}, runner.nodeID))
+	// hatching
+	<-runner.client.sendChannel()
+	if b.State() != stateHatching && b.State() != stateRunning {
+		t.Error("State should be hatching after a hatch message, got", b.State())
+	}
+
+	// hatch_complete
+	<-runner.client.sendChannel()
+	if b.State() != stateRunning {
+		t.Error("State should be running after hatch completed, got", b.State())
+	}
+
+	go func() {
+		for {
+			select {
+			case <-runner.client.sendChannel():
+			case <-runner.closeChan:
+				return
+			}
+		}
+	}()
+	runner.onMessage(newMessage("stop", nil, runner.nodeID))
+	if b.State() != stateInit {
+		t.Error("State should be ready after a stop message, got", b.State())
+	}
+}
+
+func TestPauseWithoutRunner(t *testing.T) {
+	b := NewStandaloneBoomer(10, 10)
+	if b.Pause() {
+		t.Error("Pause should return false before the test is started")
+	}
+	if b.Resume() {
+		t.Error("Resume should return false before the test is started")
+	}
+}
+
+func TestSendCustomMessageInStandaloneMode(t *testing.T) {
+	b := NewStandaloneBoomer(10, 10)
+	// should be a no-op
+	b.SendCustomMessage("order", map[string]interface{}{
+		"order_id": "123",
+	})
+}
+
+func TestRegisterMessageHandler(t *testing.T) {
+	b := NewBoomer("127.0.0.1", 5557)
+	b.RegisterMessageHandler("config", func(data map[string]interface{}) {})
+
+	if _, ok := b.messageHandlers["config"]; !ok {
+		t.Error("The handler of config message should be registered")
+	}
+}
+
+func TestRecordAggregated(t *testing.T) {
+	b := NewStandaloneBoomer(10, 10)
+	b.localRunner = newLocalRunner(nil, nil, 10, "asap", 10)
+	b.localRunner.stats.start()
+	defer b.localRunner.close()
+
+	b.RecordSuccess("http", "foo", int64(10), int64(100))
+	b.RecordSuccess("http", "foo", int64(20), int64(200))
+	b.RecordFailure("http", "bar", int64(30), "500 error")
+
+	data := <-b.localRunner.stats.messageToRunnerChan
+
+	total := data["stats_total"].(map[string]interface{})
+	if total["num_requests"].(int64) != 2 {
+		t.Error("num_requests is wrong, expected: 2, got:", total["num_requests"].(int64))
+	}
+	if total["num_failures"].(int64) != 1 {
+		t.Error("num_failures is wrong, expected: 1, got:", total["num_failures"].(int64))
+	}
+	if total["total_content_length"].(int64) != 300 {
+		t.Error("total_content_length is wrong, expected: 300, got:", total["total_content_length"].(int64))
+	}
+
+	errors := data["errors"].(map[string]map[string]interface{})
+	if len(errors) != 1 {
+		t.Fatal("The length of errors is wrong, expected: 1, got:", len(errors))
+	}
+	for _, e := range errors {
+		if e["error"].(string) != "500 error" || e["name"].(string) != "bar" || e["occurrences"].(int64) != 1 {
+			t.Error("The error is wrong, got:", e)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+// Package boomerpb defines the events streamed by boomer.GRPCOutput, the code is generated from event.proto.
+package boomerpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative event.proto
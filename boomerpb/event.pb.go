@@ -0,0 +1,427 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: event.proto
+
+package boomerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Event is the stats reported by the runner, every 3 seconds by default.
+type Event struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	NodeId string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	// unix time in milliseconds when the event is created.
+	Timestamp     int64           `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	UserCount     int32           `protobuf:"varint,3,opt,name=user_count,json=userCount,proto3" json:"user_count,omitempty"`
+	StatsTotal    *RequestStats   `protobuf:"bytes,4,opt,name=stats_total,json=statsTotal,proto3" json:"stats_total,omitempty"`
+	Stats         []*RequestStats `protobuf:"bytes,5,rep,name=stats,proto3" json:"stats,omitempty"`
+	Errors        []*RequestError `protobuf:"bytes,6,rep,name=errors,proto3" json:"errors,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_event_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_event_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_event_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Event) GetNodeId() string {
+	if x != nil {
+		return x.NodeId
+	}
+	return ""
+}
+
+func (x *Event) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Event) GetUserCount() int32 {
+	if x != nil {
+		return x.UserCount
+	}
+	return 0
+}
+
+func (x *Event) GetStatsTotal() *RequestStats {
+	if x != nil {
+		return x.StatsTotal
+	}
+	return nil
+}
+
+func (x *Event) GetStats() []*RequestStats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+func (x *Event) GetErrors() []*RequestError {
+	if x != nil {
+		return x.Errors
+	}
+	return nil
+}
+
+// RequestStats is the stats of a request since the last event.
+type RequestStats struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Method             string                 `protobuf:"bytes,1,opt,name=method,proto3" json:"method,omitempty"`
+	Name               string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	NumRequests        int64                  `protobuf:"varint,3,opt,name=num_requests,json=numRequests,proto3" json:"num_requests,omitempty"`
+	NumFailures        int64                  `protobuf:"varint,4,opt,name=num_failures,json=numFailures,proto3" json:"num_failures,omitempty"`
+	TotalResponseTime  int64                  `protobuf:"varint,5,opt,name=total_response_time,json=totalResponseTime,proto3" json:"total_response_time,omitempty"`
+	MinResponseTime    int64                  `protobuf:"varint,6,opt,name=min_response_time,json=minResponseTime,proto3" json:"min_response_time,omitempty"`
+	MaxResponseTime    int64                  `protobuf:"varint,7,opt,name=max_response_time,json=maxResponseTime,proto3" json:"max_response_time,omitempty"`
+	TotalContentLength int64                  `protobuf:"varint,8,opt,name=total_content_length,json=totalContentLength,proto3" json:"total_content_length,omitempty"`
+	// the number of requests keyed by the rounded response time in milliseconds.
+	ResponseTimes map[int64]int64 `protobuf:"bytes,9,rep,name=response_times,json=responseTimes,proto3" json:"response_times,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequestStats) Reset() {
+	*x = RequestStats{}
+	mi := &file_event_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequestStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestStats) ProtoMessage() {}
+
+func (x *RequestStats) ProtoReflect() protoreflect.Message {
+	mi := &file_event_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestStats.ProtoReflect.Descriptor instead.
+func (*RequestStats) Descriptor() ([]byte, []int) {
+	return file_event_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RequestStats) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *RequestStats) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *RequestStats) GetNumRequests() int64 {
+	if x != nil {
+		return x.NumRequests
+	}
+	return 0
+}
+
+func (x *RequestStats) GetNumFailures() int64 {
+	if x != nil {
+		return x.NumFailures
+	}
+	return 0
+}
+
+func (x *RequestStats) GetTotalResponseTime() int64 {
+	if x != nil {
+		return x.TotalResponseTime
+	}
+	return 0
+}
+
+func (x *RequestStats) GetMinResponseTime() int64 {
+	if x != nil {
+		return x.MinResponseTime
+	}
+	return 0
+}
+
+func (x *RequestStats) GetMaxResponseTime() int64 {
+	if x != nil {
+		return x.MaxResponseTime
+	}
+	return 0
+}
+
+func (x *RequestStats) GetTotalContentLength() int64 {
+	if x != nil {
+		return x.TotalContentLength
+	}
+	return 0
+}
+
+func (x *RequestStats) GetResponseTimes() map[int64]int64 {
+	if x != nil {
+		return x.ResponseTimes
+	}
+	return nil
+}
+
+// RequestError is an error of a request and its occurrences since the last event.
+type RequestError struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Method        string                 `protobuf:"bytes,1,opt,name=method,proto3" json:"method,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	Occurrences   int64                  `protobuf:"varint,4,opt,name=occurrences,proto3" json:"occurrences,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequestError) Reset() {
+	*x = RequestError{}
+	mi := &file_event_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequestError) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestError) ProtoMessage() {}
+
+func (x *RequestError) ProtoReflect() protoreflect.Message {
+	mi := &file_event_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestError.ProtoReflect.Descriptor instead.
+func (*RequestError) Descriptor() ([]byte, []int) {
+	return file_event_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RequestError) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *RequestError) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *RequestError) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *RequestError) GetOccurrences() int64 {
+	if x != nil {
+		return x.Occurrences
+	}
+	return 0
+}
+
+// StreamSummary is returned when the stream is closed.
+type StreamSummary struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Received      int64                  `protobuf:"varint,1,opt,name=received,proto3" json:"received,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamSummary) Reset() {
+	*x = StreamSummary{}
+	mi := &file_event_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamSummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamSummary) ProtoMessage() {}
+
+func (x *StreamSummary) ProtoReflect() protoreflect.Message {
+	mi := &file_event_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamSummary.ProtoReflect.Descriptor instead.
+func (*StreamSummary) Descriptor() ([]byte, []int) {
+	return file_event_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *StreamSummary) GetReceived() int64 {
+	if x != nil {
+		return x.Received
+	}
+	return 0
+}
+
+var File_event_proto protoreflect.FileDescriptor
+
+const file_event_proto_rawDesc = "" +
+	"\n" +
+	"\vevent.proto\x12\x06boomer\"\xee\x01\n" +
+	"\x05Event\x12\x17\n" +
+	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x12\x1c\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12\x1d\n" +
+	"\n" +
+	"user_count\x18\x03 \x01(\x05R\tuserCount\x125\n" +
+	"\vstats_total\x18\x04 \x01(\v2\x14.boomer.RequestStatsR\n" +
+	"statsTotal\x12*\n" +
+	"\x05stats\x18\x05 \x03(\v2\x14.boomer.RequestStatsR\x05stats\x12,\n" +
+	"\x06errors\x18\x06 \x03(\v2\x14.boomer.RequestErrorR\x06errors\"\xcc\x03\n" +
+	"\fRequestStats\x12\x16\n" +
+	"\x06method\x18\x01 \x01(\tR\x06method\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12!\n" +
+	"\fnum_requests\x18\x03 \x01(\x03R\vnumRequests\x12!\n" +
+	"\fnum_failures\x18\x04 \x01(\x03R\vnumFailures\x12.\n" +
+	"\x13total_response_time\x18\x05 \x01(\x03R\x11totalResponseTime\x12*\n" +
+	"\x11min_response_time\x18\x06 \x01(\x03R\x0fminResponseTime\x12*\n" +
+	"\x11max_response_time\x18\a \x01(\x03R\x0fmaxResponseTime\x120\n" +
+	"\x14total_content_length\x18\b \x01(\x03R\x12totalContentLength\x12N\n" +
+	"\x0eresponse_times\x18\t \x03(\v2'.boomer.RequestStats.ResponseTimesEntryR\rresponseTimes\x1a@\n" +
+	"\x12ResponseTimesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\x03R\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\"r\n" +
+	"\fRequestError\x12\x16\n" +
+	"\x06method\x18\x01 \x01(\tR\x06method\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\x12 \n" +
+	"\voccurrences\x18\x04 \x01(\x03R\voccurrences\"+\n" +
+	"\rStreamSummary\x12\x1a\n" +
+	"\breceived\x18\x01 \x01(\x03R\breceived2B\n" +
+	"\x0eEventCollector\x120\n" +
+	"\x06Stream\x12\r.boomer.Event\x1a\x15.boomer.StreamSummary(\x01B#Z!github.com/myzhan/boomer/boomerpbb\x06proto3"
+
+var (
+	file_event_proto_rawDescOnce sync.Once
+	file_event_proto_rawDescData []byte
+)
+
+func file_event_proto_rawDescGZIP() []byte {
+	file_event_proto_rawDescOnce.Do(func() {
+		file_event_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_event_proto_rawDesc), len(file_event_proto_rawDesc)))
+	})
+	return file_event_proto_rawDescData
+}
+
+var file_event_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_event_proto_goTypes = []any{
+	(*Event)(nil),         // 0: boomer.Event
+	(*RequestStats)(nil),  // 1: boomer.RequestStats
+	(*RequestError)(nil),  // 2: boomer.RequestError
+	(*StreamSummary)(nil), // 3: boomer.StreamSummary
+	nil,                   // 4: boomer.RequestStats.ResponseTimesEntry
+}
+var file_event_proto_depIdxs = []int32{
+	1, // 0: boomer.Event.stats_total:type_name -> boomer.RequestStats
+	1, // 1: boomer.Event.stats:type_name -> boomer.RequestStats
+	2, // 2: boomer.Event.errors:type_name -> boomer.RequestError
+	4, // 3: boomer.RequestStats.response_times:type_name -> boomer.RequestStats.ResponseTimesEntry
+	0, // 4: boomer.EventCollector.Stream:input_type -> boomer.Event
+	3, // 5: boomer.EventCollector.Stream:output_type -> boomer.StreamSummary
+	5, // [5:6] is the sub-list for method output_type
+	4, // [4:5] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_event_proto_init() }
+func file_event_proto_init() {
+	if File_event_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_event_proto_rawDesc), len(file_event_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_event_proto_goTypes,
+		DependencyIndexes: file_event_proto_depIdxs,
+		MessageInfos:      file_event_proto_msgTypes,
+	}.Build()
+	File_event_proto = out.File
+	file_event_proto_goTypes = nil
+	file_event_proto_depIdxs = nil
+}
@@ -0,0 +1,120 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: event.proto
+
+package boomerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	EventCollector_Stream_FullMethodName = "/boomer.EventCollector/Stream"
+)
+
+// EventCollectorClient is the client API for EventCollector service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// EventCollector collects the events of boomer, which are sent by GRPCOutput.
+type EventCollectorClient interface {
+	// Stream receives the events of a test until the output stops.
+	Stream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Event, StreamSummary], error)
+}
+
+type eventCollectorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEventCollectorClient(cc grpc.ClientConnInterface) EventCollectorClient {
+	return &eventCollectorClient{cc}
+}
+
+func (c *eventCollectorClient) Stream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Event, StreamSummary], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &EventCollector_ServiceDesc.Streams[0], EventCollector_Stream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Event, StreamSummary]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type EventCollector_StreamClient = grpc.ClientStreamingClient[Event, StreamSummary]
+
+// EventCollectorServer is the server API for EventCollector service.
+// All implementations must embed UnimplementedEventCollectorServer
+// for forward compatibility.
+//
+// EventCollector collects the events of boomer, which are sent by GRPCOutput.
+type EventCollectorServer interface {
+	// Stream receives the events of a test until the output stops.
+	Stream(grpc.ClientStreamingServer[Event, StreamSummary]) error
+	mustEmbedUnimplementedEventCollectorServer()
+}
+
+// UnimplementedEventCollectorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedEventCollectorServer struct{}
+
+func (UnimplementedEventCollectorServer) Stream(grpc.ClientStreamingServer[Event, StreamSummary]) error {
+	return status.Error(codes.Unimplemented, "method Stream not implemented")
+}
+func (UnimplementedEventCollectorServer) mustEmbedUnimplementedEventCollectorServer() {}
+func (UnimplementedEventCollectorServer) testEmbeddedByValue()                        {}
+
+// UnsafeEventCollectorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EventCollectorServer will
+// result in compilation errors.
+type UnsafeEventCollectorServer interface {
+	mustEmbedUnimplementedEventCollectorServer()
+}
+
+func RegisterEventCollectorServer(s grpc.ServiceRegistrar, srv EventCollectorServer) {
+	// If the following call panics, it indicates UnimplementedEventCollectorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&EventCollector_ServiceDesc, srv)
+}
+
+func _EventCollector_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EventCollectorServer).Stream(&grpc.GenericServerStream[Event, StreamSummary]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type EventCollector_StreamServer = grpc.ClientStreamingServer[Event, StreamSummary]
+
+// EventCollector_ServiceDesc is the grpc.ServiceDesc for EventCollector service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EventCollector_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "boomer.EventCollector",
+	HandlerType: (*EventCollectorServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			Handler:       _EventCollector_Stream_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "event.proto",
+}
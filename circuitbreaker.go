@@ -0,0 +1,133 @@
+package boomer
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CircuitBreaker stops picking a task while its downstream keeps failing, rather than hammering it
+// and flooding the stats with failures. The failures are recorded by RecordFailure, RecordError
+// or a panic with the name of the task, and a success with the name resets the count.
+// Once MaxFailures consecutive failures occur within Window, the circuit opens and the task is not
+// picked any more. After ProbeInterval, the task is picked again as a probe, the circuit closes on
+// the next success, or opens again on the next failure.
+// A "boomer:circuit_open" or "boomer:circuit_closed" event is published with the name of the task.
+type CircuitBreaker struct {
+	MaxFailures int
+	// Window is the max duration between the first and the last of the consecutive failures, 0 means unlimited.
+	Window        time.Duration
+	ProbeInterval time.Duration
+}
+
+func (cb *CircuitBreaker) validate() error {
+	if cb.MaxFailures <= 0 {
+		return errors.New("the max failures of circuit breaker should be positive")
+	}
+	if cb.Window < 0 {
+		return errors.New("the window of circuit breaker should not be negative")
+	}
+	if cb.ProbeInterval <= 0 {
+		return errors.New("the probe interval of circuit breaker should be positive")
+	}
+	return nil
+}
+
+const (
+	circuitClosed int32 = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuit is the state of the CircuitBreaker of the tasks with the same name.
+type circuit struct {
+	breaker *CircuitBreaker
+	// circuitClosed, circuitOpen or circuitHalfOpen, it's read by the task selection without lock.
+	state int32
+
+	lock         sync.Mutex
+	failures     int
+	firstFailure time.Time
+}
+
+func (c *circuit) isOpen() bool {
+	return c != nil && atomic.LoadInt32(&c.state) == circuitOpen
+}
+
+// record counts a result, it returns the new state if the circuit opens or closes, otherwise -1.
+func (c *circuit) record(failed bool, now time.Time) int32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	state := atomic.LoadInt32(&c.state)
+	if !failed {
+		c.failures = 0
+		if state == circuitHalfOpen {
+			atomic.StoreInt32(&c.state, circuitClosed)
+			return circuitClosed
+		}
+		return -1
+	}
+
+	switch state {
+	case circuitOpen:
+		// the executions started before the circuit opens
+		return -1
+	case circuitHalfOpen:
+		atomic.StoreInt32(&c.state, circuitOpen)
+		return circuitOpen
+	}
+	if c.failures == 0 || (c.breaker.Window > 0 && now.Sub(c.firstFailure) > c.breaker.Window) {
+		c.failures = 0
+		c.firstFailure = now
+	}
+	c.failures++
+	if c.failures < c.breaker.MaxFailures {
+		return -1
+	}
+	c.failures = 0
+	atomic.StoreInt32(&c.state, circuitOpen)
+	return circuitOpen
+}
+
+// getCircuit returns the circuit of the tasks named name, or nil if none of them has a CircuitBreaker.
+func (r *runner) getCircuit(name string) *circuit {
+	r.circuitsOnce.Do(func() {
+		for _, task := range r.tasks {
+			if task == nil || task.CircuitBreaker == nil {
+				continue
+			}
+			if r.circuits == nil {
+				r.circuits = make(map[string]*circuit)
+			}
+			if _, ok := r.circuits[task.Name]; !ok {
+				r.circuits[task.Name] = &circuit{breaker: task.CircuitBreaker}
+			}
+		}
+	})
+	return r.circuits[name]
+}
+
+// recordResult updates the circuit of the tasks named name, the task selection is updated
+// if the circuit opens or closes.
+func (r *runner) recordResult(name string, failed bool) {
+	c := r.getCircuit(name)
+	if c == nil {
+		return
+	}
+	switch c.record(failed, time.Now()) {
+	case circuitOpen:
+		logger.Errorf("The circuit of task %s is open, it's probed again after %v", name, c.breaker.ProbeInterval)
+		r.updateActiveWeights()
+		Events.Publish("boomer:circuit_open", name)
+		time.AfterFunc(c.breaker.ProbeInterval, func() {
+			if atomic.CompareAndSwapInt32(&c.state, circuitOpen, circuitHalfOpen) {
+				r.updateActiveWeights()
+			}
+		})
+	case circuitClosed:
+		logger.Infof("The circuit of task %s is closed", name)
+		Events.Publish("boomer:circuit_closed", name)
+	}
+}
@@ -0,0 +1,118 @@
+package boomer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	events := make(chan string, 10)
+	onOpen := func(name string) {
+		events <- "open " + name
+	}
+	onClosed := func(name string) {
+		events <- "closed " + name
+	}
+	Events.Subscribe("boomer:circuit_open", onOpen)
+	defer Events.Unsubscribe("boomer:circuit_open", onOpen)
+	Events.Subscribe("boomer:circuit_closed", onClosed)
+	defer Events.Unsubscribe("boomer:circuit_closed", onClosed)
+
+	fn := func() {}
+	r := newLocalRunner([]*Task{
+		{Name: "foo", Fn: fn, Weight: 1, CircuitBreaker: &CircuitBreaker{
+			MaxFailures:   3,
+			Window:        time.Second,
+			ProbeInterval: 100 * time.Millisecond,
+		}},
+		{Name: "bar", Fn: fn, Weight: 1},
+	}, nil, 1, "asap", 1)
+	r.updateActiveWeights()
+	isPicked := func() bool {
+		return r.getActiveWeights()[0] > 0
+	}
+	waitForProbe := func() {
+		for i := 0; i < 100 && !isPicked(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !isPicked() {
+			t.Fatal("foo should be probed again after the probe interval")
+		}
+	}
+
+	// the failures are not consecutive
+	r.recordFailure("http", "foo", 10, "timeout", "")
+	r.recordFailure("http", "foo", 10, "timeout", "")
+	r.recordSuccess("http", "foo", 10, 100)
+	r.recordFailure("http", "foo", 10, "timeout", "")
+	r.recordFailure("http", "foo", 10, "timeout", "")
+	r.recordFailure("http", "bar", 10, "timeout", "")
+	if !isPicked() {
+		t.Fatal("The circuit should not open without 3 consecutive failures")
+	}
+
+	r.recordFailure("http", "foo", 10, "timeout", "")
+	if isPicked() {
+		t.Fatal("foo should not be picked once the circuit opens")
+	}
+	if event := <-events; event != "open foo" {
+		t.Error("Expected the circuit of foo to open, got", event)
+	}
+
+	// the probe fails
+	waitForProbe()
+	r.recordFailure("http", "foo", 10, "timeout", "")
+	if isPicked() {
+		t.Fatal("foo should not be picked if the probe fails")
+	}
+	if event := <-events; event != "open foo" {
+		t.Error("Expected the circuit of foo to open again, got", event)
+	}
+
+	// recovered
+	waitForProbe()
+	r.recordSuccess("http", "foo", 10, 100)
+	if event := <-events; event != "closed foo" {
+		t.Error("Expected the circuit of foo to close, got", event)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !isPicked() {
+		t.Error("foo should be picked after the circuit closes")
+	}
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	r := newLocalRunner([]*Task{
+		{Name: "foo", Fn: func() {}, CircuitBreaker: &CircuitBreaker{
+			MaxFailures:   3,
+			Window:        50 * time.Millisecond,
+			ProbeInterval: time.Second,
+		}},
+	}, nil, 1, "asap", 1)
+
+	r.recordFailure("http", "foo", 10, "timeout", "")
+	r.recordFailure("http", "foo", 10, "timeout", "")
+	time.Sleep(100 * time.Millisecond)
+	r.recordFailure("http", "foo", 10, "timeout", "")
+	r.recordFailure("http", "foo", 10, "timeout", "")
+	if r.getCircuit("foo").isOpen() {
+		t.Error("The failures out of the window should not open the circuit")
+	}
+	r.recordFailure("http", "foo", 10, "timeout", "")
+	if !r.getCircuit("foo").isOpen() {
+		t.Error("3 consecutive failures within the window should open the circuit")
+	}
+}
+
+func TestValidateCircuitBreaker(t *testing.T) {
+	for _, cb := range []*CircuitBreaker{
+		{MaxFailures: 0, ProbeInterval: time.Second},
+		{MaxFailures: 1, Window: -time.Second, ProbeInterval: time.Second},
+		{MaxFailures: 1},
+	} {
+		task := &Task{Fn: func() {}, CircuitBreaker: cb}
+		if task.validate() == nil {
+			t.Error("The circuit breaker should be invalid", cb)
+		}
+	}
+}
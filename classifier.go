@@ -0,0 +1,87 @@
+package boomer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// The error categories of DefaultErrorClassifier.
+const (
+	ErrorCategoryTimeout           = "timeout"
+	ErrorCategoryCanceled          = "canceled"
+	ErrorCategoryConnectionRefused = "connection_refused"
+	ErrorCategoryConnectionReset   = "connection_reset"
+	ErrorCategoryDNS               = "dns"
+	ErrorCategoryEOF               = "eof"
+	ErrorCategoryHTTP4xx           = "http_4xx"
+	ErrorCategoryHTTP5xx           = "http_5xx"
+	ErrorCategoryOther             = "other"
+)
+
+// ErrorCategoryPanic is the category of the failures recorded when a task panics.
+const ErrorCategoryPanic = "panic"
+
+// ErrorCategoryInjected is the category of the failures injected by Boomer.SetFailureRate.
+const ErrorCategoryInjected = "injected"
+
+// isConnectionError returns true if the category means the request couldn't even connect to the target.
+func isConnectionError(category string) bool {
+	switch category {
+	case ErrorCategoryConnectionRefused, ErrorCategoryDNS:
+		return true
+	}
+	return false
+}
+
+// ErrorClassifier maps an error to a category, like "timeout", which is reported to master
+// along with the error, so failures can be grouped by their categories.
+type ErrorClassifier func(err error) string
+
+// DefaultErrorClassifier classifies the common errors of net, net/url and net/http.
+// An error with a StatusCode() int method, or wrapping one, is classified by its HTTP status code.
+func DefaultErrorClassifier(err error) string {
+	var statusErr interface {
+		StatusCode() int
+	}
+	if errors.As(err, &statusErr) {
+		switch code := statusErr.StatusCode(); {
+		case code >= 500:
+			return ErrorCategoryHTTP5xx
+		case code >= 400:
+			return ErrorCategoryHTTP4xx
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorCategoryCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrorCategoryTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorCategoryConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return ErrorCategoryConnectionReset
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorCategoryEOF
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return ErrorCategoryTimeout
+		}
+		return ErrorCategoryDNS
+	}
+
+	// url.Error and net.OpError implement net.Error, so do the timeouts of http.Client
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorCategoryTimeout
+	}
+
+	return ErrorCategoryOther
+}
@@ -0,0 +1,85 @@
+package boomer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.code)
+}
+
+func (e *statusError) StatusCode() int {
+	return e.code
+}
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+func TestDefaultErrorClassifier(t *testing.T) {
+	opError := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: err}}
+	}
+	urlError := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://localhost/", Err: err}
+	}
+
+	cases := []struct {
+		err      error
+		category string
+	}{
+		{context.DeadlineExceeded, ErrorCategoryTimeout},
+		{urlError(context.DeadlineExceeded), ErrorCategoryTimeout},
+		{urlError(&timeoutError{}), ErrorCategoryTimeout},
+		{os.ErrDeadlineExceeded, ErrorCategoryTimeout},
+		{&net.DNSError{Err: "i/o timeout", Name: "foo", IsTimeout: true}, ErrorCategoryTimeout},
+		{context.Canceled, ErrorCategoryCanceled},
+		{urlError(opError(syscall.ECONNREFUSED)), ErrorCategoryConnectionRefused},
+		{opError(syscall.ECONNRESET), ErrorCategoryConnectionReset},
+		{opError(syscall.EPIPE), ErrorCategoryConnectionReset},
+		{urlError(&net.DNSError{Err: "no such host", Name: "foo", IsNotFound: true}), ErrorCategoryDNS},
+		{io.EOF, ErrorCategoryEOF},
+		{urlError(io.ErrUnexpectedEOF), ErrorCategoryEOF},
+		{&statusError{code: 503}, ErrorCategoryHTTP5xx},
+		{fmt.Errorf("request failed: %w", &statusError{code: 500}), ErrorCategoryHTTP5xx},
+		{&statusError{code: 404}, ErrorCategoryHTTP4xx},
+		{errors.New("something wrong"), ErrorCategoryOther},
+		{&statusError{code: 302}, ErrorCategoryOther},
+	}
+	for _, c := range cases {
+		if category := DefaultErrorClassifier(c.err); category != c.category {
+			t.Errorf("Expected %q to be classified as %s, got %s", c.err, c.category, category)
+		}
+	}
+}
+
+func TestDefaultErrorClassifierWithRealErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err = net.Dial("tcp", addr)
+	if err == nil {
+		t.Fatal("Connecting to a closed port should fail")
+	}
+	if category := DefaultErrorClassifier(err); category != ErrorCategoryConnectionRefused {
+		t.Errorf("Expected %q to be classified as connection_refused, got %s", err, category)
+	}
+}
@@ -1,9 +1,157 @@
 package boomer
 
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
 type client interface {
 	connect() (err error)
 	close()
 	recvChannel() chan *message
 	sendChannel() chan *message
 	disconnectedChannel() chan bool
+	// flush sends all the messages queued in the send channel, it returns false if they are not sent in timeout.
+	flush(timeout time.Duration) bool
+}
+
+// masterAddr is the address of a master, a slave can fail over between several masters.
+type masterAddr struct {
+	host string
+	port int
+}
+
+func (a masterAddr) String() string {
+	return net.JoinHostPort(a.host, strconv.Itoa(a.port))
+}
+
+// parseMasterAddr parses an address like "127.0.0.1:5557" or "[::1]:5557".
+func parseMasterAddr(addr string) (masterAddr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return masterAddr{}, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return masterAddr{}, fmt.Errorf("invalid port of master %s", addr)
+	}
+	return masterAddr{host: host, port: p}, nil
+}
+
+// curveKeyLength is the length of a Z85-encoded CurveZMQ key.
+const curveKeyLength = 40
+
+// curveOptions enables CurveZMQ encryption for the connection to master,
+// all the keys are Z85-encoded, which are generated by tools like curve_keygen.
+type curveOptions struct {
+	serverKey string
+	publicKey string
+	secretKey string
+}
+
+func (o *curveOptions) validate() error {
+	if o.serverKey == "" || o.publicKey == "" || o.secretKey == "" {
+		return errors.New("CurveZMQ needs the server key, the public key and the secret key")
+	}
+	for name, key := range map[string]string{"server": o.serverKey, "public": o.publicKey, "secret": o.secretKey} {
+		if len(key) != curveKeyLength {
+			return fmt.Errorf("the %s key of CurveZMQ should be %d characters Z85-encoded, got %d characters", name, curveKeyLength, len(key))
+		}
+	}
+	return nil
+}
+
+// resolveBindAddress resolves the local address to connect to master from, bindAddr is an IP address,
+// or the name of a network interface, like "eth1", whose first address is used.
+// It returns an error if the address isn't assigned to any local interface.
+func resolveBindAddress(bindAddr string) (*net.TCPAddr, error) {
+	if ip := net.ParseIP(bindAddr); ip != nil {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the local addresses to bind %s: %v", bindAddr, err)
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return &net.TCPAddr{IP: ip}, nil
+			}
+		}
+		return nil, fmt.Errorf("the bind address %s is not assigned to any local interface", bindAddr)
+	}
+
+	iface, err := net.InterfaceByName(bindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("the bind address %s is neither an IP address nor a local interface: %v", bindAddr, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("the interface %s to bind is down", bindAddr)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the addresses of interface %s: %v", bindAddr, err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			return &net.TCPAddr{IP: ipNet.IP}, nil
+		}
+	}
+	return nil, fmt.Errorf("the interface %s to bind has no IP address", bindAddr)
+}
+
+const (
+	maxSendAttempts       = 5
+	initialSendRetryDelay = 10 * time.Millisecond
+	maxSendRetryDelay     = time.Second
+)
+
+// sendWithRetry calls send until it succeeds, backing off exponentially between the attempts.
+// After maxSendAttempts failures, it publishes a "boomer:send_error" event with the message type
+// and the last error, then gives up. It gives up early without publishing if shutdown is closed.
+func sendWithRetry(send func([]byte) error, body []byte, msgType string, shutdown chan bool) (err error) {
+	delay := initialSendRetryDelay
+	for attempt := 1; ; attempt++ {
+		if err = send(body); err == nil {
+			return nil
+		}
+		if attempt >= maxSendAttempts {
+			break
+		}
+		logger.Debugf("Error sending %s message(attempt %d), retry in %v: %v", msgType, attempt, delay, err)
+		select {
+		case <-shutdown:
+			return err
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxSendRetryDelay {
+			delay = maxSendRetryDelay
+		}
+	}
+	Events.Publish("boomer:send_error", msgType, err)
+	return err
+}
+
+// requestFlush asks the send goroutine of a client to send the queued messages by requests,
+// and waits until they are sent. It gives up if timeout elapses, or shutdown is closed.
+func requestFlush(requests chan chan bool, shutdown chan bool, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	done := make(chan bool)
+	select {
+	case requests <- done:
+	case <-shutdown:
+		return false
+	case <-timer.C:
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-shutdown:
+		return false
+	case <-timer.C:
+		return false
+	}
 }
@@ -4,7 +4,7 @@ package boomer
 
 import (
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/zeromq/goczmq"
 )
@@ -13,6 +13,8 @@ type czmqSocketClient struct {
 	masterHost string
 	masterPort int
 	identity   string
+	curve      *curveOptions
+	bindAddr   string
 
 	dealerSocket *goczmq.Sock
 
@@ -20,10 +22,11 @@ type czmqSocketClient struct {
 	toMaster               chan *message
 	disconnectedFromMaster chan bool
 	shutdownChan           chan bool
+	flushRequests          chan chan bool
 }
 
 func newClient(masterHost string, masterPort int, identity string) (client *czmqSocketClient) {
-	log.Println("Boomer is built with goczmq support.")
+	logger.Debugf("Boomer is built with goczmq support.")
 	client = &czmqSocketClient{
 		masterHost:             masterHost,
 		masterPort:             masterPort,
@@ -32,15 +35,29 @@ func newClient(masterHost string, masterPort int, identity string) (client *czmq
 		toMaster:               make(chan *message, 100),
 		disconnectedFromMaster: make(chan bool),
 		shutdownChan:           make(chan bool),
+		flushRequests:          make(chan chan bool),
 	}
 
 	return client
 }
 
 func (c *czmqSocketClient) connect() (err error) {
-	addr := fmt.Sprintf("tcp://%s:%d", c.masterHost, c.masterPort)
+	addr, err := c.endpoint()
+	if err != nil {
+		return err
+	}
+	if c.curve != nil {
+		if err = c.curve.validate(); err != nil {
+			return err
+		}
+	}
 	dealer := goczmq.NewSock(goczmq.Dealer)
 	dealer.SetOption(goczmq.SockSetIdentity(c.identity))
+	if c.curve != nil {
+		dealer.SetOption(goczmq.SockSetCurveServerkey(c.curve.serverKey))
+		dealer.SetOption(goczmq.SockSetCurvePublickey(c.curve.publicKey))
+		dealer.SetOption(goczmq.SockSetCurveSecretkey(c.curve.secretKey))
+	}
 	err = dealer.Connect(addr)
 	if err != nil {
 		return err
@@ -48,7 +65,7 @@ func (c *czmqSocketClient) connect() (err error) {
 
 	c.dealerSocket = dealer
 
-	log.Printf("Boomer is connected to master(%s) press Ctrl+c to quit.\n", addr)
+	logger.Infof("Boomer is connected to master(%s) press Ctrl+c to quit.", addr)
 
 	go c.recv()
 	go c.send()
@@ -56,6 +73,23 @@ func (c *czmqSocketClient) connect() (err error) {
 	return nil
 }
 
+// endpoint returns the endpoint of master, like "tcp://10.0.0.2;10.0.0.1:5557"
+// if the connection is bound to 10.0.0.2.
+func (c *czmqSocketClient) endpoint() (string, error) {
+	if c.bindAddr == "" {
+		return fmt.Sprintf("tcp://%s:%d", c.masterHost, c.masterPort), nil
+	}
+	localAddr, err := resolveBindAddress(c.bindAddr)
+	if err != nil {
+		return "", err
+	}
+	source := localAddr.IP.String()
+	if localAddr.IP.To4() == nil {
+		source = "[" + source + "]"
+	}
+	return fmt.Sprintf("tcp://%s;%s:%d", source, c.masterHost, c.masterPort), nil
+}
+
 func (c *czmqSocketClient) close() {
 	close(c.shutdownChan)
 	c.dealerSocket.Destroy()
@@ -73,16 +107,16 @@ func (c *czmqSocketClient) recv() {
 		default:
 			msg, _, err := c.dealerSocket.RecvFrame()
 			if err != nil {
-				log.Printf("Error reading: %v\n", err)
+				logger.Errorf("Error reading: %v", err)
 				continue
 			}
 			decodedMsg, err := newMessageFromBytes(msg)
 			if err != nil {
-				log.Printf("Msgpack decode fail: %v\n", err)
+				logger.Errorf("Msgpack decode fail: %v", err)
 				continue
 			}
 			if decodedMsg.NodeID != c.identity {
-				log.Printf("Recv a %s message for node(%s), not for me(%s), dropped.\n", decodedMsg.Type, decodedMsg.NodeID, c.identity)
+				logger.Debugf("Recv a %s message for node(%s), not for me(%s), dropped.", decodedMsg.Type, decodedMsg.NodeID, c.identity)
 				continue
 			}
 			c.fromMaster <- decodedMsg
@@ -104,19 +138,31 @@ func (c *czmqSocketClient) send() {
 			if msg.Type == "quit" {
 				c.disconnectedFromMaster <- true
 			}
+		case done := <-c.flushRequests:
+			// the messages queued before the flush request
+			for n := len(c.toMaster); n > 0; n-- {
+				c.sendMessage(<-c.toMaster)
+			}
+			close(done)
 		}
 	}
 }
 
+func (c *czmqSocketClient) flush(timeout time.Duration) bool {
+	return requestFlush(c.flushRequests, c.shutdownChan, timeout)
+}
+
 func (c *czmqSocketClient) sendMessage(msg *message) {
 	serializedMessage, err := msg.serialize()
 	if err != nil {
-		log.Printf("Msgpack encode fail: %v\n", err)
+		logger.Errorf("Msgpack encode fail: %v", err)
 		return
 	}
-	err = c.dealerSocket.SendFrame(serializedMessage, goczmq.FlagNone)
+	err = sendWithRetry(func(body []byte) error {
+		return c.dealerSocket.SendFrame(body, goczmq.FlagNone)
+	}, serializedMessage, msg.Type, c.shutdownChan)
 	if err != nil {
-		log.Printf("Error sending: %v\n", err)
+		logger.Errorf("Error sending: %v", err)
 	}
 }
 
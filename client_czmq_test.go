@@ -0,0 +1,60 @@
+// +build goczmq
+
+package boomer
+
+import (
+	"testing"
+
+	"github.com/zeromq/goczmq"
+)
+
+func TestCzmqClientWithCurve(t *testing.T) {
+	serverCert := goczmq.NewCert()
+	defer serverCert.Destroy()
+	clientCert := goczmq.NewCert()
+	defer clientCert.Destroy()
+
+	client := newClient("127.0.0.1", 6557, "testing")
+	client.curve = &curveOptions{
+		serverKey: serverCert.PublicText(),
+		publicKey: clientCert.PublicText(),
+		secretKey: clientCert.SecretText(),
+	}
+	if err := client.connect(); err != nil {
+		t.Fatal("Failed to connect with CurveZMQ:", err)
+	}
+	defer client.close()
+
+	if client.dealerSocket.CurveServerkey() != serverCert.PublicText() {
+		t.Error("The server key of socket is wrong, got", client.dealerSocket.CurveServerkey())
+	}
+	if client.dealerSocket.CurvePublickey() != clientCert.PublicText() {
+		t.Error("The public key of socket is wrong, got", client.dealerSocket.CurvePublickey())
+	}
+}
+
+func TestCzmqClientWithInvalidCurve(t *testing.T) {
+	client := newClient("127.0.0.1", 6557, "testing")
+	client.curve = &curveOptions{serverKey: "server"}
+
+	if err := client.connect(); err == nil {
+		t.Error("Connecting with invalid CurveZMQ keys should fail")
+	}
+}
+
+func TestCzmqClientEndpoint(t *testing.T) {
+	client := newClient("127.0.0.1", 6557, "testing")
+	if endpoint, _ := client.endpoint(); endpoint != "tcp://127.0.0.1:6557" {
+		t.Error("Unexpected endpoint", endpoint)
+	}
+
+	client.bindAddr = "127.0.0.1"
+	if endpoint, _ := client.endpoint(); endpoint != "tcp://127.0.0.1;127.0.0.1:6557" {
+		t.Error("The endpoint should be bound to 127.0.0.1, got", endpoint)
+	}
+
+	client.bindAddr = "192.0.2.1"
+	if err := client.connect(); err == nil {
+		t.Error("Connecting from an address which isn't assigned to any local interface should fail")
+	}
+}
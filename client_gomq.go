@@ -3,8 +3,11 @@
 package boomer
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"net"
+	"strconv"
+	"time"
 
 	"github.com/zeromq/gomq"
 	"github.com/zeromq/gomq/zmtp"
@@ -14,6 +17,8 @@ type gomqSocketClient struct {
 	masterHost string
 	masterPort int
 	identity   string
+	curve      *curveOptions
+	bindAddr   string
 
 	dealerSocket gomq.Dealer
 
@@ -21,10 +26,11 @@ type gomqSocketClient struct {
 	toMaster               chan *message
 	disconnectedFromMaster chan bool
 	shutdownChan           chan bool
+	flushRequests          chan chan bool
 }
 
 func newClient(masterHost string, masterPort int, identity string) (client *gomqSocketClient) {
-	log.Println("Boomer is built with gomq support.")
+	logger.Debugf("Boomer is built with gomq support.")
 	client = &gomqSocketClient{
 		masterHost:             masterHost,
 		masterPort:             masterPort,
@@ -33,25 +39,56 @@ func newClient(masterHost string, masterPort int, identity string) (client *gomq
 		toMaster:               make(chan *message, 100),
 		disconnectedFromMaster: make(chan bool),
 		shutdownChan:           make(chan bool),
+		flushRequests:          make(chan chan bool),
 	}
 	return client
 }
 
 func (c *gomqSocketClient) connect() (err error) {
+	if c.curve != nil {
+		return errors.New("CurveZMQ is not supported by gomq, build boomer with -tags 'goczmq' to enable it")
+	}
 	addr := fmt.Sprintf("tcp://%s:%d", c.masterHost, c.masterPort)
 	c.dealerSocket = gomq.NewDealer(zmtp.NewSecurityNull(), c.identity)
 
-	if err = c.dealerSocket.Connect(addr); err != nil {
+	if c.bindAddr != "" {
+		err = c.connectFrom(c.bindAddr)
+	} else {
+		err = c.dealerSocket.Connect(addr)
+	}
+	if err != nil {
 		return err
 	}
 
-	log.Printf("Boomer is connected to master(%s) press Ctrl+c to quit.\n", addr)
+	logger.Infof("Boomer is connected to master(%s) press Ctrl+c to quit.", addr)
 	go c.recv()
 	go c.send()
 
 	return nil
 }
 
+// connectFrom does what gomq.Dealer.Connect does, but dials master from bindAddr.
+func (c *gomqSocketClient) connectFrom(bindAddr string) error {
+	localAddr, err := resolveBindAddress(bindAddr)
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{LocalAddr: localAddr}
+	netConn, err := dialer.Dial("tcp", net.JoinHostPort(c.masterHost, strconv.Itoa(c.masterPort)))
+	if err != nil {
+		return err
+	}
+	zmtpConn := zmtp.NewConnection(netConn)
+	_, err = zmtpConn.Prepare(c.dealerSocket.SecurityMechanism(), c.dealerSocket.SocketType(), c.dealerSocket.SocketIdentity(), false, nil)
+	if err != nil {
+		netConn.Close()
+		return err
+	}
+	c.dealerSocket.AddConnection(gomq.NewConnection(netConn, zmtpConn))
+	zmtpConn.Recv(c.dealerSocket.RecvChannel())
+	return nil
+}
+
 func (c *gomqSocketClient) close() {
 	close(c.shutdownChan)
 }
@@ -74,16 +111,16 @@ func (c *gomqSocketClient) recv() {
 			}
 			body, err := msg.Body[0], msg.Err
 			if err != nil {
-				log.Printf("Error reading: %v\n", err)
+				logger.Errorf("Error reading: %v", err)
 				continue
 			}
 			decodedMsg, err := newMessageFromBytes(body)
 			if err != nil {
-				log.Printf("Msgpack decode fail: %v\n", err)
+				logger.Errorf("Msgpack decode fail: %v", err)
 				continue
 			}
 			if decodedMsg.NodeID != c.identity {
-				log.Printf("Recv a %s message for node(%s), not for me(%s), dropped.\n", decodedMsg.Type, decodedMsg.NodeID, c.identity)
+				logger.Debugf("Recv a %s message for node(%s), not for me(%s), dropped.", decodedMsg.Type, decodedMsg.NodeID, c.identity)
 				continue
 			}
 			c.fromMaster <- decodedMsg
@@ -105,19 +142,29 @@ func (c *gomqSocketClient) send() {
 			if msg.Type == "quit" {
 				c.disconnectedFromMaster <- true
 			}
+		case done := <-c.flushRequests:
+			// the messages queued before the flush request
+			for n := len(c.toMaster); n > 0; n-- {
+				c.sendMessage(<-c.toMaster)
+			}
+			close(done)
 		}
 	}
 }
 
+func (c *gomqSocketClient) flush(timeout time.Duration) bool {
+	return requestFlush(c.flushRequests, c.shutdownChan, timeout)
+}
+
 func (c *gomqSocketClient) sendMessage(msg *message) {
 	serializedMessage, err := msg.serialize()
 	if err != nil {
-		log.Printf("Msgpack encode fail: %v\n", err)
+		logger.Errorf("Msgpack encode fail: %v", err)
 		return
 	}
-	err = c.dealerSocket.Send(serializedMessage)
+	err = sendWithRetry(c.dealerSocket.Send, serializedMessage, msg.Type, c.shutdownChan)
 	if err != nil {
-		log.Printf("Error sending: %v\n", err)
+		logger.Errorf("Error sending: %v", err)
 	}
 }
 
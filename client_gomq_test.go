@@ -198,3 +198,55 @@ func TestPingPong(t *testing.T) {
 		t.Error("client doesn't recv pong message")
 	}
 }
+
+func TestGomqClientWithCurve(t *testing.T) {
+	client := newClient("127.0.0.1", 6557, "testing")
+	client.curve = &curveOptions{}
+
+	err := client.connect()
+	if err == nil || !strings.Contains(err.Error(), "CurveZMQ") {
+		t.Error("gomq client should refuse to connect with CurveZMQ, got", err)
+	}
+}
+
+func TestGomqClientWithBindAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	remoteAddrs := make(chan net.Addr, 1)
+	go func() {
+		netConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		router := NewRouter(zmtp.NewSecurityNull(), "master")
+		zmtpConn := zmtp.NewConnection(netConn)
+		zmtpConn.Prepare(router.SecurityMechanism(), router.SocketType(), router.SocketIdentity(), true, nil)
+		remoteAddrs <- netConn.RemoteAddr()
+	}()
+
+	client := newClient("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, "testing")
+	client.bindAddr = "127.0.0.1"
+	if err = client.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.close()
+
+	select {
+	case addr := <-remoteAddrs:
+		if remoteIP := addr.(*net.TCPAddr).IP.String(); remoteIP != "127.0.0.1" {
+			t.Error("Master should see the connection from 127.0.0.1, got", remoteIP)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Master doesn't accept the connection")
+	}
+
+	client = newClient("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, "testing")
+	client.bindAddr = "no-such-interface"
+	if err = client.connect(); err == nil || !strings.Contains(err.Error(), "no-such-interface") {
+		t.Error("Connecting from an unknown interface should fail, got", err)
+	}
+}
@@ -0,0 +1,198 @@
+package boomer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	transportZMQ = "zmq"
+	transportTCP = "tcp"
+)
+
+// maxFrameSize limits the size of a message from master, to avoid allocating a huge buffer
+// for a corrupted length prefix.
+const maxFrameSize = 16 * 1024 * 1024
+
+const tcpDialTimeout = 5 * time.Second
+
+// tcpSocketClient speaks the same msgpack messages as the zmq clients over a plain TCP socket,
+// each message is prefixed with its length as a 4-byte big-endian unsigned integer.
+// It doesn't need cgo or libzmq, but the master must speak the same framing.
+type tcpSocketClient struct {
+	masterHost string
+	masterPort int
+	identity   string
+	curve      *curveOptions
+	bindAddr   string
+
+	conn      net.Conn
+	closeOnce sync.Once
+
+	fromMaster             chan *message
+	toMaster               chan *message
+	disconnectedFromMaster chan bool
+	shutdownChan           chan bool
+	flushRequests          chan chan bool
+}
+
+func newTCPClient(masterHost string, masterPort int, identity string) (client *tcpSocketClient) {
+	client = &tcpSocketClient{
+		masterHost:             masterHost,
+		masterPort:             masterPort,
+		identity:               identity,
+		fromMaster:             make(chan *message, 100),
+		toMaster:               make(chan *message, 100),
+		disconnectedFromMaster: make(chan bool),
+		shutdownChan:           make(chan bool),
+		flushRequests:          make(chan chan bool),
+	}
+	return client
+}
+
+func (c *tcpSocketClient) connect() (err error) {
+	if c.curve != nil {
+		return errors.New("CurveZMQ is not supported by the tcp transport")
+	}
+	dialer := &net.Dialer{Timeout: tcpDialTimeout}
+	if c.bindAddr != "" {
+		if dialer.LocalAddr, err = resolveBindAddress(c.bindAddr); err != nil {
+			return err
+		}
+	}
+	addr := net.JoinHostPort(c.masterHost, strconv.Itoa(c.masterPort))
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Boomer is connected to master(tcp://%s) press Ctrl+c to quit.", addr)
+	c.start(conn)
+	return nil
+}
+
+// start reads and writes messages on conn, which is a net.Pipe in tests.
+func (c *tcpSocketClient) start(conn net.Conn) {
+	c.conn = conn
+	go c.recv()
+	go c.send()
+}
+
+func (c *tcpSocketClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.shutdownChan)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+}
+
+func (c *tcpSocketClient) recvChannel() chan *message {
+	return c.fromMaster
+}
+
+func (c *tcpSocketClient) recv() {
+	for {
+		body, err := readFrame(c.conn)
+		if err != nil {
+			select {
+			case <-c.shutdownChan:
+			default:
+				logger.Errorf("Error reading: %v", err)
+			}
+			return
+		}
+		decodedMsg, err := newMessageFromBytes(body)
+		if err != nil {
+			logger.Errorf("Msgpack decode fail: %v", err)
+			continue
+		}
+		if decodedMsg.NodeID != c.identity {
+			logger.Debugf("Recv a %s message for node(%s), not for me(%s), dropped.", decodedMsg.Type, decodedMsg.NodeID, c.identity)
+			continue
+		}
+		select {
+		case c.fromMaster <- decodedMsg:
+		case <-c.shutdownChan:
+			return
+		}
+	}
+}
+
+func (c *tcpSocketClient) sendChannel() chan *message {
+	return c.toMaster
+}
+
+func (c *tcpSocketClient) send() {
+	for {
+		select {
+		case <-c.shutdownChan:
+			return
+		case msg := <-c.toMaster:
+			c.sendMessage(msg)
+			if msg.Type == "quit" {
+				c.disconnectedFromMaster <- true
+			}
+		case done := <-c.flushRequests:
+			// the messages queued before the flush request
+			for n := len(c.toMaster); n > 0; n-- {
+				c.sendMessage(<-c.toMaster)
+			}
+			close(done)
+		}
+	}
+}
+
+func (c *tcpSocketClient) flush(timeout time.Duration) bool {
+	return requestFlush(c.flushRequests, c.shutdownChan, timeout)
+}
+
+func (c *tcpSocketClient) sendMessage(msg *message) {
+	serializedMessage, err := msg.serialize()
+	if err != nil {
+		logger.Errorf("Msgpack encode fail: %v", err)
+		return
+	}
+	err = sendWithRetry(func(body []byte) error {
+		return writeFrame(c.conn, body)
+	}, serializedMessage, msg.Type, c.shutdownChan)
+	if err != nil {
+		logger.Errorf("Error sending: %v", err)
+	}
+}
+
+func (c *tcpSocketClient) disconnectedChannel() chan bool {
+	return c.disconnectedFromMaster
+}
+
+// writeFrame writes body prefixed with its length in a single write.
+func writeFrame(w io.Writer, body []byte) error {
+	frame := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	copy(frame[4:], body)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads a length-prefixed body.
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the limit of %d bytes", size, maxFrameSize)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
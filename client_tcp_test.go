@@ -0,0 +1,181 @@
+package boomer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	for _, body := range [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte("x"), 1024)} {
+		if err := writeFrame(buf, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, expected := range [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte("x"), 1024)} {
+		body, err := readFrame(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, expected) {
+			t.Errorf("Expected %d bytes, got %d bytes", len(expected), len(body))
+		}
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], maxFrameSize+1)
+	if _, err := readFrame(bytes.NewReader(header[:])); err == nil {
+		t.Error("Frames exceeding the limit should be rejected")
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], 10)
+	if _, err := readFrame(bytes.NewReader(append(header[:], "short"...))); err == nil {
+		t.Error("Truncated frames should be rejected")
+	}
+}
+
+func TestTCPClientOverPipe(t *testing.T) {
+	clientConn, masterConn := net.Pipe()
+	defer masterConn.Close()
+
+	client := newTCPClient("127.0.0.1", 5557, "testing")
+	client.start(clientConn)
+	defer client.close()
+
+	client.sendChannel() <- newMessage("client_ready", map[string]interface{}{"foo": "bar"}, "testing")
+	body, err := readFrame(masterConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := newMessageFromBytes(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "client_ready" || msg.NodeID != "testing" || string(msg.Data["foo"].([]byte)) != "bar" {
+		t.Error("Master doesn't recv the client_ready message, got", msg)
+	}
+
+	// messages for other nodes are dropped
+	for _, m := range []*message{newMessage("hatch", nil, "others"), newMessage("stop", nil, "testing")} {
+		serialized, err := m.serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = writeFrame(masterConn, serialized); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case msg = <-client.recvChannel():
+		if msg.Type != "stop" || msg.NodeID != "testing" {
+			t.Error("Client should only recv the stop message, got", msg.Type, msg.NodeID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Client doesn't recv the stop message")
+	}
+}
+
+func TestTCPClientConnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conns <- conn
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	client := newTCPClient("127.0.0.1", addr.Port, "testing")
+	if err = client.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.close()
+
+	masterConn := <-conns
+	defer masterConn.Close()
+
+	client.sendChannel() <- newMessage("ping", nil, "testing")
+	body, err := readFrame(masterConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := newMessageFromBytes(body); msg == nil || msg.Type != "ping" {
+		t.Error("Master doesn't recv the ping message")
+	}
+}
+
+func TestTCPClientConnectWithCurve(t *testing.T) {
+	client := newTCPClient("127.0.0.1", 5557, "testing")
+	client.curve = &curveOptions{}
+	if err := client.connect(); err == nil {
+		t.Error("CurveZMQ should not be supported by the tcp transport")
+	}
+}
+
+func TestTCPClientConnectRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	client := newTCPClient("127.0.0.1", port, "testing")
+	if err = client.connect(); err == nil {
+		t.Error(fmt.Sprintf("Connecting to a closed port(%d) should fail", port))
+	}
+}
+
+func TestTCPClientConnectWithBindAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conns <- conn
+		}
+	}()
+
+	client := newTCPClient("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, "testing")
+	client.bindAddr = "127.0.0.1"
+	if err = client.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.close()
+
+	masterConn := <-conns
+	defer masterConn.Close()
+	if localIP := client.conn.LocalAddr().(*net.TCPAddr).IP.String(); localIP != "127.0.0.1" {
+		t.Error("The connection should be bound to 127.0.0.1, got", localIP)
+	}
+	if remoteIP := masterConn.RemoteAddr().(*net.TCPAddr).IP.String(); remoteIP != "127.0.0.1" {
+		t.Error("Master should see the connection from 127.0.0.1, got", remoteIP)
+	}
+
+	client = newTCPClient("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, "testing")
+	client.bindAddr = "192.0.2.1"
+	if err = client.connect(); err == nil {
+		t.Error("Connecting from an address which isn't assigned to any local interface should fail")
+	}
+}
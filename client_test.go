@@ -0,0 +1,129 @@
+package boomer
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCurveOptionsValidate(t *testing.T) {
+	key := strings.Repeat("a", curveKeyLength)
+
+	options := &curveOptions{serverKey: key, publicKey: key, secretKey: key}
+	if err := options.validate(); err != nil {
+		t.Error("Valid keys should pass the validation, got", err)
+	}
+
+	options = &curveOptions{serverKey: key, publicKey: key}
+	if err := options.validate(); err == nil {
+		t.Error("Missing secret key should fail the validation")
+	}
+
+	options = &curveOptions{serverKey: key, publicKey: key, secretKey: "short"}
+	if err := options.validate(); err == nil || !strings.Contains(err.Error(), "secret key") {
+		t.Error("Invalid secret key should fail the validation, got", err)
+	}
+}
+
+// flakySender fails the first failures sends.
+type flakySender struct {
+	failures int
+	attempts int
+	sent     [][]byte
+}
+
+func (s *flakySender) send(body []byte) error {
+	s.attempts++
+	if s.attempts <= s.failures {
+		return errors.New("resource temporarily unavailable")
+	}
+	s.sent = append(s.sent, body)
+	return nil
+}
+
+func TestSendWithRetry(t *testing.T) {
+	sendErrors := make(chan string, 1)
+	onSendError := func(msgType string, err error) {
+		sendErrors <- msgType
+	}
+	Events.Subscribe("boomer:send_error", onSendError)
+	defer Events.Unsubscribe("boomer:send_error", onSendError)
+
+	sender := &flakySender{failures: 3}
+	start := time.Now()
+	if err := sendWithRetry(sender.send, []byte("stats"), "stats", make(chan bool)); err != nil {
+		t.Error("The message should be sent after retrying, got", err)
+	}
+	if sender.attempts != 4 || len(sender.sent) != 1 || string(sender.sent[0]) != "stats" {
+		t.Error("Expected 4 attempts and the message sent once, got", sender.attempts, len(sender.sent))
+	}
+	// 10ms + 20ms + 40ms
+	if elapsed := time.Since(start); elapsed < 7*initialSendRetryDelay {
+		t.Error("Retries should back off exponentially, took", elapsed)
+	}
+	select {
+	case msgType := <-sendErrors:
+		t.Error("boomer:send_error should not be published if the retry succeeds, got", msgType)
+	default:
+	}
+
+	sender = &flakySender{failures: maxSendAttempts}
+	if err := sendWithRetry(sender.send, []byte("heartbeat"), "heartbeat", make(chan bool)); err == nil {
+		t.Error("Expected an error after all the attempts fail")
+	}
+	if sender.attempts != maxSendAttempts || len(sender.sent) != 0 {
+		t.Error("Expected", maxSendAttempts, "attempts, got", sender.attempts)
+	}
+	select {
+	case msgType := <-sendErrors:
+		if msgType != "heartbeat" {
+			t.Error("Expected boomer:send_error of heartbeat, got", msgType)
+		}
+	default:
+		t.Error("boomer:send_error should be published after all the attempts fail")
+	}
+}
+
+func TestSendWithRetryShutdown(t *testing.T) {
+	shutdown := make(chan bool)
+	close(shutdown)
+
+	sender := &flakySender{failures: maxSendAttempts}
+	if err := sendWithRetry(sender.send, []byte("quit"), "quit", shutdown); err == nil {
+		t.Error("Expected an error")
+	}
+	if sender.attempts != 1 {
+		t.Error("Retrying should stop on shutdown, got", sender.attempts, "attempts")
+	}
+}
+
+func TestResolveBindAddress(t *testing.T) {
+	addr, err := resolveBindAddress("127.0.0.1")
+	if err != nil || addr.IP.String() != "127.0.0.1" || addr.Port != 0 {
+		t.Error("Expected to bind 127.0.0.1, got", addr, err)
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback == 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addr, err = resolveBindAddress(iface.Name)
+		if err != nil || !addr.IP.IsLoopback() {
+			t.Errorf("Expected to bind the address of loopback interface %s, got %v, %v", iface.Name, addr, err)
+		}
+		break
+	}
+
+	// 192.0.2.0/24 is reserved for documentation
+	for _, bindAddr := range []string{"192.0.2.1", "no-such-interface", ""} {
+		if _, err = resolveBindAddress(bindAddr); err == nil {
+			t.Errorf("Binding %q should fail", bindAddr)
+		}
+	}
+}
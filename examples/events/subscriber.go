@@ -24,6 +24,48 @@ func main() {
 		log.Println("The master asks me to spawn", workers, "goroutines with a hatch rate of", hatchRate, "per second.")
 	})
 
+	boomer.Events.Subscribe("boomer:hatch_progress", func(spawned, target int) {
+		log.Println(spawned, "of", target, "goroutines are spawned.")
+	})
+
+	boomer.Events.Subscribe("boomer:spawn_complete", func(workers int) {
+		log.Println("All the", workers, "goroutines are spawned.")
+	})
+
+	boomer.Events.Subscribe("boomer:cpu_warning", func(cpuPercent float64) {
+		log.Println("The cpu usage is", cpuPercent, "percent, the generated load may be unreliable.")
+	})
+
+	boomer.Events.Subscribe("boomer:stats", func(data map[string]interface{}) {
+		// custom fields are sent to master and outputs along with the stats
+		data["build"] = "v1.0.0"
+	})
+
+	boomer.Events.Subscribe("boomer:report", func(data map[string]interface{}) {
+		// the same report as master and outputs get, every 3 seconds
+		log.Println("The report of", data["user_count"], "goroutines is ready.")
+	})
+
+	boomer.Events.Subscribe("boomer:sla_violation", func(violation boomer.SLAViolation) {
+		log.Println("The SLA is violated,", violation.Metric, "is", violation.Actual, "exceeding", violation.Threshold)
+	})
+
+	boomer.Events.Subscribe("boomer:panic", func(taskName string, recovered interface{}) {
+		log.Println("The task", taskName, "panics,", recovered)
+	})
+
+	boomer.Events.Subscribe("boomer:circuit_open", func(taskName string) {
+		log.Println("The task", taskName, "keeps failing, it's not picked until the probe succeeds.")
+	})
+
+	boomer.Events.Subscribe("boomer:circuit_closed", func(taskName string) {
+		log.Println("The task", taskName, "is recovered.")
+	})
+
+	boomer.Events.Subscribe("boomer:send_error", func(msgType string, err error) {
+		log.Println("Failed to send", msgType, "to master,", err)
+	})
+
 	boomer.Events.Subscribe("boomer:stop", func() {
 		log.Println("The master asks me to stop.")
 	})
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/myzhan/boomer"
+)
+
+// This is an example about how to share a pooled http client by all the goroutines,
+// rather than every goroutine creating its own client and running out of sockets.
+
+func setup() (interface{}, error) {
+	transport := &http.Transport{
+		MaxIdleConns:        1000,
+		MaxIdleConnsPerHost: 1000,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}, nil
+}
+
+func teardown(shared interface{}) {
+	shared.(*http.Client).CloseIdleConnections()
+}
+
+func foo(ctx context.Context) {
+	client := boomer.SharedFromContext(ctx).(*http.Client)
+	request, err := http.NewRequestWithContext(ctx, "GET", "http://localhost:8080/", nil)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	start := time.Now()
+	response, err := client.Do(request)
+	elapsed := time.Since(start)
+	if err != nil {
+		globalBoomer.RecordFailure("http", "foo", elapsed.Nanoseconds()/int64(time.Millisecond), err.Error())
+		return
+	}
+	// drain the body, so the connection is reused
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+	globalBoomer.RecordSuccess("http", "foo", elapsed.Nanoseconds()/int64(time.Millisecond), response.ContentLength)
+}
+
+var globalBoomer *boomer.Boomer
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	globalBoomer = boomer.NewStandaloneBoomer(100, 10)
+	// setup is called once per hatch, not once per goroutine
+	globalBoomer.SetHatchSetupFunc(setup, teardown)
+	globalBoomer.Run(&boomer.Task{
+		Name:          "foo",
+		Weight:        10,
+		FnWithContext: foo,
+	})
+}
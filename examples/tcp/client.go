@@ -37,7 +37,7 @@ func worker() {
 			n, err := conn.Write([]byte("hello"))
 			elapsed := time.Since(start)
 			if err != nil {
-				boomer.RecordFailure("tcp", "write failure", elapsed.Nanoseconds()/int64(time.Millisecond), err.Error())
+				boomer.RecordError("tcp", "write failure", elapsed.Nanoseconds()/int64(time.Millisecond), err)
 				continue
 			}
 			// len("hello") == 5
@@ -50,7 +50,7 @@ func worker() {
 			n, err = conn.Read(readBuff)
 			elapsed = time.Since(start)
 			if err != nil {
-				boomer.RecordFailure("tcp", "read failure", elapsed.Nanoseconds()/int64(time.Millisecond), err.Error())
+				boomer.RecordError("tcp", "read failure", elapsed.Nanoseconds()/int64(time.Millisecond), err)
 				continue
 			}
 
@@ -5,7 +5,6 @@ package boomer
 import (
 	"flag"
 	"fmt"
-	"log"
 	"math"
 	"reflect"
 	"sync"
@@ -17,11 +16,16 @@ var masterPort int
 var maxRPS int64
 var requestIncreaseRate string
 var hatchType string
+var stepSize int
+var stepDuration time.Duration
+var spikeCount int
+var spikeDuration time.Duration
 var runTasks string
 var memoryProfile string
 var memoryProfileDuration time.Duration
 var cpuProfile string
 var cpuProfileDuration time.Duration
+var verboseLog bool
 
 var successRetiredWarning = &sync.Once{}
 var failureRetiredWarning = &sync.Once{}
@@ -29,15 +33,15 @@ var failureRetiredWarning = &sync.Once{}
 func createRateLimiter(maxRPS int64, requestIncreaseRate string) (rateLimiter RateLimiter, err error) {
 	if requestIncreaseRate != "-1" {
 		if maxRPS > 0 {
-			log.Println("The max RPS that boomer may generate is limited to", maxRPS, "with a increase rate", requestIncreaseRate)
+			logger.Infof("The max RPS that boomer may generate is limited to %d with a increase rate %s", maxRPS, requestIncreaseRate)
 			rateLimiter, err = NewRampUpRateLimiter(maxRPS, requestIncreaseRate, time.Second)
 		} else {
-			log.Println("The max RPS that boomer may generate is limited by a increase rate", requestIncreaseRate)
+			logger.Infof("The max RPS that boomer may generate is limited by a increase rate %s", requestIncreaseRate)
 			rateLimiter, err = NewRampUpRateLimiter(math.MaxInt64, requestIncreaseRate, time.Second)
 		}
 	} else {
 		if maxRPS > 0 {
-			log.Println("The max RPS that boomer may generate is limited to", maxRPS)
+			logger.Infof("The max RPS that boomer may generate is limited to %d", maxRPS)
 			rateLimiter = NewStableRateLimiter(maxRPS, time.Second)
 		}
 	}
@@ -60,14 +64,14 @@ func convertResponseTime(origin interface{}) int64 {
 
 func legacySuccessHandler(requestType string, name string, responseTime interface{}, responseLength int64) {
 	successRetiredWarning.Do(func() {
-		log.Println("boomer.Events.Publish(\"request_success\") is less performant and deprecated, use boomer.RecordSuccess() instead.")
+		logger.Infof("boomer.Events.Publish(\"request_success\") is less performant and deprecated, use boomer.RecordSuccess() instead.")
 	})
 	defaultBoomer.RecordSuccess(requestType, name, convertResponseTime(responseTime), responseLength)
 }
 
 func legacyFailureHandler(requestType string, name string, responseTime interface{}, exception string) {
 	failureRetiredWarning.Do(func() {
-		log.Println("boomer.Events.Publish(\"request_failure\") is less performant and deprecated, use boomer.RecordFailure() instead.")
+		logger.Infof("boomer.Events.Publish(\"request_failure\") is less performant and deprecated, use boomer.RecordFailure() instead.")
 	})
 	defaultBoomer.RecordFailure(requestType, name, convertResponseTime(responseTime), exception)
 }
@@ -80,7 +84,11 @@ func initLegacyEventHandlers() {
 func init() {
 	flag.Int64Var(&maxRPS, "max-rps", 0, "Max RPS that boomer can generate, disabled by default.")
 	flag.StringVar(&requestIncreaseRate, "request-increase-rate", "-1", "Request increase rate, disabled by default.")
-	flag.StringVar(&hatchType, "hatch-type", "asap", "How to create goroutines according to hatch rate, 'asap' will do it as soon as possible while 'smooth' means a constant pace.")
+	flag.StringVar(&hatchType, "hatch-type", "asap", "How to create goroutines according to hatch rate, 'asap' will do it as soon as possible while 'smooth' means a constant pace. 'step' and 'spike' are also supported.")
+	flag.IntVar(&stepSize, "step-size", 0, "The number of goroutines created in each step if hatch-type is step, defaults to hatch rate.")
+	flag.DurationVar(&stepDuration, "step-duration", time.Second, "The duration between two steps if hatch-type is step.")
+	flag.IntVar(&spikeCount, "spike-count", 0, "The number of extra goroutines created if hatch-type is spike.")
+	flag.DurationVar(&spikeDuration, "spike-duration", 10*time.Second, "How long the extra goroutines run if hatch-type is spike.")
 	flag.StringVar(&runTasks, "run-tasks", "", "Run tasks without connecting to the master, multiply tasks is separated by comma. Usually, it's for debug purpose.")
 	flag.StringVar(&masterHost, "master-host", "127.0.0.1", "Host or IP address of locust master for distributed load testing.")
 	flag.IntVar(&masterPort, "master-port", 5557, "The port to connect to that is used by the locust master for distributed load testing.")
@@ -88,4 +96,5 @@ func init() {
 	flag.DurationVar(&memoryProfileDuration, "mem-profile-duration", 30*time.Second, "Memory profile duration.")
 	flag.StringVar(&cpuProfile, "cpu-profile", "", "Enable CPU profiling.")
 	flag.DurationVar(&cpuProfileDuration, "cpu-profile-duration", 30*time.Second, "CPU profile duration.")
+	flag.BoolVar(&verboseLog, "verbose", false, "Write debug and info logs, only errors are written by default.")
 }
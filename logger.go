@@ -0,0 +1,56 @@
+package boomer
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Logger is used by boomer to write logs.
+// By default, logs are written by the standard log package, use SetLogger to replace it,
+// for example, with a structured logger like zap or logrus.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+var logger Logger = &stdLogger{}
+
+// SetLogger replaces the default logger.
+// It must be called before the test is started.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// verbose is 1 if the default logger writes debug and info logs.
+var verbose int32
+
+// SetVerbose makes the default logger write debug and info logs, like the progress of hatching
+// and the messages from master. By default, only errors are written.
+// It has no effect on the logger set by SetLogger, which decides the levels by itself.
+func SetVerbose(v bool) {
+	if v {
+		atomic.StoreInt32(&verbose, 1)
+	} else {
+		atomic.StoreInt32(&verbose, 0)
+	}
+}
+
+// stdLogger writes logs by the standard log package, so log.SetOutput and log.SetFlags still work.
+type stdLogger struct{}
+
+func (l *stdLogger) Debugf(format string, v ...interface{}) {
+	if atomic.LoadInt32(&verbose) == 1 {
+		log.Printf(format, v...)
+	}
+}
+
+func (l *stdLogger) Infof(format string, v ...interface{}) {
+	if atomic.LoadInt32(&verbose) == 1 {
+		log.Printf(format, v...)
+	}
+}
+
+func (l *stdLogger) Errorf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
@@ -0,0 +1,121 @@
+package boomer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type capturingLogger struct {
+	lock     sync.Mutex
+	messages map[string][]string
+}
+
+func newCapturingLogger() *capturingLogger {
+	return &capturingLogger{
+		messages: make(map[string][]string),
+	}
+}
+
+func (l *capturingLogger) log(level string, format string, v ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.messages[level] = append(l.messages[level], fmt.Sprintf(format, v...))
+}
+
+func (l *capturingLogger) Debugf(format string, v ...interface{}) {
+	l.log("debug", format, v...)
+}
+
+func (l *capturingLogger) Infof(format string, v ...interface{}) {
+	l.log("info", format, v...)
+}
+
+func (l *capturingLogger) Errorf(format string, v ...interface{}) {
+	l.log("error", format, v...)
+}
+
+func (l *capturingLogger) contains(level string, substr string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, message := range l.messages[level] {
+		if strings.Contains(message, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSetLogger(t *testing.T) {
+	capturing := newCapturingLogger()
+	SetLogger(capturing)
+	defer SetLogger(&stdLogger{})
+
+	runner := &runner{}
+	runner.tasks = []*Task{
+		{
+			Fn: func() {},
+		},
+	}
+	runner.hatchRate = 10
+	runner.workersWaitGroup = &sync.WaitGroup{}
+	runner.stopChan = make(chan bool)
+	defer close(runner.stopChan)
+
+	runner.spawnWorkers(0, runner.stopChan, nil)
+
+	if !capturing.contains("info", "Hatching and swarming 0 clients at the rate 10 clients/s...") {
+		t.Error("The hatch message should be logged at info level, got", capturing.messages)
+	}
+
+	runner.safeRun(func() {
+		panic("Runner will catch this panic")
+	})
+	if !capturing.contains("error", "Runner will catch this panic") {
+		t.Error("The panic should be logged at error level, got", capturing.messages)
+	}
+}
+
+func TestSetVerbose(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetVerbose(false)
+
+	hatch := func() {
+		runner := &runner{}
+		runner.tasks = []*Task{
+			{
+				Fn: func() {},
+			},
+		}
+		runner.hatchRate = 10
+		runner.workersWaitGroup = &sync.WaitGroup{}
+		runner.stopChan = make(chan bool)
+		defer close(runner.stopChan)
+		runner.spawnWorkers(0, runner.stopChan, nil)
+	}
+
+	SetVerbose(false)
+	hatch()
+	if buf.Len() != 0 {
+		t.Error("Nothing should be logged during a hatch if verbose is off, got", buf.String())
+	}
+
+	// errors are always logged
+	logger.Errorf("something wrong")
+	if !strings.Contains(buf.String(), "something wrong") {
+		t.Error("Errors should be logged if verbose is off, got", buf.String())
+	}
+
+	buf.Reset()
+	SetVerbose(true)
+	hatch()
+	if !strings.Contains(buf.String(), "Hatching and swarming 0 clients") {
+		t.Error("The hatch message should be logged if verbose is on, got", buf.String())
+	}
+}
@@ -8,6 +8,11 @@ var (
 	mh codec.MsgpackHandle
 )
 
+func init() {
+	// set once here, serialize and newMessageFromBytes are called concurrently by the send and recv goroutines.
+	mh.StructToArray = true
+}
+
 type message struct {
 	Type   string                 `codec:"type"`
 	Data   map[string]interface{} `codec:"data"`
@@ -23,14 +28,12 @@ func newMessage(t string, data map[string]interface{}, nodeID string) (msg *mess
 }
 
 func (m *message) serialize() (out []byte, err error) {
-	mh.StructToArray = true
 	enc := codec.NewEncoderBytes(&out, &mh)
 	err = enc.Encode(m)
 	return out, err
 }
 
 func newMessageFromBytes(raw []byte) (newMsg *message, err error) {
-	mh.StructToArray = true
 	dec := codec.NewDecoderBytes(raw, &mh)
 	newMsg = &message{}
 	err = dec.Decode(newMsg)
@@ -1,13 +1,35 @@
 package boomer
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/myzhan/boomer/boomerpb"
 	"github.com/olekukonko/tablewriter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 // Output is primarily responsible for printing test results to different destinations
@@ -16,7 +38,8 @@ import (
 // When running in distribute mode, test results will be reported to master with or without
 // an output.
 // All the OnXXX function will be call in a separated goroutine, just in case some output will block.
-// But it will wait for all outputs return to avoid data lost.
+// But it will wait for all outputs return to avoid data lost, unless an output doesn't return in time,
+// which is skipped and logged.
 type Output interface {
 	// OnStart will be call before the test starts.
 	OnStart()
@@ -60,6 +83,31 @@ func getMedianResponseTime(numRequests int64, responseTimes map[int64]int64) int
 	return medianResponseTime
 }
 
+// getResponseTimePercentile returns the response time under which the given percent of requests are,
+// it uses the same algorithm as locust, see calculate_response_time_percentile in locust's stats.py.
+func getResponseTimePercentile(numRequests int64, responseTimes map[int64]int64, percent float64) int64 {
+	if numRequests == 0 || len(responseTimes) == 0 {
+		return 0
+	}
+	numOfRequests := int64(float64(numRequests) * percent)
+	sortedKeys := make([]int64, 0, len(responseTimes))
+	for k := range responseTimes {
+		sortedKeys = append(sortedKeys, k)
+	}
+	// in reverse order
+	sort.Slice(sortedKeys, func(i, j int) bool {
+		return sortedKeys[i] > sortedKeys[j]
+	})
+	processedCount := int64(0)
+	for _, k := range sortedKeys {
+		processedCount += responseTimes[k]
+		if numRequests-processedCount <= numOfRequests {
+			return k
+		}
+	}
+	return 0
+}
+
 func getAvgResponseTime(numRequests int64, totalResponseTime int64) (avgResponseTime float64) {
 	avgResponseTime = float64(0)
 	if numRequests != 0 {
@@ -104,6 +152,16 @@ func (o *ConsoleOutput) OnEvent(data map[string]interface{}) {
 
 	currentTime := time.Now()
 	println(fmt.Sprintf("Current time: %s", currentTime.Format("2006/01/02 15:04:05")))
+	printStatsTable(stats)
+
+	tasks, ok := data["tasks"].([]interface{})
+	if ok && len(tasks) > 0 {
+		println("Tasks:")
+		printStatsTable(tasks)
+	}
+}
+
+func printStatsTable(stats []interface{}) {
 	table := tablewriter.NewWriter(os.Stdout)
 	table.SetHeader([]string{"Type", "Name", "# requests", "# fails", "Median", "Average", "Min", "Max", "Content Size", "# reqs/sec"})
 
@@ -144,3 +202,1289 @@ func (o *ConsoleOutput) OnEvent(data map[string]interface{}) {
 	table.Render()
 	println()
 }
+
+// TableConsoleOutput prints a table of the requests with their counts, current RPS, failures and percentiles
+// since the output is started, which is refreshed in place on a terminal. If stdout is not a terminal, like
+// a file or a pipe, it falls back to print a plain line for each request on every event.
+type TableConsoleOutput struct {
+	writer     io.Writer
+	isTerminal bool
+	// the number of lines of the last table, which are overwritten by the next one.
+	lines int
+
+	rows map[requestKey]*tableConsoleRow
+}
+
+type tableConsoleRow struct {
+	numRequests   int64
+	numFailures   int64
+	currentRps    int64
+	responseTimes map[int64]int64
+}
+
+// NewTableConsoleOutput returns a TableConsoleOutput.
+func NewTableConsoleOutput() *TableConsoleOutput {
+	isTerminal := false
+	if info, err := os.Stdout.Stat(); err == nil {
+		isTerminal = info.Mode()&os.ModeCharDevice != 0
+	}
+	return &TableConsoleOutput{
+		writer:     os.Stdout,
+		isTerminal: isTerminal,
+	}
+}
+
+// OnStart clears the rows.
+func (o *TableConsoleOutput) OnStart() {
+	o.rows = make(map[requestKey]*tableConsoleRow)
+	o.lines = 0
+}
+
+// OnStop of TableConsoleOutput has nothing to do.
+func (o *TableConsoleOutput) OnStop() {
+
+}
+
+// OnEvent adds the stats to the rows, and prints them.
+func (o *TableConsoleOutput) OnEvent(data map[string]interface{}) {
+	stats, ok := data["stats"].([]interface{})
+	if !ok {
+		return
+	}
+	if o.rows == nil {
+		o.rows = make(map[requestKey]*tableConsoleRow)
+	}
+	for _, stat := range stats {
+		s := stat.(map[string]interface{})
+		key := requestKey{method: s["method"].(string), name: s["name"].(string)}
+		row, ok := o.rows[key]
+		if !ok {
+			row = &tableConsoleRow{responseTimes: make(map[int64]int64)}
+			o.rows[key] = row
+		}
+		numRequests := s["num_requests"].(int64)
+		row.numRequests += numRequests
+		row.numFailures += s["num_failures"].(int64)
+		row.currentRps = getCurrentRps(numRequests, s["num_reqs_per_sec"].(map[int64]int64))
+		for responseTime, count := range s["response_times"].(map[int64]int64) {
+			row.responseTimes[responseTime] += count
+		}
+	}
+
+	keys := make([]requestKey, 0, len(o.rows))
+	for key := range o.rows {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].name < keys[j].name
+	})
+
+	if o.isTerminal {
+		o.printTable(keys)
+	} else {
+		o.printLines(keys)
+	}
+}
+
+func (o *TableConsoleOutput) percentiles(row *tableConsoleRow) (p50, p95, p99 int64) {
+	return getResponseTimePercentile(row.numRequests, row.responseTimes, 0.5),
+		getResponseTimePercentile(row.numRequests, row.responseTimes, 0.95),
+		getResponseTimePercentile(row.numRequests, row.responseTimes, 0.99)
+}
+
+func (o *TableConsoleOutput) printTable(keys []requestKey) {
+	var buf bytes.Buffer
+	table := tablewriter.NewWriter(&buf)
+	table.SetHeader([]string{"Type", "Name", "# requests", "# reqs/sec", "# fails", "p50", "p95", "p99"})
+	for _, key := range keys {
+		row := o.rows[key]
+		p50, p95, p99 := o.percentiles(row)
+		table.Append([]string{
+			key.method,
+			key.name,
+			strconv.FormatInt(row.numRequests, 10),
+			strconv.FormatInt(row.currentRps, 10),
+			strconv.FormatInt(row.numFailures, 10),
+			strconv.FormatInt(p50, 10),
+			strconv.FormatInt(p95, 10),
+			strconv.FormatInt(p99, 10),
+		})
+	}
+	table.Render()
+
+	if o.lines > 0 {
+		// move the cursor up to the last table and clear it
+		fmt.Fprintf(o.writer, "\033[%dA\033[J", o.lines)
+	}
+	o.writer.Write(buf.Bytes())
+	o.lines = bytes.Count(buf.Bytes(), []byte("\n"))
+}
+
+func (o *TableConsoleOutput) printLines(keys []requestKey) {
+	currentTime := time.Now().Format("2006/01/02 15:04:05")
+	for _, key := range keys {
+		row := o.rows[key]
+		p50, p95, p99 := o.percentiles(row)
+		fmt.Fprintf(o.writer, "%s %s %s requests=%d rps=%d failures=%d p50=%d p95=%d p99=%d\n",
+			currentTime, key.method, key.name, row.numRequests, row.currentRps, row.numFailures, p50, p95, p99)
+	}
+}
+
+// MemoryOutput keeps all the events in memory, so tests of a task suite can inspect them.
+type MemoryOutput struct {
+	lock       sync.Mutex
+	events     []map[string]interface{}
+	startCount int
+	stopCount  int
+}
+
+// NewMemoryOutput returns a MemoryOutput.
+func NewMemoryOutput() *MemoryOutput {
+	return &MemoryOutput{}
+}
+
+// OnStart counts the calls.
+func (o *MemoryOutput) OnStart() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.startCount++
+}
+
+// OnStop counts the calls.
+func (o *MemoryOutput) OnStop() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.stopCount++
+}
+
+// OnEvent records the event.
+func (o *MemoryOutput) OnEvent(data map[string]interface{}) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.events = append(o.events, data)
+}
+
+// Events returns a copy of the events recorded so far, in the order they are received.
+// The events are shared with other outputs, they shouldn't be modified.
+func (o *MemoryOutput) Events() []map[string]interface{} {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	events := make([]map[string]interface{}, len(o.events))
+	copy(events, o.events)
+	return events
+}
+
+// StartCount returns how many times OnStart is called.
+func (o *MemoryOutput) StartCount() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.startCount
+}
+
+// StopCount returns how many times OnStop is called.
+func (o *MemoryOutput) StopCount() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.stopCount
+}
+
+// PrometheusOutput exposes the test results on /metrics for prometheus to scrape.
+type PrometheusOutput struct {
+	addr     string
+	registry *prometheus.Registry
+	listener net.Listener
+	server   *http.Server
+
+	// the names of the labels of the requests, which are exported besides method and name.
+	labelNames []string
+
+	requestsTotal *prometheus.CounterVec
+	failuresTotal *prometheus.CounterVec
+	responseTime  *prometheus.SummaryVec
+	currentRPS    prometheus.Gauge
+	users         prometheus.Gauge
+}
+
+// NewPrometheusOutput returns a PrometheusOutput, which serves metrics on addr, like ":9646".
+func NewPrometheusOutput(addr string) *PrometheusOutput {
+	o := &PrometheusOutput{
+		addr: addr,
+	}
+	o.register()
+	return o
+}
+
+// SetLabelNames exports the labels of the requests named names, which are recorded by
+// RecordSuccessWithLabels and RecordFailureWithLabels, like "tenant" and "shard". The other labels
+// are ignored, and the missing ones are empty. It must be called before the output is started.
+func (o *PrometheusOutput) SetLabelNames(names ...string) {
+	o.labelNames = names
+	o.register()
+}
+
+// register creates the metrics with the label names in a new registry.
+func (o *PrometheusOutput) register() {
+	labelNames := append([]string{"method", "name"}, o.labelNames...)
+	o.registry = prometheus.NewRegistry()
+	o.requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "boomer",
+		Name:      "requests_total",
+		Help:      "The number of requests.",
+	}, labelNames)
+	o.failuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "boomer",
+		Name:      "failures_total",
+		Help:      "The number of failures.",
+	}, labelNames)
+	o.responseTime = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace:  "boomer",
+		Name:       "response_time_milliseconds",
+		Help:       "The response time in milliseconds.",
+		Objectives: map[float64]float64{0.5: 0.01, 0.9: 0.01, 0.95: 0.005, 0.99: 0.001},
+	}, labelNames)
+	o.currentRPS = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "boomer",
+		Name:      "current_rps",
+		Help:      "The current requests per second.",
+	})
+	o.users = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "boomer",
+		Name:      "users",
+		Help:      "The number of users.",
+	})
+	o.registry.MustRegister(o.requestsTotal, o.failuresTotal, o.responseTime, o.currentRPS, o.users)
+}
+
+// OnStart starts the http server.
+func (o *PrometheusOutput) OnStart() {
+	listener, err := net.Listen("tcp", o.addr)
+	if err != nil {
+		logger.Errorf("Failed to start prometheus output on %s, %v", o.addr, err)
+		return
+	}
+	o.listener = listener
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(o.registry, promhttp.HandlerOpts{}))
+	o.server = &http.Server{Handler: mux}
+	go o.server.Serve(listener)
+}
+
+// OnStop shuts down the http server.
+func (o *PrometheusOutput) OnStop() {
+	if o.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	o.server.Shutdown(ctx)
+}
+
+// OnEvent updates the metrics.
+func (o *PrometheusOutput) OnEvent(data map[string]interface{}) {
+	if userCount, ok := data["user_count"].(int32); ok {
+		o.users.Set(float64(userCount))
+	}
+
+	if statsTotal, ok := data["stats_total"].(map[string]interface{}); ok {
+		numRequests, _ := statsTotal["num_requests"].(int64)
+		numReqsPerSecond, _ := statsTotal["num_reqs_per_sec"].(map[int64]int64)
+		o.currentRPS.Set(float64(getCurrentRps(numRequests, numReqsPerSecond)))
+	}
+
+	stats, ok := data["stats"].([]interface{})
+	if !ok {
+		return
+	}
+	for _, stat := range stats {
+		s := stat.(map[string]interface{})
+		labelValues := []string{s["method"].(string), s["name"].(string)}
+		labels, _ := s["labels"].(map[string]string)
+		for _, labelName := range o.labelNames {
+			labelValues = append(labelValues, labels[labelName])
+		}
+		o.requestsTotal.WithLabelValues(labelValues...).Add(float64(s["num_requests"].(int64)))
+		o.failuresTotal.WithLabelValues(labelValues...).Add(float64(s["num_failures"].(int64)))
+		summary := o.responseTime.WithLabelValues(labelValues...)
+		for responseTime, count := range s["response_times"].(map[int64]int64) {
+			for i := int64(0); i < count; i++ {
+				summary.Observe(float64(responseTime))
+			}
+		}
+	}
+}
+
+// CSVOutput writes a summary of the test results to a csv file, one row per event.
+type CSVOutput struct {
+	path   string
+	file   *os.File
+	writer *csv.Writer
+}
+
+// NewCSVOutput returns a CSVOutput, which writes to path.
+func NewCSVOutput(path string) *CSVOutput {
+	return &CSVOutput{
+		path: path,
+	}
+}
+
+// OnStart creates or truncates the csv file and writes the header.
+func (o *CSVOutput) OnStart() {
+	file, err := os.Create(o.path)
+	if err != nil {
+		logger.Errorf("Failed to create csv output %s, %v", o.path, err)
+		return
+	}
+	o.file = file
+	o.writer = csv.NewWriter(file)
+	o.write([]string{"timestamp", "user_count", "current_rps", "num_failures", "median_response_time"})
+}
+
+// OnStop flushes and closes the csv file.
+func (o *CSVOutput) OnStop() {
+	if o.file == nil {
+		return
+	}
+	o.writer.Flush()
+	if err := o.writer.Error(); err != nil {
+		logger.Errorf("Failed to flush csv output %s, %v", o.path, err)
+	}
+	if err := o.file.Close(); err != nil {
+		logger.Errorf("Failed to close csv output %s, %v", o.path, err)
+	}
+	o.file = nil
+}
+
+// OnEvent writes a row of the total stats.
+func (o *CSVOutput) OnEvent(data map[string]interface{}) {
+	if o.file == nil {
+		return
+	}
+	statsTotal, ok := data["stats_total"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	userCount, _ := data["user_count"].(int32)
+	numRequests, _ := statsTotal["num_requests"].(int64)
+	numFailures, _ := statsTotal["num_failures"].(int64)
+	numReqsPerSecond, _ := statsTotal["num_reqs_per_sec"].(map[int64]int64)
+	responseTimes, _ := statsTotal["response_times"].(map[int64]int64)
+
+	o.write([]string{
+		time.Now().Format(time.RFC3339),
+		strconv.FormatInt(int64(userCount), 10),
+		strconv.FormatInt(getCurrentRps(numRequests, numReqsPerSecond), 10),
+		strconv.FormatInt(numFailures, 10),
+		strconv.FormatInt(getMedianResponseTime(numRequests, responseTimes), 10),
+	})
+}
+
+func (o *CSVOutput) write(row []string) {
+	if err := o.writer.Write(row); err != nil {
+		logger.Errorf("Failed to write csv output %s, %v", o.path, err)
+		return
+	}
+	// flush every row, so the file is still useful if boomer crashes
+	o.writer.Flush()
+	if err := o.writer.Error(); err != nil {
+		logger.Errorf("Failed to write csv output %s, %v", o.path, err)
+	}
+}
+
+// OpenMetricsFileOutput writes the test results to a new OpenMetrics text file in dir on every event,
+// like boomer_20060102T150405.000000000Z.prom, for a sidecar to ship them in an air-gapped environment.
+// The counters and the histogram accumulate across the files, only the latest files are kept.
+type OpenMetricsFileOutput struct {
+	dir       string
+	retention int
+	registry  *prometheus.Registry
+
+	requestsTotal *prometheus.CounterVec
+	failuresTotal *prometheus.CounterVec
+	responseTime  *prometheus.HistogramVec
+	currentRPS    prometheus.Gauge
+	users         prometheus.Gauge
+}
+
+// defaultOpenMetricsRetention is the number of files kept by OpenMetricsFileOutput by default.
+const defaultOpenMetricsRetention = 10
+
+// NewOpenMetricsFileOutput returns an OpenMetricsFileOutput, which writes to dir and keeps the latest 10 files.
+func NewOpenMetricsFileOutput(dir string) *OpenMetricsFileOutput {
+	o := &OpenMetricsFileOutput{
+		dir:       dir,
+		retention: defaultOpenMetricsRetention,
+		registry:  prometheus.NewRegistry(),
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "boomer",
+			Name:      "requests_total",
+			Help:      "The number of requests.",
+		}, []string{"method", "name"}),
+		failuresTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "boomer",
+			Name:      "failures_total",
+			Help:      "The number of failures.",
+		}, []string{"method", "name"}),
+		responseTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "boomer",
+			Name:      "response_time_milliseconds",
+			Help:      "The response time in milliseconds.",
+			Buckets:   []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000},
+		}, []string{"method", "name"}),
+		currentRPS: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "boomer",
+			Name:      "current_rps",
+			Help:      "The current requests per second.",
+		}),
+		users: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "boomer",
+			Name:      "users",
+			Help:      "The number of users.",
+		}),
+	}
+	o.registry.MustRegister(o.requestsTotal, o.failuresTotal, o.responseTime, o.currentRPS, o.users)
+	return o
+}
+
+// SetRetention keeps the latest count files in dir, the older ones are removed, 0 keeps all of them.
+func (o *OpenMetricsFileOutput) SetRetention(count int) {
+	if count < 0 {
+		logger.Errorf("Wrong retention of openmetrics files, expected 0 or a positive count, was %d", count)
+		return
+	}
+	o.retention = count
+}
+
+// OnStart creates dir if it doesn't exist.
+func (o *OpenMetricsFileOutput) OnStart() {
+	if err := os.MkdirAll(o.dir, 0755); err != nil {
+		logger.Errorf("Failed to create the directory of openmetrics output %s, %v", o.dir, err)
+	}
+}
+
+// OnStop of OpenMetricsFileOutput has nothing to do.
+func (o *OpenMetricsFileOutput) OnStop() {
+
+}
+
+// OnEvent updates the metrics and writes all of them to a new file.
+func (o *OpenMetricsFileOutput) OnEvent(data map[string]interface{}) {
+	if userCount, ok := data["user_count"].(int32); ok {
+		o.users.Set(float64(userCount))
+	}
+
+	if statsTotal, ok := data["stats_total"].(map[string]interface{}); ok {
+		numRequests, _ := statsTotal["num_requests"].(int64)
+		numReqsPerSecond, _ := statsTotal["num_reqs_per_sec"].(map[int64]int64)
+		o.currentRPS.Set(float64(getCurrentRps(numRequests, numReqsPerSecond)))
+	}
+
+	if stats, ok := data["stats"].([]interface{}); ok {
+		for _, stat := range stats {
+			s := stat.(map[string]interface{})
+			method, name := s["method"].(string), s["name"].(string)
+			o.requestsTotal.WithLabelValues(method, name).Add(float64(s["num_requests"].(int64)))
+			o.failuresTotal.WithLabelValues(method, name).Add(float64(s["num_failures"].(int64)))
+			histogram := o.responseTime.WithLabelValues(method, name)
+			for responseTime, count := range s["response_times"].(map[int64]int64) {
+				for i := int64(0); i < count; i++ {
+					histogram.Observe(float64(responseTime))
+				}
+			}
+		}
+	}
+
+	if err := o.write(); err != nil {
+		logger.Errorf("Failed to write openmetrics output to %s, %v", o.dir, err)
+		return
+	}
+	o.removeOldFiles()
+}
+
+// write writes to a temporary file and renames it, so the sidecar never ships a partial file.
+func (o *OpenMetricsFileOutput) write() error {
+	families, err := o.registry.Gather()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(o.dir, "boomer_"+time.Now().UTC().Format("20060102T150405.000000000Z")+".prom")
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, family := range families {
+		if _, err = expfmt.MetricFamilyToOpenMetrics(writer, family); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		_, err = expfmt.FinalizeOpenMetrics(writer)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// removeOldFiles removes the files beyond the retention, the names sort by time.
+func (o *OpenMetricsFileOutput) removeOldFiles() {
+	if o.retention == 0 {
+		return
+	}
+	paths, err := filepath.Glob(filepath.Join(o.dir, "boomer_*.prom"))
+	if err != nil || len(paths) <= o.retention {
+		return
+	}
+	sort.Strings(paths)
+	for _, path := range paths[:len(paths)-o.retention] {
+		if err := os.Remove(path); err != nil {
+			logger.Errorf("Failed to remove the old openmetrics file %s, %v", path, err)
+		}
+	}
+}
+
+// StatsdOutput sends the test results to statsd over UDP, which never blocks the runner
+// even if statsd is down.
+type StatsdOutput struct {
+	addr   string
+	prefix string
+	conn   net.Conn
+}
+
+// NewStatsdOutput returns a StatsdOutput, which sends metrics to addr, like "127.0.0.1:8125".
+// All the metric names start with prefix, like "boomer".
+func NewStatsdOutput(addr, prefix string) *StatsdOutput {
+	return &StatsdOutput{
+		addr:   addr,
+		prefix: strings.TrimSuffix(prefix, "."),
+	}
+}
+
+// OnStart creates the UDP connection.
+func (o *StatsdOutput) OnStart() {
+	conn, err := net.Dial("udp", o.addr)
+	if err != nil {
+		logger.Errorf("Failed to start statsd output on %s, %v", o.addr, err)
+		return
+	}
+	o.conn = conn
+}
+
+// OnStop closes the UDP connection.
+func (o *StatsdOutput) OnStop() {
+	if o.conn == nil {
+		return
+	}
+	o.conn.Close()
+	o.conn = nil
+}
+
+// OnEvent sends the request totals and failures as counters, the current RPS and user count as gauges.
+func (o *StatsdOutput) OnEvent(data map[string]interface{}) {
+	if o.conn == nil {
+		return
+	}
+
+	if userCount, ok := data["user_count"].(int32); ok {
+		o.send("users", int64(userCount), "g")
+	}
+
+	if statsTotal, ok := data["stats_total"].(map[string]interface{}); ok {
+		numRequests, _ := statsTotal["num_requests"].(int64)
+		numFailures, _ := statsTotal["num_failures"].(int64)
+		numReqsPerSecond, _ := statsTotal["num_reqs_per_sec"].(map[int64]int64)
+		o.send("requests", numRequests, "c")
+		o.send("failures", numFailures, "c")
+		o.send("current_rps", getCurrentRps(numRequests, numReqsPerSecond), "g")
+	}
+
+	stats, ok := data["stats"].([]interface{})
+	if !ok {
+		return
+	}
+	for _, stat := range stats {
+		s := stat.(map[string]interface{})
+		key := statsdKey(s["method"].(string)) + "." + statsdKey(s["name"].(string))
+		o.send("requests."+key, s["num_requests"].(int64), "c")
+		o.send("failures."+key, s["num_failures"].(int64), "c")
+	}
+}
+
+func (o *StatsdOutput) send(name string, value int64, metricType string) {
+	if o.prefix != "" {
+		name = o.prefix + "." + name
+	}
+	line := fmt.Sprintf("%s:%d|%s", name, value, metricType)
+	if _, err := o.conn.Write([]byte(line)); err != nil {
+		logger.Debugf("Failed to send %s to statsd, %v", line, err)
+	}
+}
+
+var statsdInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
+
+// statsdKey replaces the characters not allowed in a statsd key, like ":", "|" and ".".
+func statsdKey(name string) string {
+	return statsdInvalidChars.ReplaceAllString(name, "_")
+}
+
+// DogStatsdOutput sends the test results to the DogStatsD of Datadog over UDP, like StatsdOutput,
+// the metrics are tagged with the node ID, and the method and name of each request.
+type DogStatsdOutput struct {
+	addr   string
+	tags   []string
+	nodeID string
+	conn   net.Conn
+}
+
+// NewDogStatsdOutput returns a DogStatsdOutput, which sends metrics to addr, like "127.0.0.1:8125".
+// All the metrics are tagged with tags, like "service:api" and "env:prod".
+func NewDogStatsdOutput(addr string, tags []string) *DogStatsdOutput {
+	return &DogStatsdOutput{
+		addr:   addr,
+		tags:   tags,
+		nodeID: getNodeID(),
+	}
+}
+
+// OnStart creates the UDP connection.
+func (o *DogStatsdOutput) OnStart() {
+	conn, err := net.Dial("udp", o.addr)
+	if err != nil {
+		logger.Errorf("Failed to start dogstatsd output on %s, %v", o.addr, err)
+		return
+	}
+	o.conn = conn
+}
+
+// OnStop closes the UDP connection.
+func (o *DogStatsdOutput) OnStop() {
+	if o.conn == nil {
+		return
+	}
+	o.conn.Close()
+	o.conn = nil
+}
+
+// OnEvent sends the request totals and failures as counters, the current RPS and user count as gauges,
+// and the response times of each request as a distribution.
+func (o *DogStatsdOutput) OnEvent(data map[string]interface{}) {
+	if o.conn == nil {
+		return
+	}
+
+	nodeID := o.nodeID
+	if id, ok := data["node_id"].(string); ok {
+		nodeID = id
+	}
+	tags := append(append([]string{}, o.tags...), "node_id:"+dogStatsdTag(nodeID))
+
+	if userCount, ok := data["user_count"].(int32); ok {
+		o.send("boomer.users", int64(userCount), "g", tags)
+	}
+
+	if statsTotal, ok := data["stats_total"].(map[string]interface{}); ok {
+		numRequests, _ := statsTotal["num_requests"].(int64)
+		numFailures, _ := statsTotal["num_failures"].(int64)
+		numReqsPerSecond, _ := statsTotal["num_reqs_per_sec"].(map[int64]int64)
+		o.send("boomer.requests", numRequests, "c", tags)
+		o.send("boomer.failures", numFailures, "c", tags)
+		o.send("boomer.current_rps", getCurrentRps(numRequests, numReqsPerSecond), "g", tags)
+	}
+
+	stats, ok := data["stats"].([]interface{})
+	if !ok {
+		return
+	}
+	for _, stat := range stats {
+		s := stat.(map[string]interface{})
+		requestTags := append(tags[:len(tags):len(tags)],
+			"method:"+dogStatsdTag(s["method"].(string)), "name:"+dogStatsdTag(s["name"].(string)))
+		o.send("boomer.request.requests", s["num_requests"].(int64), "c", requestTags)
+		o.send("boomer.request.failures", s["num_failures"].(int64), "c", requestTags)
+		responseTimes, _ := s["response_times"].(map[int64]int64)
+		for responseTime, count := range responseTimes {
+			// a sample rate of 1/count makes DogStatsD count the response time count times
+			o.send("boomer.request.response_time", responseTime, fmt.Sprintf("d|@%g", 1/float64(count)), requestTags)
+		}
+	}
+}
+
+func (o *DogStatsdOutput) send(name string, value int64, metricType string, tags []string) {
+	line := fmt.Sprintf("%s:%d|%s|#%s", name, value, metricType, strings.Join(tags, ","))
+	if _, err := o.conn.Write([]byte(line)); err != nil {
+		logger.Debugf("Failed to send %s to dogstatsd, %v", line, err)
+	}
+}
+
+var dogStatsdInvalidChars = regexp.MustCompile(`[|,#\s]`)
+
+// dogStatsdTag replaces the characters not allowed in a tag value, like "|", "," and "#".
+func dogStatsdTag(value string) string {
+	return dogStatsdInvalidChars.ReplaceAllString(value, "_")
+}
+
+// GraphiteOutput writes the test results to graphite with the plaintext protocol over TCP.
+// If the connection is lost, it reconnects on the next event, and the results in between are dropped.
+type GraphiteOutput struct {
+	addr    string
+	prefix  string
+	conn    net.Conn
+	started bool
+}
+
+// graphiteTimeout limits connecting and writing to graphite, so a slow graphite never blocks the runner.
+const graphiteTimeout = 3 * time.Second
+
+// NewGraphiteOutput returns a GraphiteOutput, which sends metrics to addr, like "127.0.0.1:2003".
+// All the metric names start with prefix, like "boomer".
+func NewGraphiteOutput(addr, prefix string) *GraphiteOutput {
+	return &GraphiteOutput{
+		addr:   addr,
+		prefix: strings.TrimSuffix(prefix, "."),
+	}
+}
+
+// OnStart connects to graphite.
+func (o *GraphiteOutput) OnStart() {
+	o.started = true
+	o.connect()
+}
+
+// OnStop closes the connection.
+func (o *GraphiteOutput) OnStop() {
+	o.started = false
+	if o.conn == nil {
+		return
+	}
+	o.conn.Close()
+	o.conn = nil
+}
+
+// OnEvent writes the request totals, failures, current RPS and user count, and the totals and failures of each request.
+func (o *GraphiteOutput) OnEvent(data map[string]interface{}) {
+	if !o.started || (o.conn == nil && !o.connect()) {
+		return
+	}
+
+	timestamp := time.Now().Unix()
+	var buf strings.Builder
+	write := func(name string, value int64) {
+		if o.prefix != "" {
+			name = o.prefix + "." + name
+		}
+		fmt.Fprintf(&buf, "%s %d %d\n", name, value, timestamp)
+	}
+
+	if userCount, ok := data["user_count"].(int32); ok {
+		write("users", int64(userCount))
+	}
+
+	if statsTotal, ok := data["stats_total"].(map[string]interface{}); ok {
+		numRequests, _ := statsTotal["num_requests"].(int64)
+		numFailures, _ := statsTotal["num_failures"].(int64)
+		numReqsPerSecond, _ := statsTotal["num_reqs_per_sec"].(map[int64]int64)
+		write("requests", numRequests)
+		write("failures", numFailures)
+		write("current_rps", getCurrentRps(numRequests, numReqsPerSecond))
+	}
+
+	if stats, ok := data["stats"].([]interface{}); ok {
+		for _, stat := range stats {
+			s := stat.(map[string]interface{})
+			key := statsdKey(s["method"].(string)) + "." + statsdKey(s["name"].(string))
+			write("requests."+key, s["num_requests"].(int64))
+			write("failures."+key, s["num_failures"].(int64))
+		}
+	}
+
+	if buf.Len() == 0 {
+		return
+	}
+	o.conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+	if _, err := o.conn.Write([]byte(buf.String())); err != nil {
+		logger.Errorf("Failed to write to graphite %s, it will reconnect on the next event, %v", o.addr, err)
+		o.conn.Close()
+		o.conn = nil
+	}
+}
+
+func (o *GraphiteOutput) connect() bool {
+	conn, err := net.DialTimeout("tcp", o.addr, graphiteTimeout)
+	if err != nil {
+		logger.Errorf("Failed to connect to graphite %s, %v", o.addr, err)
+		return false
+	}
+	o.conn = conn
+	return true
+}
+
+// OTelOutput records the test results with OpenTelemetry instruments and exports them with exporter.
+// All the data points are attributed with the node ID, and the method and name of the request if any.
+type OTelOutput struct {
+	exporter sdkmetric.Exporter
+	nodeID   string
+	provider *sdkmetric.MeterProvider
+
+	requests     metric.Int64Counter
+	failures     metric.Int64Counter
+	responseTime metric.Int64Histogram
+	users        metric.Int64UpDownCounter
+	lastUsers    int64
+}
+
+// NewOTelOutput returns an OTelOutput, which exports metrics with exporter, like an OTLP exporter.
+func NewOTelOutput(exporter sdkmetric.Exporter) *OTelOutput {
+	return &OTelOutput{
+		exporter: exporter,
+		nodeID:   getNodeID(),
+	}
+}
+
+// OnStart creates the meter provider and the instruments.
+func (o *OTelOutput) OnStart() {
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(o.exporter)))
+	meter := provider.Meter("github.com/myzhan/boomer")
+
+	var err error
+	if o.requests, err = meter.Int64Counter("boomer.requests", metric.WithDescription("The number of requests.")); err != nil {
+		logger.Errorf("Failed to start otel output, %v", err)
+		return
+	}
+	if o.failures, err = meter.Int64Counter("boomer.failures", metric.WithDescription("The number of failures.")); err != nil {
+		logger.Errorf("Failed to start otel output, %v", err)
+		return
+	}
+	if o.responseTime, err = meter.Int64Histogram("boomer.response_time", metric.WithDescription("The response time."), metric.WithUnit("ms")); err != nil {
+		logger.Errorf("Failed to start otel output, %v", err)
+		return
+	}
+	if o.users, err = meter.Int64UpDownCounter("boomer.users", metric.WithDescription("The number of users.")); err != nil {
+		logger.Errorf("Failed to start otel output, %v", err)
+		return
+	}
+	o.lastUsers = 0
+	o.provider = provider
+}
+
+// OnStop flushes the remaining metrics to the exporter and shuts down the meter provider.
+func (o *OTelOutput) OnStop() {
+	if o.provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := o.provider.ForceFlush(ctx); err != nil {
+		logger.Errorf("Failed to flush otel output, %v", err)
+	}
+	if err := o.provider.Shutdown(ctx); err != nil {
+		logger.Errorf("Failed to shutdown otel output, %v", err)
+	}
+	o.provider = nil
+}
+
+// OnEvent records the requests, failures and response times of each request, and the change of user count.
+func (o *OTelOutput) OnEvent(data map[string]interface{}) {
+	if o.provider == nil {
+		return
+	}
+	ctx := context.Background()
+
+	nodeID := o.nodeID
+	if id, ok := data["node_id"].(string); ok {
+		nodeID = id
+	}
+	nodeAttr := attribute.String("node_id", nodeID)
+
+	if userCount, ok := data["user_count"].(int32); ok {
+		o.users.Add(ctx, int64(userCount)-o.lastUsers, metric.WithAttributes(nodeAttr))
+		o.lastUsers = int64(userCount)
+	}
+
+	stats, ok := data["stats"].([]interface{})
+	if !ok {
+		return
+	}
+	for _, stat := range stats {
+		s := stat.(map[string]interface{})
+		attrs := metric.WithAttributes(nodeAttr,
+			attribute.String("method", s["method"].(string)),
+			attribute.String("name", s["name"].(string)))
+		o.requests.Add(ctx, s["num_requests"].(int64), attrs)
+		o.failures.Add(ctx, s["num_failures"].(int64), attrs)
+		responseTimes, _ := s["response_times"].(map[int64]int64)
+		for responseTime, count := range responseTimes {
+			for i := int64(0); i < count; i++ {
+				o.responseTime.Record(ctx, responseTime, attrs)
+			}
+		}
+	}
+}
+
+// kafkaProducer is implemented by *kafka.Writer, it's replaced in tests.
+type kafkaProducer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaOutput produces every event as a JSON message to a kafka topic, keyed by the node ID.
+// Messages are batched and flushed asynchronously, so a slow or unavailable kafka never blocks the runner.
+type KafkaOutput struct {
+	brokers  []string
+	topic    string
+	nodeID   string
+	producer kafkaProducer
+}
+
+// NewKafkaOutput returns a KafkaOutput, which produces to topic on brokers, like []string{"127.0.0.1:9092"}.
+func NewKafkaOutput(brokers []string, topic string) *KafkaOutput {
+	return &KafkaOutput{
+		brokers: brokers,
+		topic:   topic,
+		nodeID:  getNodeID(),
+	}
+}
+
+// OnStart creates the producer.
+func (o *KafkaOutput) OnStart() {
+	o.producer = &kafka.Writer{
+		Addr:         kafka.TCP(o.brokers...),
+		Topic:        o.topic,
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: time.Second,
+		Async:        true,
+		Completion: func(messages []kafka.Message, err error) {
+			if err != nil {
+				logger.Errorf("Failed to produce %d messages to kafka topic %s, %v", len(messages), o.topic, err)
+			}
+		},
+	}
+}
+
+// OnStop flushes the pending messages and closes the producer.
+func (o *KafkaOutput) OnStop() {
+	if o.producer == nil {
+		return
+	}
+	if err := o.producer.Close(); err != nil {
+		logger.Errorf("Failed to close kafka output, %v", err)
+	}
+	o.producer = nil
+}
+
+// OnEvent serializes data as JSON and produces it, the key is the node ID in data, or of current process.
+func (o *KafkaOutput) OnEvent(data map[string]interface{}) {
+	if o.producer == nil {
+		return
+	}
+
+	nodeID := o.nodeID
+	if id, ok := data["node_id"].(string); ok {
+		nodeID = id
+	}
+	value, err := json.Marshal(data)
+	if err != nil {
+		logger.Errorf("Failed to serialize the event for kafka output, %v", err)
+		return
+	}
+	// the producer is asynchronous, it returns once the message is queued
+	err = o.producer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte(nodeID),
+		Value: value,
+	})
+	if err != nil {
+		logger.Errorf("Failed to produce the event to kafka topic %s, %v", o.topic, err)
+	}
+}
+
+const (
+	// ElasticsearchOutput flushes the buffered documents every elasticsearchFlushInterval.
+	elasticsearchFlushInterval = 10 * time.Second
+	// elasticsearchTimeout limits every bulk request, so a slow elasticsearch never blocks OnStop for long.
+	elasticsearchTimeout = 10 * time.Second
+)
+
+// ElasticsearchOutput indexes every event as a document with the _bulk API of elasticsearch, the node ID
+// and the timestamp are added to the documents as "node_id" and "@timestamp".
+// Documents are buffered and flushed every 10 seconds by a separated goroutine, and when it stops.
+// If a bulk request fails, the documents in it are dropped.
+type ElasticsearchOutput struct {
+	url    string
+	index  string
+	nodeID string
+	client *http.Client
+
+	lock sync.Mutex
+	docs [][]byte
+
+	done    chan bool
+	flushed chan bool
+
+	// flushInterval overrides elasticsearchFlushInterval if not 0, used in tests.
+	flushInterval time.Duration
+}
+
+// NewElasticsearchOutput returns an ElasticsearchOutput, which indexes to index of the elasticsearch at url,
+// like "http://127.0.0.1:9200".
+func NewElasticsearchOutput(url, index string) *ElasticsearchOutput {
+	return &ElasticsearchOutput{
+		url:    strings.TrimSuffix(url, "/"),
+		index:  index,
+		nodeID: getNodeID(),
+		client: &http.Client{Timeout: elasticsearchTimeout},
+	}
+}
+
+// OnStart starts flushing the documents periodically.
+func (o *ElasticsearchOutput) OnStart() {
+	flushInterval := elasticsearchFlushInterval
+	if o.flushInterval != 0 {
+		flushInterval = o.flushInterval
+	}
+	o.done = make(chan bool)
+	o.flushed = make(chan bool)
+	go func() {
+		defer close(o.flushed)
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				o.flush()
+			case <-o.done:
+				return
+			}
+		}
+	}()
+}
+
+// OnStop flushes the pending documents.
+func (o *ElasticsearchOutput) OnStop() {
+	if o.done == nil {
+		return
+	}
+	close(o.done)
+	<-o.flushed
+	o.done = nil
+	o.flush()
+}
+
+// OnEvent buffers data as a document, the node ID in data takes precedence over the one of current process.
+func (o *ElasticsearchOutput) OnEvent(data map[string]interface{}) {
+	// data is shared with other outputs, don't modify it
+	doc := make(map[string]interface{}, len(data)+2)
+	for k, v := range data {
+		doc[k] = v
+	}
+	if _, ok := doc["node_id"]; !ok {
+		doc["node_id"] = o.nodeID
+	}
+	doc["@timestamp"] = time.Now().UTC().Format(time.RFC3339Nano)
+	value, err := json.Marshal(doc)
+	if err != nil {
+		logger.Errorf("Failed to serialize the event for elasticsearch output, %v", err)
+		return
+	}
+	o.lock.Lock()
+	o.docs = append(o.docs, value)
+	o.lock.Unlock()
+}
+
+// flush sends the buffered documents in a bulk request, the body is NDJSON of an index action
+// followed by the document for each of them.
+func (o *ElasticsearchOutput) flush() {
+	o.lock.Lock()
+	docs := o.docs
+	o.docs = nil
+	o.lock.Unlock()
+	if len(docs) == 0 {
+		return
+	}
+
+	action, _ := json.Marshal(map[string]interface{}{
+		"index": map[string]string{"_index": o.index},
+	})
+	var body bytes.Buffer
+	for _, doc := range docs {
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+
+	resp, err := o.client.Post(o.url+"/_bulk", "application/x-ndjson", &body)
+	if err != nil {
+		logger.Errorf("Failed to index %d documents to elasticsearch %s, %v", len(docs), o.url, err)
+		return
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if resp.StatusCode/100 != 2 {
+		logger.Errorf("Failed to index %d documents to elasticsearch %s, status %s", len(docs), o.url, resp.Status)
+	} else if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && result.Errors {
+		logger.Errorf("Some of %d documents are not indexed to elasticsearch %s", len(docs), o.url)
+	}
+}
+
+const (
+	// GRPCOutput drops the events if grpcOutputBufferSize events are waiting to be sent.
+	grpcOutputBufferSize = 100
+	// GRPCOutput waits at most grpcOutputStopTimeout for the pending events to be sent when it stops.
+	grpcOutputStopTimeout = 3 * time.Second
+)
+
+// GRPCOutput streams every event to the EventCollector service defined in boomerpb/event.proto.
+// Events are sent by a separated goroutine, if the stream stalls, they are buffered and then dropped,
+// so the runner is never blocked.
+type GRPCOutput struct {
+	target   string
+	dialOpts []grpc.DialOption
+	nodeID   string
+
+	conn   *grpc.ClientConn
+	events chan *boomerpb.Event
+	done   chan bool
+	cancel context.CancelFunc
+
+	// stopTimeout overrides grpcOutputStopTimeout if not 0, used in tests.
+	stopTimeout time.Duration
+}
+
+// NewGRPCOutput returns a GRPCOutput, which dials target, like "127.0.0.1:8000", with opts.
+// The connection is insecure if no opts is given.
+func NewGRPCOutput(target string, opts ...grpc.DialOption) *GRPCOutput {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return &GRPCOutput{
+		target:   target,
+		dialOpts: opts,
+		nodeID:   getNodeID(),
+	}
+}
+
+// OnStart dials target and opens the stream in background.
+func (o *GRPCOutput) OnStart() {
+	conn, err := grpc.NewClient(o.target, o.dialOpts...)
+	if err != nil {
+		logger.Errorf("Failed to start grpc output on %s, %v", o.target, err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	o.conn = conn
+	o.cancel = cancel
+	o.events = make(chan *boomerpb.Event, grpcOutputBufferSize)
+	o.done = make(chan bool)
+	go o.send(ctx, boomerpb.NewEventCollectorClient(conn), o.events, o.done)
+}
+
+func (o *GRPCOutput) send(ctx context.Context, client boomerpb.EventCollectorClient, events chan *boomerpb.Event, done chan bool) {
+	defer close(done)
+	stream, err := client.Stream(ctx)
+	if err != nil {
+		logger.Errorf("Failed to open the stream of grpc output on %s, events will be dropped, %v", o.target, err)
+		return
+	}
+	for event := range events {
+		if err = stream.Send(event); err != nil {
+			logger.Errorf("Failed to send to grpc output on %s, events will be dropped, %v", o.target, err)
+			return
+		}
+	}
+	if _, err = stream.CloseAndRecv(); err != nil {
+		logger.Errorf("Failed to close the stream of grpc output on %s, %v", o.target, err)
+	}
+}
+
+// OnStop closes the stream after the pending events are sent, and closes the connection.
+func (o *GRPCOutput) OnStop() {
+	if o.events == nil {
+		return
+	}
+	close(o.events)
+	stopTimeout := grpcOutputStopTimeout
+	if o.stopTimeout != 0 {
+		stopTimeout = o.stopTimeout
+	}
+	select {
+	case <-o.done:
+	case <-time.After(stopTimeout):
+		logger.Errorf("Timeout waiting for grpc output on %s to send the pending events", o.target)
+	}
+	o.cancel()
+	o.conn.Close()
+	o.events = nil
+}
+
+// OnEvent queues the event to be sent, it's dropped if too many events are pending.
+func (o *GRPCOutput) OnEvent(data map[string]interface{}) {
+	if o.events == nil {
+		return
+	}
+	select {
+	case o.events <- newGRPCEvent(o.nodeID, data):
+	default:
+		logger.Errorf("Too many events are pending for grpc output on %s, dropped", o.target)
+	}
+}
+
+// newGRPCEvent converts the event data, the node ID in data takes precedence over nodeID.
+func newGRPCEvent(nodeID string, data map[string]interface{}) *boomerpb.Event {
+	if id, ok := data["node_id"].(string); ok {
+		nodeID = id
+	}
+	event := &boomerpb.Event{
+		NodeId:    nodeID,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if userCount, ok := data["user_count"].(int32); ok {
+		event.UserCount = userCount
+	}
+	if statsTotal, ok := data["stats_total"].(map[string]interface{}); ok {
+		event.StatsTotal = newGRPCRequestStats(statsTotal)
+	}
+	if stats, ok := data["stats"].([]interface{}); ok {
+		for _, stat := range stats {
+			if s, ok := stat.(map[string]interface{}); ok {
+				event.Stats = append(event.Stats, newGRPCRequestStats(s))
+			}
+		}
+	}
+	if errors, ok := data["errors"].(map[string]map[string]interface{}); ok {
+		for _, e := range errors {
+			requestError := &boomerpb.RequestError{}
+			requestError.Method, _ = e["method"].(string)
+			requestError.Name, _ = e["name"].(string)
+			requestError.Error, _ = e["error"].(string)
+			requestError.Occurrences, _ = e["occurrences"].(int64)
+			event.Errors = append(event.Errors, requestError)
+		}
+	}
+	return event
+}
+
+func newGRPCRequestStats(stat map[string]interface{}) *boomerpb.RequestStats {
+	s := &boomerpb.RequestStats{}
+	s.Method, _ = stat["method"].(string)
+	s.Name, _ = stat["name"].(string)
+	s.NumRequests, _ = stat["num_requests"].(int64)
+	s.NumFailures, _ = stat["num_failures"].(int64)
+	s.TotalResponseTime, _ = stat["total_response_time"].(int64)
+	s.MinResponseTime, _ = stat["min_response_time"].(int64)
+	s.MaxResponseTime, _ = stat["max_response_time"].(int64)
+	s.TotalContentLength, _ = stat["total_content_length"].(int64)
+	s.ResponseTimes, _ = stat["response_times"].(map[int64]int64)
+	return s
+}
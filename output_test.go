@@ -1,8 +1,34 @@
 package boomer
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/myzhan/boomer/boomerpb"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func TestGetMedianResponseTime(t *testing.T) {
@@ -26,6 +52,37 @@ func TestGetMedianResponseTime(t *testing.T) {
 	}
 }
 
+func TestGetResponseTimePercentile(t *testing.T) {
+	numRequests := int64(100)
+	responseTimes := map[int64]int64{
+		10:  50,
+		20:  40,
+		100: 9,
+		500: 1,
+	}
+
+	tests := []struct {
+		percent  float64
+		expected int64
+	}{
+		{0.4, 10},
+		{0.5, 20},
+		{0.9, 100},
+		{0.95, 100},
+		{0.99, 500},
+	}
+	for _, test := range tests {
+		percentile := getResponseTimePercentile(numRequests, responseTimes, test.percent)
+		if percentile != test.expected {
+			t.Errorf("percentile %.2f is wrong, expected: %d, got: %d", test.percent, test.expected, percentile)
+		}
+	}
+
+	if getResponseTimePercentile(int64(0), map[int64]int64{}, 0.95) != 0 {
+		t.Error("percentile should be 0 without any request")
+	}
+}
+
 func TestGetAvgResponseTime(t *testing.T) {
 	numRequests := int64(3)
 	totalResponseTime := int64(100)
@@ -106,3 +163,1158 @@ func TestConsoleOutput(t *testing.T) {
 
 	o.OnStop()
 }
+
+func TestTableConsoleOutput(t *testing.T) {
+	buf := &bytes.Buffer{}
+	o := NewTableConsoleOutput()
+	o.writer = buf
+	o.isTerminal = true
+	o.OnStart()
+
+	data := map[string]interface{}{}
+	stat := map[string]interface{}{}
+	data["stats"] = []interface{}{stat}
+
+	stat["name"] = "http"
+	stat["method"] = "post"
+	stat["num_requests"] = int64(100)
+	stat["num_failures"] = int64(10)
+	stat["response_times"] = map[int64]int64{
+		10:  1,
+		100: 99,
+	}
+	stat["num_reqs_per_sec"] = map[int64]int64{
+		1: 20,
+		2: 40,
+		3: 40,
+	}
+
+	o.OnEvent(data)
+	table := buf.String()
+	if !regexp.MustCompile(`TYPE\s*\|\s*NAME\s*\|\s*# REQUESTS\s*\|\s*# REQS/SEC\s*\|\s*# FAILS\s*\|\s*P50\s*\|\s*P95\s*\|\s*P99`).MatchString(table) {
+		t.Error("The table should have the header, got", table)
+	}
+	if !regexp.MustCompile(`post\s*\|\s*http\s*\|\s*100\s*\|\s*33\s*\|\s*10\s*\|\s*100\s*\|\s*100\s*\|\s*100\s*\|`).MatchString(table) {
+		t.Error("The table should have a row of post http, got", table)
+	}
+	if strings.Contains(table, "\033[") {
+		t.Error("The first table should not overwrite anything, got", table)
+	}
+
+	buf.Reset()
+	o.OnEvent(data)
+	table = buf.String()
+	if !strings.HasPrefix(table, "\033[") {
+		t.Error("The table should be refreshed in place, got", table)
+	}
+	if !regexp.MustCompile(`post\s*\|\s*http\s*\|\s*200\s*\|`).MatchString(table) {
+		t.Error("The requests should be accumulated, got", table)
+	}
+
+	o.OnStop()
+}
+
+func TestTableConsoleOutputWithoutTerminal(t *testing.T) {
+	buf := &bytes.Buffer{}
+	o := NewTableConsoleOutput()
+	o.writer = buf
+	o.isTerminal = false
+	o.OnStart()
+
+	o.OnEvent(map[string]interface{}{
+		"stats": []interface{}{
+			map[string]interface{}{
+				"name":             "http",
+				"method":           "post",
+				"num_requests":     int64(100),
+				"num_failures":     int64(10),
+				"response_times":   map[int64]int64{10: 1, 100: 99},
+				"num_reqs_per_sec": map[int64]int64{1: 20, 2: 40, 3: 40},
+			},
+		},
+	})
+	output := buf.String()
+	if strings.Contains(output, "\033[") || strings.Contains(output, "|") {
+		t.Error("No table should be printed without a terminal, got", output)
+	}
+	if !strings.Contains(output, "post http requests=100 rps=33 failures=10 p50=100 p95=100 p99=100\n") {
+		t.Error("A plain line should be printed for post http, got", output)
+	}
+
+	o.OnStop()
+}
+
+func TestMemoryOutput(t *testing.T) {
+	var runner *localRunner
+	task := &Task{
+		Name: "foo",
+		Fn: func() {
+			runner.recordSuccess("http", "foo", 10, 100)
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	o := NewMemoryOutput()
+	runner = newLocalRunner([]*Task{task}, nil, 1, "asap", 1)
+	runner.clearOutputs()
+	runner.addOutput(o)
+
+	done := make(chan bool)
+	go func() {
+		runner.run()
+		close(done)
+	}()
+	for i := 0; i < 40 && len(o.Events()) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	runner.close()
+	<-done
+
+	if o.StartCount() != 1 || o.StopCount() != 1 {
+		t.Error("OnStart and OnStop should be called once, got", o.StartCount(), o.StopCount())
+	}
+	events := o.Events()
+	if len(events) == 0 {
+		t.Fatal("The events should be recorded")
+	}
+	stats := events[0]["stats"].([]interface{})
+	if len(stats) != 1 || stats[0].(map[string]interface{})["name"] != "foo" {
+		t.Error("The event should contain the stats of foo, got", stats)
+	}
+
+	// Events returns a copy
+	events[0] = nil
+	if o.Events()[0] == nil {
+		t.Error("The recorded events should not be changed by the caller")
+	}
+}
+
+func TestPrometheusOutput(t *testing.T) {
+	o := NewPrometheusOutput("127.0.0.1:0")
+	o.OnStart()
+	defer o.OnStop()
+
+	if o.listener == nil {
+		t.Fatal("The http server is not started")
+	}
+
+	data := map[string]interface{}{}
+	stat := map[string]interface{}{}
+	data["stats"] = []interface{}{stat}
+	data["user_count"] = int32(10)
+	data["stats_total"] = map[string]interface{}{
+		"num_requests": int64(100),
+		"num_reqs_per_sec": map[int64]int64{
+			1: 50,
+			2: 50,
+		},
+	}
+
+	stat["name"] = "foo"
+	stat["method"] = "http"
+	stat["num_requests"] = int64(100)
+	stat["num_failures"] = int64(10)
+	stat["response_times"] = map[int64]int64{
+		10:  1,
+		100: 99,
+	}
+
+	o.OnEvent(data)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", o.listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	exposition := string(body)
+
+	expectedSamples := []string{
+		`boomer_requests_total{method="http",name="foo"} 100`,
+		`boomer_failures_total{method="http",name="foo"} 10`,
+		`boomer_response_time_milliseconds_count{method="http",name="foo"} 100`,
+		`boomer_response_time_milliseconds_sum{method="http",name="foo"} 9910`,
+		`boomer_current_rps 50`,
+		`boomer_users 10`,
+	}
+	for _, sample := range expectedSamples {
+		if !strings.Contains(exposition, sample) {
+			t.Error("Expected sample is not found:", sample)
+		}
+	}
+}
+
+func TestPrometheusOutputWithLabels(t *testing.T) {
+	o := NewPrometheusOutput("127.0.0.1:0")
+	o.SetLabelNames("tenant", "shard")
+	o.OnStart()
+	defer o.OnStop()
+
+	if o.listener == nil {
+		t.Fatal("The http server is not started")
+	}
+
+	o.OnEvent(map[string]interface{}{
+		"stats": []interface{}{
+			map[string]interface{}{
+				"name":           "foo",
+				"method":         "http",
+				"labels":         map[string]string{"tenant": "a", "shard": "1", "region": "eu"},
+				"num_requests":   int64(10),
+				"num_failures":   int64(1),
+				"response_times": map[int64]int64{10: 10},
+			},
+			map[string]interface{}{
+				"name":           "foo",
+				"method":         "http",
+				"labels":         map[string]string{"tenant": "b"},
+				"num_requests":   int64(20),
+				"num_failures":   int64(2),
+				"response_times": map[int64]int64{10: 20},
+			},
+		},
+	})
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", o.listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	exposition := string(body)
+
+	expectedSamples := []string{
+		`boomer_requests_total{method="http",name="foo",shard="1",tenant="a"} 10`,
+		`boomer_failures_total{method="http",name="foo",shard="1",tenant="a"} 1`,
+		`boomer_requests_total{method="http",name="foo",shard="",tenant="b"} 20`,
+		`boomer_failures_total{method="http",name="foo",shard="",tenant="b"} 2`,
+	}
+	for _, sample := range expectedSamples {
+		if !strings.Contains(exposition, sample) {
+			t.Error("Expected sample is not found:", sample)
+		}
+	}
+	if strings.Contains(exposition, "region") {
+		t.Error("The labels not set by SetLabelNames should be ignored")
+	}
+}
+
+func TestCSVOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boomer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.csv")
+
+	// the file is truncated
+	ioutil.WriteFile(path, []byte("garbage\n"), 0644)
+
+	o := NewCSVOutput(path)
+	o.OnStart()
+	for i := int64(1); i <= 2; i++ {
+		o.OnEvent(map[string]interface{}{
+			"user_count": int32(10 * i),
+			"stats_total": map[string]interface{}{
+				"num_requests": 100 * i,
+				"num_failures": i,
+				"num_reqs_per_sec": map[int64]int64{
+					1: 50 * i,
+					2: 50 * i,
+				},
+				"response_times": map[int64]int64{
+					10 * i: 100 * i,
+				},
+			},
+		})
+	}
+	o.OnStop()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 3 {
+		t.Fatal("Expected a header and 2 rows, got", len(records), "records")
+	}
+	expectedHeader := "timestamp,user_count,current_rps,num_failures,median_response_time"
+	if strings.Join(records[0], ",") != expectedHeader {
+		t.Error("The header is wrong, got", records[0])
+	}
+	expectedRows := []string{"10,50,1,10", "20,100,2,20"}
+	for i, expected := range expectedRows {
+		if got := strings.Join(records[i+1][1:], ","); got != expected {
+			t.Error("Row", i+1, "is wrong, expected:", expected, "got:", got)
+		}
+	}
+}
+
+func TestCSVOutputWithInvalidPath(t *testing.T) {
+	o := NewCSVOutput(filepath.Join("not", "exist", "stats.csv"))
+	o.OnStart()
+	// should not panic
+	o.OnEvent(map[string]interface{}{})
+	o.OnStop()
+}
+
+// parseOpenMetrics checks the text against the OpenMetrics exposition format, and returns the samples by name with labels.
+func parseOpenMetrics(text string) (map[string]float64, error) {
+	metricName := `[a-zA-Z_:][a-zA-Z0-9_:]*`
+	typeLine := regexp.MustCompile(`^# TYPE (` + metricName + `) (counter|gauge|histogram|summary|unknown|info|stateset|gaugehistogram)$`)
+	helpLine := regexp.MustCompile(`^# (HELP|UNIT) (` + metricName + `) .*$`)
+	sampleLine := regexp.MustCompile(`^(` + metricName + `)(\{(?:[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*",?)*\})? (\S+)( \S+)?$`)
+
+	lines := strings.Split(text, "\n")
+	if len(lines) < 2 || lines[len(lines)-2] != "# EOF" || lines[len(lines)-1] != "" {
+		return nil, fmt.Errorf("the exposition should end with # EOF")
+	}
+	samples := make(map[string]float64)
+	family := ""
+	for i, line := range lines[:len(lines)-2] {
+		if m := typeLine.FindStringSubmatch(line); m != nil {
+			family = m[1]
+			continue
+		}
+		if helpLine.MatchString(line) {
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d is invalid: %s", i+1, line)
+		}
+		if family == "" || !strings.HasPrefix(m[1], family) {
+			return nil, fmt.Errorf("line %d is not in a declared metric family: %s", i+1, line)
+		}
+		value, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d has an invalid value: %s", i+1, line)
+		}
+		samples[m[1]+m[2]] = value
+	}
+	return samples, nil
+}
+
+func TestOpenMetricsFileOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boomer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir = filepath.Join(dir, "metrics")
+
+	o := NewOpenMetricsFileOutput(dir)
+	o.SetRetention(3)
+	o.OnStart()
+	for i := int64(1); i <= 5; i++ {
+		o.OnEvent(map[string]interface{}{
+			"user_count": int32(10 * i),
+			"stats_total": map[string]interface{}{
+				"num_requests": int64(100),
+				"num_reqs_per_sec": map[int64]int64{
+					1: 50,
+					2: 50,
+				},
+			},
+			"stats": []interface{}{
+				map[string]interface{}{
+					"method":         "http",
+					"name":           "/foo",
+					"num_requests":   int64(100),
+					"num_failures":   int64(10),
+					"response_times": map[int64]int64{20: 60, 200: 40},
+				},
+			},
+		})
+	}
+	o.OnStop()
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(paths) != 3 {
+		t.Fatal("Only the latest 3 files should be kept, got", paths)
+	}
+	content, err := ioutil.ReadFile(paths[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := parseOpenMetrics(string(content))
+	if err != nil {
+		t.Fatalf("The file should be valid openmetrics, %v\n%s", err, content)
+	}
+	expected := map[string]float64{
+		`boomer_users`:       50,
+		`boomer_current_rps`: 50,
+		`boomer_requests_total{method="http",name="/foo"}`:                              500,
+		`boomer_failures_total{method="http",name="/foo"}`:                              50,
+		`boomer_response_time_milliseconds_bucket{method="http",name="/foo",le="25.0"}`: 300,
+		`boomer_response_time_milliseconds_count{method="http",name="/foo"}`:            500,
+	}
+	for name, value := range expected {
+		if samples[name] != value {
+			t.Errorf("%s should be %v, got %v", name, value, samples[name])
+		}
+	}
+}
+
+func TestOpenMetricsFileOutputWithInvalidDir(t *testing.T) {
+	file, err := ioutil.TempFile("", "boomer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Close()
+
+	o := NewOpenMetricsFileOutput(filepath.Join(file.Name(), "metrics"))
+	o.OnStart()
+	// should not panic
+	o.OnEvent(map[string]interface{}{})
+	o.OnStop()
+}
+
+func TestStatsdKey(t *testing.T) {
+	if key := statsdKey("/api/v1:foo|bar.baz"); key != "_api_v1_foo_bar_baz" {
+		t.Error("Invalid characters should be replaced, got", key)
+	}
+}
+
+func TestStatsdOutput(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	o := NewStatsdOutput(conn.LocalAddr().String(), "boomer.")
+	o.OnStart()
+	defer o.OnStop()
+
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(10),
+		"stats_total": map[string]interface{}{
+			"num_requests": int64(100),
+			"num_failures": int64(10),
+			"num_reqs_per_sec": map[int64]int64{
+				1: 50,
+				2: 50,
+			},
+		},
+		"stats": []interface{}{
+			map[string]interface{}{
+				"method":       "http",
+				"name":         "/foo",
+				"num_requests": int64(100),
+				"num_failures": int64(10),
+			},
+		},
+	})
+
+	expectedLines := []string{
+		"boomer.users:10|g",
+		"boomer.requests:100|c",
+		"boomer.failures:10|c",
+		"boomer.current_rps:50|g",
+		"boomer.requests.http._foo:100|c",
+		"boomer.failures.http._foo:10|c",
+	}
+	received := make(map[string]bool)
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for len(received) < len(expectedLines) {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		received[string(buf[:n])] = true
+	}
+	for _, line := range expectedLines {
+		if !received[line] {
+			t.Error("Expected line is not received:", line)
+		}
+	}
+}
+
+func TestStatsdOutputWithoutStatsd(t *testing.T) {
+	// nobody listens on the port, sending must not block or panic
+	o := NewStatsdOutput("127.0.0.1:1", "boomer")
+	o.OnStart()
+	defer o.OnStop()
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			o.OnEvent(map[string]interface{}{
+				"user_count": int32(10),
+			})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("StatsdOutput should not block if statsd is down")
+	}
+}
+
+func TestDogStatsdOutput(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	o := NewDogStatsdOutput(conn.LocalAddr().String(), []string{"service:api", "env:prod"})
+	o.OnStart()
+	defer o.OnStop()
+
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(10),
+		"node_id":    "slave-1",
+		"stats_total": map[string]interface{}{
+			"num_requests": int64(100),
+			"num_failures": int64(10),
+			"num_reqs_per_sec": map[int64]int64{
+				1: 50,
+				2: 50,
+			},
+		},
+		"stats": []interface{}{
+			map[string]interface{}{
+				"method":         "http",
+				"name":           "/foo,bar",
+				"num_requests":   int64(100),
+				"num_failures":   int64(10),
+				"response_times": map[int64]int64{10: 1, 30: 4},
+			},
+		},
+	})
+
+	tags := "#service:api,env:prod,node_id:slave-1"
+	requestTags := tags + ",method:http,name:/foo_bar"
+	expectedLines := []string{
+		"boomer.users:10|g|" + tags,
+		"boomer.requests:100|c|" + tags,
+		"boomer.failures:10|c|" + tags,
+		"boomer.current_rps:50|g|" + tags,
+		"boomer.request.requests:100|c|" + requestTags,
+		"boomer.request.failures:10|c|" + requestTags,
+		"boomer.request.response_time:10|d|@1|" + requestTags,
+		"boomer.request.response_time:30|d|@0.25|" + requestTags,
+	}
+	received := make(map[string]bool)
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for len(received) < len(expectedLines) {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		received[string(buf[:n])] = true
+	}
+	for _, line := range expectedLines {
+		if !received[line] {
+			t.Error("Expected line is not received:", line)
+		}
+	}
+
+	// tagged with the node ID of current process if the event doesn't have one
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(20),
+	})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := string(buf[:n]); line != "boomer.users:20|g|#service:api,env:prod,node_id:"+o.nodeID {
+		t.Error("Unexpected line", line)
+	}
+}
+
+func TestDogStatsdOutputWithoutDogStatsd(t *testing.T) {
+	// nobody listens on the port, sending must not block or panic
+	o := NewDogStatsdOutput("127.0.0.1:1", nil)
+	o.OnStart()
+	defer o.OnStop()
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			o.OnEvent(map[string]interface{}{
+				"user_count": int32(10),
+			})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("DogStatsdOutput should not block if dogstatsd is down")
+	}
+}
+
+func TestGraphiteOutput(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	o := NewGraphiteOutput(listener.Addr().String(), "boomer.")
+	o.OnStart()
+	defer o.OnStop()
+
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(10),
+		"stats_total": map[string]interface{}{
+			"num_requests": int64(100),
+			"num_failures": int64(10),
+			"num_reqs_per_sec": map[int64]int64{
+				1: 50,
+				2: 50,
+			},
+		},
+		"stats": []interface{}{
+			map[string]interface{}{
+				"method":       "http",
+				"name":         "/foo",
+				"num_requests": int64(100),
+				"num_failures": int64(10),
+			},
+		},
+	})
+
+	conn := <-conns
+	reader := bufio.NewReader(conn)
+	expectedMetrics := []string{
+		"boomer.users 10",
+		"boomer.requests 100",
+		"boomer.failures 10",
+		"boomer.current_rps 50",
+		"boomer.requests.http._foo 100",
+		"boomer.failures.http._foo 10",
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for _, expected := range expectedMetrics {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0]+" "+fields[1] != expected {
+			t.Errorf("Expected line %q, got %q", expected, line)
+			continue
+		}
+		if timestamp, err := strconv.ParseInt(fields[2], 10, 64); err != nil || time.Now().Unix()-timestamp > 5 {
+			t.Error("The timestamp should be current unix time, got", fields[2])
+		}
+	}
+
+	// reconnect after the connection is lost
+	conn.Close()
+	for i := 0; i < 10 && o.conn != nil; i++ {
+		o.OnEvent(map[string]interface{}{
+			"user_count": int32(20),
+		})
+		time.Sleep(10 * time.Millisecond)
+	}
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(30),
+	})
+	select {
+	case conn = <-conns:
+	case <-time.After(time.Second):
+		t.Fatal("Graphite output should reconnect after the connection is lost")
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "boomer.users 30 ") {
+		t.Error("Expected users line after reconnected, got", line, err)
+	}
+}
+
+func TestGraphiteOutputWithoutGraphite(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	o := NewGraphiteOutput(addr, "")
+	o.OnStart()
+	// nothing panics
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(10),
+	})
+	o.OnStop()
+}
+
+// memoryExporter keeps the data points exported by the sdk, keyed by metric name.
+type memoryExporter struct {
+	lock   sync.Mutex
+	points map[string][]metricPoint
+}
+
+type metricPoint struct {
+	attrs attribute.Set
+	value int64
+	count uint64
+}
+
+func (e *memoryExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	return sdkmetric.DefaultTemporalitySelector(kind)
+}
+
+func (e *memoryExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+func (e *memoryExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.points = make(map[string][]metricPoint)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, p := range data.DataPoints {
+					e.points[m.Name] = append(e.points[m.Name], metricPoint{attrs: p.Attributes, value: p.Value})
+				}
+			case metricdata.Histogram[int64]:
+				for _, p := range data.DataPoints {
+					e.points[m.Name] = append(e.points[m.Name], metricPoint{attrs: p.Attributes, value: p.Sum, count: p.Count})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (e *memoryExporter) ForceFlush(ctx context.Context) error {
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestOTelOutput(t *testing.T) {
+	exporter := &memoryExporter{}
+	o := NewOTelOutput(exporter)
+	o.OnStart()
+
+	o.OnEvent(map[string]interface{}{
+		"node_id":    "hostname_1234",
+		"user_count": int32(10),
+		"stats": []interface{}{
+			map[string]interface{}{
+				"method":       "http",
+				"name":         "/foo",
+				"num_requests": int64(3),
+				"num_failures": int64(1),
+				"response_times": map[int64]int64{
+					10: 2,
+					40: 1,
+				},
+			},
+		},
+	})
+	o.OnEvent(map[string]interface{}{
+		"node_id":    "hostname_1234",
+		"user_count": int32(5),
+	})
+	o.OnStop()
+
+	exporter.lock.Lock()
+	defer exporter.lock.Unlock()
+
+	expectedPoints := map[string]metricPoint{
+		"boomer.requests":      {value: 3},
+		"boomer.failures":      {value: 1},
+		"boomer.response_time": {value: 60, count: 3},
+		"boomer.users":         {value: 5},
+	}
+	for name, expected := range expectedPoints {
+		points := exporter.points[name]
+		if len(points) != 1 {
+			t.Errorf("Expected one data point of %s, got %d", name, len(points))
+			continue
+		}
+		p := points[0]
+		if p.value != expected.value || p.count != expected.count {
+			t.Errorf("Expected %s to be %d with count %d, got %d with count %d", name, expected.value, expected.count, p.value, p.count)
+		}
+		if nodeID, _ := p.attrs.Value("node_id"); nodeID.AsString() != "hostname_1234" {
+			t.Errorf("Expected %s to be attributed with the node ID, got %v", name, nodeID.AsString())
+		}
+		if name != "boomer.users" {
+			if method, _ := p.attrs.Value("method"); method.AsString() != "http" {
+				t.Errorf("Expected %s to be attributed with the method, got %v", name, method.AsString())
+			}
+			if requestName, _ := p.attrs.Value("name"); requestName.AsString() != "/foo" {
+				t.Errorf("Expected %s to be attributed with the name, got %v", name, requestName.AsString())
+			}
+		}
+	}
+}
+
+func TestOTelOutputBeforeStart(t *testing.T) {
+	exporter := &memoryExporter{}
+	o := NewOTelOutput(exporter)
+
+	// nothing is recorded or exported until the output is started
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(10),
+	})
+	o.OnStop()
+
+	if len(exporter.points) != 0 {
+		t.Error("Nothing should be exported before OnStart, got", exporter.points)
+	}
+}
+
+type mockProducer struct {
+	messages []kafka.Message
+	closed   bool
+}
+
+func (p *mockProducer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	p.messages = append(p.messages, msgs...)
+	return nil
+}
+
+func (p *mockProducer) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestElasticsearchOutput(t *testing.T) {
+	bodies := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/_bulk" {
+			t.Error("The documents should be posted to /_bulk, got", r.Method, r.URL.Path)
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+			t.Error("The content type should be application/x-ndjson, got", contentType)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	o := NewElasticsearchOutput(server.URL+"/", "boomer")
+	o.OnStart()
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(10),
+		"stats": []interface{}{
+			map[string]interface{}{
+				"method":         "http",
+				"name":           "/foo",
+				"num_requests":   int64(100),
+				"response_times": map[int64]int64{20: 100},
+			},
+		},
+	})
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(20),
+		"node_id":    "slave-1",
+	})
+	select {
+	case body := <-bodies:
+		t.Fatal("The documents should be buffered until the interval or OnStop, got", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+	o.OnStop()
+
+	var body string
+	select {
+	case body = <-bodies:
+	default:
+		t.Fatal("The pending documents should be flushed on OnStop")
+	}
+	if !strings.HasSuffix(body, "\n") {
+		t.Error("The bulk body should end with a newline")
+	}
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatal("Expected an action and a document for each event, got", lines)
+	}
+	for i := 0; i < len(lines); i += 2 {
+		var action map[string]map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &action); err != nil || action["index"]["_index"] != "boomer" {
+			t.Error("Line", i+1, "should be an index action, got", lines[i])
+		}
+	}
+	var docs [2]map[string]interface{}
+	for i := range docs {
+		if err := json.Unmarshal([]byte(lines[2*i+1]), &docs[i]); err != nil {
+			t.Fatal("Line", 2*i+2, "should be a JSON document, got", lines[2*i+1])
+		}
+		if _, err := time.Parse(time.RFC3339Nano, fmt.Sprint(docs[i]["@timestamp"])); err != nil {
+			t.Error("The document should carry the timestamp, got", docs[i])
+		}
+	}
+	if docs[0]["node_id"] != o.nodeID || docs[0]["user_count"] != float64(10) {
+		t.Error("The document should carry the node ID and the event, got", docs[0])
+	}
+	if docs[1]["node_id"] != "slave-1" || docs[1]["user_count"] != float64(20) {
+		t.Error("The node ID in the event should take precedence, got", docs[1])
+	}
+
+	// flushed on the interval
+	o = NewElasticsearchOutput(server.URL, "boomer")
+	o.flushInterval = 50 * time.Millisecond
+	o.OnStart()
+	defer o.OnStop()
+	o.OnEvent(map[string]interface{}{"user_count": int32(30)})
+	select {
+	case body = <-bodies:
+		if strings.Count(body, "\n") != 2 {
+			t.Error("Expected an action and a document, got", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The documents should be flushed on the interval")
+	}
+}
+
+func TestElasticsearchOutputWithoutElasticsearch(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	o := NewElasticsearchOutput("http://"+addr, "boomer")
+	o.OnStart()
+	// should not panic or block
+	o.OnEvent(map[string]interface{}{"user_count": int32(10)})
+	o.OnStop()
+}
+
+func TestKafkaOutput(t *testing.T) {
+	o := NewKafkaOutput([]string{"127.0.0.1:9092"}, "boomer")
+	o.OnStart()
+	if writer, ok := o.producer.(*kafka.Writer); !ok || writer.Topic != "boomer" || !writer.Async {
+		t.Fatal("An asynchronous producer of the topic should be created")
+	}
+	producer := &mockProducer{}
+	o.producer = producer
+
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(10),
+		"node_id":    "slave-1",
+		"stats": []interface{}{
+			map[string]interface{}{
+				"method":         "http",
+				"name":           "/foo",
+				"num_requests":   int64(100),
+				"response_times": map[int64]int64{10: 100},
+			},
+		},
+	})
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(20),
+	})
+	o.OnStop()
+
+	if !producer.closed {
+		t.Error("The producer should be closed on stop")
+	}
+	if len(producer.messages) != 2 {
+		t.Fatal("Expected 2 messages, got", len(producer.messages))
+	}
+
+	if key := string(producer.messages[0].Key); key != "slave-1" {
+		t.Error("The node ID in the event should be the key, got", key)
+	}
+	var event map[string]interface{}
+	if err := json.Unmarshal(producer.messages[0].Value, &event); err != nil {
+		t.Fatal(err)
+	}
+	stat := event["stats"].([]interface{})[0].(map[string]interface{})
+	if event["user_count"] != float64(10) || stat["name"] != "/foo" || stat["num_requests"] != float64(100) {
+		t.Error("The payload should be the event, got", event)
+	}
+	if responseTimes := stat["response_times"].(map[string]interface{}); responseTimes["10"] != float64(100) {
+		t.Error("The response times should be serialized, got", responseTimes)
+	}
+
+	if key := string(producer.messages[1].Key); key != o.nodeID {
+		t.Error("The node ID of current process should be the key, got", key)
+	}
+
+	// nothing is produced after stopped
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(30),
+	})
+	if len(producer.messages) != 2 {
+		t.Error("Nothing should be produced after OnStop")
+	}
+}
+
+type mockEventCollector struct {
+	boomerpb.UnimplementedEventCollectorServer
+	events chan *boomerpb.Event
+	// stall makes the collector never read the stream.
+	stall chan bool
+}
+
+func (c *mockEventCollector) Stream(stream grpc.ClientStreamingServer[boomerpb.Event, boomerpb.StreamSummary]) error {
+	if c.stall != nil {
+		select {
+		case <-c.stall:
+		case <-stream.Context().Done():
+		}
+		return nil
+	}
+	received := int64(0)
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return stream.SendAndClose(&boomerpb.StreamSummary{Received: received})
+		}
+		received++
+		c.events <- event
+	}
+}
+
+func startEventCollector(t *testing.T, collector *mockEventCollector) *GRPCOutput {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	boomerpb.RegisterEventCollectorServer(server, collector)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	return NewGRPCOutput("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+}
+
+func TestGRPCOutput(t *testing.T) {
+	collector := &mockEventCollector{events: make(chan *boomerpb.Event, 10)}
+	o := startEventCollector(t, collector)
+	o.OnStart()
+
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(10),
+		"node_id":    "slave-1",
+		"stats_total": map[string]interface{}{
+			"num_requests": int64(100),
+		},
+		"stats": []interface{}{
+			map[string]interface{}{
+				"method":            "http",
+				"name":              "/foo",
+				"num_requests":      int64(100),
+				"num_failures":      int64(10),
+				"min_response_time": int64(0),
+				"max_response_time": int64(30),
+				"response_times":    map[int64]int64{10: 90, 30: 10},
+			},
+		},
+		"errors": map[string]map[string]interface{}{
+			"key": {
+				"method":      "http",
+				"name":        "/foo",
+				"error":       "timeout",
+				"occurrences": int64(10),
+			},
+		},
+	})
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(20),
+	})
+	o.OnStop()
+
+	var events []*boomerpb.Event
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-collector.events:
+			events = append(events, event)
+		case <-time.After(time.Second):
+			t.Fatal("Expected 2 events, got", len(events))
+		}
+	}
+
+	event := events[0]
+	if event.NodeId != "slave-1" || event.UserCount != 10 || event.Timestamp == 0 {
+		t.Error("Unexpected event", event)
+	}
+	if event.StatsTotal.GetNumRequests() != 100 {
+		t.Error("Expected 100 requests in total, got", event.StatsTotal.GetNumRequests())
+	}
+	if len(event.Stats) != 1 {
+		t.Fatal("Expected stats of one request, got", len(event.Stats))
+	}
+	stat := event.Stats[0]
+	if stat.Method != "http" || stat.Name != "/foo" || stat.NumRequests != 100 || stat.NumFailures != 10 ||
+		stat.MaxResponseTime != 30 || stat.ResponseTimes[10] != 90 || stat.ResponseTimes[30] != 10 {
+		t.Error("Unexpected stats", stat)
+	}
+	if len(event.Errors) != 1 || event.Errors[0].Error != "timeout" || event.Errors[0].Occurrences != 10 {
+		t.Error("Unexpected errors", event.Errors)
+	}
+
+	if events[1].NodeId != o.nodeID || events[1].UserCount != 20 {
+		t.Error("Unexpected event", events[1])
+	}
+
+	// nothing panics after stopped
+	o.OnEvent(map[string]interface{}{
+		"user_count": int32(30),
+	})
+}
+
+func TestGRPCOutputStalled(t *testing.T) {
+	collector := &mockEventCollector{stall: make(chan bool)}
+	defer close(collector.stall)
+	o := startEventCollector(t, collector)
+	o.stopTimeout = 100 * time.Millisecond
+	o.OnStart()
+
+	stat := map[string]interface{}{
+		"method":         "http",
+		"name":           strings.Repeat("x", 1024),
+		"response_times": map[int64]int64{},
+	}
+	for i := int64(0); i < 1000; i++ {
+		stat["response_times"].(map[int64]int64)[i] = i
+	}
+	start := time.Now()
+	for i := 0; i < 2*grpcOutputBufferSize; i++ {
+		o.OnEvent(map[string]interface{}{
+			"user_count": int32(i),
+			"stats":      []interface{}{stat},
+		})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("OnEvent should never block on a stalled stream, took", elapsed)
+	}
+
+	start = time.Now()
+	o.OnStop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("OnStop should not wait for a stalled stream, took", elapsed)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -16,8 +17,8 @@ type RateLimiter interface {
 	Start()
 
 	// Acquire() is called before executing a task.Fn function.
-	// If Acquire() returns true, the task.Fn function will be executed.
-	// If Acquire() returns false, the task.Fn function won't be executed this time, but Acquire() will be called very soon.
+	// If Acquire() returns false, the task.Fn function will be executed.
+	// If Acquire() returns true, the task.Fn function won't be executed this time, but Acquire() will be called very soon.
 	// It works like:
 	// for {
 	//      blocked := rateLimiter.Acquire()
@@ -25,14 +26,37 @@ type RateLimiter interface {
 	//	        task.Fn()
 	//      }
 	// }
-	// Acquire() should block the caller until execution is allowed.
+	// Acquire() should park the caller until execution is allowed, like waiting for the bucket to be refilled,
+	// rather than returning true immediately. Otherwise, the goroutines back off exponentially
+	// up to maxAcquireBackoff on consecutive blocked acquires, to avoid spinning on the CPU.
 	Acquire() bool
 
 	// Stop is used to disable the rate limiter.
-	// It can be implemented as a noop if not needed.
+	// It must unblock all the goroutines parked in Acquire, which return true then, otherwise they hang
+	// until the bucket is refilled, which may never happen after stopped.
+	// It can be implemented as a noop if Acquire never blocks.
 	Stop()
 }
 
+// maxAcquireBackoff is the max time a goroutine waits after consecutive blocked acquires.
+const maxAcquireBackoff = 100 * time.Millisecond
+
+// acquireBackoff returns how long a goroutine waits after the nth consecutive blocked acquire.
+// The first one is retried at once, because a well-behaved rate limiter has already blocked the caller.
+func acquireBackoff(consecutiveBlocked int) time.Duration {
+	if consecutiveBlocked <= 1 {
+		return 0
+	}
+	if consecutiveBlocked > 8 {
+		return maxAcquireBackoff
+	}
+	backoff := time.Millisecond << uint(consecutiveBlocked-2)
+	if backoff > maxAcquireBackoff {
+		return maxAcquireBackoff
+	}
+	return backoff
+}
+
 // A StableRateLimiter uses the token bucket algorithm.
 // the bucket is refilled according to the refill period, no burst is allowed.
 type StableRateLimiter struct {
@@ -41,6 +65,8 @@ type StableRateLimiter struct {
 	refillPeriod     time.Duration
 	broadcastChannel chan bool
 	quitChannel      chan bool
+	// lock guards broadcastChannel and quitChannel, which are replaced by the refilling goroutine and Start.
+	lock sync.RWMutex
 }
 
 // NewStableRateLimiter returns a StableRateLimiter.
@@ -56,8 +82,10 @@ func NewStableRateLimiter(threshold int64, refillPeriod time.Duration) (rateLimi
 
 // Start to refill the bucket periodically.
 func (limiter *StableRateLimiter) Start() {
-	limiter.quitChannel = make(chan bool)
-	quitChannel := limiter.quitChannel
+	quitChannel := make(chan bool)
+	limiter.lock.Lock()
+	limiter.quitChannel = quitChannel
+	limiter.lock.Unlock()
 	go func() {
 		for {
 			select {
@@ -66,28 +94,41 @@ func (limiter *StableRateLimiter) Start() {
 			default:
 				atomic.StoreInt64(&limiter.currentThreshold, limiter.threshold)
 				time.Sleep(limiter.refillPeriod)
-				close(limiter.broadcastChannel)
-				limiter.broadcastChannel = make(chan bool)
+				limiter.broadcast()
 			}
 		}
 	}()
 }
 
+// broadcast wakes up all the goroutines waiting for the bucket to be refilled.
+func (limiter *StableRateLimiter) broadcast() {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	close(limiter.broadcastChannel)
+	limiter.broadcastChannel = make(chan bool)
+}
+
 // Acquire a token from the bucket, returns true if the bucket is exhausted.
 func (limiter *StableRateLimiter) Acquire() (blocked bool) {
 	permit := atomic.AddInt64(&limiter.currentThreshold, -1)
 	if permit < 0 {
-		blocked = true
-		// block until the bucket is refilled
-		<-limiter.broadcastChannel
-	} else {
-		blocked = false
+		limiter.lock.RLock()
+		broadcastChannel, quitChannel := limiter.broadcastChannel, limiter.quitChannel
+		limiter.lock.RUnlock()
+		// block until the bucket is refilled or the rate limiter is stopped
+		select {
+		case <-broadcastChannel:
+		case <-quitChannel:
+		}
+		return true
 	}
-	return blocked
+	return false
 }
 
-// Stop the rate limiter.
+// Stop the rate limiter, the goroutines blocked in Acquire return at once.
 func (limiter *StableRateLimiter) Stop() {
+	limiter.lock.RLock()
+	defer limiter.lock.RUnlock()
 	close(limiter.quitChannel)
 }
 
@@ -108,6 +149,8 @@ type RampUpRateLimiter struct {
 	broadcastChannel chan bool
 	rampUpChannel    chan bool
 	quitChannel      chan bool
+	// lock guards broadcastChannel and quitChannel, which are replaced by the refilling goroutine and Start.
+	lock sync.RWMutex
 }
 
 // NewRampUpRateLimiter returns a RampUpRateLimiter.
@@ -155,8 +198,10 @@ func (limiter *RampUpRateLimiter) parseRampUpRate(rampUpRate string) (rampUpStep
 
 // Start to refill the bucket periodically.
 func (limiter *RampUpRateLimiter) Start() {
-	limiter.quitChannel = make(chan bool)
-	quitChannel := limiter.quitChannel
+	quitChannel := make(chan bool)
+	limiter.lock.Lock()
+	limiter.quitChannel = quitChannel
+	limiter.lock.Unlock()
 	// bucket updater
 	go func() {
 		for {
@@ -164,10 +209,9 @@ func (limiter *RampUpRateLimiter) Start() {
 			case <-quitChannel:
 				return
 			default:
-				atomic.StoreInt64(&limiter.currentThreshold, limiter.nextThreshold)
+				atomic.StoreInt64(&limiter.currentThreshold, atomic.LoadInt64(&limiter.nextThreshold))
 				time.Sleep(limiter.refillPeriod)
-				close(limiter.broadcastChannel)
-				limiter.broadcastChannel = make(chan bool)
+				limiter.broadcast()
 			}
 		}
 	}()
@@ -178,7 +222,7 @@ func (limiter *RampUpRateLimiter) Start() {
 			case <-quitChannel:
 				return
 			default:
-				nextValue := limiter.nextThreshold + limiter.rampUpStep
+				nextValue := atomic.LoadInt64(&limiter.nextThreshold) + limiter.rampUpStep
 				if nextValue < 0 {
 					// int64 overflow
 					nextValue = int64(math.MaxInt64)
@@ -193,21 +237,281 @@ func (limiter *RampUpRateLimiter) Start() {
 	}()
 }
 
+// broadcast wakes up all the goroutines waiting for the bucket to be refilled.
+func (limiter *RampUpRateLimiter) broadcast() {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	close(limiter.broadcastChannel)
+	limiter.broadcastChannel = make(chan bool)
+}
+
 // Acquire a token from the bucket, returns true if the bucket is exhausted.
 func (limiter *RampUpRateLimiter) Acquire() (blocked bool) {
 	permit := atomic.AddInt64(&limiter.currentThreshold, -1)
 	if permit < 0 {
-		blocked = true
-		// block until the bucket is refilled
-		<-limiter.broadcastChannel
-	} else {
-		blocked = false
+		limiter.lock.RLock()
+		broadcastChannel, quitChannel := limiter.broadcastChannel, limiter.quitChannel
+		limiter.lock.RUnlock()
+		// block until the bucket is refilled or the rate limiter is stopped
+		select {
+		case <-broadcastChannel:
+		case <-quitChannel:
+		}
+		return true
 	}
-	return blocked
+	return false
 }
 
-// Stop the rate limiter.
+// Stop the rate limiter, the goroutines blocked in Acquire return at once.
 func (limiter *RampUpRateLimiter) Stop() {
-	limiter.nextThreshold = 0
+	atomic.StoreInt64(&limiter.nextThreshold, 0)
+	limiter.lock.RLock()
+	defer limiter.lock.RUnlock()
+	close(limiter.quitChannel)
+}
+
+// A TokenBucketRateLimiter uses the token bucket algorithm.
+// the bucket is refilled at a steady rate, and at most burst tokens can be kept in the bucket.
+type TokenBucketRateLimiter struct {
+	rate        int64
+	maxRate     int64
+	burst       int
+	tokens      chan bool
+	quitChannel chan bool
+	lock        sync.RWMutex
+}
+
+// NewTokenBucketLimiter returns a TokenBucketRateLimiter, which allows rate executions per second
+// with a burst of burst executions.
+func NewTokenBucketLimiter(rate int, burst int) (rateLimiter *TokenBucketRateLimiter) {
+	if rate < 1 {
+		rate = 1
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	rateLimiter = &TokenBucketRateLimiter{
+		rate:    int64(rate),
+		maxRate: int64(rate),
+		burst:   burst,
+		tokens:  make(chan bool, burst),
+	}
+	return rateLimiter
+}
+
+func (limiter *TokenBucketRateLimiter) setRate(rate int64) {
+	atomic.StoreInt64(&limiter.rate, rate)
+}
+
+func (limiter *TokenBucketRateLimiter) getRate() int64 {
+	return atomic.LoadInt64(&limiter.rate)
+}
+
+// Start to refill the bucket at a steady rate, the bucket is full when started.
+func (limiter *TokenBucketRateLimiter) Start() {
+	quitChannel := make(chan bool)
+	limiter.lock.Lock()
+	limiter.quitChannel = quitChannel
+	limiter.lock.Unlock()
+
+	limiter.fill(limiter.burst)
+
+	interval := time.Second / time.Duration(limiter.maxRate)
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		lastRefill := time.Now()
+		pending := float64(0)
+		for {
+			select {
+			case <-quitChannel:
+				return
+			case now := <-ticker.C:
+				pending += now.Sub(lastRefill).Seconds() * float64(limiter.getRate())
+				lastRefill = now
+				filled := limiter.fill(int(pending))
+				if filled < int(pending) {
+					// the bucket is full, the remaining tokens are discarded
+					pending = 0
+				} else {
+					pending -= float64(filled)
+				}
+			}
+		}
+	}()
+}
+
+// fill puts at most count tokens into the bucket without blocking, returns the number of tokens put.
+func (limiter *TokenBucketRateLimiter) fill(count int) (filled int) {
+	for filled < count {
+		select {
+		case limiter.tokens <- true:
+			filled++
+		default:
+			return filled
+		}
+	}
+	return filled
+}
+
+// Acquire a token from the bucket, it blocks until a token is available.
+// It returns true only if the rate limiter is stopped while waiting.
+func (limiter *TokenBucketRateLimiter) Acquire() (blocked bool) {
+	limiter.lock.RLock()
+	quitChannel := limiter.quitChannel
+	limiter.lock.RUnlock()
+
+	select {
+	case <-limiter.tokens:
+		return false
+	case <-quitChannel:
+		return true
+	}
+}
+
+// Stop the rate limiter, the goroutines blocked in Acquire return at once.
+func (limiter *TokenBucketRateLimiter) Stop() {
+	limiter.lock.RLock()
+	defer limiter.lock.RUnlock()
+	close(limiter.quitChannel)
+}
+
+// A LinearRampUpRateLimiter is a TokenBucketRateLimiter whose rate increases linearly
+// from startRate to targetRate during rampDuration, and stays at targetRate afterwards.
+type LinearRampUpRateLimiter struct {
+	*TokenBucketRateLimiter
+	startRate    int
+	targetRate   int
+	rampDuration time.Duration
+}
+
+// NewLinearRampUpRateLimiter returns a LinearRampUpRateLimiter.
+// For example, NewLinearRampUpRateLimiter(10, 1000, 5*time.Minute) starts at 10 RPS and climbs to 1000 RPS in 5 minutes.
+func NewLinearRampUpRateLimiter(startRate, targetRate int, rampDuration time.Duration) (rateLimiter *LinearRampUpRateLimiter) {
+	if startRate < 1 {
+		startRate = 1
+	}
+	if targetRate < startRate {
+		targetRate = startRate
+	}
+	bucket := NewTokenBucketLimiter(targetRate, startRate)
+	bucket.setRate(int64(startRate))
+	rateLimiter = &LinearRampUpRateLimiter{
+		TokenBucketRateLimiter: bucket,
+		startRate:              startRate,
+		targetRate:             targetRate,
+		rampDuration:           rampDuration,
+	}
+	return rateLimiter
+}
+
+// Start to refill the bucket and ramp up the rate.
+func (limiter *LinearRampUpRateLimiter) Start() {
+	limiter.setRate(int64(limiter.startRate))
+	limiter.TokenBucketRateLimiter.Start()
+
+	limiter.lock.RLock()
+	quitChannel := limiter.quitChannel
+	limiter.lock.RUnlock()
+
+	interval := limiter.rampDuration / 100
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		startTime := time.Now()
+		for {
+			select {
+			case <-quitChannel:
+				return
+			case now := <-ticker.C:
+				elapsed := now.Sub(startTime)
+				if elapsed >= limiter.rampDuration {
+					limiter.setRate(int64(limiter.targetRate))
+					return
+				}
+				progress := float64(elapsed) / float64(limiter.rampDuration)
+				limiter.setRate(int64(float64(limiter.startRate) + float64(limiter.targetRate-limiter.startRate)*progress))
+			}
+		}
+	}()
+}
+
+// A SlidingWindowRateLimiter allows at most rate executions in any sliding window of window long,
+// unlike the token bucket ones, no burst is allowed at the edges of windows.
+// It keeps the times of the last rate executions, a caller reserves the earliest time allowed
+// and waits until then, so the waiting callers don't wake up and compete for the permits.
+type SlidingWindowRateLimiter struct {
+	window time.Duration
+	// times is a ring of the times of the last rate executions, including the reserved ones,
+	// next points to the oldest one, which is replaced by the next execution.
+	times       []time.Time
+	next        int
+	quitChannel chan bool
+	// lock guards times, next and quitChannel.
+	lock sync.Mutex
+}
+
+// NewSlidingWindowLimiter returns a SlidingWindowRateLimiter, which allows rate executions per window.
+func NewSlidingWindowLimiter(rate int, window time.Duration) (rateLimiter *SlidingWindowRateLimiter) {
+	if rate < 1 {
+		rate = 1
+	}
+	if window <= 0 {
+		window = time.Second
+	}
+	rateLimiter = &SlidingWindowRateLimiter{
+		window: window,
+		times:  make([]time.Time, rate),
+	}
+	return rateLimiter
+}
+
+// Start to limit the executions, the times of the previous executions are forgotten.
+func (limiter *SlidingWindowRateLimiter) Start() {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.times = make([]time.Time, len(limiter.times))
+	limiter.next = 0
+	limiter.quitChannel = make(chan bool)
+}
+
+// Acquire reserves the earliest time allowed by the window, it blocks until then.
+// It returns true only if the rate limiter is stopped while waiting.
+func (limiter *SlidingWindowRateLimiter) Acquire() (blocked bool) {
+	limiter.lock.Lock()
+	now := time.Now()
+	allowed := now
+	if oldest := limiter.times[limiter.next]; !oldest.IsZero() && oldest.Add(limiter.window).After(now) {
+		allowed = oldest.Add(limiter.window)
+	}
+	limiter.times[limiter.next] = allowed
+	limiter.next = (limiter.next + 1) % len(limiter.times)
+	quitChannel := limiter.quitChannel
+	limiter.lock.Unlock()
+
+	wait := allowed.Sub(now)
+	if wait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return false
+	case <-quitChannel:
+		return true
+	}
+}
+
+// Stop the rate limiter, the goroutines blocked in Acquire return at once.
+func (limiter *SlidingWindowRateLimiter) Stop() {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
 	close(limiter.quitChannel)
 }
@@ -1,6 +1,10 @@
 package boomer
 
 import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -53,6 +57,208 @@ func TestRampUpRateLimiter(t *testing.T) {
 	}
 }
 
+func TestTokenBucketRateLimiter(t *testing.T) {
+	rateLimiter := NewTokenBucketLimiter(100, 10)
+	rateLimiter.Start()
+
+	// the bucket is full when started
+	for i := 0; i < 10; i++ {
+		if blocked := rateLimiter.Acquire(); blocked {
+			t.Error("Unexpected blocked by rate limiter")
+		}
+	}
+
+	// many goroutines acquire concurrently for a second
+	acquired := int64(0)
+	quit := make(chan bool)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-quit:
+					return
+				default:
+					if blocked := rateLimiter.Acquire(); !blocked {
+						atomic.AddInt64(&acquired, 1)
+					}
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Second)
+	close(quit)
+	count := atomic.LoadInt64(&acquired)
+	// unblock the goroutines waiting for a token
+	rateLimiter.Stop()
+	wg.Wait()
+
+	if count < 90 || count > 110 {
+		t.Error("The sustained rate should be close to 100, was:", count)
+	}
+}
+
+func TestTokenBucketRateLimiterStop(t *testing.T) {
+	rateLimiter := NewTokenBucketLimiter(1, 1)
+	rateLimiter.Start()
+
+	if blocked := rateLimiter.Acquire(); blocked {
+		t.Error("Unexpected blocked by rate limiter")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rateLimiter.Stop()
+	}()
+	if blocked := rateLimiter.Acquire(); !blocked {
+		t.Error("Acquire should return blocked when the rate limiter is stopped")
+	}
+}
+
+func TestStopUnblocksAcquire(t *testing.T) {
+	rampUp, _ := NewRampUpRateLimiter(1, "1/1m", time.Minute)
+	limiters := map[string]RateLimiter{
+		"stable":         NewStableRateLimiter(1, time.Minute),
+		"ramp-up":        rampUp,
+		"token-bucket":   NewTokenBucketLimiter(1, 1),
+		"linear-ramp-up": NewLinearRampUpRateLimiter(1, 1, time.Minute),
+		"sliding-window": NewSlidingWindowLimiter(1, time.Minute),
+	}
+	for name, rateLimiter := range limiters {
+		rateLimiter.Start()
+		// the bucket is refilled only once a minute, the workers are parked in Acquire once it's exhausted
+		const workers = 10
+		returned := make(chan bool, workers)
+		for i := 0; i < workers; i++ {
+			go func() {
+				returned <- rateLimiter.Acquire()
+			}()
+		}
+		time.Sleep(50 * time.Millisecond)
+		if n := len(returned); n > workers/2 {
+			t.Errorf("%s: most of the workers should be blocked before stop, %d returned", name, n)
+		}
+
+		rateLimiter.Stop()
+		timeout := time.After(time.Second)
+		for i := 0; i < workers; i++ {
+			select {
+			case <-returned:
+			case <-timeout:
+				t.Fatalf("%s: all the workers should be unblocked by stop, %d are still blocked", name, workers-i)
+			}
+		}
+	}
+}
+
+func TestLinearRampUpRateLimiter(t *testing.T) {
+	rateLimiter := NewLinearRampUpRateLimiter(100, 1100, time.Second)
+	rateLimiter.Start()
+
+	acquired := int64(0)
+	quit := make(chan bool)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-quit:
+					return
+				default:
+					if blocked := rateLimiter.Acquire(); !blocked {
+						atomic.AddInt64(&acquired, 1)
+					}
+				}
+			}
+		}()
+	}
+
+	// sample the throughput of 200ms windows along the ramp,
+	// the expected value is the rate in the middle of the window.
+	samples := []struct {
+		offset   time.Duration
+		expected float64
+	}{
+		{200 * time.Millisecond, 400},
+		{600 * time.Millisecond, 800},
+		{1200 * time.Millisecond, 1100},
+	}
+	startTime := time.Now()
+	for _, sample := range samples {
+		time.Sleep(sample.offset - time.Since(startTime))
+		begin := atomic.LoadInt64(&acquired)
+		time.Sleep(200 * time.Millisecond)
+		rps := float64(atomic.LoadInt64(&acquired)-begin) * 5
+		if math.Abs(rps-sample.expected) > sample.expected*0.25 {
+			t.Errorf("The throughput at %v should be close to %.0f, was %.0f", sample.offset, sample.expected, rps)
+		}
+	}
+
+	close(quit)
+	rateLimiter.Stop()
+	wg.Wait()
+
+	if rateLimiter.getRate() != 1100 {
+		t.Error("The rate should reach the target after ramp up, expected: 1100, was:", rateLimiter.getRate())
+	}
+}
+
+func TestSlidingWindowRateLimiter(t *testing.T) {
+	const rate = 10
+	const window = 200 * time.Millisecond
+	rateLimiter := NewSlidingWindowLimiter(rate, window)
+	rateLimiter.Start()
+
+	// many goroutines acquire concurrently for a second
+	lock := sync.Mutex{}
+	acquired := make([]time.Time, 0)
+	quit := make(chan bool)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-quit:
+					return
+				default:
+					if blocked := rateLimiter.Acquire(); !blocked {
+						lock.Lock()
+						acquired = append(acquired, time.Now())
+						lock.Unlock()
+					}
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Second)
+	close(quit)
+	// unblock the goroutines waiting for the window
+	rateLimiter.Stop()
+	wg.Wait()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(acquired) < 4*rate || len(acquired) > 6*rate {
+		t.Error("The sustained rate should be close to 50 per second, was:", len(acquired))
+	}
+	sort.Slice(acquired, func(i, j int) bool {
+		return acquired[i].Before(acquired[j])
+	})
+	// the goroutines may be scheduled a little late after the times allowed
+	const tolerance = 20 * time.Millisecond
+	for i := rate; i < len(acquired); i++ {
+		if elapsed := acquired[i].Sub(acquired[i-rate]); elapsed < window-tolerance {
+			t.Fatalf("At most %d acquires should succeed in any %v, but %d succeeded in %v", rate, window, rate+1, elapsed)
+		}
+	}
+}
+
 func TestParseRampUpRate(t *testing.T) {
 	rateLimiter := &RampUpRateLimiter{}
 	rampUpStep, rampUpPeriod, _ := rateLimiter.parseRampUpRate("100")
@@ -99,3 +305,15 @@ func TestParseInvalidRampUpRate(t *testing.T) {
 		t.Error("Expected ErrParsingRampUpRate")
 	}
 }
+
+func TestAcquireBackoff(t *testing.T) {
+	expected := []time.Duration{0, 0, time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond}
+	for i, backoff := range expected {
+		if acquireBackoff(i) != backoff {
+			t.Error("The backoff of", i, "consecutive blocked acquires is wrong, expected:", backoff, "was:", acquireBackoff(i))
+		}
+	}
+	if acquireBackoff(100) != maxAcquireBackoff {
+		t.Error("The backoff should not exceed", maxAcquireBackoff, "was:", acquireBackoff(100))
+	}
+}
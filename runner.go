@@ -1,10 +1,12 @@
 package boomer
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"os"
+	"math"
+	"math/rand"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,189 +19,1344 @@ const (
 	stateRunning  = "running"
 	stateStopped  = "stopped"
 	stateQuitting = "quitting"
+	statePaused   = "paused"
+)
+
+// The reasons of stopping, which are sent to master in the reason of the client_stopped and quit messages.
+// Boomer.QuitWithReason can send other reasons, like "sla violated".
+const (
+	// StopReasonNormal is the reason if no other reason is given, like Boomer.Quit.
+	StopReasonNormal = "normal"
+	// StopReasonMaster is the reason if master asks the slave to stop or quit.
+	StopReasonMaster = "master"
+	// StopReasonRunTime is the reason if the run time limit is reached.
+	StopReasonRunTime = "run time reached"
+	// StopReasonMaxRequests is the reason if the max requests limit is reached.
+	StopReasonMaxRequests = "max requests reached"
+	// StopReasonSignal is the reason if boomer is interrupted by SIGINT or SIGTERM.
+	StopReasonSignal = "signal"
 )
 
 const (
 	slaveReportInterval = 3 * time.Second
 	heartbeatInterval   = 1 * time.Second
+	reconnectMinBackoff = 1 * time.Second
+	reconnectMaxBackoff = 30 * time.Second
+	// outputs not returning in time are skipped.
+	outputEventTimeout     = slaveReportInterval
+	outputLifecycleTimeout = 10 * time.Second
+	// workers check again after disabledTasksPollInterval if all the tasks are disabled.
+	disabledTasksPollInterval = 100 * time.Millisecond
+	// the weights of tasks with Task.WeightFn are re-evaluated every weightRefreshInterval.
+	weightRefreshInterval = time.Second
 )
 
 type runner struct {
 	hatchType string
-	state     string
 	tasks     []*Task
 
+	// state is written by the goroutine receiving messages from master and read by others,
+	// use getState() and setState() to access it.
+	state     string
+	stateLock sync.RWMutex
+
 	rateLimiter      RateLimiter
 	rateLimitEnabled bool
 	stats            *requestStats
 
 	numClients int32
 	hatchRate  int
+	// the user count reported to master and outputs is userCountOverride if it's not 0,
+	// or numClients times userCountMultiplier if it's greater than 1.
+	userCountMultiplier int
+	userCountOverride   int
+	// if hatchInterval is not 0, the asap and smooth hatch types spawn one goroutine every hatchInterval
+	// instead of hatchRate goroutines per second, which allows rates below one per second.
+	hatchInterval time.Duration
 
 	// all running workers(goroutines) will select on this channel.
 	// close this channel will stop all running workers.
 	stopChan chan bool
+	// stopLock guards stopChan and runTimeTimer, stop may be called by the run time timer
+	// and master at the same time.
+	stopLock sync.Mutex
+
+	// the random generators of workers are seeded by randSeed if it's not 0, which makes the task
+	// selection reproducible. workerSeq is the sequence number of workers in current hatch.
+	randSeed  int64
+	workerSeq int64
+	// taskSelection is "random" by default, or "weighted-round-robin" to pick the tasks by roundRobin,
+	// which is shared by all the workers.
+	taskSelection string
+	roundRobin    *weightedRoundRobin
+	// singleUser spawns only 1 worker picking the tasks by roundRobin, whatever the spawn count is.
+	singleUser bool
+
+	// every message sent to this channel stops one of the running workers, it's used to ramp down.
+	rampDownChan chan bool
 
 	// close this channel will stop all goroutines used in runner.
 	closeChan chan bool
 
-	outputs []Output
+	// hatchContext is passed to Task.FnWithContext, cancelHatch is called when the runner stops.
+	hatchContext context.Context
+	cancelHatch  context.CancelFunc
+
+	// hatchSetupFunc sets up the resources shared by all the workers once per hatch, like a pooled
+	// http client, hatchTeardownFunc releases them after the workers have returned.
+	hatchSetupFunc    func() (interface{}, error)
+	hatchTeardownFunc func(shared interface{})
+	shared            sharedResources
+
+	// workers of the current hatch, it's used by stop() to wait for running tasks.
+	workersWaitGroup *sync.WaitGroup
+	runningWorkers   int32
+	// stop() waits at most stopTimeout for the running tasks to return, 0 means no waiting.
+	stopTimeout time.Duration
+
+	// the test stops after runTime since the last hatch, 0 means no limit.
+	runTime      time.Duration
+	runTimeTimer *time.Timer
+	// the test stops after maxRequests task executions since the last hatch, 0 means no limit.
+	maxRequests int64
+	numRequests int64
+	// onLimitReached is called when runTime or maxRequests is reached.
+	onLimitReached func()
+	// onRampedDown is called when all the goroutines are stopped by stopHatching, it may be nil.
+	onRampedDown func()
+	// stopReason is the reason of quitting locally, it's StopReasonNormal if not set.
+	stopReason atomic.Value
+
+	outputs     []*outputEntry
+	outputsLock sync.RWMutex
+	// every report is checked against sla if it's not nil, slaViolated is set to 1 once
+	// it's violated with SLA.FailOnViolation.
+	sla         *SLA
+	slaViolated int32
+	// overrides outputEventTimeout and outputLifecycleTimeout if it's not 0, it's used in tests.
+	outputTimeout time.Duration
+
+	// workers sleep a random duration between minWait and maxWait after each task execution.
+	minWait time.Duration
+	maxWait time.Duration
+
+	// the records in warmup since every hatch are not counted in the stats, warmupEnd is in unix nano.
+	warmup    time.Duration
+	warmupEnd int64
+
+	// the fraction of successes recorded as injected failures, 0 means none.
+	failureRate float64
+
+	// the workers pick tasks by activeWeights, which is recomputed when a task is enabled or disabled
+	// by name, so it takes effect without hatching again.
+	disabledTasks     map[string]bool
+	disabledTasksLock sync.Mutex
+	activeWeights     atomic.Value
+	// tasks reaching Task.MaxIterations of all the goroutines are exhausted until the next hatch,
+	// taskIterations counts their executions, which are created once and reset by every hatch.
+	exhaustedTasks     map[*Task]bool
+	taskIterations     map[*Task]*int64
+	taskIterationsOnce sync.Once
+	// the circuits of the tasks with a CircuitBreaker, keyed by task name, they are created once.
+	circuits     map[string]*circuit
+	circuitsOnce sync.Once
+	// overrides weightRefreshInterval if it's not 0, it's used in tests.
+	weightRefreshInterval time.Duration
+	// swaps the weights of tasks by the time of day, it's evaluated by clock, which is replaced in tests.
+	weightSchedule WeightSchedule
+	clock          func() time.Time
+
+	// limit the number of goroutines executing a task with MaxConcurrency at the same time.
+	taskSemaphores     map[*Task]chan struct{}
+	taskSemaphoresOnce sync.Once
+
+	// hatchType "step" spawns stepSize goroutines every stepDuration.
+	stepSize     int
+	stepDuration time.Duration
+	// hatchType "spike" spawns spikeCount extra goroutines after hatching, which quit after spikeDuration.
+	spikeCount    int
+	spikeDuration time.Duration
+
+	// workers block between iterations on resumeChan while paused is 1.
+	paused           int32
+	resumeChan       chan bool
+	stateBeforePause string
+	pauseLock        sync.Mutex
+}
+
+func (r *runner) getState() string {
+	r.stateLock.RLock()
+	defer r.stateLock.RUnlock()
+	return r.state
+}
+
+func (r *runner) setState(state string) {
+	r.stateLock.Lock()
+	r.state = state
+	r.stateLock.Unlock()
 }
 
-// safeRun runs fn and recovers from unexpected panics.
+// setStateUnless changes the state unless it's one of excluded, it returns false if it's not changed,
+// so a transition never overwrites a concurrent one, like hatching completes after master asks to stop.
+func (r *runner) setStateUnless(state string, excluded ...string) bool {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+	for _, s := range excluded {
+		if r.state == s {
+			return false
+		}
+	}
+	r.state = state
+	return true
+}
+
+// safeRun runs fn and recovers from unexpected panics, the panic is logged and returned.
 // it prevents panics from Task.Fn crashing boomer.
-func (r *runner) safeRun(fn func()) {
+func (r *runner) safeRun(fn func()) (recovered interface{}) {
 	defer func() {
 		// don't panic
-		err := recover()
-		if err != nil {
+		recovered = recover()
+		if recovered != nil {
 			stackTrace := debug.Stack()
-			errMsg := fmt.Sprintf("%v", err)
-			os.Stderr.Write([]byte(errMsg))
-			os.Stderr.Write([]byte("\n"))
-			os.Stderr.Write(stackTrace)
+			logger.Errorf("%v\n%s", recovered, stackTrace)
 		}
 	}()
 	fn()
+	return nil
 }
 
-func (r *runner) addOutput(o Output) {
-	r.outputs = append(r.outputs, o)
+// runTask runs the task with safeRun and records the execution in the per-task stats.
+// A panic is recorded as a failure of the "panic" type and published as a boomer:panic event.
+func (r *runner) runTask(ctx context.Context, task *Task) {
+	failed := true
+	startTime := time.Now()
+	recovered := r.safeRun(func() {
+		task.run(ctx)
+		failed = false
+	})
+	elapsed := time.Since(startTime)
+	responseTime := int64(elapsed / time.Millisecond)
+	if recovered != nil {
+		Events.Publish("boomer:panic", task.Name, recovered)
+	}
+	if r.stats == nil || r.isWarmingUp() {
+		return
+	}
+	select {
+	case r.stats.taskExecutionChan <- &taskExecution{
+		name:         task.Name,
+		responseTime: responseTime,
+		failed:       failed,
+		slow:         task.LatencyBudget > 0 && elapsed > task.LatencyBudget,
+	}:
+	case <-r.closeChan:
+	}
+	if recovered != nil {
+		name := task.Name
+		if name == "" {
+			name = unnamedTask
+		}
+		r.recordFailure("panic", name, responseTime, fmt.Sprintf("%v", recovered), ErrorCategoryPanic)
+	}
 }
 
-func (r *runner) outputOnStart() {
-	size := len(r.outputs)
-	if size == 0 {
+// reportedUserCount returns the user count in the stats and hatch_complete messages, which may
+// represent more virtual users than the goroutines.
+func (r *runner) reportedUserCount() int32 {
+	if r.userCountOverride > 0 {
+		return int32(r.userCountOverride)
+	}
+	numClients := atomic.LoadInt32(&r.numClients)
+	if r.userCountMultiplier > 1 {
+		return numClients * int32(r.userCountMultiplier)
+	}
+	return numClients
+}
+
+// setStopReason sets the reason of quitting, which is sent to master in the quit message.
+func (r *runner) setStopReason(reason string) {
+	r.stopReason.Store(reason)
+}
+
+// getStopReason returns the reason of quitting, StopReasonNormal if it's not set.
+func (r *runner) getStopReason() string {
+	if reason, ok := r.stopReason.Load().(string); ok && reason != "" {
+		return reason
+	}
+	return StopReasonNormal
+}
+
+// isWarmingUp returns true in the warmup since current hatch starts.
+func (r *runner) isWarmingUp() bool {
+	return r.warmup > 0 && time.Now().UnixNano() < atomic.LoadInt64(&r.warmupEnd)
+}
+
+// recordSuccess sends a success to the stats goroutine, it gives up if the runner is closed.
+// It's ignored in the warmup, and recorded as a failure at the chance of failureRate.
+func (r *runner) recordSuccess(requestType, name string, responseTime int64, responseLength int64) {
+	r.recordSuccessWithLabels(requestType, name, responseTime, responseLength, nil)
+}
+
+// recordSuccessWithLabels is like recordSuccess, but the success is aggregated by labels too.
+func (r *runner) recordSuccessWithLabels(requestType, name string, responseTime int64, responseLength int64, labels map[string]string) {
+	if r.isWarmingUp() {
 		return
 	}
-	wg := sync.WaitGroup{}
-	wg.Add(size)
-	for _, output := range r.outputs {
-		go func(o Output) {
-			o.OnStart()
-			wg.Done()
-		}(output)
+	if r.failureRate > 0 && rand.Float64() < r.failureRate {
+		r.recordFailureWithLabels(requestType, name, responseTime, "injected failure", ErrorCategoryInjected, labels)
+		return
 	}
-	wg.Wait()
+	r.recordResult(name, false)
+	select {
+	case r.stats.requestSuccessChan <- &requestSuccess{
+		requestType:    requestType,
+		name:           name,
+		responseTime:   responseTime,
+		responseLength: responseLength,
+		labels:         copyLabels(labels),
+	}:
+	case <-r.closeChan:
+	}
+}
+
+// recordFailure sends a failure to the stats goroutine, it gives up if the runner is closed.
+// The category is optional. It's ignored in the warmup.
+func (r *runner) recordFailure(requestType, name string, responseTime int64, exception, category string) {
+	r.recordFailureWithLabels(requestType, name, responseTime, exception, category, nil)
 }
 
-func (r *runner) outputOnEevent(data map[string]interface{}) {
-	size := len(r.outputs)
-	if size == 0 {
+// recordFailureWithLabels is like recordFailure, but the failure is aggregated by labels too.
+func (r *runner) recordFailureWithLabels(requestType, name string, responseTime int64, exception, category string, labels map[string]string) {
+	if r.isWarmingUp() {
 		return
 	}
-	wg := sync.WaitGroup{}
-	wg.Add(size)
-	for _, output := range r.outputs {
-		go func(o Output) {
-			o.OnEvent(data)
-			wg.Done()
-		}(output)
+	r.recordResult(name, true)
+	select {
+	case r.stats.requestFailureChan <- &requestFailure{
+		requestType:  requestType,
+		name:         name,
+		responseTime: responseTime,
+		error:        exception,
+		category:     category,
+		labels:       copyLabels(labels),
+	}:
+	case <-r.closeChan:
 	}
-	wg.Wait()
 }
 
-func (r *runner) outputOnStop() {
-	size := len(r.outputs)
-	if size == 0 {
+// outputEntry is a registered output. The busy flag is kept per registration rather than keyed by
+// the output, which may be a value of an uncomparable type, or equal to another registered output.
+type outputEntry struct {
+	output Output
+	// 1 while the last call hasn't returned, the output is skipped until it returns.
+	busy int32
+}
+
+func (r *runner) addOutput(o Output) {
+	r.outputsLock.Lock()
+	defer r.outputsLock.Unlock()
+	r.outputs = append(r.outputs, &outputEntry{output: o})
+}
+
+// removeOutput removes o from the outputs, it returns false if o is not found.
+func (r *runner) removeOutput(o Output) bool {
+	r.outputsLock.Lock()
+	defer r.outputsLock.Unlock()
+	for i, entry := range r.outputs {
+		if entry.output == o {
+			// copy on write, the outputs being called are not affected
+			outputs := make([]*outputEntry, 0, len(r.outputs)-1)
+			outputs = append(outputs, r.outputs[:i]...)
+			r.outputs = append(outputs, r.outputs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (r *runner) clearOutputs() {
+	r.outputsLock.Lock()
+	defer r.outputsLock.Unlock()
+	r.outputs = nil
+}
+
+func (r *runner) getOutputs() []Output {
+	entries := r.getOutputEntries()
+	outputs := make([]Output, 0, len(entries))
+	for _, entry := range entries {
+		outputs = append(outputs, entry.output)
+	}
+	return outputs
+}
+
+func (r *runner) getOutputEntries() []*outputEntry {
+	r.outputsLock.RLock()
+	defer r.outputsLock.RUnlock()
+	return r.outputs
+}
+
+// fanOutOutputs calls fn with every output in separated goroutines, and waits for all of them at most timeout.
+// Outputs are few and called every few seconds, so a goroutine per call is cheap enough.
+// A panic in an output is recovered, and an output not returning in time is skipped,
+// so a misbehaving output can't stall the runner. An output is not called again until its last
+// call returns, so a hanging output doesn't pile up goroutines, and outputs are never called concurrently.
+func (r *runner) fanOutOutputs(timeout time.Duration, fn func(o Output)) {
+	outputs := r.getOutputEntries()
+	if len(outputs) == 0 {
 		return
 	}
-	wg := sync.WaitGroup{}
-	wg.Add(size)
-	for _, output := range r.outputs {
-		go func(o Output) {
-			o.OnStop()
-			wg.Done()
-		}(output)
+	if r.outputTimeout > 0 {
+		timeout = r.outputTimeout
 	}
-	wg.Wait()
+	// buffered, so the outputs returning after the timeout don't block
+	done := make(chan bool, len(outputs))
+	size := 0
+	for _, entry := range outputs {
+		if !atomic.CompareAndSwapInt32(&entry.busy, 0, 1) {
+			logger.Errorf("The last call of output %T hasn't returned, it's skipped", entry.output)
+			continue
+		}
+		size++
+		go func(entry *outputEntry) {
+			defer atomic.StoreInt32(&entry.busy, 0)
+			r.safeRun(func() {
+				fn(entry.output)
+			})
+			done <- true
+		}(entry)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for finished := 0; finished < size; finished++ {
+		select {
+		case <-done:
+		case <-timer.C:
+			logger.Errorf("%d of %d outputs didn't return in %v, they are skipped", size-finished, size, timeout)
+			return
+		}
+	}
+}
+
+func (r *runner) outputOnStart() {
+	r.fanOutOutputs(outputLifecycleTimeout, func(o Output) {
+		o.OnStart()
+	})
+}
+
+func (r *runner) outputOnEvent(data map[string]interface{}) {
+	r.fanOutOutputs(outputEventTimeout, func(o Output) {
+		o.OnEvent(data)
+	})
+}
+
+func (r *runner) outputOnStop() {
+	r.fanOutOutputs(outputLifecycleTimeout, func(o Output) {
+		o.OnStop()
+	})
+}
+
+// dryRun runs every task once with its hooks in current goroutine, and returns the problems found.
+func (r *runner) dryRun() (problems []string) {
+	for i, task := range r.tasks {
+		if task == nil {
+			problems = append(problems, fmt.Sprintf("task #%d is nil", i))
+			continue
+		}
+		name := task.displayName(i)
+		if err := task.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("task %s is invalid, %v", name, err))
+			if task.Fn == nil && task.FnWithContext == nil {
+				continue
+			}
+		}
+
+		if task.OnStart != nil {
+			var err error
+			if recovered := r.safeRun(func() { err = task.OnStart() }); recovered != nil {
+				problems = append(problems, fmt.Sprintf("task %s panics in OnStart, %v", name, recovered))
+				continue
+			}
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("task %s fails in OnStart, %v", name, err))
+				continue
+			}
+		}
+		logger.Infof("Dry running %s", name)
+		if recovered := r.safeRun(func() { task.run(context.Background()) }); recovered != nil {
+			problems = append(problems, fmt.Sprintf("task %s panics, %v", name, recovered))
+		}
+		if task.OnStop != nil {
+			if recovered := r.safeRun(task.OnStop); recovered != nil {
+				problems = append(problems, fmt.Sprintf("task %s panics in OnStop, %v", name, recovered))
+			}
+		}
+	}
+
+	if len(r.tasks) == 0 {
+		problems = append(problems, "no task to run")
+	} else if weightSum := r.getWeightSum(); math.IsNaN(weightSum) || math.IsInf(weightSum, 0) {
+		problems = append(problems, fmt.Sprintf("the sum of weights %v is invalid", weightSum))
+	}
+	return problems
 }
 
-func (r *runner) getWeightSum() (weightSum int) {
+func (r *runner) getWeightSum() (weightSum float64) {
 	for _, task := range r.tasks {
-		weightSum += task.Weight
+		// reported by dryRun rather than panicking here
+		if task == nil {
+			continue
+		}
+		weightSum += task.getWeight()
 	}
 	return weightSum
 }
 
-func (r *runner) spawnWorkers(spawnCount int, quit chan bool, hatchCompleteFunc func()) {
-	log.Println("Hatching and swarming", spawnCount, "clients at the rate", r.hatchRate, "clients/s...")
+// getCumulativeWeights returns the prefix sums of the task weights, the last element is the sum
+// of all the weights. The weights in weightSchedule override the ones of the tasks at current time.
+// Tasks disabled by name count as zero weight. If all the enabled tasks have no weight,
+// each of them counts as 1, so they have the same chance to be picked.
+// The caller must hold disabledTasksLock if tasks can be enabled or disabled concurrently.
+func (r *runner) getCumulativeWeights() (cumulativeWeights []float64) {
+	var scheduledWeights map[string]float64
+	if len(r.weightSchedule) > 0 {
+		scheduledWeights = r.weightSchedule.weightsAt(r.now())
+	}
+	getWeight := func(task *Task) float64 {
+		if weight, ok := scheduledWeights[task.Name]; ok {
+			return weight
+		}
+		return task.getWeight()
+	}
 
-	weightSum := r.getWeightSum()
+	uniform := true
+	for _, task := range r.tasks {
+		if !r.isTaskExcluded(task) && getWeight(task) != 0 {
+			uniform = false
+			break
+		}
+	}
+
+	cumulativeWeights = make([]float64, len(r.tasks))
+	weightSum := float64(0)
+	for i, task := range r.tasks {
+		switch {
+		case r.isTaskExcluded(task):
+		case uniform:
+			weightSum++
+		default:
+			weightSum += getWeight(task)
+		}
+		cumulativeWeights[i] = weightSum
+	}
+	return cumulativeWeights
+}
+
+// isTaskExcluded returns true if the task is disabled by name, exhausted or its circuit is open, it's never picked.
+func (r *runner) isTaskExcluded(task *Task) bool {
+	return r.disabledTasks[task.Name] || r.exhaustedTasks[task] || r.getCircuit(task.Name).isOpen()
+}
+
+// excludeTasks returns a copy of cumulativeWeights in which the weights of the excluded tasks are zero.
+func excludeTasks(tasks []*Task, cumulativeWeights []float64, excluded func(task *Task) bool) []float64 {
+	weights := make([]float64, len(cumulativeWeights))
+	previous, weightSum := float64(0), float64(0)
+	for i, cumulativeWeight := range cumulativeWeights {
+		if !excluded(tasks[i]) {
+			weightSum += cumulativeWeight - previous
+		}
+		previous = cumulativeWeight
+		weights[i] = weightSum
+	}
+	return weights
+}
+
+// getTaskIterations returns the counter of a task with a global MaxIterations, or nil if it's unlimited.
+func (r *runner) getTaskIterations(task *Task) *int64 {
+	r.taskIterationsOnce.Do(func() {
+		r.taskIterations = make(map[*Task]*int64)
+		for _, t := range r.tasks {
+			if t != nil && t.MaxIterations > 0 && !t.MaxIterationsPerUser {
+				r.taskIterations[t] = new(int64)
+			}
+		}
+	})
+	return r.taskIterations[task]
+}
+
+// resetTaskIterations makes the exhausted tasks available again, it's called by every hatch.
+func (r *runner) resetTaskIterations() {
+	for _, task := range r.tasks {
+		if counter := r.getTaskIterations(task); counter != nil {
+			atomic.StoreInt64(counter, 0)
+		}
+	}
+	r.disabledTasksLock.Lock()
+	r.exhaustedTasks = nil
+	r.disabledTasksLock.Unlock()
+}
+
+// reserveIteration counts an execution of the task, it returns false if the task has been executed
+// MaxIterations times by all the goroutines, or by current goroutine whose executions are userIterations.
+// The task is excluded from the selection once the limit is reached.
+func (r *runner) reserveIteration(task *Task, userIterations map[*Task]int) bool {
+	if task.MaxIterations <= 0 {
+		return true
+	}
+	if task.MaxIterationsPerUser {
+		if userIterations[task] >= task.MaxIterations {
+			return false
+		}
+		userIterations[task]++
+		return true
+	}
+
+	n := atomic.AddInt64(r.getTaskIterations(task), 1)
+	if n > int64(task.MaxIterations) {
+		return false
+	}
+	if n == int64(task.MaxIterations) {
+		r.disabledTasksLock.Lock()
+		if r.exhaustedTasks == nil {
+			r.exhaustedTasks = make(map[*Task]bool)
+		}
+		r.exhaustedTasks[task] = true
+		r.activeWeights.Store(r.getCumulativeWeights())
+		r.disabledTasksLock.Unlock()
+	}
+	return true
+}
+
+// updateActiveWeights recomputes the cumulative weights used by the running workers.
+func (r *runner) updateActiveWeights() {
+	r.disabledTasksLock.Lock()
+	defer r.disabledTasksLock.Unlock()
+	r.activeWeights.Store(r.getCumulativeWeights())
+}
+
+// refreshWeights calls updateActiveWeights periodically until quit is closed,
+// if any of the tasks has a WeightFn, or the weights are scheduled.
+func (r *runner) refreshWeights(quit chan bool) {
+	dynamic := len(r.weightSchedule) > 0
+	for _, task := range r.tasks {
+		if task.WeightFn != nil {
+			dynamic = true
+			break
+		}
+	}
+	if !dynamic {
+		return
+	}
+
+	interval := weightRefreshInterval
+	if r.weightRefreshInterval > 0 {
+		interval = r.weightRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			r.updateActiveWeights()
+		}
+	}
+}
+
+// now returns the current time by clock, or time.Now if it's nil.
+func (r *runner) now() time.Time {
+	if r.clock != nil {
+		return r.clock()
+	}
+	return time.Now()
+}
+
+// getActiveWeights returns the cumulative weights stored by updateActiveWeights.
+func (r *runner) getActiveWeights() []float64 {
+	cumulativeWeights, _ := r.activeWeights.Load().([]float64)
+	return cumulativeWeights
+}
+
+// setTaskEnabled enables or disables all the tasks named name, the running workers
+// pick tasks accordingly from their next iteration. It returns false if there is no such task.
+func (r *runner) setTaskEnabled(name string, enabled bool) bool {
+	r.disabledTasksLock.Lock()
+	defer r.disabledTasksLock.Unlock()
+
+	found := false
 	for _, task := range r.tasks {
-		percent := float64(task.Weight) / float64(weightSum)
-		amount := int(round(float64(spawnCount)*percent, .5, 0))
+		if task.Name == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	if enabled {
+		delete(r.disabledTasks, name)
+	} else {
+		if r.disabledTasks == nil {
+			r.disabledTasks = make(map[string]bool)
+		}
+		r.disabledTasks[name] = true
+	}
+	r.activeWeights.Store(r.getCumulativeWeights())
+	return true
+}
 
-		if weightSum == 0 {
-			amount = int(float64(spawnCount) / float64(len(r.tasks)))
+// pickTask makes a weighted random draw, the task whose cumulative boundary the roll
+// falls into is selected, so each task is picked with a probability of weight / weightSum.
+// It returns nil if no task can be picked, e.g. all of them are disabled.
+func (r *runner) pickTask(rd *rand.Rand, cumulativeWeights []float64) *Task {
+	tasksCount := len(r.tasks)
+	if tasksCount == 0 || len(cumulativeWeights) != tasksCount {
+		return nil
+	}
+	weightSum := cumulativeWeights[tasksCount-1]
+	if weightSum <= 0 {
+		return nil
+	}
+	if tasksCount == 1 {
+		return r.tasks[0]
+	}
+	roll := rd.Float64() * weightSum
+	// the first task whose cumulative weight is greater than roll
+	index := sort.Search(tasksCount, func(i int) bool {
+		return cumulativeWeights[i] > roll
+	})
+	if index == tasksCount {
+		// guard against floating-point rounding, the first task reaching the sum is never a disabled one
+		index = sort.Search(tasksCount, func(i int) bool {
+			return cumulativeWeights[i] >= weightSum
+		})
+	}
+	return r.tasks[index]
+}
+
+// weightedRoundRobin is the smooth weighted round-robin of nginx, in every cycle of weightSum picks,
+// each task is picked weight times, and the picks of a task are spread evenly in the cycle.
+type weightedRoundRobin struct {
+	lock           sync.Mutex
+	currentWeights []float64
+}
+
+// pick returns the task with the largest current weight, after every current weight is increased by
+// the weight of its task, then the current weight of the picked task is decreased by weightSum.
+// It returns nil if no task can be picked, like pickTask.
+func (w *weightedRoundRobin) pick(tasks []*Task, cumulativeWeights []float64) *Task {
+	if len(tasks) == 0 || len(cumulativeWeights) != len(tasks) {
+		return nil
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.currentWeights) != len(tasks) {
+		w.currentWeights = make([]float64, len(tasks))
+	}
+	best := -1
+	previous, weightSum := float64(0), float64(0)
+	for i, cumulativeWeight := range cumulativeWeights {
+		weight := cumulativeWeight - previous
+		previous = cumulativeWeight
+		if weight <= 0 {
+			// the disabled tasks start over when they are enabled
+			w.currentWeights[i] = 0
+			continue
+		}
+		weightSum += weight
+		w.currentWeights[i] += weight
+		if best < 0 || w.currentWeights[i] > w.currentWeights[best] {
+			best = i
 		}
+	}
+	if best < 0 {
+		return nil
+	}
+	w.currentWeights[best] -= weightSum
+	return tasks[best]
+}
+
+// maxHatchProgressEvents limits the boomer:hatch_progress events published in one hatch,
+// so the overhead is low even if millions of goroutines are spawned.
+const maxHatchProgressEvents = 100
+
+// spawnWorkers spawns spawnCount goroutines, the spawned and target counts are published in
+// boomer:hatch_progress events along the way, the last one is published when all are spawned.
+func (r *runner) spawnWorkers(spawnCount int, quit chan bool, hatchCompleteFunc func()) {
+	if r.hatchInterval > 0 {
+		logger.Infof("Hatching and swarming %d clients at the interval of %v...", spawnCount, r.hatchInterval)
+	} else {
+		logger.Infof("Hatching and swarming %d clients at the rate %d clients/s...", spawnCount, r.hatchRate)
+	}
+
+	if r.singleUser && spawnCount > 1 {
+		logger.Infof("Single user mode, only 1 client is spawned rather than %d", spawnCount)
+		spawnCount = 1
+	}
+
+	r.updateActiveWeights()
+	go r.refreshWeights(quit)
+	if (r.taskSelection == "weighted-round-robin" || r.singleUser) && r.roundRobin == nil {
+		r.roundRobin = &weightedRoundRobin{}
+	}
+	wg := r.workersWaitGroup
+	ctx := r.hatchContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, ok := r.setupShared(ctx, wg, quit)
+	if !ok {
+		return
+	}
 
-		for i := 1; i <= amount; i++ {
-			if r.hatchType == "smooth" {
-				time.Sleep(time.Duration(1000000/r.hatchRate) * time.Microsecond)
-			} else if i%r.hatchRate == 0 {
-				time.Sleep(1 * time.Second)
+	progressStep := (spawnCount + maxHatchProgressEvents - 1) / maxHatchProgressEvents
+	for i := 0; i < spawnCount; i++ {
+		switch r.hatchType {
+		case "smooth":
+			if interval := r.getHatchInterval(); interval > 0 {
+				select {
+				case <-quit:
+					return
+				case <-time.After(interval):
+				}
+			}
+		case "step":
+			if i > 0 && i%r.getStepSize() == 0 {
+				select {
+				case <-quit:
+					return
+				case <-time.After(r.getStepDuration()):
+				}
+			}
+		case "spike":
+			// spawn all at once
+		default:
+			// hatchRate goroutines at once every second, or one by one every hatchInterval
+			var delay time.Duration
+			if r.hatchInterval > 0 {
+				delay = r.hatchInterval
+			} else if r.hatchRate > 0 && i%r.hatchRate == 0 {
+				delay = time.Second
+			}
+			if i > 0 && delay > 0 {
+				select {
+				case <-quit:
+					return
+				case <-time.After(delay):
+				}
 			}
+		}
+
+		select {
+		case <-quit:
+			// quit hatching goroutine
+			return
+		default:
+			r.spawnWorker(ctx, wg, quit)
+		}
+		if spawned := i + 1; spawned%progressStep == 0 || spawned == spawnCount {
+			Events.Publish("boomer:hatch_progress", spawned, spawnCount)
+		}
+	}
+
+	if r.hatchType == "spike" && r.spikeCount > 0 && !r.singleUser {
+		r.spawnSpike(ctx, wg, quit)
+	}
+
+	Events.Publish("boomer:spawn_complete", int(atomic.LoadInt32(&r.numClients)))
+	if hatchCompleteFunc != nil {
+		hatchCompleteFunc()
+	}
+}
+
+// seedSequence makes the seeds of workers differ, even if they are created in the same nanosecond.
+var seedSequence int64
+
+// newRand returns a random generator for a new worker, it's not safe for concurrent use.
+func (r *runner) newRand() *rand.Rand {
+	if r.randSeed != 0 {
+		r.workerSeq++
+		return rand.New(rand.NewSource(r.randSeed + r.workerSeq))
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano() + atomic.AddInt64(&seedSequence, 1)))
+}
 
+// spawnWorker starts a goroutine running tasks in a loop until quit is closed.
+func (r *runner) spawnWorker(ctx context.Context, wg *sync.WaitGroup, quit chan bool) {
+	rampDown := r.rampDownChan
+	roundRobin := r.roundRobin
+	rd := r.newRand()
+	atomic.AddInt32(&r.numClients, 1)
+	atomic.AddInt32(&r.runningWorkers, 1)
+	wg.Add(1)
+	go func() {
+		defer func() {
+			atomic.AddInt32(&r.runningWorkers, -1)
+			wg.Done()
+		}()
+		if !r.startUser() {
+			atomic.AddInt32(&r.numClients, -1)
+			return
+		}
+		defer r.stopUser(r.tasks)
+		consecutiveBlocked := 0
+		// executions of the tasks with Task.MaxIterationsPerUser
+		userIterations := make(map[*Task]int)
+		for {
 			select {
 			case <-quit:
-				// quit hatching goroutine
+				return
+			case <-rampDown:
+				atomic.AddInt32(&r.numClients, -1)
 				return
 			default:
-				atomic.AddInt32(&r.numClients, 1)
-				go func(fn func()) {
-					for {
-						select {
-						case <-quit:
-							return
-						default:
-							if r.rateLimitEnabled {
-								blocked := r.rateLimiter.Acquire()
-								if !blocked {
-									r.safeRun(fn)
-								}
-							} else {
-								r.safeRun(fn)
+				if !r.waitIfPaused(quit, rampDown) {
+					return
+				}
+				cumulativeWeights := r.getActiveWeights()
+				if len(userIterations) > 0 {
+					cumulativeWeights = excludeTasks(r.tasks, cumulativeWeights, func(t *Task) bool {
+						return t.MaxIterationsPerUser && userIterations[t] >= t.MaxIterations
+					})
+				}
+				var task *Task
+				if roundRobin != nil {
+					task = roundRobin.pick(r.tasks, cumulativeWeights)
+				} else {
+					task = r.pickTask(rd, cumulativeWeights)
+				}
+				if task == nil {
+					// all the tasks are disabled or exhausted, wait for one of them to be enabled
+					select {
+					case <-quit:
+						return
+					case <-time.After(disabledTasksPollInterval):
+					}
+					continue
+				}
+				if r.rateLimitEnabled {
+					blocked := r.rateLimiter.Acquire()
+					if blocked {
+						consecutiveBlocked++
+						if backoff := acquireBackoff(consecutiveBlocked); backoff > 0 {
+							select {
+							case <-quit:
+								return
+							case <-time.After(backoff):
 							}
 						}
+						continue
 					}
-				}(task.Fn)
+					consecutiveBlocked = 0
+				}
+				if !r.reserveIteration(task, userIterations) {
+					continue
+				}
+				if r.maxRequests > 0 {
+					// count before running, so that all the workers together never exceed maxRequests
+					n := atomic.AddInt64(&r.numRequests, 1)
+					if n > r.maxRequests {
+						<-quit
+						return
+					}
+					if !r.runTaskWithLimit(ctx, task, quit) {
+						return
+					}
+					if n == r.maxRequests && r.onLimitReached != nil {
+						logger.Infof("Max requests limit of %d is reached, boomer will quit", r.maxRequests)
+						r.setStopReason(StopReasonMaxRequests)
+						go r.onLimitReached()
+					}
+				} else if !r.runTaskWithLimit(ctx, task, quit) {
+					return
+				}
+				if r.wait(rd, quit, rampDown, task) {
+					return
+				}
 			}
 		}
+	}()
+}
+
+// runTaskWithLimit runs the task once it's executed by less than Task.MaxConcurrency goroutines,
+// it returns false if quit is closed while waiting.
+func (r *runner) runTaskWithLimit(ctx context.Context, task *Task, quit chan bool) bool {
+	semaphore := r.getTaskSemaphore(task)
+	if semaphore == nil {
+		r.runTask(ctx, task)
+		return true
 	}
+	select {
+	case semaphore <- struct{}{}:
+	case <-quit:
+		return false
+	}
+	defer func() {
+		<-semaphore
+	}()
+	r.runTask(ctx, task)
+	return true
+}
 
-	if hatchCompleteFunc != nil {
-		hatchCompleteFunc()
+// getTaskSemaphore returns the semaphore limiting the concurrency of task, or nil if it's unlimited.
+// The semaphores are created once and shared by all the hatches, so the goroutines of a previous hatch
+// which are still running count too.
+func (r *runner) getTaskSemaphore(task *Task) chan struct{} {
+	r.taskSemaphoresOnce.Do(func() {
+		r.taskSemaphores = make(map[*Task]chan struct{})
+		for _, t := range r.tasks {
+			if t != nil && t.MaxConcurrency > 0 {
+				r.taskSemaphores[t] = make(chan struct{}, t.MaxConcurrency)
+			}
+		}
+	})
+	return r.taskSemaphores[task]
+}
+
+// spawnSpike over-provisions spikeCount extra goroutines, which quit after spikeDuration.
+func (r *runner) spawnSpike(ctx context.Context, wg *sync.WaitGroup, quit chan bool) {
+	logger.Infof("Spiking %d extra clients for %v", r.spikeCount, r.spikeDuration)
+	spikeQuit := make(chan bool)
+	for i := 0; i < r.spikeCount; i++ {
+		r.spawnWorker(ctx, wg, spikeQuit)
+	}
+	go func() {
+		select {
+		case <-quit:
+		case <-time.After(r.spikeDuration):
+			atomic.AddInt32(&r.numClients, -int32(r.spikeCount))
+		}
+		close(spikeQuit)
+	}()
+}
+
+// getStepSize returns the number of goroutines spawned in each step, which defaults to hatch rate.
+func (r *runner) getStepSize() int {
+	if r.stepSize > 0 {
+		return r.stepSize
+	}
+	if r.hatchRate > 0 {
+		return r.hatchRate
+	}
+	return 1
+}
+
+// getHatchInterval returns the interval between two goroutines spawned by the smooth hatch type,
+// 0 means spawning all at once if both hatchInterval and hatchRate are not set.
+func (r *runner) getHatchInterval() time.Duration {
+	if r.hatchInterval > 0 {
+		return r.hatchInterval
+	}
+	if r.hatchRate > 0 {
+		return time.Second / time.Duration(r.hatchRate)
+	}
+	return 0
+}
+
+// getStepDuration returns the duration between two steps, which defaults to 1 second.
+func (r *runner) getStepDuration() time.Duration {
+	if r.stepDuration > 0 {
+		return r.stepDuration
+	}
+	return time.Second
+}
+
+// startUser calls OnStart of all the tasks, before a goroutine enters the loop.
+// If one of them fails, the tasks already started are stopped and false is returned.
+func (r *runner) startUser() bool {
+	weightSum := r.getWeightSum()
+	for i, task := range r.tasks {
+		if task.OnStart == nil || (isTaskDisabled(task, weightSum) && !r.weightSchedule.isScheduled(task)) {
+			continue
+		}
+		if err := task.OnStart(); err != nil {
+			logger.Errorf("OnStart of task %s failed, the user is aborted: %v", task.Name, err)
+			r.stopUser(r.tasks[:i])
+			return false
+		}
+	}
+	return true
+}
+
+// stopUser calls OnStop of the given tasks, when a goroutine quits.
+func (r *runner) stopUser(tasks []*Task) {
+	weightSum := r.getWeightSum()
+	for _, task := range tasks {
+		if task.OnStop != nil && (!isTaskDisabled(task, weightSum) || r.weightSchedule.isScheduled(task)) {
+			r.safeRun(task.OnStop)
+		}
+	}
+}
+
+// isTaskDisabled returns true if the task has a static zero weight while the others don't, so it's never picked.
+func isTaskDisabled(task *Task, weightSum float64) bool {
+	return task.WeightFn == nil && task.getWeight() == 0 && weightSum > 0
+}
+
+// pause makes the workers block between iterations, but they remain alive.
+// It returns false if the runner is not hatching or running.
+func (r *runner) pause() bool {
+	r.pauseLock.Lock()
+	defer r.pauseLock.Unlock()
+
+	state := r.getState()
+	if state != stateHatching && state != stateRunning {
+		return false
+	}
+	r.resumeChan = make(chan bool)
+	r.stateBeforePause = state
+	atomic.StoreInt32(&r.paused, 1)
+	r.setState(statePaused)
+	return true
+}
+
+// resume unblocks the paused workers, it returns false if the runner is not paused.
+func (r *runner) resume() bool {
+	r.pauseLock.Lock()
+	defer r.pauseLock.Unlock()
+
+	if atomic.LoadInt32(&r.paused) == 0 {
+		return false
+	}
+	r.setState(r.stateBeforePause)
+	r.clearPause()
+	return true
+}
+
+// clearPause unblocks the paused workers without changing the state, pauseLock must be held.
+func (r *runner) clearPause() {
+	if atomic.LoadInt32(&r.paused) == 0 {
+		return
+	}
+	atomic.StoreInt32(&r.paused, 0)
+	close(r.resumeChan)
+	r.resumeChan = nil
+}
+
+// setRunning sets the state to running when hatching completes, or the state to restore if it's paused.
+// It returns false if the runner is stopped or quitting during hatching.
+func (r *runner) setRunning() bool {
+	r.pauseLock.Lock()
+	defer r.pauseLock.Unlock()
+
+	if atomic.LoadInt32(&r.paused) == 1 {
+		r.stateBeforePause = stateRunning
+		return true
+	}
+	return r.setStateUnless(stateRunning, stateInit, stateStopped, stateQuitting)
+}
+
+// waitIfPaused blocks until the runner is resumed, it returns false if quit is closed
+// or the goroutine is ramped down in the meantime.
+func (r *runner) waitIfPaused(quit chan bool, rampDown chan bool) bool {
+	if atomic.LoadInt32(&r.paused) == 0 {
+		return true
+	}
+	r.pauseLock.Lock()
+	resumeChan := r.resumeChan
+	r.pauseLock.Unlock()
+	if resumeChan == nil {
+		return true
+	}
+	select {
+	case <-resumeChan:
+		return true
+	case <-quit:
+		return false
+	case <-rampDown:
+		atomic.AddInt32(&r.numClients, -1)
+		return false
+	}
+}
+
+// wait sleeps a random duration between minWait and maxWait, like the wait_time of locust.
+// The wait time of the executed task takes precedence over the runner's.
+// It returns true if quit is closed or the goroutine is ramped down in the meantime.
+func (r *runner) wait(rd *rand.Rand, quit chan bool, rampDown chan bool, task *Task) bool {
+	minWait, maxWait := task.getWaitTime(r.minWait, r.maxWait)
+	if minWait <= 0 && maxWait <= 0 {
+		return false
+	}
+	waitTime := minWait
+	if maxWait > minWait {
+		waitTime += time.Duration(rd.Int63n(int64(maxWait - minWait)))
+	}
+	timer := time.NewTimer(waitTime)
+	defer timer.Stop()
+	select {
+	case <-quit:
+		return true
+	case <-rampDown:
+		atomic.AddInt32(&r.numClients, -1)
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// currentStopChan holds the stopChan of the runner which started hatching last.
+var currentStopChan atomic.Value
+
+// ShouldStop returns true if the current runner is stopped. Tasks with several steps can call it between
+// the steps to return early, without passing the context of Task.FnWithContext around.
+// It returns false if no runner has started hatching.
+func ShouldStop() bool {
+	stopChan, ok := currentStopChan.Load().(chan bool)
+	if !ok {
+		return false
+	}
+	select {
+	case <-stopChan:
+		return true
+	default:
+		return false
 	}
 }
 
 func (r *runner) startHatching(spawnCount int, hatchRate int, hatchCompleteFunc func()) {
 	r.stats.clearStatsChan <- true
-	r.stopChan = make(chan bool)
+	stopChan := make(chan bool)
+	currentStopChan.Store(stopChan)
+	r.rampDownChan = make(chan bool)
+	r.workersWaitGroup = &sync.WaitGroup{}
+	r.hatchContext, r.cancelHatch = context.WithCancel(context.Background())
 
 	r.hatchRate = hatchRate
-	r.numClients = 0
+	atomic.StoreInt32(&r.numClients, 0)
+	atomic.StoreInt64(&r.numRequests, 0)
+	r.resetTaskIterations()
+	r.workerSeq = 0
+	if r.warmup > 0 {
+		logger.Infof("Warming up for %v, the requests are not counted in the stats", r.warmup)
+		atomic.StoreInt64(&r.warmupEnd, time.Now().Add(r.warmup).UnixNano())
+	}
 
-	go r.spawnWorkers(spawnCount, r.stopChan, hatchCompleteFunc)
+	r.stopLock.Lock()
+	r.stopChan = stopChan
+	// a new hatch resets the timer
+	if r.runTimeTimer != nil {
+		r.runTimeTimer.Stop()
+	}
+	if r.runTime > 0 && r.onLimitReached != nil {
+		r.runTimeTimer = time.AfterFunc(r.runTime, func() {
+			logger.Infof("Run time limit of %v is reached, boomer will quit", r.runTime)
+			r.setStopReason(StopReasonRunTime)
+			r.onLimitReached()
+		})
+	}
+	r.stopLock.Unlock()
+
+	go r.spawnWorkers(spawnCount, stopChan, hatchCompleteFunc)
+}
+
+// getStopChan returns the stopChan of current hatch.
+func (r *runner) getStopChan() chan bool {
+	r.stopLock.Lock()
+	defer r.stopLock.Unlock()
+	return r.stopChan
+}
+
+// stopHatching stops the workers one by one at the rate of rate workers per second,
+// symmetric to hatching, then stops the runner. A rate <= 0 stops all the workers at once.
+func (r *runner) stopHatching(rate int) {
+	quit := r.getStopChan()
+	if quit == nil {
+		// never hatched
+		return
+	}
+	if rate > 0 {
+		count := int(atomic.LoadInt32(&r.numClients))
+		logger.Infof("Ramping down %d clients at the rate %d clients/s...", count, rate)
+		interval := time.Duration(1000000/rate) * time.Microsecond
+		for i := 0; i < count; i++ {
+			select {
+			case r.rampDownChan <- true:
+			case <-quit:
+				// stopped in the meantime
+				return
+			}
+			if i < count-1 {
+				select {
+				case <-time.After(interval):
+				case <-quit:
+					return
+				}
+			}
+		}
+	}
+	r.stop()
+	r.setState(stateStopped)
+	if r.onRampedDown != nil {
+		r.onRampedDown()
+	}
 }
 
 func (r *runner) stop() {
+	// checking and closing stopChan must be atomic, or it's closed twice by concurrent calls
+	r.stopLock.Lock()
+	select {
+	case <-r.stopChan:
+		// already stopped
+		r.stopLock.Unlock()
+		return
+	default:
+	}
+
+	if r.runTimeTimer != nil {
+		r.runTimeTimer.Stop()
+	}
+
+	// stop previous goroutines without blocking
+	// those goroutines will exit when r.runTask returns
+	close(r.stopChan)
+	r.stopLock.Unlock()
+
 	// publish the boomer stop event
 	// user's code can subscribe to this event and do thins like cleaning up
 	Events.Publish("boomer:stop")
 
-	// stop previous goroutines without blocking
-	// those goroutines will exit when r.safeRun returns
-	close(r.stopChan)
+	r.pauseLock.Lock()
+	r.clearPause()
+	r.pauseLock.Unlock()
+	if r.cancelHatch != nil {
+		r.cancelHatch()
+	}
 	if r.rateLimitEnabled {
 		r.rateLimiter.Stop()
 	}
+
+	if r.stopTimeout > 0 {
+		r.waitForWorkers(r.workersWaitGroup, r.stopTimeout)
+	}
+	// report the stats since the last tick, rather than losing them if the runner is closed
+	if r.stats != nil {
+		r.stats.flush()
+	}
+}
+
+// waitForWorkers blocks until all the workers have returned or the timeout elapses.
+func (r *runner) waitForWorkers(wg *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logger.Infof("Timeout waiting for workers to stop, %d workers are still running",
+			atomic.LoadInt32(&r.runningWorkers))
+	}
 }
 
 type localRunner struct {
 	runner
 
 	hatchCount int
+	// called with the number of users once all of them are spawned, unless the runner is closed in the meantime.
+	hatchCompleteFunc func(count int)
 }
 
 func newLocalRunner(tasks []*Task, rateLimiter RateLimiter, hatchCount int, hatchType string, hatchRate int) (r *localRunner) {
@@ -209,6 +1366,8 @@ func newLocalRunner(tasks []*Task, rateLimiter RateLimiter, hatchCount int, hatc
 	r.hatchRate = hatchRate
 	r.hatchCount = hatchCount
 	r.closeChan = make(chan bool)
+	r.workersWaitGroup = &sync.WaitGroup{}
+	r.onLimitReached = r.limitReached
 	r.addOutput(NewConsoleOutput())
 
 	if rateLimiter != nil {
@@ -221,8 +1380,9 @@ func newLocalRunner(tasks []*Task, rateLimiter RateLimiter, hatchCount int, hatc
 }
 
 func (r *localRunner) run() {
-	r.state = stateInit
+	r.setState(stateInit)
 	r.stats.start()
+	r.outputOnStart()
 
 	wg := sync.WaitGroup{}
 	wg.Add(1)
@@ -230,11 +1390,17 @@ func (r *localRunner) run() {
 		for {
 			select {
 			case data := <-r.stats.messageToRunnerChan:
-				data["user_count"] = r.numClients
-				r.outputOnEevent(data)
+				r.report(data)
 			case <-r.closeChan:
 				Events.Publish("boomer:quit")
 				r.stop()
+				// the stats flushed by stop
+				for len(r.stats.messageToRunnerChan) > 0 {
+					r.report(<-r.stats.messageToRunnerChan)
+				}
+				r.stats.close()
+				r.setState(stateStopped)
+				r.outputOnStop()
 				wg.Done()
 				return
 			}
@@ -244,15 +1410,43 @@ func (r *localRunner) run() {
 	if r.rateLimitEnabled {
 		r.rateLimiter.Start()
 	}
-	r.startHatching(r.hatchCount, r.hatchRate, nil)
+	r.setState(stateHatching)
+	r.startHatching(r.hatchCount, r.hatchRate, r.hatchComplete)
 
 	wg.Wait()
 }
 
+// report publishes the stats to outputs and subscribers, and checks them against the SLA.
+func (r *localRunner) report(data map[string]interface{}) {
+	data["user_count"] = r.reportedUserCount()
+	// subscribers can add custom fields before it's sent
+	Events.Publish("boomer:stats", data)
+	r.outputOnEvent(data)
+	// the final report, subscribers shouldn't modify it
+	Events.Publish("boomer:report", data)
+	r.checkSLA(data)
+}
+
+func (r *localRunner) hatchComplete() {
+	if r.setRunning() && r.hatchCompleteFunc != nil {
+		r.hatchCompleteFunc(int(atomic.LoadInt32(&r.numClients)))
+	}
+}
+
+// limitReached closes the runner, which stops the workers and publishes boomer:quit.
+func (r *localRunner) limitReached() {
+	r.setState(stateStopped)
+	r.close()
+}
+
 func (r *localRunner) close() {
-	if r.stats != nil {
-		r.stats.close()
+	select {
+	case <-r.closeChan:
+		// already closed
+		return
+	default:
 	}
+	// the stats goroutine is closed by run after the final report
 	close(r.closeChan)
 }
 
@@ -260,20 +1454,95 @@ func (r *localRunner) close() {
 type slaveRunner struct {
 	runner
 
-	nodeID     string
-	masterHost string
-	masterPort int
-	client     client
+	nodeID string
+	// the slave connects to masters[masterIndex], and fails over to the next one if it's unreachable or lost.
+	masters     []masterAddr
+	masterIndex int
+	client      client
+	clientLock  sync.RWMutex
+	// newClient creates the client used to connect to master, it's replaced in tests.
+	newClient func(masterHost string, masterPort int, identity string) client
+
+	// the slave reconnects to master if no message is received in masterTimeout, 0 means never.
+	masterTimeout time.Duration
+	// unix nano of the last message received from master.
+	lastMasterMessage int64
+	// closed to stop the listener of current client when reconnecting.
+	listenerQuit chan bool
+	// run() retries connecting to master connectRetries times if it fails, negative means until connected.
+	connectRetries int
+	// the backoff between connecting attempts starts from connectBackoff and doubles up to reconnectMaxBackoff.
+	connectBackoff time.Duration
+
+	// "zmq" by default, or "tcp" to talk to master with length-prefixed messages over a plain TCP socket.
+	transport string
+	// enables CurveZMQ encryption if it's not nil.
+	curve *curveOptions
+	// the local IP address or interface to connect to master from, empty means chosen by the OS.
+	bindAddr string
+	// the capacity of the channel of messages to master, 0 means the default of the client.
+	sendBufferSize int
+	// close() waits up to drainTimeout for the queued messages to be sent to master, 0 means no waiting.
+	drainTimeout time.Duration
+	// "block" by default, "drop-oldest" or "drop-newest" drops a stats message if statsQueue is full,
+	// instead of blocking the reporting goroutine. Other messages are never dropped.
+	sendPolicy string
+	// the stats messages wait in statsQueue for room in the send channel if sendPolicy drops stats,
+	// so the messages queued in the send channel are never touched. It's nil with the "block" policy.
+	statsQueue chan *message
+
+	// handlers of the custom messages from master, keyed by message type.
+	messageHandlers     map[string]func(data map[string]interface{})
+	messageHandlersLock sync.RWMutex
+
+	// samples the cpu and memory usage of current process for heartbeats, it's replaced in tests.
+	sampleUsage func() (cpuPercent float64, rss uint64)
+	// boomer:cpu_warning is published if the cpu usage exceeds cpuWarningThreshold, 0 means never.
+	cpuWarningThreshold float64
+	// math.Float64bits of the last cpu usage exceeding cpuWarningThreshold, 0 if it's below.
+	cpuWarningUsage uint64
+
+	// every heartbeat is shifted randomly by up to heartbeatJitter, so the heartbeats of slaves started
+	// at the same time spread within the interval, 0 means no jitter.
+	heartbeatJitter time.Duration
+
+	// static metadata of the slave, sent to master in client_ready, and heartbeat if heartbeatMetadata is true.
+	metadata          map[string]interface{}
+	heartbeatMetadata bool
 }
 
-func newSlaveRunner(masterHost string, masterPort int, tasks []*Task, rateLimiter RateLimiter, hatchType string) (r *slaveRunner) {
+func newSlaveRunner(masters []masterAddr, tasks []*Task, rateLimiter RateLimiter, hatchType string) (r *slaveRunner) {
 	r = &slaveRunner{}
-	r.masterHost = masterHost
-	r.masterPort = masterPort
+	r.masters = masters
 	r.tasks = tasks
 	r.hatchType = hatchType
 	r.nodeID = getNodeID()
+	r.newClient = func(masterHost string, masterPort int, identity string) client {
+		if r.transport == transportTCP {
+			c := newTCPClient(masterHost, masterPort, identity)
+			c.curve = r.curve
+			c.bindAddr = r.bindAddr
+			if r.sendBufferSize > 0 {
+				c.toMaster = make(chan *message, r.sendBufferSize)
+			}
+			return c
+		}
+		c := newClient(masterHost, masterPort, identity)
+		c.curve = r.curve
+		c.bindAddr = r.bindAddr
+		if r.sendBufferSize > 0 {
+			c.toMaster = make(chan *message, r.sendBufferSize)
+		}
+		return c
+	}
+	r.sampleUsage = newUsageSampler()
+	r.connectBackoff = reconnectMinBackoff
 	r.closeChan = make(chan bool)
+	r.workersWaitGroup = &sync.WaitGroup{}
+	r.onLimitReached = r.limitReached
+	r.onRampedDown = func() {
+		r.sendClientStopped(r.getStopReason())
+	}
 
 	if rateLimiter != nil {
 		r.rateLimitEnabled = true
@@ -284,16 +1553,191 @@ func newSlaveRunner(masterHost string, masterPort int, tasks []*Task, rateLimite
 	return r
 }
 
+// checkCPUUsage publishes boomer:cpu_warning when the cpu usage exceeds the threshold,
+// once until it drops below the threshold again. The load generated by a cpu-bound slave is unreliable.
+func (r *slaveRunner) checkCPUUsage(cpuPercent float64) {
+	if r.cpuWarningThreshold <= 0 {
+		return
+	}
+	if cpuPercent < r.cpuWarningThreshold {
+		atomic.StoreUint64(&r.cpuWarningUsage, 0)
+		return
+	}
+	if atomic.SwapUint64(&r.cpuWarningUsage, math.Float64bits(cpuPercent)) == 0 {
+		logger.Errorf("CPU usage is %.1f%%, exceeds the threshold of %.1f%%, the generated load may be unreliable", cpuPercent, r.cpuWarningThreshold)
+		Events.Publish("boomer:cpu_warning", cpuPercent)
+	}
+}
+
+func (r *slaveRunner) clientReadyData() map[string]interface{} {
+	if r.metadata == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"metadata": r.metadata,
+	}
+}
+
+func (r *slaveRunner) getClient() client {
+	r.clientLock.RLock()
+	defer r.clientLock.RUnlock()
+	return r.client
+}
+
+func (r *slaveRunner) setClient(c client) {
+	r.clientLock.Lock()
+	r.client = c
+	r.clientLock.Unlock()
+}
+
 func (r *slaveRunner) hatchComplete() {
 	data := make(map[string]interface{})
-	data["count"] = r.numClients
-	r.client.sendChannel() <- newMessage("hatch_complete", data, r.nodeID)
-	r.state = stateRunning
+	data["count"] = r.reportedUserCount()
+	// master has asked to stop or quit in the meantime
+	if !r.setRunning() {
+		return
+	}
+	r.getClient().sendChannel() <- newMessage("hatch_complete", data, r.nodeID)
+}
+
+// limitReached stops the workers and publishes boomer:quit, which sends a quit message to master.
+func (r *slaveRunner) limitReached() {
+	r.stop()
+	r.setState(stateStopped)
+	Events.Publish("boomer:quit")
+}
+
+// sendClientStopped tells master all the goroutines are stopped, and why.
+func (r *slaveRunner) sendClientStopped(reason string) {
+	c := r.getClient()
+	if c == nil {
+		return
+	}
+	c.sendChannel() <- newMessage("client_stopped", map[string]interface{}{
+		"reason": reason,
+	}, r.nodeID)
+}
+
+// sendCustomMessage queues a message to master, unless the runner is not connected or closed.
+func (r *slaveRunner) sendCustomMessage(messageType string, data map[string]interface{}) {
+	c := r.getClient()
+	if c == nil {
+		logger.Errorf("Boomer is not connected to master, the %s message is dropped", messageType)
+		return
+	}
+	select {
+	case c.sendChannel() <- newMessage(messageType, data, r.nodeID):
+	case <-r.closeChan:
+	}
+}
+
+// nextHeartbeat returns the duration before the next heartbeat, which is heartbeatInterval
+// shifted randomly within heartbeatJitter, so the average interval is still heartbeatInterval.
+func (r *slaveRunner) nextHeartbeat() time.Duration {
+	if r.heartbeatJitter <= 0 {
+		return heartbeatInterval
+	}
+	return heartbeatInterval - r.heartbeatJitter + time.Duration(rand.Int63n(int64(2*r.heartbeatJitter)+1))
+}
+
+// sendStats queues a stats message to master, it blocks until there is room in the send channel,
+// or queues the message in statsQueue and drops a stats message by sendPolicy if it's full.
+func (r *slaveRunner) sendStats(msg *message) {
+	if r.statsQueue == nil {
+		r.getClient().sendChannel() <- msg
+		return
+	}
+	select {
+	case r.statsQueue <- msg:
+		return
+	default:
+	}
+	if r.sendPolicy == "drop-oldest" {
+		// the reporting goroutine is the only sender, the room can't be taken by others
+		select {
+		case <-r.statsQueue:
+		default:
+			// taken by forwardStats in the meantime
+		}
+		r.statsQueue <- msg
+		logger.Errorf("The send channel to master is full, the oldest stats message is dropped")
+		return
+	}
+	logger.Errorf("The send channel to master is full, the stats message is dropped")
+}
+
+// forwardStats moves the stats messages from statsQueue to the send channel until the runner is closed.
+func (r *slaveRunner) forwardStats() {
+	for {
+		select {
+		case msg := <-r.statsQueue:
+			select {
+			case r.getClient().sendChannel() <- msg:
+			case <-r.closeChan:
+				return
+			}
+		case <-r.closeChan:
+			return
+		}
+	}
 }
 
+// flushStatsQueue moves the stats messages left in statsQueue to the send channel of c, so they're
+// drained on close too. It gives up after timeout.
+func (r *slaveRunner) flushStatsQueue(c client, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-r.statsQueue:
+			select {
+			case c.sendChannel() <- msg:
+			case <-timer.C:
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// registerMessageHandler registers a handler for the custom messages of messageType from master.
+func (r *slaveRunner) registerMessageHandler(messageType string, handler func(data map[string]interface{})) {
+	r.messageHandlersLock.Lock()
+	defer r.messageHandlersLock.Unlock()
+	if r.messageHandlers == nil {
+		r.messageHandlers = make(map[string]func(data map[string]interface{}))
+	}
+	r.messageHandlers[messageType] = handler
+}
+
+// dispatchCustomMessage runs the handler of msg in a new goroutine, so it doesn't block the listener.
+// It returns false if no handler is registered for the type of msg.
+func (r *slaveRunner) dispatchCustomMessage(msg *message) bool {
+	switch msg.Type {
+	case "hatch", "stop", "quit":
+		return false
+	}
+	r.messageHandlersLock.RLock()
+	handler, ok := r.messageHandlers[msg.Type]
+	r.messageHandlersLock.RUnlock()
+	if !ok {
+		logger.Debugf("Recv a %s message from master without a handler, dropped", msg.Type)
+		return false
+	}
+	go r.safeRun(func() {
+		handler(msg.Data)
+	})
+	return true
+}
+
+// onQuiting sends a quit message with the stop reason to master when boomer:quit is published,
+// unless the master asks it to quit.
 func (r *slaveRunner) onQuiting() {
-	if r.state != stateQuitting {
-		r.client.sendChannel() <- newMessage("quit", nil, r.nodeID)
+	if r.getState() != stateQuitting {
+		r.getClient().sendChannel() <- newMessage("quit", map[string]interface{}{
+			"reason": r.getStopReason(),
+		}, r.nodeID)
 	}
 }
 
@@ -301,86 +1745,165 @@ func (r *slaveRunner) close() {
 	if r.stats != nil {
 		r.stats.close()
 	}
-	if r.client != nil {
-		r.client.close()
+	r.outputOnStop()
+	if c := r.getClient(); c != nil {
+		if r.drainTimeout > 0 {
+			r.flushStatsQueue(c, r.drainTimeout)
+		}
+		if r.drainTimeout > 0 && !c.flush(r.drainTimeout) {
+			logger.Errorf("Timeout sending the queued messages to master in %v, they are dropped", r.drainTimeout)
+		}
+		c.close()
 	}
 	close(r.closeChan)
 }
 
+// parseHatchMessage reads num_clients and hatch_rate from a hatch message, which may be
+// encoded as any numeric type by different versions of locust.
+func parseHatchMessage(msg *message) (workers int, hatchRate int, err error) {
+	workers, ok := toInt(msg.Data["num_clients"])
+	if !ok {
+		return 0, 0, fmt.Errorf("num_clients should be a number, got %v", msg.Data["num_clients"])
+	}
+	hatchRate, ok = toInt(msg.Data["hatch_rate"])
+	if !ok {
+		return 0, 0, fmt.Errorf("hatch_rate should be a number, got %v", msg.Data["hatch_rate"])
+	}
+	if workers <= 0 || hatchRate <= 0 {
+		return 0, 0, fmt.Errorf("num_clients is %d, hatch_rate is %d", workers, hatchRate)
+	}
+	return workers, hatchRate, nil
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int8:
+		return int(n), true
+	case int16:
+		return int(n), true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case uint:
+		return int(n), true
+	case uint8:
+		return int(n), true
+	case uint16:
+		return int(n), true
+	case uint32:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	case float32:
+		return int(n), true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// onHatchMessage ignores a malformed hatch message, the slave keeps its state and goroutines.
 func (r *slaveRunner) onHatchMessage(msg *message) {
-	r.client.sendChannel() <- newMessage("hatching", nil, r.nodeID)
-	rate, _ := msg.Data["hatch_rate"]
-	clients, _ := msg.Data["num_clients"]
-	hatchRate := int(rate.(float64))
-	workers := 0
-	if _, ok := clients.(uint64); ok {
-		workers = int(clients.(uint64))
-	} else {
-		workers = int(clients.(int64))
+	workers, hatchRate, err := parseHatchMessage(msg)
+	if err != nil {
+		logger.Errorf("Invalid hatch message from master, %v, ignored", err)
+		return
 	}
-	if workers == 0 || hatchRate == 0 {
-		log.Printf("Invalid hatch message from master, num_clients is %d, hatch_rate is %d\n",
-			workers, hatchRate)
-	} else {
-		Events.Publish("boomer:hatch", workers, hatchRate)
 
-		if r.rateLimitEnabled {
-			r.rateLimiter.Start()
-		}
-		r.startHatching(workers, hatchRate, r.hatchComplete)
+	if state := r.getState(); state == stateHatching || state == stateRunning || state == statePaused {
+		r.setState(stateHatching)
+		r.stop()
 	}
+	r.setState(stateHatching)
+	r.getClient().sendChannel() <- newMessage("hatching", nil, r.nodeID)
+	Events.Publish("boomer:hatch", workers, hatchRate)
+
+	if r.rateLimitEnabled {
+		r.rateLimiter.Start()
+	}
+	r.startHatching(workers, hatchRate, r.hatchComplete)
 }
 
 // Runner acts as a state machine.
 func (r *slaveRunner) onMessage(msg *message) {
-	switch r.state {
+	// stats_reset can be received in any state, it clears the stats without tearing down workers.
+	if msg.Type == "stats_reset" {
+		logger.Infof("Recv stats_reset message from master, all the stats are cleared")
+		r.stats.clearStatsChan <- true
+		return
+	}
+
+	if r.dispatchCustomMessage(msg) {
+		return
+	}
+
+	switch r.getState() {
 	case stateInit:
 		switch msg.Type {
 		case "hatch":
-			r.state = stateHatching
 			r.onHatchMessage(msg)
 		case "quit":
-			Events.Publish("boomer:quit")
+			r.onQuitMessage()
 		}
-	case stateHatching:
-		fallthrough
-	case stateRunning:
+	case stateHatching, stateRunning, statePaused:
 		switch msg.Type {
 		case "hatch":
-			r.state = stateHatching
-			r.stop()
 			r.onHatchMessage(msg)
 		case "stop":
 			r.stop()
-			r.state = stateStopped
-			log.Println("Recv stop message from master, all the goroutines are stopped")
-			r.client.sendChannel() <- newMessage("client_stopped", nil, r.nodeID)
-			r.client.sendChannel() <- newMessage("client_ready", nil, r.nodeID)
-			r.state = stateInit
+			r.setState(stateStopped)
+			logger.Infof("Recv stop message from master, all the goroutines are stopped")
+			r.sendClientStopped(StopReasonMaster)
+			r.getClient().sendChannel() <- newMessage("client_ready", r.clientReadyData(), r.nodeID)
+			r.setState(stateInit)
 		case "quit":
-			r.stop()
-			log.Println("Recv quit message from master, all the goroutines are stopped")
-			Events.Publish("boomer:quit")
-			r.state = stateInit
+			r.onQuitMessage()
 		}
 	case stateStopped:
 		switch msg.Type {
 		case "hatch":
-			r.state = stateHatching
 			r.onHatchMessage(msg)
 		case "quit":
-			Events.Publish("boomer:quit")
-			r.state = stateInit
+			r.onQuitMessage()
 		}
 	}
 }
 
+// onQuitMessage stops the workers if they are running, acks master with a quit message
+// and publishes boomer:quit. The listener stops receiving messages after it.
+func (r *slaveRunner) onQuitMessage() {
+	state := r.getState()
+	// onQuiting doesn't send the quit message again
+	r.setState(stateQuitting)
+	if state == stateHatching || state == stateRunning || state == statePaused {
+		r.stop()
+		logger.Infof("Recv quit message from master, all the goroutines are stopped")
+	}
+	r.getClient().sendChannel() <- newMessage("quit", map[string]interface{}{
+		"reason": StopReasonMaster,
+	}, r.nodeID)
+	Events.Publish("boomer:quit")
+}
+
 func (r *slaveRunner) startListener() {
+	c := r.getClient()
+	quit := make(chan bool)
+	r.listenerQuit = quit
+	atomic.StoreInt64(&r.lastMasterMessage, time.Now().UnixNano())
 	go func() {
 		for {
 			select {
-			case msg := <-r.client.recvChannel():
+			case msg := <-c.recvChannel():
+				atomic.StoreInt64(&r.lastMasterMessage, time.Now().UnixNano())
 				r.onMessage(msg)
+				if r.getState() == stateQuitting {
+					return
+				}
+			case <-quit:
+				return
 			case <-r.closeChan:
 				return
 			}
@@ -388,17 +1911,94 @@ func (r *slaveRunner) startListener() {
 	}()
 }
 
-func (r *slaveRunner) run() {
-	r.state = stateInit
-	r.client = newClient(r.masterHost, r.masterPort, r.nodeID)
+// masterLost returns true if no message is received from master in masterTimeout.
+func (r *slaveRunner) masterLost() bool {
+	if r.masterTimeout <= 0 {
+		return false
+	}
+	lastMasterMessage := time.Unix(0, atomic.LoadInt64(&r.lastMasterMessage))
+	return time.Since(lastMasterMessage) > r.masterTimeout
+}
 
-	err := r.client.connect()
-	if err != nil {
+// reconnect tears down current client and connects to master again with backoff,
+// until it succeeds or the runner is closed.
+func (r *slaveRunner) reconnect() bool {
+	logger.Errorf("No message is received from master(%s) in %v, reconnecting", r.master(), r.masterTimeout)
+	close(r.listenerQuit)
+	r.getClient().close()
+	// master forgets the users of a lost slave, so stop them and start over,
+	// stop clears the pause too, or the paused workers are blocked forever
+	if state := r.getState(); state == stateHatching || state == stateRunning || state == statePaused {
+		r.stop()
+	}
+	r.setState(stateInit)
+
+	// try the other masters first
+	r.nextMaster()
+	c := r.connect(-1)
+	if c == nil {
+		return false
+	}
+	r.startListener()
+	c.sendChannel() <- newMessage("client_ready", r.clientReadyData(), r.nodeID)
+	return true
+}
+
+// master returns the master to connect to.
+func (r *slaveRunner) master() masterAddr {
+	return r.masters[r.masterIndex]
+}
+
+// nextMaster fails over to the next master, it returns true if it has tried all the masters.
+func (r *slaveRunner) nextMaster() bool {
+	r.masterIndex = (r.masterIndex + 1) % len(r.masters)
+	return r.masterIndex == 0
+}
+
+// connect creates a client as current client and connects it to master, if it fails, the next master is
+// tried at once. After all the masters are tried, it retries with backoff up to retries times, negative
+// retries means retrying until it succeeds. It returns nil if all the attempts fail or the runner is closed
+// while waiting.
+func (r *slaveRunner) connect(retries int) client {
+	backoff := r.connectBackoff
+	for attempt := 0; ; {
+		master := r.master()
+		c := r.newClient(master.host, master.port, r.nodeID)
+		r.setClient(c)
+		err := c.connect()
+		if err == nil {
+			return c
+		}
 		if strings.Contains(err.Error(), "Socket type DEALER is not compatible with PULL") {
-			log.Println("Newer version of locust changes ZMQ socket to DEALER and ROUTER, you should update your locust version.")
-		} else {
-			log.Printf("Failed to connect to master(%s:%d) with error %v\n", r.masterHost, r.masterPort, err)
+			logger.Errorf("Newer version of locust changes ZMQ socket to DEALER and ROUTER, you should update your locust version.")
+			return nil
 		}
+		if !r.nextMaster() {
+			logger.Errorf("Failed to connect to master(%s) with error %v, failing over to master(%s)", master, err, r.master())
+			continue
+		}
+		if retries >= 0 && attempt >= retries {
+			logger.Errorf("Failed to connect to master(%s) with error %v", master, err)
+			return nil
+		}
+		attempt++
+		logger.Errorf("Failed to connect to master(%s) with error %v, retry in %v", master, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-r.closeChan:
+			return nil
+		}
+		backoff *= 2
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+func (r *slaveRunner) run() {
+	r.setState(stateInit)
+
+	if r.connect(r.connectRetries) == nil {
 		return
 	}
 
@@ -406,21 +2006,42 @@ func (r *slaveRunner) run() {
 	r.startListener()
 
 	r.stats.start()
+	r.outputOnStart()
 
 	// tell master, I'm ready
-	r.client.sendChannel() <- newMessage("client_ready", nil, r.nodeID)
+	r.getClient().sendChannel() <- newMessage("client_ready", r.clientReadyData(), r.nodeID)
+
+	if r.sendPolicy == "drop-oldest" || r.sendPolicy == "drop-newest" {
+		size := r.sendBufferSize
+		if size == 0 {
+			size = cap(r.getClient().sendChannel())
+		}
+		r.statsQueue = make(chan *message, size)
+		go r.forwardStats()
+	}
 
 	// report to master
 	go func() {
 		for {
 			select {
 			case data := <-r.stats.messageToRunnerChan:
-				if r.state == stateInit || r.state == stateStopped {
+				// the stats flushed by stopping are sent anyway
+				stats, _ := data["stats"].([]interface{})
+				if state := r.getState(); (state == stateInit || state == stateStopped) && len(stats) == 0 {
 					continue
 				}
-				data["user_count"] = r.numClients
-				r.client.sendChannel() <- newMessage("stats", data, r.nodeID)
-				r.outputOnEevent(data)
+				data["user_count"] = r.reportedUserCount()
+				data["node_id"] = r.nodeID
+				if usage := atomic.LoadUint64(&r.cpuWarningUsage); usage != 0 {
+					data["current_cpu_usage"] = math.Float64frombits(usage)
+				}
+				// subscribers can add custom fields before it's sent
+				Events.Publish("boomer:stats", data)
+				r.sendStats(newMessage("stats", data, r.nodeID))
+				r.outputOnEvent(data)
+				// the final report, subscribers shouldn't modify it
+				Events.Publish("boomer:report", data)
+				r.checkSLA(data)
 			case <-r.closeChan:
 				return
 			}
@@ -430,14 +2051,27 @@ func (r *slaveRunner) run() {
 	// heartbeat
 	// See: https://github.com/locustio/locust/commit/a8c0d7d8c588f3980303358298870f2ea394ab93
 	go func() {
-		var ticker = time.NewTicker(heartbeatInterval)
+		var timer = time.NewTimer(r.nextHeartbeat())
+		defer timer.Stop()
 		for {
 			select {
-			case <-ticker.C:
+			case <-timer.C:
+				timer.Reset(r.nextHeartbeat())
+				// master stops sending messages after it asks the slave to quit
+				if r.getState() != stateQuitting && r.masterLost() && !r.reconnect() {
+					return
+				}
+				cpuPercent, rss := r.sampleUsage()
+				r.checkCPUUsage(cpuPercent)
 				data := map[string]interface{}{
-					"state": r.state,
+					"state":                r.getState(),
+					"current_cpu_usage":    cpuPercent,
+					"current_memory_usage": rss,
+				}
+				if r.heartbeatMetadata && r.metadata != nil {
+					data["metadata"] = r.metadata
 				}
-				r.client.sendChannel() <- newMessage("heartbeat", data, r.nodeID)
+				r.getClient().sendChannel() <- newMessage("heartbeat", data, r.nodeID)
 			case <-r.closeChan:
 				return
 			}
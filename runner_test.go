@@ -1,6 +1,14 @@
 package boomer
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -31,6 +39,143 @@ func TestSafeRun(t *testing.T) {
 	})
 }
 
+func TestRunTask(t *testing.T) {
+	runner := &runner{stats: newRequestStats()}
+	runner.runTask(context.Background(), &Task{
+		Name: "foo",
+		Fn:   func() {},
+	})
+	runner.runTask(context.Background(), &Task{
+		Name: "foo",
+		Fn: func() {
+			panic("Runner will catch this panic")
+		},
+	})
+
+	execution := <-runner.stats.taskExecutionChan
+	if execution.name != "foo" || execution.failed {
+		t.Error("Expected a successful execution of foo, got", execution.name, execution.failed)
+	}
+	execution = <-runner.stats.taskExecutionChan
+	if execution.name != "foo" || !execution.failed {
+		t.Error("Expected a failed execution of foo, got", execution.name, execution.failed)
+	}
+}
+
+func TestLatencyBudget(t *testing.T) {
+	runner := &runner{stats: newRequestStats()}
+	durations := []time.Duration{0, 40 * time.Millisecond, 0, 40 * time.Millisecond, 40 * time.Millisecond}
+	i := 0
+	task := &Task{
+		Name: "foo",
+		Fn: func() {
+			time.Sleep(durations[i])
+			i++
+		},
+		LatencyBudget: 20 * time.Millisecond,
+	}
+	for range durations {
+		runner.runTask(context.Background(), task)
+	}
+	// no budget
+	runner.runTask(context.Background(), &Task{
+		Name: "bar",
+		Fn: func() {
+			time.Sleep(40 * time.Millisecond)
+		},
+	})
+
+	for range append(durations, 0) {
+		e := <-runner.stats.taskExecutionChan
+		runner.stats.logTaskExecution(e.name, e.responseTime, e.failed, e.slow)
+	}
+	slowCalls := map[string]interface{}{}
+	for _, task := range runner.stats.collectReportData()["tasks"].([]interface{}) {
+		entry := task.(map[string]interface{})
+		slowCalls[entry["name"].(string)] = entry["num_slow_calls"]
+	}
+	if slowCalls["foo"] != int64(3) {
+		t.Error("The calls of foo exceeding the budget should be counted, expected: 3, got:", slowCalls["foo"])
+	}
+	if slowCalls["bar"] != int64(0) {
+		t.Error("The calls without budget should never be slow, got:", slowCalls["bar"])
+	}
+}
+
+func TestFailureRate(t *testing.T) {
+	runner := &runner{stats: newRequestStats(), failureRate: 0.2}
+	const total = 10000
+	successes, failures := 0, 0
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for successes+failures < total {
+			select {
+			case <-runner.stats.requestSuccessChan:
+				successes++
+			case f := <-runner.stats.requestFailureChan:
+				if f.category != ErrorCategoryInjected {
+					t.Error("The injected failure should be in the injected category, got", f.category)
+				}
+				failures++
+			}
+		}
+	}()
+	for i := 0; i < total; i++ {
+		runner.recordSuccess("http", "foo", 10, 100)
+	}
+	<-done
+
+	if fraction := float64(failures) / total; fraction < 0.18 || fraction > 0.22 {
+		t.Error("The fraction of failures should be close to 0.2, got", fraction)
+	}
+}
+
+func TestPanicRecordedAsFailure(t *testing.T) {
+	runner := &runner{stats: newRequestStats()}
+
+	panics := make(chan string, 1)
+	onPanic := func(name string, recovered interface{}) {
+		panics <- fmt.Sprintf("%s: %v", name, recovered)
+	}
+	Events.Subscribe("boomer:panic", onPanic)
+	defer Events.Unsubscribe("boomer:panic", onPanic)
+
+	runner.runTask(context.Background(), &Task{
+		Name: "foo",
+		Fn: func() {
+			panic("Runner will catch this panic")
+		},
+	})
+
+	failure := <-runner.stats.requestFailureChan
+	if failure.requestType != "panic" || failure.name != "foo" {
+		t.Error("Expected a failure of panic foo, got", failure.requestType, failure.name)
+	}
+	if failure.error != "Runner will catch this panic" || failure.category != ErrorCategoryPanic {
+		t.Error("The panic message should be recorded as the error, got", failure.error, failure.category)
+	}
+	select {
+	case p := <-panics:
+		if p != "foo: Runner will catch this panic" {
+			t.Error("Unexpected boomer:panic event,", p)
+		}
+	default:
+		t.Error("boomer:panic should be published")
+	}
+
+	// unnamed tasks
+	runner.runTask(context.Background(), &Task{
+		Fn: func() {
+			panic(errors.New("something wrong"))
+		},
+	})
+	failure = <-runner.stats.requestFailureChan
+	if failure.name != unnamedTask || failure.error != "something wrong" {
+		t.Error("Expected a failure of the unnamed task, got", failure.name, failure.error)
+	}
+}
+
 func TestOutputOnStart(t *testing.T) {
 	hitOutput := &HitOutput{}
 	hitOutput2 := &HitOutput{}
@@ -46,13 +191,13 @@ func TestOutputOnStart(t *testing.T) {
 	}
 }
 
-func TestOutputOnEevent(t *testing.T) {
+func TestOutputOnEvent(t *testing.T) {
 	hitOutput := &HitOutput{}
 	hitOutput2 := &HitOutput{}
 	runner := &runner{}
 	runner.addOutput(hitOutput)
 	runner.addOutput(hitOutput2)
-	runner.outputOnEevent(nil)
+	runner.outputOnEvent(nil)
 	if !hitOutput.onEvent {
 		t.Error("hitOutput's OnEvent has not been called")
 	}
@@ -61,161 +206,2872 @@ func TestOutputOnEevent(t *testing.T) {
 	}
 }
 
-func TestOutputOnStop(t *testing.T) {
-	hitOutput := &HitOutput{}
-	hitOutput2 := &HitOutput{}
-	runner := &runner{}
-	runner.addOutput(hitOutput)
-	runner.addOutput(hitOutput2)
-	runner.outputOnStop()
-	if !hitOutput.onStop {
-		t.Error("hitOutput's OnStop has not been called")
+// countingOutput counts the events it receives.
+type countingOutput struct {
+	starts int32
+	events int32
+}
+
+func (o *countingOutput) OnStart() {
+	atomic.AddInt32(&o.starts, 1)
+}
+
+func (o *countingOutput) OnEvent(data map[string]interface{}) {
+	atomic.AddInt32(&o.events, 1)
+}
+
+func (o *countingOutput) OnStop() {}
+
+func TestOutputOnEventToAllOutputs(t *testing.T) {
+	runner := &runner{}
+	outputs := make([]*countingOutput, 5)
+	for i := range outputs {
+		outputs[i] = &countingOutput{}
+		runner.addOutput(outputs[i])
+	}
+	for i := 0; i < 10; i++ {
+		runner.outputOnEvent(map[string]interface{}{})
+	}
+	for i, o := range outputs {
+		if events := atomic.LoadInt32(&o.events); events != 10 {
+			t.Error("Output", i, "should receive every event, expected: 10, was:", events)
+		}
+	}
+}
+
+// hangingOutput blocks in OnEvent until release is closed.
+type hangingOutput struct {
+	release chan bool
+	calls   int32
+}
+
+func (o *hangingOutput) OnStart() {}
+
+func (o *hangingOutput) OnEvent(data map[string]interface{}) {
+	atomic.AddInt32(&o.calls, 1)
+	<-o.release
+}
+
+func (o *hangingOutput) OnStop() {}
+
+// panickingOutput panics in OnEvent.
+type panickingOutput struct{}
+
+func (o *panickingOutput) OnStart() {}
+
+func (o *panickingOutput) OnEvent(data map[string]interface{}) {
+	panic("output panics")
+}
+
+func (o *panickingOutput) OnStop() {}
+
+func TestOutputOnEventWithMisbehavingOutputs(t *testing.T) {
+	hanging := &hangingOutput{release: make(chan bool)}
+	defer close(hanging.release)
+	counting := &countingOutput{}
+
+	runner := &runner{}
+	runner.outputTimeout = 100 * time.Millisecond
+	runner.addOutput(hanging)
+	runner.addOutput(&panickingOutput{})
+	runner.addOutput(counting)
+
+	for i := 0; i < 3; i++ {
+		startTime := time.Now()
+		runner.outputOnEvent(map[string]interface{}{})
+		if elapsed := time.Since(startTime); elapsed > 500*time.Millisecond {
+			t.Error("A hanging output should be skipped after the timeout, took", elapsed)
+		}
+	}
+	if events := atomic.LoadInt32(&counting.events); events != 3 {
+		t.Error("The other outputs should receive every event, expected: 3, was:", events)
+	}
+	if calls := atomic.LoadInt32(&hanging.calls); calls != 1 {
+		t.Error("A hanging output should not be called again before it returns, was called", calls, "times")
+	}
+}
+
+func TestOutputCalledAgainAfterReturning(t *testing.T) {
+	hanging := &hangingOutput{release: make(chan bool)}
+
+	runner := &runner{}
+	runner.outputTimeout = 10 * time.Millisecond
+	runner.addOutput(hanging)
+
+	runner.outputOnEvent(map[string]interface{}{})
+	close(hanging.release)
+	time.Sleep(10 * time.Millisecond)
+	runner.outputOnEvent(map[string]interface{}{})
+	if calls := atomic.LoadInt32(&hanging.calls); calls != 2 {
+		t.Error("The output should be called again once the last call returns, was called", calls, "times")
+	}
+}
+
+// valueOutput is an output of an uncomparable value type, the copies share calls.
+type valueOutput struct {
+	tags  []string
+	calls *int32
+}
+
+func (o valueOutput) OnStart() {}
+
+func (o valueOutput) OnEvent(data map[string]interface{}) {
+	atomic.AddInt32(o.calls, 1)
+}
+
+func (o valueOutput) OnStop() {}
+
+func TestOutputsOfValueTypes(t *testing.T) {
+	calls := int32(0)
+	output := valueOutput{tags: []string{"foo"}, calls: &calls}
+
+	runner := &runner{}
+	runner.addOutput(output)
+	runner.addOutput(output)
+	runner.outputOnEvent(map[string]interface{}{})
+
+	if calls := atomic.LoadInt32(&calls); calls != 2 {
+		t.Error("Every registered output should be called, was called", calls, "times")
+	}
+}
+
+func TestRemoveOutputs(t *testing.T) {
+	runner := &runner{}
+	outputA, outputB, outputC := &countingOutput{}, &countingOutput{}, &countingOutput{}
+	runner.addOutput(outputA)
+	runner.addOutput(outputB)
+	runner.addOutput(outputC)
+	runner.outputOnEvent(map[string]interface{}{})
+
+	if !runner.removeOutput(outputB) {
+		t.Error("outputB should be removed")
+	}
+	if runner.removeOutput(outputB) {
+		t.Error("outputB is already removed")
+	}
+	runner.outputOnEvent(map[string]interface{}{})
+
+	expected := []int32{2, 1, 2}
+	for i, o := range []*countingOutput{outputA, outputB, outputC} {
+		if events := atomic.LoadInt32(&o.events); events != expected[i] {
+			t.Error("The events received by output", i, "is wrong, expected:", expected[i], "was:", events)
+		}
+	}
+
+	runner.clearOutputs()
+	runner.outputOnEvent(map[string]interface{}{})
+	if events := atomic.LoadInt32(&outputA.events); events != 2 {
+		t.Error("Cleared outputs should not receive events, was:", events)
+	}
+}
+
+func TestRemoveOutputConcurrently(t *testing.T) {
+	// run it with -race
+	runner := &runner{}
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			o := &countingOutput{}
+			runner.addOutput(o)
+			runner.removeOutput(o)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			runner.outputOnEvent(map[string]interface{}{})
+		}
+	}()
+	wg.Wait()
+}
+
+func TestOutputOnStop(t *testing.T) {
+	hitOutput := &HitOutput{}
+	hitOutput2 := &HitOutput{}
+	runner := &runner{}
+	runner.addOutput(hitOutput)
+	runner.addOutput(hitOutput2)
+	runner.outputOnStop()
+	if !hitOutput.onStop {
+		t.Error("hitOutput's OnStop has not been called")
+	}
+	if !hitOutput2.onStop {
+		t.Error("hitOutput2's OnStop has not been called")
+	}
+}
+
+func TestLocalRunner(t *testing.T) {
+	taskA := &Task{
+		Weight: 10,
+		Fn: func() {
+			time.Sleep(time.Second)
+		},
+		Name: "TaskA",
+	}
+	tasks := []*Task{taskA}
+	runner := newLocalRunner(tasks, nil, 2, "asap", 2)
+	go runner.run()
+	time.Sleep(4 * time.Second)
+	runner.close()
+}
+
+func TestCloseReleasesWorkersBlockedOnStats(t *testing.T) {
+	task := &Task{
+		Name: "foo",
+		Fn: func() {
+			time.Sleep(300 * time.Millisecond)
+		},
+	}
+	// more in-flight workers than the buffer of taskExecutionChan
+	runner := newLocalRunner([]*Task{task}, nil, 300, "asap", 300)
+	runner.clearOutputs()
+	done := make(chan bool)
+	go func() {
+		runner.run()
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	runner.close()
+	<-done
+
+	time.Sleep(time.Second)
+	if running := atomic.LoadInt32(&runner.runningWorkers); running != 0 {
+		t.Error("Workers shouldn't block on recording the task execution after close, still running:", running)
+	}
+}
+
+func TestLocalHatchCompleteFunc(t *testing.T) {
+	task := &Task{
+		Name: "foo",
+		Fn: func() {
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{task}, nil, 10, "asap", 50)
+	runner.clearOutputs()
+	completed := make(chan int, 10)
+	runner.hatchCompleteFunc = func(count int) {
+		completed <- count
+	}
+	go runner.run()
+	defer runner.close()
+
+	select {
+	case count := <-completed:
+		if count != 10 {
+			t.Error("The callback should be called after 10 users are spawned, got", count)
+		}
+		if state := runner.getState(); state != stateRunning {
+			t.Error("The state should be running when the callback is called, got", state)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The hatch complete callback should be called")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if len(completed) != 0 {
+		t.Error("The hatch complete callback should be called only once")
+	}
+}
+
+// spinningRateLimiter always blocks without parking the caller.
+type spinningRateLimiter struct {
+	acquired int64
+}
+
+func (limiter *spinningRateLimiter) Start() {}
+
+func (limiter *spinningRateLimiter) Acquire() bool {
+	atomic.AddInt64(&limiter.acquired, 1)
+	return true
+}
+
+func (limiter *spinningRateLimiter) Stop() {}
+
+func TestMaxConcurrency(t *testing.T) {
+	var current, observed, limitedCount, unlimitedCount int64
+	limited := &Task{
+		Name:           "limited",
+		Weight:         1,
+		MaxConcurrency: 5,
+		Fn: func() {
+			n := atomic.AddInt64(&current, 1)
+			for {
+				max := atomic.LoadInt64(&observed)
+				if n <= max || atomic.CompareAndSwapInt64(&observed, max, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt64(&current, -1)
+			atomic.AddInt64(&limitedCount, 1)
+		},
+	}
+	unlimited := &Task{
+		Name:   "unlimited",
+		Weight: 1,
+		Fn: func() {
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&unlimitedCount, 1)
+		},
+	}
+	runner := newLocalRunner([]*Task{limited, unlimited}, nil, 100, "asap", 100)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = time.Second
+
+	runner.startHatching(100, 100, nil)
+	time.Sleep(300 * time.Millisecond)
+	runner.stop()
+
+	if max := atomic.LoadInt64(&observed); max > 5 {
+		t.Error("The concurrency of the limited task should never exceed 5, got", max)
+	} else if max == 0 {
+		t.Error("The limited task should be executed")
+	}
+	if atomic.LoadInt64(&unlimitedCount) <= atomic.LoadInt64(&limitedCount) {
+		t.Error("The unlimited task should be executed more often than the limited one, got",
+			atomic.LoadInt64(&unlimitedCount), atomic.LoadInt64(&limitedCount))
+	}
+	if running := atomic.LoadInt32(&runner.runningWorkers); running != 0 {
+		t.Error("The goroutines waiting for the limited task should quit on stop, still running:", running)
+	}
+}
+
+func TestBlockedAcquireBacksOff(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {},
+	}
+	rateLimiter := &spinningRateLimiter{}
+	runner := newLocalRunner([]*Task{taskA}, rateLimiter, 100, "asap", 100)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = time.Second
+
+	runner.startHatching(100, 100, nil)
+	time.Sleep(500 * time.Millisecond)
+	runner.stop()
+
+	// without backing off, every goroutine calls Acquire() millions of times per second
+	if acquired := atomic.LoadInt64(&rateLimiter.acquired); acquired > 100*20 {
+		t.Error("Goroutines should back off on consecutive blocked acquires, Acquire() is called", acquired, "times")
+	}
+	if running := atomic.LoadInt32(&runner.runningWorkers); running != 0 {
+		t.Error("Backing off goroutines should return on stop, still running:", running)
+	}
+}
+
+func TestSpawnWorkers(t *testing.T) {
+	taskA := &Task{
+		Weight: 10,
+		Fn: func() {
+			time.Sleep(time.Second)
+		},
+		Name: "TaskA",
+	}
+	taskB := &Task{
+		Weight: 20,
+		Fn: func() {
+			time.Sleep(2 * time.Second)
+		},
+		Name: "TaskB",
+	}
+	tasks := []*Task{taskA, taskB}
+	rateLimiter := NewStableRateLimiter(100, time.Second)
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, tasks, rateLimiter, "asap")
+	defer runner.close()
+
+	runner.client = newClient("localhost", 5557, runner.nodeID)
+	runner.hatchRate = 10
+
+	runner.spawnWorkers(10, runner.stopChan, runner.hatchComplete)
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 10 {
+		t.Error("Number of goroutines mismatches, expected: 10, current count", numClients)
+	}
+}
+
+func TestPickTask(t *testing.T) {
+	tests := []struct {
+		weights  []int
+		expected []float64
+	}{
+		{[]int{1, 2, 7}, []float64{0.1, 0.2, 0.7}},
+		{[]int{7, 2, 1}, []float64{0.7, 0.2, 0.1}},
+		{[]int{1, 1}, []float64{0.5, 0.5}},
+		{[]int{0, 0, 0}, []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}},
+	}
+
+	for _, test := range tests {
+		runner := &runner{}
+		for _, weight := range test.weights {
+			runner.tasks = append(runner.tasks, &Task{Weight: weight})
+		}
+		cumulativeWeights := runner.getCumulativeWeights()
+		rd := rand.New(rand.NewSource(1))
+
+		draws := 100000
+		counts := make(map[*Task]int)
+		for i := 0; i < draws; i++ {
+			counts[runner.pickTask(rd, cumulativeWeights)]++
+		}
+
+		for i, task := range runner.tasks {
+			frequency := float64(counts[task]) / float64(draws)
+			if math.Abs(frequency-test.expected[i]) > 0.01 {
+				t.Errorf("weights %v, task %d is selected with frequency %.3f, expected: %.3f",
+					test.weights, i, frequency, test.expected[i])
+			}
+		}
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	taskA := &Task{Name: "A", Weight: 5}
+	taskB := &Task{Name: "B", Weight: 1}
+	taskC := &Task{Name: "C", Weight: 1}
+	r := &runner{tasks: []*Task{taskA, taskB, taskC}}
+	cumulativeWeights := r.getCumulativeWeights()
+
+	roundRobin := &weightedRoundRobin{}
+	var sequence string
+	for i := 0; i < 14; i++ {
+		sequence += roundRobin.pick(r.tasks, cumulativeWeights).Name
+	}
+	// the same sequence as nginx, repeated every cycle
+	if sequence != "AABACAA"+"AABACAA" {
+		t.Error("Unexpected sequence", sequence)
+	}
+
+	for _, weights := range [][]int{{1, 2, 7}, {3, 2}, {0, 4, 1}, {1, 1, 1}} {
+		r = &runner{}
+		weightSum := 0
+		for _, weight := range weights {
+			r.tasks = append(r.tasks, &Task{Weight: weight})
+			weightSum += weight
+		}
+		cumulativeWeights = r.getCumulativeWeights()
+		roundRobin = &weightedRoundRobin{}
+		for cycle := 0; cycle < 3; cycle++ {
+			counts := make(map[*Task]int)
+			for i := 0; i < weightSum; i++ {
+				counts[roundRobin.pick(r.tasks, cumulativeWeights)]++
+			}
+			for i, task := range r.tasks {
+				if counts[task] != weights[i] {
+					t.Errorf("weights %v, task %d is picked %d times in cycle %d, expected: %d",
+						weights, i, counts[task], cycle, weights[i])
+				}
+			}
+		}
+	}
+
+	// disabled tasks are skipped
+	r = &runner{tasks: []*Task{taskA, taskB, taskC}}
+	r.setTaskEnabled("A", false)
+	sequence = ""
+	for i := 0; i < 4; i++ {
+		sequence += roundRobin.pick(r.tasks, r.getActiveWeights()).Name
+	}
+	if sequence != "BCBC" {
+		t.Error("Only task B and C should be picked in turn, got", sequence)
+	}
+	r.setTaskEnabled("B", false)
+	r.setTaskEnabled("C", false)
+	if task := roundRobin.pick(r.tasks, r.getActiveWeights()); task != nil {
+		t.Error("No task should be picked if all of them are disabled, got", task)
+	}
+}
+
+func TestSpawnWorkersWithWeightedRoundRobin(t *testing.T) {
+	var lock sync.Mutex
+	var sequence string
+	done := make(chan bool)
+	record := func(name string) func() {
+		return func() {
+			lock.Lock()
+			defer lock.Unlock()
+			if len(sequence) < 12 {
+				sequence += name
+				if len(sequence) == 12 {
+					close(done)
+				}
+			}
+		}
+	}
+	tasks := []*Task{
+		{Name: "A", Weight: 3, Fn: record("A")},
+		{Name: "B", Weight: 1, Fn: record("B")},
+		{Name: "C", Weight: 2, Fn: record("C")},
+	}
+	runner := newLocalRunner(tasks, nil, 1, "asap", 1)
+	runner.taskSelection = "weighted-round-robin"
+	defer runner.close()
+
+	quit := make(chan bool)
+	runner.spawnWorkers(1, quit, nil)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The worker should run 12 tasks, got", sequence)
+	}
+	close(quit)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if sequence != "ACABCA"+"ACABCA" {
+		t.Error("The tasks should be picked by weighted round-robin, got", sequence)
+	}
+}
+
+func TestSpawnWorkersWithSingleUser(t *testing.T) {
+	var lock sync.Mutex
+	var sequence string
+	var running, maxRunning int32
+	done := make(chan bool)
+	record := func(name string) func() {
+		return func() {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			lock.Lock()
+			if n > maxRunning {
+				maxRunning = n
+			}
+			if len(sequence) < 12 {
+				sequence += name
+				if len(sequence) == 12 {
+					close(done)
+				}
+			}
+			lock.Unlock()
+			time.Sleep(time.Millisecond)
+		}
+	}
+	tasks := []*Task{
+		{Name: "A", Weight: 3, Fn: record("A")},
+		{Name: "B", Weight: 1, Fn: record("B")},
+		{Name: "C", Weight: 2, Fn: record("C")},
+	}
+	runner := newLocalRunner(tasks, nil, 10, "spike", 10)
+	runner.singleUser = true
+	runner.spikeCount = 5
+	runner.spikeDuration = time.Second
+	defer runner.close()
+
+	quit := make(chan bool)
+	runner.spawnWorkers(10, quit, nil)
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 1 {
+		t.Error("Only 1 goroutine should be spawned in single user mode, got", numClients)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The worker should run 12 tasks, got", sequence)
+	}
+	close(quit)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if maxRunning != 1 {
+		t.Error("Only 1 task should run at a time, got", maxRunning)
+	}
+	if sequence != "ACABCA"+"ACABCA" {
+		t.Error("The tasks should be picked in a stable cycle, got", sequence)
+	}
+}
+
+func TestPickTaskWithZeroWeight(t *testing.T) {
+	disabled := &Task{Name: "disabled", Weight: 0}
+	for _, tasks := range [][]*Task{
+		{{Weight: 1}, disabled, {Weight: 3}},
+		{disabled, {Weight: 1}},
+		{{Weight: 1}, disabled},
+		{{WeightF: 0.1}, disabled, {WeightF: 0.2}},
+	} {
+		runner := &runner{tasks: tasks}
+		cumulativeWeights := runner.getCumulativeWeights()
+		rd := rand.New(rand.NewSource(1))
+		for i := 0; i < 100000; i++ {
+			if task := runner.pickTask(rd, cumulativeWeights); task == disabled {
+				t.Fatal("The task with zero weight should never be picked")
+			}
+		}
+	}
+}
+
+func TestPickTaskWithDisabledTasks(t *testing.T) {
+	taskA := &Task{Name: "A", Weight: 1}
+	taskB := &Task{Name: "B", Weight: 3}
+	r := &runner{tasks: []*Task{taskA, taskB}}
+	rd := rand.New(rand.NewSource(1))
+
+	if !r.setTaskEnabled("B", false) {
+		t.Fatal("Task B should be found")
+	}
+	if r.setTaskEnabled("C", false) {
+		t.Error("Task C should not be found")
+	}
+	for i := 0; i < 1000; i++ {
+		if task := r.pickTask(rd, r.getActiveWeights()); task != taskA {
+			t.Fatal("Only task A should be picked, got", task)
+		}
+	}
+
+	r.setTaskEnabled("A", false)
+	if task := r.pickTask(rd, r.getActiveWeights()); task != nil {
+		t.Error("No task should be picked if all of them are disabled, got", task)
+	}
+
+	r.setTaskEnabled("B", true)
+	for i := 0; i < 1000; i++ {
+		if task := r.pickTask(rd, r.getActiveWeights()); task != taskB {
+			t.Fatal("Only task B should be picked, got", task)
+		}
+	}
+
+	// tasks without weight have the same chance, except the disabled ones
+	r = &runner{tasks: []*Task{{Name: "A"}, {Name: "B"}, {Name: "C"}}}
+	r.setTaskEnabled("B", false)
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[r.pickTask(rd, r.getActiveWeights()).Name]++
+	}
+	if counts["B"] != 0 || counts["A"] == 0 || counts["C"] == 0 {
+		t.Error("Only task A and C should be picked, got", counts)
+	}
+}
+
+func TestDisableTaskWhileRunning(t *testing.T) {
+	var countA, countB int64
+	taskA := &Task{
+		Name:   "A",
+		Weight: 1,
+		Fn: func() {
+			atomic.AddInt64(&countA, 1)
+			time.Sleep(time.Millisecond)
+		},
+	}
+	taskB := &Task{
+		Name:   "B",
+		Weight: 1,
+		Fn: func() {
+			atomic.AddInt64(&countB, 1)
+			time.Sleep(time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA, taskB}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = time.Second
+
+	runner.startHatching(10, 10, nil)
+	defer runner.stop()
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt64(&countB) == 0 {
+		t.Fatal("Task B should be executed before it's disabled")
+	}
+
+	runner.setTaskEnabled("B", false)
+	// executions in progress are not interrupted
+	time.Sleep(20 * time.Millisecond)
+	a, b := atomic.LoadInt64(&countA), atomic.LoadInt64(&countB)
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt64(&countB) != b {
+		t.Error("Task B should not be executed after it's disabled")
+	}
+	if atomic.LoadInt64(&countA) == a {
+		t.Error("Task A should keep running")
+	}
+
+	runner.setTaskEnabled("B", true)
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt64(&countB) == b {
+		t.Error("Task B should be executed again after it's enabled")
+	}
+}
+
+func TestWeightFn(t *testing.T) {
+	var writeWeight int64 = 1
+	read := &Task{Name: "read", Weight: 9}
+	write := &Task{Name: "write", WeightFn: func() int {
+		return int(atomic.LoadInt64(&writeWeight))
+	}}
+	r := &runner{tasks: []*Task{read, write}}
+	rd := rand.New(rand.NewSource(1))
+	frequencyOfWrite := func() float64 {
+		count := 0
+		for i := 0; i < 100000; i++ {
+			if r.pickTask(rd, r.getActiveWeights()) == write {
+				count++
+			}
+		}
+		return float64(count) / 100000
+	}
+
+	r.updateActiveWeights()
+	if frequency := frequencyOfWrite(); math.Abs(frequency-0.1) > 0.01 {
+		t.Error("write should be picked with frequency 0.1, got", frequency)
+	}
+
+	// more writes later
+	atomic.StoreInt64(&writeWeight, 27)
+	r.updateActiveWeights()
+	if frequency := frequencyOfWrite(); math.Abs(frequency-0.75) > 0.01 {
+		t.Error("write should be picked with frequency 0.75, got", frequency)
+	}
+
+	// zero weight of WeightFn doesn't disable the hooks
+	atomic.StoreInt64(&writeWeight, 0)
+	r.updateActiveWeights()
+	if frequency := frequencyOfWrite(); frequency != 0 {
+		t.Error("write should not be picked with zero weight, got", frequency)
+	}
+	if isTaskDisabled(write, r.getWeightSum()) {
+		t.Error("Tasks with WeightFn should never be disabled")
+	}
+}
+
+func TestWeightFnWhileRunning(t *testing.T) {
+	var writeWeight, reads, writes int64
+	read := &Task{
+		Name:   "read",
+		Weight: 1,
+		Fn: func() {
+			atomic.AddInt64(&reads, 1)
+			time.Sleep(time.Millisecond)
+		},
+	}
+	write := &Task{
+		Name: "write",
+		WeightFn: func() int {
+			return int(atomic.LoadInt64(&writeWeight))
+		},
+		Fn: func() {
+			atomic.AddInt64(&writes, 1)
+			time.Sleep(time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{read, write}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = time.Second
+	runner.weightRefreshInterval = 10 * time.Millisecond
+
+	runner.startHatching(10, 10, nil)
+	defer runner.stop()
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt64(&writes) != 0 || atomic.LoadInt64(&reads) == 0 {
+		t.Fatal("Only read should be executed, got", atomic.LoadInt64(&reads), atomic.LoadInt64(&writes))
+	}
+
+	// switch to writes only
+	atomic.StoreInt64(&writeWeight, 1000000)
+	time.Sleep(50 * time.Millisecond)
+	r, w := atomic.LoadInt64(&reads), atomic.LoadInt64(&writes)
+	time.Sleep(50 * time.Millisecond)
+	if newWrites := atomic.LoadInt64(&writes) - w; newWrites == 0 || atomic.LoadInt64(&reads)-r > newWrites/10 {
+		t.Error("Mostly write should be executed after the weight changes, got", atomic.LoadInt64(&reads)-r, newWrites)
+	}
+}
+
+func TestMaxIterations(t *testing.T) {
+	var setups, perUserSetups, others int64
+	setup := &Task{
+		Name:          "setup",
+		MaxIterations: 5,
+		Fn: func() {
+			atomic.AddInt64(&setups, 1)
+		},
+	}
+	perUserSetup := &Task{
+		Name:                 "per-user setup",
+		MaxIterations:        2,
+		MaxIterationsPerUser: true,
+		Fn: func() {
+			atomic.AddInt64(&perUserSetups, 1)
+		},
+	}
+	other := &Task{
+		Name: "other",
+		Fn: func() {
+			atomic.AddInt64(&others, 1)
+			time.Sleep(time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{setup, perUserSetup, other}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = time.Second
+
+	runner.startHatching(10, 10, nil)
+	time.Sleep(200 * time.Millisecond)
+	runner.stop()
+
+	if n := atomic.LoadInt64(&setups); n != 5 {
+		t.Error("The setup task should be executed 5 times by all the goroutines, got", n)
+	}
+	if n := atomic.LoadInt64(&perUserSetups); n != 20 {
+		t.Error("The per-user setup task should be executed twice by each of the 10 goroutines, got", n)
+	}
+	if atomic.LoadInt64(&others) == 0 {
+		t.Error("The other task should keep running")
+	}
+
+	// a new hatch starts over
+	runner.startHatching(10, 10, nil)
+	time.Sleep(200 * time.Millisecond)
+	runner.stop()
+	if n := atomic.LoadInt64(&setups); n != 10 {
+		t.Error("The setup task should be executed 5 more times after hatching again, got", n)
+	}
+}
+
+func TestMaxIterationsOfAllTasks(t *testing.T) {
+	var count int64
+	task := &Task{
+		MaxIterations: 3,
+		Fn: func() {
+			atomic.AddInt64(&count, 1)
+		},
+	}
+	runner := newLocalRunner([]*Task{task}, nil, 5, "asap", 5)
+	defer runner.stats.close()
+	runner.stats.start()
+
+	// goroutines wait without running anything once all the tasks are exhausted
+	runner.startHatching(5, 5, nil)
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt64(&count); n != 3 {
+		t.Error("The task should be executed 3 times, got", n)
+	}
+	if n := atomic.LoadInt32(&runner.numClients); n != 5 {
+		t.Error("The goroutines should keep alive, got", n)
+	}
+	runner.stop()
+}
+
+func TestExcludeTasks(t *testing.T) {
+	tasks := []*Task{{Name: "A"}, {Name: "B"}, {Name: "C"}}
+	weights := excludeTasks(tasks, []float64{1, 3, 6}, func(task *Task) bool {
+		return task.Name == "B"
+	})
+	if fmt.Sprint(weights) != "[1 1 4]" {
+		t.Error("The weight of B should be excluded, got", weights)
+	}
+}
+
+func TestDisabledTaskHooks(t *testing.T) {
+	var starts, stops int32
+	disabled := &Task{
+		Weight: 0,
+		OnStart: func() error {
+			atomic.AddInt32(&starts, 1)
+			return nil
+		},
+		OnStop: func() {
+			atomic.AddInt32(&stops, 1)
+		},
+	}
+
+	r := &runner{tasks: []*Task{{Weight: 1}, disabled}}
+	if !r.startUser() {
+		t.Fatal("The user should start")
+	}
+	r.stopUser(r.tasks)
+	if starts != 0 || stops != 0 {
+		t.Error("The hooks of the disabled task should not be called, got", starts, stops)
+	}
+
+	// all the tasks have zero weight, none of them is disabled
+	r = &runner{tasks: []*Task{{Weight: 0}, disabled}}
+	r.startUser()
+	r.stopUser(r.tasks)
+	if starts != 1 || stops != 1 {
+		t.Error("The hooks should be called if all the tasks have zero weight, got", starts, stops)
+	}
+}
+
+func TestRandSeed(t *testing.T) {
+	tasks := []*Task{
+		{Name: "A", Weight: 1},
+		{Name: "B", Weight: 2},
+		{Name: "C", Weight: 3},
+	}
+	pickTasks := func(runner *localRunner) []string {
+		cumulativeWeights := runner.getCumulativeWeights()
+		names := make([]string, 0)
+		for i := 0; i < 3; i++ {
+			rd := runner.newRand()
+			for j := 0; j < 100; j++ {
+				names = append(names, runner.pickTask(rd, cumulativeWeights).Name)
+			}
+		}
+		return names
+	}
+
+	runner1 := newLocalRunner(tasks, nil, 10, "asap", 10)
+	runner1.randSeed = 42
+	runner2 := newLocalRunner(tasks, nil, 10, "asap", 10)
+	runner2.randSeed = 42
+	names1, names2 := pickTasks(runner1), pickTasks(runner2)
+	for i := range names1 {
+		if names1[i] != names2[i] {
+			t.Fatal("Runners with the same seed should pick the same tasks, differ at", i)
+		}
+	}
+
+	// workers of the same runner are seeded differently
+	if strings.Join(names1[:100], ",") == strings.Join(names1[100:200], ",") {
+		t.Error("Workers should be seeded differently")
+	}
+
+	runner3 := newLocalRunner(tasks, nil, 10, "asap", 10)
+	runner4 := newLocalRunner(tasks, nil, 10, "asap", 10)
+	if strings.Join(pickTasks(runner3), ",") == strings.Join(pickTasks(runner4), ",") {
+		t.Error("Runners without a seed should pick different tasks")
+	}
+}
+
+func TestConcurrentTaskSelection(t *testing.T) {
+	// every worker owns its random generator, run it with -race to make sure nothing is shared.
+	var countA, countB int64
+	taskA := &Task{
+		Weight: 1,
+		Fn: func() {
+			atomic.AddInt64(&countA, 1)
+		},
+	}
+	taskB := &Task{
+		Weight: 3,
+		Fn: func() {
+			atomic.AddInt64(&countB, 1)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA, taskB}, nil, 200, "asap", 200)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = time.Second
+
+	runner.startHatching(200, 200, nil)
+	time.Sleep(100 * time.Millisecond)
+	runner.stop()
+
+	a, b := atomic.LoadInt64(&countA), atomic.LoadInt64(&countB)
+	if a == 0 || b == 0 {
+		t.Fatal("Both tasks should be picked, got", a, b)
+	}
+	if ratio := float64(b) / float64(a); ratio < 2 || ratio > 4 {
+		t.Error("The ratio of picks should be close to the weights 3:1, was", ratio)
+	}
+}
+
+func TestPickTaskWithFractionalWeights(t *testing.T) {
+	tests := []struct {
+		tasks    []*Task
+		expected []float64
+	}{
+		{[]*Task{{WeightF: 0.5}, {WeightF: 99.5}}, []float64{0.005, 0.995}},
+		{[]*Task{{WeightF: 0.25}, {WeightF: 0.75}}, []float64{0.25, 0.75}},
+		// WeightF takes precedence, Weight is used when WeightF is zero
+		{[]*Task{{Weight: 100, WeightF: 1}, {Weight: 3}}, []float64{0.25, 0.75}},
+	}
+
+	for _, test := range tests {
+		runner := &runner{tasks: test.tasks}
+		cumulativeWeights := runner.getCumulativeWeights()
+		rd := rand.New(rand.NewSource(1))
+
+		draws := 100000
+		counts := make(map[*Task]int)
+		for i := 0; i < draws; i++ {
+			counts[runner.pickTask(rd, cumulativeWeights)]++
+		}
+
+		for i, task := range runner.tasks {
+			frequency := float64(counts[task]) / float64(draws)
+			if math.Abs(frequency-test.expected[i]) > 0.01 {
+				t.Errorf("task %d is selected with frequency %.3f, expected: %.3f",
+					i, frequency, test.expected[i])
+			}
+		}
+	}
+}
+
+func TestSpawnWorkersSmoothly(t *testing.T) {
+	taskA := &Task{
+		Weight: 10,
+		Fn: func() {
+			time.Sleep(time.Second)
+		},
+		Name: "TaskA",
+	}
+	tasks := []*Task{taskA}
+
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, tasks, nil, "smooth")
+	defer runner.close()
+
+	runner.client = newClient("localhost", 5557, runner.nodeID)
+	runner.hatchRate = 10
+
+	go runner.spawnWorkers(10, runner.stopChan, runner.hatchComplete)
+	time.Sleep(2 * time.Millisecond)
+
+	currentClients := atomic.LoadInt32(&runner.numClients)
+	if currentClients > 3 {
+		t.Error("Spawning goroutines too fast, current count", currentClients)
+	}
+}
+
+func TestSpawnWorkersWithHatchInterval(t *testing.T) {
+	tests := []struct {
+		hatchType string
+		interval  time.Duration
+	}{
+		// less than one goroutine per second
+		{"asap", 1200 * time.Millisecond},
+		{"smooth", 100 * time.Millisecond},
+	}
+	for _, test := range tests {
+		hatchType := test.hatchType
+		starts := make(chan time.Time, 10)
+		taskA := &Task{
+			OnStart: func() error {
+				starts <- time.Now()
+				return nil
+			},
+			Fn: func() {
+				time.Sleep(10 * time.Millisecond)
+			},
+		}
+		runner := newLocalRunner([]*Task{taskA}, nil, 3, hatchType, 0)
+		runner.hatchInterval = test.interval
+		runner.stopChan = make(chan bool)
+
+		go runner.spawnWorkers(3, runner.stopChan, nil)
+		first := <-starts
+		var second time.Time
+		select {
+		case second = <-starts:
+		case <-time.After(2 * test.interval):
+			t.Fatal(hatchType, "the second goroutine should be spawned after the hatch interval")
+		}
+		if spacing := second.Sub(first); spacing < test.interval-10*time.Millisecond {
+			t.Error(hatchType, "goroutines should be spawned every", test.interval, "got", spacing)
+		}
+
+		// quitting doesn't wait for the hatch interval
+		close(runner.stopChan)
+		time.Sleep(20 * time.Millisecond)
+		if n := atomic.LoadInt32(&runner.numClients); n != 2 {
+			t.Error(hatchType, "no more goroutines should be spawned after quit, got", n)
+		}
+		runner.stats.close()
+	}
+}
+
+func TestSpawnWorkersWithZeroHatchRate(t *testing.T) {
+	for _, hatchType := range []string{"asap", "smooth", "step"} {
+		runner := newLocalRunner([]*Task{{Fn: func() {}}}, nil, 3, hatchType, 0)
+		runner.stopChan = make(chan bool)
+		runner.stepDuration = time.Millisecond
+
+		done := make(chan bool)
+		go func() {
+			runner.spawnWorkers(3, runner.stopChan, nil)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error(hatchType, "spawning with zero hatch rate should not block")
+		}
+		close(runner.stopChan)
+		runner.stats.close()
+		if n := atomic.LoadInt32(&runner.numClients); n != 3 {
+			t.Error(hatchType, "expected 3 goroutines, got", n)
+		}
+	}
+}
+
+func TestHatchAndStop(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(time.Second)
+		},
+	}
+	taskB := &Task{
+		Fn: func() {
+			time.Sleep(2 * time.Second)
+		},
+	}
+	tasks := []*Task{taskA, taskB}
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, tasks, nil, "asap")
+	defer runner.close()
+	runner.client = newClient("localhost", 5557, runner.nodeID)
+
+	go func() {
+		var ticker = time.NewTicker(time.Second)
+		for {
+			select {
+			case <-ticker.C:
+				t.Error("Timeout waiting for message sent by startHatching()")
+				return
+			case <-runner.stats.clearStatsChan:
+				// just quit
+				return
+			}
+		}
+	}()
+
+	runner.startHatching(10, 10, runner.hatchComplete)
+	// wait for spawning goroutines
+	time.Sleep(100 * time.Millisecond)
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 10 {
+		t.Error("Number of goroutines mismatches, expected: 10, current count", numClients)
+	}
+
+	msg := <-runner.client.sendChannel()
+	if msg.Type != "hatch_complete" {
+		t.Error("Runner should send hatch_complete message when hatching completed, got", msg.Type)
+	}
+	runner.stop()
+
+	runner.onQuiting()
+	msg = <-runner.client.sendChannel()
+	if msg.Type != "quit" {
+		t.Error("Runner should send quit message on quitting, got", msg.Type)
+	}
+	if reason := msg.Data["reason"]; reason != StopReasonNormal {
+		t.Error("The quit message should carry the normal reason, got", reason)
+	}
+}
+
+func TestStop(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(time.Second)
+		},
+	}
+	tasks := []*Task{taskA}
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, tasks, nil, "asap")
+	runner.stopChan = make(chan bool)
+
+	stopped := false
+	handler := func() {
+		stopped = true
+	}
+	Events.Subscribe("boomer:stop", handler)
+	defer Events.Unsubscribe("boomer:stop", handler)
+
+	runner.stop()
+
+	if stopped != true {
+		t.Error("Expected stopped to be true, was", stopped)
+	}
+}
+
+func TestShouldStop(t *testing.T) {
+	steps := int32(0)
+	taskA := &Task{
+		Fn: func() {
+			for i := 0; i < 10; i++ {
+				if ShouldStop() {
+					return
+				}
+				atomic.AddInt32(&steps, 1)
+				time.Sleep(10 * time.Millisecond)
+			}
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 1, "asap", 1)
+	defer runner.stats.close()
+	runner.stats.start()
+
+	runner.startHatching(1, 1, nil)
+	time.Sleep(30 * time.Millisecond)
+	if ShouldStop() {
+		t.Error("ShouldStop should be false before the runner stops")
+	}
+
+	runner.stop()
+	if !ShouldStop() {
+		t.Error("ShouldStop should be true after the runner stops")
+	}
+	stepsAtStop := atomic.LoadInt32(&steps)
+	time.Sleep(30 * time.Millisecond)
+	// the step in progress when stopping may still be counted
+	if n := atomic.LoadInt32(&steps); n > stepsAtStop+1 {
+		t.Error("The task should return at the next checkpoint, but ran", n-stepsAtStop, "more steps")
+	}
+}
+
+func TestStepHatch(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 6, "step", 10)
+	runner.stepSize = 2
+	runner.stepDuration = 100 * time.Millisecond
+	runner.hatchRate = 10
+	runner.stopChan = make(chan bool)
+	defer runner.stop()
+
+	go runner.spawnWorkers(6, runner.stopChan, nil)
+
+	expected := []int32{2, 4, 6}
+	time.Sleep(50 * time.Millisecond)
+	for i, count := range expected {
+		if i > 0 {
+			time.Sleep(runner.stepDuration)
+		}
+		if numClients := atomic.LoadInt32(&runner.numClients); numClients != count {
+			t.Error("The number of clients in step", i, "is wrong, expected:", count, "was:", numClients)
+		}
+	}
+}
+
+func TestSpikeHatch(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 5, "spike", 1)
+	runner.spikeCount = 3
+	runner.spikeDuration = 100 * time.Millisecond
+	runner.stopChan = make(chan bool)
+	defer runner.stop()
+
+	startTime := time.Now()
+	runner.spawnWorkers(5, runner.stopChan, nil)
+	if elapsed := time.Since(startTime); elapsed > 50*time.Millisecond {
+		t.Error("All the goroutines should be spawned at once, took", elapsed)
+	}
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 8 {
+		t.Error("The extra goroutines should be spawned, expected: 8, was:", numClients)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 5 {
+		t.Error("The extra goroutines should quit after spikeDuration, expected: 5, was:", numClients)
+	}
+	if running := atomic.LoadInt32(&runner.runningWorkers); running != 5 {
+		t.Error("The extra goroutines should quit after spikeDuration, expected: 5, was:", running)
+	}
+}
+
+func TestStopHatching(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 50, "asap", 50)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.setState(stateRunning)
+
+	runner.startHatching(50, 50, nil)
+	time.Sleep(100 * time.Millisecond)
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 50 {
+		t.Fatal("The number of clients is wrong, expected: 50, was:", numClients)
+	}
+
+	done := make(chan bool)
+	go func() {
+		runner.stopHatching(10)
+		close(done)
+	}()
+
+	// the first client leaves at once, and then 10 clients per second
+	for i := 1; i <= 4; i++ {
+		time.Sleep(time.Second)
+		expected := int32(50 - 1 - 10*i)
+		numClients := atomic.LoadInt32(&runner.numClients)
+		if numClients < expected-2 || numClients > expected+2 {
+			t.Error("The clients should decrease linearly, expected about:", expected, "was:", numClients)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stopHatching should return after all the clients left")
+	}
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 0 {
+		t.Error("All the clients should leave, was:", numClients)
+	}
+	if runner.getState() != stateStopped {
+		t.Error("The runner should be stopped after ramping down, got", runner.getState())
+	}
+}
+
+func TestStopHatchingBeforeHatch(t *testing.T) {
+	runner := newLocalRunner([]*Task{{Fn: func() {}}}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+
+	// it shouldn't panic
+	runner.stopHatching(10)
+}
+
+func TestStopHatchingWhenPaused(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 4, "asap", 4)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = time.Second
+	runner.setState(stateRunning)
+
+	runner.startHatching(4, 4, nil)
+	time.Sleep(50 * time.Millisecond)
+	if !runner.pause() {
+		t.Fatal("The runner should be paused")
+	}
+
+	done := make(chan bool)
+	go func() {
+		runner.stopHatching(100)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stopHatching shouldn't wait for resuming")
+	}
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 0 {
+		t.Error("All the clients should leave, was:", numClients)
+	}
+}
+
+func TestStopHatchingSendsClientStopped(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(time.Millisecond)
+		},
+	}
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, []*Task{taskA}, nil, "asap")
+	defer runner.close()
+	c := newFakeClient()
+	runner.client = c
+	runner.stats.start()
+	runner.setState(stateRunning)
+
+	runner.startHatching(10, 10, nil)
+	time.Sleep(50 * time.Millisecond)
+	runner.stopHatching(0)
+
+	for len(c.toMaster) > 0 {
+		msg := <-c.toMaster
+		if msg.Type == "client_stopped" {
+			if reason := msg.Data["reason"]; reason != StopReasonNormal {
+				t.Error("The reason should be", StopReasonNormal, "got", reason)
+			}
+			return
+		}
+	}
+	t.Error("A client_stopped message should be sent to master after ramping down")
+}
+
+func TestSpawnCompleteEvent(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 10, "asap", 10)
+	defer runner.stop()
+
+	spawnComplete := make(chan int, 10)
+	handler := func(count int) {
+		spawnComplete <- count
+	}
+	Events.Subscribe("boomer:spawn_complete", handler)
+	defer Events.Unsubscribe("boomer:spawn_complete", handler)
+
+	runner.stopChan = make(chan bool)
+	runner.spawnWorkers(10, runner.stopChan, nil)
+
+	select {
+	case count := <-spawnComplete:
+		if count != 10 {
+			t.Error("The count of boomer:spawn_complete is wrong, expected: 10, was:", count)
+		}
+	default:
+		t.Fatal("boomer:spawn_complete should be published after spawnWorkers completes")
+	}
+	select {
+	case <-spawnComplete:
+		t.Error("boomer:spawn_complete should be published only once")
+	default:
+	}
+}
+
+func TestHatchProgressEvent(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 250, "asap", 0)
+	defer runner.stop()
+
+	progress := make(chan [2]int, 1000)
+	handler := func(spawned, target int) {
+		progress <- [2]int{spawned, target}
+	}
+	Events.Subscribe("boomer:hatch_progress", handler)
+	defer Events.Unsubscribe("boomer:hatch_progress", handler)
+
+	runner.stopChan = make(chan bool)
+	runner.spawnWorkers(250, runner.stopChan, nil)
+	close(progress)
+
+	count, last := 0, 0
+	for p := range progress {
+		count++
+		if p[1] != 250 {
+			t.Error("The target of boomer:hatch_progress should be 250, was:", p[1])
+		}
+		if p[0] <= last {
+			t.Errorf("The spawned count of boomer:hatch_progress should increase, %d after %d", p[0], last)
+		}
+		last = p[0]
+	}
+	if last != 250 {
+		t.Error("The last boomer:hatch_progress should reach the target, was:", last)
+	}
+	if count < 2 || count > maxHatchProgressEvents+1 {
+		t.Error("Wrong number of boomer:hatch_progress events, was:", count)
+	}
+}
+
+func TestStopWaitsForWorkers(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(100 * time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = 2 * time.Second
+
+	runner.startHatching(10, 10, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	startTime := time.Now()
+	runner.stop()
+	elapsed := time.Since(startTime)
+
+	if running := atomic.LoadInt32(&runner.runningWorkers); running != 0 {
+		t.Error("All the workers should have exited, still running:", running)
+	}
+	if elapsed >= runner.stopTimeout {
+		t.Error("stop() should return as soon as the workers exit, took", elapsed)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(2 * time.Second)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = 100 * time.Millisecond
+
+	runner.startHatching(10, 10, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	startTime := time.Now()
+	runner.stop()
+	elapsed := time.Since(startTime)
+
+	if elapsed < runner.stopTimeout || elapsed > time.Second {
+		t.Error("stop() should return after the timeout elapses, took", elapsed)
+	}
+	if running := atomic.LoadInt32(&runner.runningWorkers); running != 10 {
+		t.Error("The slow workers should be still running, expected: 10, was:", running)
+	}
+}
+
+func TestStopCancelsContext(t *testing.T) {
+	taskWithContext := &Task{
+		FnWithContext: func(ctx context.Context) {
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		},
+	}
+	runner := newLocalRunner([]*Task{taskWithContext}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = time.Second
+
+	runner.startHatching(10, 10, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	startTime := time.Now()
+	runner.stop()
+	elapsed := time.Since(startTime)
+
+	if running := atomic.LoadInt32(&runner.runningWorkers); running != 0 {
+		t.Error("Context-aware tasks should return on stop, still running:", running)
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Error("Context-aware tasks should return promptly on stop, took", elapsed)
+	}
+
+	taskWithoutContext := &Task{
+		Fn: func() {
+			time.Sleep(5 * time.Second)
+		},
+	}
+	runner = newLocalRunner([]*Task{taskWithoutContext}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = 100 * time.Millisecond
+
+	runner.startHatching(10, 10, nil)
+	time.Sleep(50 * time.Millisecond)
+	runner.stop()
+
+	if running := atomic.LoadInt32(&runner.runningWorkers); running != 10 {
+		t.Error("Plain tasks should not be interrupted by stop, expected: 10, was:", running)
+	}
+}
+
+func TestConcurrentStop(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.runTime = 50 * time.Millisecond
+	runner.onLimitReached = runner.stop
+
+	for i := 0; i < 10; i++ {
+		runner.startHatching(10, 10, nil)
+		time.Sleep(50 * time.Millisecond)
+		// the run time limit and others stop the runner at the same time, stopChan is closed only once
+		wg := sync.WaitGroup{}
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runner.stop()
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func TestRunTimeOfSlaveRunner(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, []*Task{taskA}, nil, "asap")
+	defer runner.close()
+	runner.client = newClient("localhost", 5557, runner.nodeID)
+	runner.stats.start()
+	runner.runTime = 200 * time.Millisecond
+	runner.setState(stateInit)
+
+	quitMessages := make(chan bool, 10)
+	receiver := func() {
+		quitMessages <- true
+	}
+	Events.Subscribe("boomer:quit", receiver)
+	defer Events.Unsubscribe("boomer:quit", receiver)
+	Events.Subscribe("boomer:quit", runner.onQuiting)
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+
+	hatchMessage := newMessage("hatch", map[string]interface{}{
+		"hatch_rate":  float64(10),
+		"num_clients": int64(10),
+	}, runner.nodeID)
+	runner.onMessage(hatchMessage)
+
+	// a new hatch message resets the timer
+	time.Sleep(150 * time.Millisecond)
+	runner.onMessage(hatchMessage)
+	time.Sleep(150 * time.Millisecond)
+	if runner.getState() != stateRunning {
+		t.Error("The timer should be reset by a new hatch, expected state running, got", runner.getState())
+	}
+	select {
+	case <-quitMessages:
+		t.Error("boomer:quit should not be published before the run time is exceeded")
+	default:
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if runner.getState() != stateStopped {
+		t.Error("The runner should stop when the run time is exceeded, got", runner.getState())
+	}
+	select {
+	case <-quitMessages:
+	case <-time.After(100 * time.Millisecond):
+		t.Error("boomer:quit should be published when the run time is exceeded")
+	}
+
+	quitSent := false
+	for len(runner.client.sendChannel()) > 0 {
+		msg := <-runner.client.sendChannel()
+		if msg.Type == "quit" {
+			quitSent = true
+		}
+	}
+	if !quitSent {
+		t.Error("The runner should send quit message to master when the run time is exceeded")
+	}
+}
+
+func TestRunTimeOfLocalRunner(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 10, "asap", 10)
+	runner.runTime = 200 * time.Millisecond
+
+	done := make(chan bool)
+	go func() {
+		runner.run()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The local runner should quit when the run time is exceeded")
+	}
+	if runner.getState() != stateStopped {
+		t.Error("The runner should stop when the run time is exceeded, got", runner.getState())
+	}
+	// it's safe to close again
+	runner.close()
+}
+
+func TestMaxRequests(t *testing.T) {
+	count := int64(0)
+	taskA := &Task{
+		Fn: func() {
+			atomic.AddInt64(&count, 1)
+			time.Sleep(time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 10, "asap", 10)
+	runner.maxRequests = 50
+
+	done := make(chan bool)
+	go func() {
+		runner.run()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("The local runner should quit when max requests is reached")
+	}
+	// wait for the running tasks
+	time.Sleep(10 * time.Millisecond)
+	if executions := atomic.LoadInt64(&count); executions != 50 {
+		t.Error("The number of executions should not exceed max requests, expected: 50, was:", executions)
+	}
+}
+
+func TestWaitTime(t *testing.T) {
+	var lock sync.Mutex
+	timestamps := make([]time.Time, 0)
+	taskA := &Task{
+		Fn: func() {
+			lock.Lock()
+			timestamps = append(timestamps, time.Now())
+			lock.Unlock()
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 1, "asap", 1)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.minWait = 50 * time.Millisecond
+	runner.maxWait = 100 * time.Millisecond
+
+	runner.startHatching(1, 1, nil)
+	time.Sleep(500 * time.Millisecond)
+	runner.stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(timestamps) < 4 || len(timestamps) > 11 {
+		t.Error("The number of iterations is out of range, expected: 4~11, was:", len(timestamps))
+	}
+	for i := 1; i < len(timestamps); i++ {
+		interval := timestamps[i].Sub(timestamps[i-1])
+		if interval < runner.minWait || interval > runner.maxWait+50*time.Millisecond {
+			t.Error("The interval between iterations should be between minWait and maxWait, was:", interval)
+		}
+	}
+}
+
+func TestWaitTimeOfTask(t *testing.T) {
+	var lock sync.Mutex
+	names := make([]string, 0)
+	timestamps := make([]time.Time, 0)
+	record := func(name string) {
+		lock.Lock()
+		names = append(names, name)
+		timestamps = append(timestamps, time.Now())
+		lock.Unlock()
+	}
+	taskA := &Task{
+		Name:    "fast",
+		Weight:  1,
+		MinWait: 20 * time.Millisecond,
+		MaxWait: 20 * time.Millisecond,
+		Fn: func() {
+			record("fast")
+		},
+	}
+	taskB := &Task{
+		Name:    "slow",
+		Weight:  1,
+		MinWait: 200 * time.Millisecond,
+		MaxWait: 200 * time.Millisecond,
+		Fn: func() {
+			record("slow")
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA, taskB}, nil, 1, "asap", 1)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.minWait = time.Second
+	runner.maxWait = time.Second
+
+	runner.startHatching(1, 1, nil)
+	time.Sleep(time.Second)
+	runner.stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(names) < 4 {
+		t.Fatal("The wait time of tasks should take precedence over the runner's, iterations:", len(names))
+	}
+	for i := 1; i < len(timestamps); i++ {
+		interval := timestamps[i].Sub(timestamps[i-1])
+		if names[i-1] == "fast" && interval >= 150*time.Millisecond {
+			t.Error("The interval after the fast task should be about 20ms, was:", interval)
+		}
+		if names[i-1] == "slow" && interval < 200*time.Millisecond {
+			t.Error("The interval after the slow task should be at least 200ms, was:", interval)
+		}
+	}
+}
+
+func TestStopDuringWaitTime(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.minWait = 10 * time.Second
+	runner.maxWait = 10 * time.Second
+	runner.stopTimeout = time.Second
+
+	runner.startHatching(10, 10, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	startTime := time.Now()
+	runner.stop()
+	elapsed := time.Since(startTime)
+
+	if running := atomic.LoadInt32(&runner.runningWorkers); running != 0 {
+		t.Error("Waiting workers should return on stop, still running:", running)
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Error("Waiting workers should return promptly on stop, took", elapsed)
+	}
+}
+
+func TestTaskHooks(t *testing.T) {
+	var onStartCount, onStopCount int32
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(10 * time.Millisecond)
+		},
+		OnStart: func() error {
+			atomic.AddInt32(&onStartCount, 1)
+			return nil
+		},
+		OnStop: func() {
+			atomic.AddInt32(&onStopCount, 1)
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = time.Second
+
+	runner.startHatching(10, 10, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	if count := atomic.LoadInt32(&onStartCount); count != 10 {
+		t.Error("OnStart should be called once per goroutine, expected: 10, was:", count)
+	}
+	if count := atomic.LoadInt32(&onStopCount); count != 0 {
+		t.Error("OnStop should not be called before stop, was:", count)
+	}
+
+	runner.stop()
+
+	if count := atomic.LoadInt32(&onStopCount); count != 10 {
+		t.Error("OnStop should be called once per goroutine, expected: 10, was:", count)
+	}
+}
+
+func TestTaskOnStartFailed(t *testing.T) {
+	var executions, onStopCount int32
+	taskA := &Task{
+		Weight: 1,
+		Fn: func() {
+			atomic.AddInt32(&executions, 1)
+		},
+		OnStart: func() error {
+			return nil
+		},
+		OnStop: func() {
+			atomic.AddInt32(&onStopCount, 1)
+		},
+	}
+	taskB := &Task{
+		Weight: 1,
+		Fn: func() {
+			atomic.AddInt32(&executions, 1)
+		},
+		OnStart: func() error {
+			return errors.New("login failed")
+		},
+	}
+	runner := newLocalRunner([]*Task{taskA, taskB}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+
+	runner.startHatching(10, 10, nil)
+	time.Sleep(50 * time.Millisecond)
+	runner.stop()
+
+	if count := atomic.LoadInt32(&executions); count != 0 {
+		t.Error("Tasks should not be run if OnStart fails, was:", count)
+	}
+	if count := atomic.LoadInt32(&onStopCount); count != 10 {
+		t.Error("The tasks already started should be stopped, expected: 10, was:", count)
+	}
+	if count := atomic.LoadInt32(&runner.numClients); count != 0 {
+		t.Error("The aborted users should not be counted, was:", count)
+	}
+}
+
+type fakeClient struct {
+	fromMaster   chan *message
+	toMaster     chan *message
+	disconnected chan bool
+	closed       chan bool
+	connectErr   error
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		fromMaster:   make(chan *message, 100),
+		toMaster:     make(chan *message, 100),
+		disconnected: make(chan bool),
+		closed:       make(chan bool),
+	}
+}
+
+func (c *fakeClient) connect() error {
+	return c.connectErr
+}
+
+func (c *fakeClient) close() {
+	close(c.closed)
+}
+
+func (c *fakeClient) recvChannel() chan *message {
+	return c.fromMaster
+}
+
+func (c *fakeClient) sendChannel() chan *message {
+	return c.toMaster
+}
+
+func (c *fakeClient) disconnectedChannel() chan bool {
+	return c.disconnected
+}
+
+func (c *fakeClient) flush(timeout time.Duration) bool {
+	return true
+}
+
+func TestSendStatsWhenChannelIsFull(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	defer runner.close()
+	c := newFakeClient()
+	runner.client = c
+
+	fill := func(types ...string) {
+		runner.statsQueue = make(chan *message, len(types))
+		for i, msgType := range types {
+			runner.statsQueue <- newMessage(msgType, map[string]interface{}{"seq": i}, runner.nodeID)
+		}
+	}
+	queued := func(ch chan *message) string {
+		var types []string
+		for len(ch) > 0 {
+			msg := <-ch
+			types = append(types, fmt.Sprintf("%s%v", msg.Type, msg.Data["seq"]))
+		}
+		return strings.Join(types, ",")
+	}
+	newStats := newMessage("stats", map[string]interface{}{"seq": "new"}, runner.nodeID)
+
+	runner.sendPolicy = "drop-oldest"
+	fill("stats", "stats", "stats")
+	runner.sendStats(newStats)
+	if got := queued(runner.statsQueue); got != "stats1,stats2,statsnew" {
+		t.Error("The oldest stats message should be dropped, got", got)
+	}
+
+	runner.sendPolicy = "drop-newest"
+	fill("stats", "stats", "stats")
+	runner.sendStats(newStats)
+	if got := queued(runner.statsQueue); got != "stats0,stats1,stats2" {
+		t.Error("The new stats message should be dropped, got", got)
+	}
+
+	runner.sendPolicy = "block"
+	runner.statsQueue = nil
+	c.toMaster = make(chan *message, 1)
+	c.toMaster <- newMessage("stats", map[string]interface{}{"seq": 0}, runner.nodeID)
+	sent := make(chan bool)
+	go func() {
+		runner.sendStats(newStats)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("The stats message should wait for room in the send channel")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-c.toMaster
+	<-sent
+	if got := queued(c.toMaster); got != "statsnew" {
+		t.Error("The new stats message should be sent, got", got)
+	}
+}
+
+func TestControlMessagesAreNotDroppedWithStats(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	defer runner.close()
+	c := newFakeClient()
+	c.toMaster = make(chan *message, 2)
+	runner.client = c
+	runner.sendPolicy = "drop-oldest"
+	runner.statsQueue = make(chan *message, 2)
+	go runner.forwardStats()
+
+	for i := 0; i < 4; i++ {
+		runner.sendStats(newMessage("stats", map[string]interface{}{"seq": i}, runner.nodeID))
+	}
+	// the send channel is full, the control message waits for room
+	stopped := make(chan bool)
+	go func() {
+		c.sendChannel() <- newMessage("client_stopped", nil, runner.nodeID)
+		close(stopped)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	for i := 4; i < 10; i++ {
+		runner.sendStats(newMessage("stats", map[string]interface{}{"seq": i}, runner.nodeID))
+	}
+
+	var received []string
+	timeout := time.After(time.Second)
+	for len(received) == 0 || received[len(received)-1] != "stats9" {
+		select {
+		case msg := <-c.toMaster:
+			received = append(received, fmt.Sprintf("%s%v", msg.Type, msg.Data["seq"]))
+		case <-timeout:
+			t.Fatal("The last stats message should be sent, got", received)
+		}
+	}
+	<-stopped
+	found := false
+	for _, msgType := range received {
+		if msgType == "client_stopped<nil>" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("The control message shouldn't be dropped with the stats messages, got", received)
+	}
+}
+
+func TestCloseDrainsSendChannel(t *testing.T) {
+	for _, drainTimeout := range []time.Duration{time.Second, 0} {
+		clientConn, masterConn := net.Pipe()
+		c := newTCPClient("127.0.0.1", 5557, "testing")
+		c.start(clientConn)
+
+		runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+		runner.client = c
+		runner.drainTimeout = drainTimeout
+
+		// the writes to the pipe block until master reads them
+		for _, msgType := range []string{"stats", "stats", "client_stopped"} {
+			c.sendChannel() <- newMessage(msgType, nil, "testing")
+		}
+		closed := make(chan bool)
+		go func() {
+			runner.close()
+			close(closed)
+		}()
+
+		if drainTimeout == 0 {
+			// closed without waiting for master
+			<-closed
+		}
+		var bodies [][]byte
+		for {
+			masterConn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+			body, err := readFrame(masterConn)
+			if err != nil {
+				break
+			}
+			bodies = append(bodies, body)
+		}
+		<-closed
+		masterConn.Close()
+
+		var received []string
+		for _, body := range bodies {
+			msg, err := newMessageFromBytes(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			received = append(received, msg.Type)
+		}
+		if drainTimeout > 0 && strings.Join(received, ",") != "stats,stats,client_stopped" {
+			t.Error("The queued messages should be sent before closed, got", received)
+		}
+		if drainTimeout == 0 && len(received) == 3 {
+			t.Error("The queued messages should be dropped without draining, got", received)
+		}
+	}
+}
+
+func TestSendCustomMessage(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	defer runner.close()
+
+	// not connected yet
+	runner.sendCustomMessage("order", nil)
+
+	client := newFakeClient()
+	runner.client = client
+	runner.sendCustomMessage("order", map[string]interface{}{
+		"order_id": "123",
+	})
+
+	msg := <-client.toMaster
+	if msg.Type != "order" {
+		t.Error("The type of message is wrong, expected: order, got:", msg.Type)
+	}
+	if msg.NodeID != runner.nodeID {
+		t.Error("The node id of message is wrong, expected:", runner.nodeID, "got:", msg.NodeID)
+	}
+	if msg.Data["order_id"] != "123" {
+		t.Error("The data of message is wrong, got:", msg.Data)
+	}
+}
+
+func TestCustomMessageHandler(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		return newFakeClient()
+	}
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+
+	received := make(chan map[string]interface{})
+	runner.registerMessageHandler("config", func(data map[string]interface{}) {
+		// a blocking handler must not block the listener
+		received <- data
+	})
+
+	client := runner.getClient().(*fakeClient)
+	client.fromMaster <- newMessage("config", map[string]interface{}{
+		"timeout": int64(10),
+	}, runner.nodeID)
+	client.fromMaster <- newMessage("config", nil, runner.nodeID)
+
+	// handlers run concurrently, the order is not guaranteed
+	first, second := <-received, <-received
+	if first["timeout"] != int64(10) && second["timeout"] != int64(10) {
+		t.Error("The handler should receive the data of message, got", first, second)
+	}
+
+	// the built-in messages can't be overridden
+	runner.registerMessageHandler("quit", func(data map[string]interface{}) {
+		t.Error("The handler of quit message should not be called")
+	})
+	if runner.dispatchCustomMessage(newMessage("quit", nil, runner.nodeID)) {
+		t.Error("The quit message should not be dispatched to custom handlers")
+	}
+	if runner.dispatchCustomMessage(newMessage("unknown", nil, runner.nodeID)) {
+		t.Error("Messages without a handler should not be dispatched")
+	}
+}
+
+func TestReconnectWhenMasterLost(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	runner.masterTimeout = 500 * time.Millisecond
+	clients := make(chan *fakeClient, 10)
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		c := newFakeClient()
+		clients <- c
+		return c
+	}
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+
+	first := <-clients
+	msg := <-first.toMaster
+	if msg.Type != "client_ready" {
+		t.Error("Runner should send client_ready message after connected, got", msg.Type)
+	}
+
+	// the master stops responding
+	var second *fakeClient
+	select {
+	case second = <-clients:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Runner should reconnect to master after masterTimeout")
+	}
+
+	select {
+	case <-first.closed:
+	default:
+		t.Error("The old client should be closed before reconnecting")
+	}
+	msg = <-second.toMaster
+	if msg.Type != "client_ready" {
+		t.Error("Runner should send client_ready message after reconnected, got", msg.Type)
+	}
+
+	// messages from master are received by the new client
+	second.fromMaster <- newMessage("stats_reset", nil, runner.nodeID)
+	time.Sleep(10 * time.Millisecond)
+	if runner.masterLost() {
+		t.Error("Master should not be lost after receiving a message from it")
+	}
+}
+
+func TestReconnectWhenPaused(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(time.Millisecond)
+		},
+	}
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, []*Task{taskA}, nil, "asap")
+	defer runner.close()
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		return newFakeClient()
+	}
+	runner.client = newFakeClient()
+	runner.listenerQuit = make(chan bool)
+	runner.stats.start()
+	runner.setState(stateRunning)
+	runner.startHatching(10, 10, nil)
+	time.Sleep(50 * time.Millisecond)
+	if !runner.pause() {
+		t.Fatal("The runner should be paused")
+	}
+
+	if !runner.reconnect() {
+		t.Fatal("The runner should reconnect to master")
+	}
+	if atomic.LoadInt32(&runner.paused) != 0 {
+		t.Error("The pause should be cleared by reconnecting")
+	}
+	done := make(chan bool)
+	go func() {
+		runner.workersWaitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("The paused workers should be stopped when master is lost")
+	}
+	if state := runner.getState(); state != stateInit {
+		t.Error("The state should be init after reconnecting, got", state)
+	}
+}
+
+func TestConnectRetries(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	runner.connectRetries = 3
+	runner.connectBackoff = 10 * time.Millisecond
+	attempts := 0
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		attempts++
+		c := newFakeClient()
+		if attempts <= 2 {
+			c.connectErr = errors.New("connection refused")
+		}
+		return c
+	}
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+
+	if attempts != 3 {
+		t.Error("Runner should connect 3 times, got", attempts)
+	}
+	client := runner.getClient().(*fakeClient)
+	if client.connectErr != nil {
+		t.Fatal("The connected client should be used")
+	}
+	select {
+	case msg := <-client.toMaster:
+		if msg.Type != "client_ready" {
+			t.Error("Runner should send client_ready message after connected, got", msg.Type)
+		}
+	case <-time.After(time.Second):
+		t.Error("Runner should register to master after retrying")
+	}
+}
+
+func TestConnectRetriesExhausted(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	runner.connectBackoff = 10 * time.Millisecond
+	attempts := 0
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		attempts++
+		c := newFakeClient()
+		c.connectErr = errors.New("connection refused")
+		return c
+	}
+
+	if c := runner.connect(2); c != nil || attempts != 3 {
+		t.Error("Runner should give up after 3 attempts, got", attempts)
+	}
+
+	// retrying forever, until the runner is closed
+	attempts = 0
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(runner.closeChan)
+	}()
+	if c := runner.connect(-1); c != nil || attempts < 2 {
+		t.Error("Runner should retry until it's closed, got", attempts)
+	}
+}
+
+func TestFailoverToNextMaster(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable.Close()
+
+	masters := []masterAddr{
+		{"127.0.0.1", unreachable.Addr().(*net.TCPAddr).Port},
+		{"127.0.0.1", listener.Addr().(*net.TCPAddr).Port},
+	}
+	runner := newSlaveRunner(masters, nil, nil, "asap")
+	runner.transport = transportTCP
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+
+	if runner.masterIndex != 1 {
+		t.Fatal("Runner should fail over to the second master, got", runner.master())
+	}
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second))
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal("The second master doesn't accept the connection,", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	body, err := readFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := newMessageFromBytes(body); msg == nil || msg.Type != "client_ready" {
+		t.Error("Runner should send client_ready message to the second master")
+	}
+}
+
+func TestFailoverWhenMasterLost(t *testing.T) {
+	masters := []masterAddr{{"10.0.0.1", 5557}, {"10.0.0.2", 5557}}
+	runner := newSlaveRunner(masters, nil, nil, "asap")
+	runner.masterTimeout = 500 * time.Millisecond
+	runner.connectBackoff = 10 * time.Millisecond
+	hosts := make(chan string, 10)
+	clients := make(chan *fakeClient, 10)
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		hosts <- masterHost
+		c := newFakeClient()
+		clients <- c
+		return c
+	}
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+
+	if host := <-hosts; host != "10.0.0.1" {
+		t.Error("Runner should connect to the first master, got", host)
+	}
+	<-clients
+
+	// the first master stops responding
+	select {
+	case host := <-hosts:
+		if host != "10.0.0.2" {
+			t.Error("Runner should fail over to the second master, got", host)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Runner should fail over after masterTimeout")
+	}
+	msg := <-(<-clients).toMaster
+	if msg.Type != "client_ready" {
+		t.Error("Runner should send client_ready message to the second master, got", msg.Type)
+	}
+}
+
+func TestConnectRetriesWithMasters(t *testing.T) {
+	masters := []masterAddr{{"10.0.0.1", 5557}, {"10.0.0.2", 5557}}
+	runner := newSlaveRunner(masters, nil, nil, "asap")
+	runner.connectBackoff = 10 * time.Millisecond
+	var hosts []string
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		hosts = append(hosts, masterHost)
+		c := newFakeClient()
+		c.connectErr = errors.New("connection refused")
+		return c
+	}
+
+	// every master is tried in each attempt
+	if c := runner.connect(1); c != nil {
+		t.Error("Runner should give up after all the attempts fail")
+	}
+	if fmt.Sprint(hosts) != "[10.0.0.1 10.0.0.2 10.0.0.1 10.0.0.2]" {
+		t.Error("Runner should try the masters in turn, got", hosts)
+	}
+}
+
+func TestHeartbeatJitter(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	runner.heartbeatJitter = 400 * time.Millisecond
+
+	var sum time.Duration
+	shortest, longest := heartbeatInterval, heartbeatInterval
+	for i := 0; i < 1000; i++ {
+		interval := runner.nextHeartbeat()
+		if interval < heartbeatInterval-runner.heartbeatJitter || interval > heartbeatInterval+runner.heartbeatJitter {
+			t.Fatal("The interval should be shifted within the jitter, got", interval)
+		}
+		if interval < shortest {
+			shortest = interval
+		}
+		if interval > longest {
+			longest = interval
+		}
+		sum += interval
+	}
+	if average := sum / 1000; average < heartbeatInterval-30*time.Millisecond || average > heartbeatInterval+30*time.Millisecond {
+		t.Error("The average interval should be the same as without jitter, got", average)
+	}
+	if heartbeatInterval-shortest < 300*time.Millisecond || longest-heartbeatInterval < 300*time.Millisecond {
+		t.Error("The intervals should spread within the jitter, got", shortest, longest)
+	}
+
+	c := newFakeClient()
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		return c
+	}
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+
+	var timestamps []time.Time
+	for len(timestamps) < 4 {
+		select {
+		case msg := <-c.toMaster:
+			if msg.Type == "heartbeat" {
+				timestamps = append(timestamps, time.Now())
+			}
+		case <-time.After(2 * heartbeatInterval):
+			t.Fatal("Runner should send heartbeat")
+		}
+	}
+	var intervals []time.Duration
+	for i := 1; i < len(timestamps); i++ {
+		intervals = append(intervals, timestamps[i].Sub(timestamps[i-1]))
+	}
+	varied := false
+	for _, interval := range intervals {
+		if interval < heartbeatInterval-runner.heartbeatJitter-50*time.Millisecond ||
+			interval > heartbeatInterval+runner.heartbeatJitter+50*time.Millisecond {
+			t.Error("The heartbeats should be sent within the jitter, got", intervals)
+		}
+		if d := interval - intervals[0]; d > 10*time.Millisecond || d < -10*time.Millisecond {
+			varied = true
+		}
+	}
+	if !varied {
+		t.Error("The intervals of heartbeats should vary, got", intervals)
+	}
+}
+
+func TestMetadataInClientReadyAndHeartbeat(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	runner.metadata = map[string]interface{}{
+		"region":  "us-east-1",
+		"version": "1.0.0",
+	}
+	runner.heartbeatMetadata = true
+	c := newFakeClient()
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		return c
+	}
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+
+	msg := <-c.toMaster
+	if msg.Type != "client_ready" {
+		t.Fatal("Runner should send client_ready message after connected, got", msg.Type)
+	}
+	metadata, ok := msg.Data["metadata"].(map[string]interface{})
+	if !ok {
+		t.Fatal("The client_ready message should carry the metadata, got", msg.Data)
+	}
+	if metadata["region"] != "us-east-1" || metadata["version"] != "1.0.0" {
+		t.Error("Unexpected metadata in client_ready message,", metadata)
+	}
+
+	select {
+	case msg = <-c.toMaster:
+	case <-time.After(2 * heartbeatInterval):
+		t.Fatal("Runner should send heartbeat")
+	}
+	if msg.Type != "heartbeat" {
+		t.Fatal("Expected heartbeat message, got", msg.Type)
+	}
+	if _, ok := msg.Data["metadata"].(map[string]interface{}); !ok {
+		t.Error("The heartbeat message should carry the metadata, got", msg.Data)
+	}
+}
+
+func TestHeartbeatWithUsage(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	runner.sampleUsage = func() (float64, uint64) {
+		return 42.5, 1024
+	}
+	c := newFakeClient()
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		return c
+	}
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+
+	<-c.toMaster // client_ready
+	var msg *message
+	select {
+	case msg = <-c.toMaster:
+	case <-time.After(2 * heartbeatInterval):
+		t.Fatal("Runner should send heartbeat")
+	}
+	if msg.Type != "heartbeat" {
+		t.Fatal("Expected heartbeat message, got", msg.Type)
+	}
+	if cpu, ok := msg.Data["current_cpu_usage"].(float64); !ok || cpu != 42.5 {
+		t.Error("The heartbeat message should carry the cpu usage, got", msg.Data["current_cpu_usage"])
+	}
+	if rss, ok := msg.Data["current_memory_usage"].(uint64); !ok || rss != 1024 {
+		t.Error("The heartbeat message should carry the memory usage, got", msg.Data["current_memory_usage"])
+	}
+}
+
+func TestCPUWarning(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	runner.cpuWarningThreshold = 90
+	usage := math.Float64bits(50)
+	runner.sampleUsage = func() (float64, uint64) {
+		return math.Float64frombits(atomic.LoadUint64(&usage)), 1024
+	}
+
+	warnings := make(chan float64, 10)
+	onWarning := func(cpuPercent float64) {
+		warnings <- cpuPercent
+	}
+	Events.Subscribe("boomer:cpu_warning", onWarning)
+	defer Events.Unsubscribe("boomer:cpu_warning", onWarning)
+
+	c := newFakeClient()
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		return c
+	}
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+
+	select {
+	case cpuPercent := <-warnings:
+		t.Fatal("No warning is expected below the threshold, got", cpuPercent)
+	case <-time.After(2 * heartbeatInterval):
+	}
+
+	atomic.StoreUint64(&usage, math.Float64bits(95))
+	select {
+	case cpuPercent := <-warnings:
+		if cpuPercent != 95 {
+			t.Error("The warning should carry the cpu usage, got", cpuPercent)
+		}
+	case <-time.After(2 * heartbeatInterval):
+		t.Fatal("boomer:cpu_warning should be published when the cpu is saturated")
+	}
+	if reported := math.Float64frombits(atomic.LoadUint64(&runner.cpuWarningUsage)); reported != 95 {
+		t.Error("The cpu usage should be reported with stats, got", reported)
+	}
+}
+
+func TestCheckCPUUsage(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	runner.cpuWarningThreshold = 90
+
+	count := 0
+	onWarning := func(cpuPercent float64) {
+		count++
+	}
+	Events.Subscribe("boomer:cpu_warning", onWarning)
+	defer Events.Unsubscribe("boomer:cpu_warning", onWarning)
+
+	// only warn once until it drops below the threshold
+	for _, cpuPercent := range []float64{95, 99, 50, 91} {
+		runner.checkCPUUsage(cpuPercent)
+	}
+	if count != 2 {
+		t.Error("Expected 2 warnings, got", count)
+	}
+
+	runner.cpuWarningThreshold = 0
+	runner.checkCPUUsage(50)
+	runner.checkCPUUsage(100)
+	if count != 2 {
+		t.Error("No warning is expected if it's disabled, got", count)
+	}
+}
+
+func TestStatsEvent(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	c := newFakeClient()
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		return c
+	}
+	enrich := func(data map[string]interface{}) {
+		data["build"] = "v1.0.0"
+	}
+	Events.Subscribe("boomer:stats", enrich)
+	defer Events.Unsubscribe("boomer:stats", enrich)
+
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+	<-c.toMaster // client_ready
+
+	runner.setState(stateRunning)
+	runner.stats.messageToRunnerChan <- map[string]interface{}{}
+	for {
+		select {
+		case msg := <-c.toMaster:
+			if msg.Type != "stats" {
+				continue
+			}
+			if msg.Data["build"] != "v1.0.0" {
+				t.Error("The stats message should contain the custom field, got", msg.Data)
+			}
+			if _, ok := msg.Data["user_count"]; !ok {
+				t.Error("The stats message should still contain user_count, got", msg.Data)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("The stats message is not sent")
+		}
+	}
+}
+
+func TestReportEvent(t *testing.T) {
+	reports := make(chan map[string]interface{}, 10)
+	onReport := func(data map[string]interface{}) {
+		// the runners closed by other tests may report nothing in the meantime
+		if stats, ok := data["stats"].([]interface{}); !ok || len(stats) > 0 {
+			reports <- data
+		}
 	}
-	if !hitOutput2.onStop {
-		t.Error("hitOutput2's OnStop has not been called")
+	Events.Subscribe("boomer:report", onReport)
+	defer Events.Unsubscribe("boomer:report", onReport)
+
+	runner := newLocalRunner(nil, nil, 0, "asap", 0)
+	runner.clearOutputs()
+	go runner.run()
+	defer runner.close()
+	// the stats are reset by hatching
+	for i := 0; i < 100 && runner.getState() != stateRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	runner.stats.requestSuccessChan <- &requestSuccess{requestType: "http", name: "foo", responseTime: 10, responseLength: 100}
+	runner.stats.requestFailureChan <- &requestFailure{requestType: "http", name: "foo", responseTime: 20, error: "timeout"}
+
+	select {
+	case data := <-reports:
+		for _, key := range []string{"stats", "stats_total", "errors", "user_count"} {
+			if _, ok := data[key]; !ok {
+				t.Errorf("The report should contain %s, got %v", key, data)
+			}
+		}
+		stats := data["stats"].([]interface{})
+		if len(stats) != 1 {
+			t.Fatal("The report should contain the stats of foo, got", stats)
+		}
+		if stat := stats[0].(map[string]interface{}); stat["num_requests"] != int64(1) || stat["num_failures"] != int64(1) {
+			t.Error("The report should contain the recorded requests, got", stat)
+		}
+	case <-time.After(slaveReportInterval + time.Second):
+		t.Fatal("No report is published")
+	}
+
+	// the same report is sent to master by a slave
+	slave := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	c := newFakeClient()
+	slave.newClient = func(masterHost string, masterPort int, identity string) client {
+		return c
+	}
+	slave.run()
+	defer slave.close()
+	defer Events.Unsubscribe("boomer:quit", slave.onQuiting)
+	<-c.toMaster // client_ready
+
+	slave.setState(stateRunning)
+	slave.stats.messageToRunnerChan <- map[string]interface{}{"foo": "bar"}
+	select {
+	case data := <-reports:
+		if data["foo"] != "bar" || data["node_id"] != slave.nodeID {
+			t.Error("The report of slave should be the stats sent to master, got", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No report is published by the slave")
 	}
 }
 
-func TestLocalRunner(t *testing.T) {
-	taskA := &Task{
-		Weight: 10,
-		Fn: func() {
-			time.Sleep(time.Second)
-		},
-		Name: "TaskA",
+func TestFlushStatsOnStop(t *testing.T) {
+	reports := make(chan map[string]interface{}, 10)
+	onReport := func(data map[string]interface{}) {
+		if stats, _ := data["stats"].([]interface{}); len(stats) > 0 {
+			reports <- data
+		}
 	}
-	tasks := []*Task{taskA}
-	runner := newLocalRunner(tasks, nil, 2, "asap", 2)
+	Events.Subscribe("boomer:report", onReport)
+	defer Events.Unsubscribe("boomer:report", onReport)
+
+	runner := newLocalRunner(nil, nil, 0, "asap", 0)
+	runner.clearOutputs()
 	go runner.run()
-	time.Sleep(4 * time.Second)
+	for i := 0; i < 100 && runner.getState() != stateRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	runner.recordSuccess("http", "foo", 10, 100)
+	runner.recordFailure("http", "foo", 20, "timeout", "")
 	runner.close()
-}
 
-func TestSpawnWorkers(t *testing.T) {
-	taskA := &Task{
-		Weight: 10,
-		Fn: func() {
-			time.Sleep(time.Second)
-		},
-		Name: "TaskA",
+	select {
+	case data := <-reports:
+		stat := data["stats"].([]interface{})[0].(map[string]interface{})
+		if stat["name"] != "foo" || stat["num_requests"] != int64(1) || stat["num_failures"] != int64(1) {
+			t.Error("The final report should contain the requests recorded before stop, got", stat)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The stats should be reported on stop without waiting for the next tick")
 	}
-	taskB := &Task{
-		Weight: 20,
-		Fn: func() {
-			time.Sleep(2 * time.Second)
-		},
-		Name: "TaskB",
+
+	// a slave sends the final stats to master, even if it's stopped
+	slave := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	c := newFakeClient()
+	slave.newClient = func(masterHost string, masterPort int, identity string) client {
+		return c
 	}
-	tasks := []*Task{taskA, taskB}
-	rateLimiter := NewStableRateLimiter(100, time.Second)
-	runner := newSlaveRunner("localhost", 5557, tasks, rateLimiter, "asap")
-	defer runner.close()
+	slave.run()
+	defer slave.close()
+	defer Events.Unsubscribe("boomer:quit", slave.onQuiting)
+	<-c.toMaster // client_ready
 
-	runner.client = newClient("localhost", 5557, runner.nodeID)
-	runner.hatchRate = 10
+	slave.stopChan = make(chan bool)
+	slave.setState(stateRunning)
+	slave.recordSuccess("http", "bar", 10, 100)
+	slave.stop()
+	slave.setState(stateStopped)
 
-	runner.spawnWorkers(10, runner.stopChan, runner.hatchComplete)
-	if runner.numClients != 10 {
-		t.Error("Number of goroutines mismatches, expected: 10, current count", runner.numClients)
+	for {
+		select {
+		case msg := <-c.toMaster:
+			if msg.Type != "stats" {
+				continue
+			}
+			stats := msg.Data["stats"].([]interface{})
+			if len(stats) != 1 || stats[0].(map[string]interface{})["name"] != "bar" {
+				t.Error("The final stats sent to master should contain the requests recorded before stop, got", stats)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("The stats should be sent to master on stop without waiting for the next tick")
+		}
 	}
 }
 
-func TestSpawnWorkersSmoothly(t *testing.T) {
-	taskA := &Task{
-		Weight: 10,
-		Fn: func() {
-			time.Sleep(time.Second)
-		},
-		Name: "TaskA",
+func TestWarmup(t *testing.T) {
+	reports := make(chan map[string]interface{}, 10)
+	onReport := func(data map[string]interface{}) {
+		// the runners closed by other tests may report nothing in the meantime
+		if stats, ok := data["stats"].([]interface{}); !ok || len(stats) > 0 {
+			reports <- data
+		}
 	}
-	tasks := []*Task{taskA}
+	Events.Subscribe("boomer:report", onReport)
+	defer Events.Unsubscribe("boomer:report", onReport)
 
-	runner := newSlaveRunner("localhost", 5557, tasks, nil, "smooth")
+	runner := newLocalRunner(nil, nil, 0, "asap", 0)
+	runner.clearOutputs()
+	runner.warmup = 300 * time.Millisecond
+	go runner.run()
 	defer runner.close()
+	for i := 0; i < 100 && runner.getState() != stateRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
 
-	runner.client = newClient("localhost", 5557, runner.nodeID)
-	runner.hatchRate = 10
+	runner.recordSuccess("http", "during", 10, 100)
+	runner.recordFailure("http", "during", 10, "timeout", "")
+	time.Sleep(400 * time.Millisecond)
+	runner.recordSuccess("http", "after", 10, 100)
 
-	go runner.spawnWorkers(10, runner.stopChan, runner.hatchComplete)
-	time.Sleep(2 * time.Millisecond)
+	select {
+	case data := <-reports:
+		stats := data["stats"].([]interface{})
+		if len(stats) != 1 {
+			t.Fatal("The report should contain the stats of after only, got", stats)
+		}
+		if stat := stats[0].(map[string]interface{}); stat["name"] != "after" || stat["num_requests"] != int64(1) {
+			t.Error("The requests during warmup should be excluded, got", stat)
+		}
+		if errors := data["errors"].(map[string]map[string]interface{}); len(errors) != 0 {
+			t.Error("The failures during warmup should be excluded, got", errors)
+		}
+	case <-time.After(slaveReportInterval + time.Second):
+		t.Fatal("No report is published")
+	}
+}
 
-	currentClients := atomic.LoadInt32(&runner.numClients)
-	if currentClients > 3 {
-		t.Error("Spawning goroutines too fast, current count", currentClients)
+func TestClientReadyWithoutMetadata(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	if data := runner.clientReadyData(); data != nil {
+		t.Error("The client_ready message should carry nothing without metadata, got", data)
 	}
 }
 
-func TestHatchAndStop(t *testing.T) {
+func TestPauseAndResume(t *testing.T) {
+	count := int64(0)
 	taskA := &Task{
 		Fn: func() {
-			time.Sleep(time.Second)
+			atomic.AddInt64(&count, 1)
+			time.Sleep(time.Millisecond)
 		},
 	}
-	taskB := &Task{
-		Fn: func() {
-			time.Sleep(2 * time.Second)
-		},
+	runner := newLocalRunner([]*Task{taskA}, nil, 10, "asap", 10)
+	defer runner.stats.close()
+	runner.stats.start()
+	runner.stopTimeout = time.Second
+
+	if runner.pause() {
+		t.Error("The runner should not be paused before hatching")
 	}
-	tasks := []*Task{taskA, taskB}
-	runner := newSlaveRunner("localhost", 5557, tasks, nil, "asap")
-	defer runner.close()
-	runner.client = newClient("localhost", 5557, runner.nodeID)
 
-	go func() {
-		var ticker = time.NewTicker(time.Second)
-		for {
-			select {
-			case <-ticker.C:
-				t.Error("Timeout waiting for message sent by startHatching()")
-				return
-			case <-runner.stats.clearStatsChan:
-				// just quit
-				return
-			}
-		}
-	}()
+	runner.setState(stateRunning)
+	runner.startHatching(10, 10, nil)
+	time.Sleep(50 * time.Millisecond)
 
-	runner.startHatching(10, 10, runner.hatchComplete)
-	// wait for spawning goroutines
+	if !runner.pause() {
+		t.Fatal("The running runner should be paused")
+	}
+	if runner.getState() != statePaused {
+		t.Error("The state should be paused, got", runner.getState())
+	}
+	// wait for the running tasks
+	time.Sleep(10 * time.Millisecond)
+	paused := atomic.LoadInt64(&count)
 	time.Sleep(100 * time.Millisecond)
-	if runner.numClients != 10 {
-		t.Error("Number of goroutines mismatches, expected: 10, current count", runner.numClients)
+	if executions := atomic.LoadInt64(&count) - paused; executions != 0 {
+		t.Error("No task should be executed while paused, was:", executions)
 	}
-
-	msg := <-runner.client.sendChannel()
-	if msg.Type != "hatch_complete" {
-		t.Error("Runner should send hatch_complete message when hatching completed, got", msg.Type)
+	if running := atomic.LoadInt32(&runner.runningWorkers); running != 10 {
+		t.Error("The workers should remain alive while paused, expected: 10, was:", running)
 	}
-	runner.stop()
 
-	runner.onQuiting()
-	msg = <-runner.client.sendChannel()
-	if msg.Type != "quit" {
-		t.Error("Runner should send quit message on quitting, got", msg.Type)
+	if !runner.resume() {
+		t.Fatal("The paused runner should be resumed")
 	}
-}
-
-func TestStop(t *testing.T) {
-	taskA := &Task{
-		Fn: func() {
-			time.Sleep(time.Second)
-		},
+	if runner.getState() != stateRunning {
+		t.Error("The state should be restored after resume, got", runner.getState())
 	}
-	tasks := []*Task{taskA}
-	runner := newSlaveRunner("localhost", 5557, tasks, nil, "asap")
-	runner.stopChan = make(chan bool)
-
-	stopped := false
-	handler := func() {
-		stopped = true
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt64(&count) == paused {
+		t.Error("Tasks should be executed again after resume")
+	}
+	if runner.resume() {
+		t.Error("The runner is not paused, resume should return false")
 	}
-	Events.Subscribe("boomer:stop", handler)
-	defer Events.Unsubscribe("boomer:stop", handler)
 
+	// stop while paused
+	runner.pause()
 	runner.stop()
-
-	if stopped != true {
-		t.Error("Expected stopped to be true, was", stopped)
+	if running := atomic.LoadInt32(&runner.runningWorkers); running != 0 {
+		t.Error("The paused workers should return on stop, still running:", running)
 	}
 }
 
@@ -225,10 +3081,10 @@ func TestOnHatchMessage(t *testing.T) {
 			time.Sleep(time.Second)
 		},
 	}
-	runner := newSlaveRunner("localhost", 5557, []*Task{taskA}, nil, "asap")
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, []*Task{taskA}, nil, "asap")
 	defer runner.close()
 	runner.client = newClient("localhost", 5557, runner.nodeID)
-	runner.state = stateInit
+	runner.setState(stateInit)
 
 	workers, hatchRate := 0, 0
 	callback := func(param1, param2 int) {
@@ -263,11 +3119,79 @@ func TestOnHatchMessage(t *testing.T) {
 	runner.onMessage(newMessage("stop", nil, runner.nodeID))
 }
 
+func TestOnMalformedHatchMessage(t *testing.T) {
+	taskA := &Task{
+		Fn: func() {
+			time.Sleep(time.Millisecond)
+		},
+	}
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, []*Task{taskA}, nil, "asap")
+	defer runner.close()
+	runner.stats.start()
+	runner.client = newFakeClient()
+	runner.setState(stateInit)
+
+	hatched := 0
+	callback := func(workers, hatchRate int) {
+		hatched++
+	}
+	Events.Subscribe("boomer:hatch", callback)
+	defer Events.Unsubscribe("boomer:hatch", callback)
+
+	malformed := []map[string]interface{}{
+		{"hatch_rate": "20", "num_clients": int64(10)},
+		{"hatch_rate": float64(20)},
+		{"hatch_rate": float64(20), "num_clients": int64(0)},
+		nil,
+	}
+	for _, data := range malformed {
+		runner.onMessage(newMessage("hatch", data, runner.nodeID))
+		if state := runner.getState(); state != stateInit {
+			t.Errorf("The runner should stay in %s after a malformed hatch message %v, got %s", stateInit, data, state)
+		}
+	}
+
+	// a running slave keeps its goroutines
+	runner.onMessage(newMessage("hatch", map[string]interface{}{
+		"hatch_rate":  int64(10),
+		"num_clients": uint64(10),
+	}, runner.nodeID))
+	time.Sleep(20 * time.Millisecond)
+	for _, data := range malformed {
+		runner.onMessage(newMessage("hatch", data, runner.nodeID))
+	}
+	if state := runner.getState(); state != stateRunning {
+		t.Error("The runner should keep running after malformed hatch messages, got", state)
+	}
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 10 {
+		t.Error("The goroutines should be kept after malformed hatch messages, got", numClients)
+	}
+	if hatched != 1 {
+		t.Error("Only the valid hatch message should be handled, got", hatched)
+	}
+
+	runner.onMessage(newMessage("stop", nil, runner.nodeID))
+}
+
+func TestParseHatchMessage(t *testing.T) {
+	for _, data := range []map[string]interface{}{
+		{"hatch_rate": float64(5), "num_clients": int64(10)},
+		{"hatch_rate": int64(5), "num_clients": uint64(10)},
+		{"hatch_rate": int8(5), "num_clients": uint16(10)},
+		{"hatch_rate": float32(5.5), "num_clients": float64(10)},
+	} {
+		workers, hatchRate, err := parseHatchMessage(newMessage("hatch", data, "test"))
+		if err != nil || workers != 10 || hatchRate != 5 {
+			t.Errorf("Failed to parse %v, got %d, %d, %v", data, workers, hatchRate, err)
+		}
+	}
+}
+
 func TestOnQuitMessage(t *testing.T) {
-	runner := newSlaveRunner("localhost", 5557, nil, nil, "asap")
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
 	defer runner.close()
 	runner.client = newClient("localhost", 5557, "test")
-	runner.state = stateInit
+	runner.setState(stateInit)
 
 	quitMessages := make(chan bool, 10)
 	receiver := func() {
@@ -286,7 +3210,7 @@ func TestOnQuitMessage(t *testing.T) {
 		break
 	}
 
-	runner.state = stateRunning
+	runner.setState(stateRunning)
 	runner.stopChan = make(chan bool)
 	runner.onMessage(newMessage("quit", nil, runner.nodeID))
 	select {
@@ -296,11 +3220,11 @@ func TestOnQuitMessage(t *testing.T) {
 		t.Error("Runner should fire boomer:quit message when it receives a quit message from the master.")
 		break
 	}
-	if runner.state != stateInit {
-		t.Error("Runner's state should be stateInit")
+	if runner.getState() != stateQuitting {
+		t.Error("Runner's state should be stateQuitting")
 	}
 
-	runner.state = stateStopped
+	runner.setState(stateStopped)
 	runner.onMessage(newMessage("quit", nil, runner.nodeID))
 	select {
 	case <-quitMessages:
@@ -309,8 +3233,207 @@ func TestOnQuitMessage(t *testing.T) {
 		t.Error("Runner should fire boomer:quit message when it receives a quit message from the master.")
 		break
 	}
-	if runner.state != stateInit {
-		t.Error("Runner's state should be stateInit")
+	if runner.getState() != stateQuitting {
+		t.Error("Runner's state should be stateQuitting")
+	}
+}
+
+func TestQuitWhileHatching(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, []*Task{{Name: "foo", Fn: func() {
+		time.Sleep(10 * time.Millisecond)
+	}}}, nil, "asap")
+	c := newFakeClient()
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		return c
+	}
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+	<-c.toMaster // client_ready
+
+	stopped := make(chan bool, 1)
+	onStop := func() {
+		stopped <- true
+	}
+	Events.Subscribe("boomer:stop", onStop)
+	defer Events.Unsubscribe("boomer:stop", onStop)
+
+	// hatching lasts 10 seconds
+	c.fromMaster <- newMessage("hatch", map[string]interface{}{
+		"hatch_rate":  float64(1),
+		"num_clients": int64(10),
+	}, runner.nodeID)
+	for i := 0; i < 100 && runner.getState() != stateHatching; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runner.getState() != stateHatching {
+		t.Fatal("Runner should be hatching, got", runner.getState())
+	}
+
+	c.fromMaster <- newMessage("quit", nil, runner.nodeID)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("The workers should be stopped when master asks the slave to quit")
+	}
+	if state := runner.getState(); state != stateQuitting {
+		t.Error("Runner's state should be stateQuitting, got", state)
+	}
+
+	// the quit message is acked once
+	var acks int
+	timeout := time.After(200 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case msg := <-c.toMaster:
+			if msg.Type == "quit" {
+				acks++
+			}
+		case <-timeout:
+			done = true
+		}
+	}
+	if acks != 1 {
+		t.Error("Runner should ack master with one quit message, got", acks)
+	}
+
+	// the listener stops receiving messages from master
+	c.fromMaster <- newMessage("hatch", map[string]interface{}{
+		"hatch_rate":  float64(1),
+		"num_clients": int64(10),
+	}, runner.nodeID)
+	time.Sleep(50 * time.Millisecond)
+	if len(c.fromMaster) != 1 || runner.getState() != stateQuitting {
+		t.Error("Messages after quit should not be handled")
+	}
+}
+
+func TestHatchCompleteAfterStop(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	c := newFakeClient()
+	runner.client = c
+
+	// master asks to stop before hatching completes
+	runner.setState(stateInit)
+	runner.hatchComplete()
+	if state := runner.getState(); state != stateInit {
+		t.Error("The state should not be changed after stopped, got", state)
+	}
+	if len(c.toMaster) != 0 {
+		t.Error("hatch_complete should not be sent after stopped")
+	}
+
+	runner.setState(stateHatching)
+	runner.hatchComplete()
+	if state := runner.getState(); state != stateRunning {
+		t.Error("The state should be running after hatching completes, got", state)
+	}
+	if msg := <-c.toMaster; msg.Type != "hatch_complete" {
+		t.Error("Runner should send hatch_complete message, got", msg.Type)
+	}
+}
+
+func TestReportedUserCount(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	c := newFakeClient()
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		return c
+	}
+	runner.userCountMultiplier = 10
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+	<-c.toMaster // client_ready
+
+	atomic.StoreInt32(&runner.numClients, 3)
+	runner.setState(stateHatching)
+	runner.hatchComplete()
+	if msg := <-c.toMaster; msg.Type != "hatch_complete" || msg.Data["count"] != int32(30) {
+		t.Error("The count of hatch_complete should be multiplied, got", msg.Type, msg.Data)
+	}
+
+	runner.stats.messageToRunnerChan <- map[string]interface{}{}
+	select {
+	case msg := <-c.toMaster:
+		if msg.Type != "stats" || msg.Data["user_count"] != int32(30) {
+			t.Error("The user_count of stats should be multiplied, got", msg.Type, msg.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The stats should be sent to master")
+	}
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 3 {
+		t.Error("numClients should stay the number of goroutines, got", numClients)
+	}
+
+	// the override takes precedence
+	runner.userCountOverride = 100
+	if count := runner.reportedUserCount(); count != 100 {
+		t.Error("The reported user count should be overridden, got", count)
+	}
+}
+
+func TestConcurrentStateTransitions(t *testing.T) {
+	// run it with -race, the state is changed by the listener and read by the reporter and heartbeat
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, []*Task{{Name: "foo", Fn: func() {
+		time.Sleep(time.Millisecond)
+	}}}, nil, "asap")
+	c := newFakeClient()
+	runner.newClient = func(masterHost string, masterPort int, identity string) client {
+		return c
+	}
+	runner.run()
+	defer runner.close()
+	defer Events.Unsubscribe("boomer:quit", runner.onQuiting)
+
+	done := make(chan bool)
+	defer close(done)
+	stats := make(chan *message, 1000)
+	go func() {
+		for {
+			select {
+			case msg := <-c.toMaster:
+				if msg.Type == "stats" {
+					stats <- msg
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			c.fromMaster <- newMessage("hatch", map[string]interface{}{
+				"hatch_rate":  float64(100),
+				"num_clients": int64(5),
+			}, runner.nodeID)
+			time.Sleep(time.Millisecond)
+			c.fromMaster <- newMessage("stop", nil, runner.nodeID)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			runner.stats.messageToRunnerChan <- map[string]interface{}{}
+			runner.getState()
+		}
+	}()
+	wg.Wait()
+
+	for i := 0; i < 100 && (runner.getState() != stateInit || len(c.fromMaster) > 0); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state := runner.getState(); state != stateInit {
+		t.Error("The runner should be stopped at last, got", state)
+	}
+	for len(stats) > 0 {
+		msg := <-stats
+		if _, ok := msg.Data["user_count"].(int32); !ok {
+			t.Error("The stats should contain the user count, got", msg.Data)
+		}
 	}
 }
 
@@ -327,10 +3450,10 @@ func TestOnMessage(t *testing.T) {
 	}
 	tasks := []*Task{taskA, taskB}
 
-	runner := newSlaveRunner("localhost", 5557, tasks, nil, "asap")
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, tasks, nil, "asap")
 	defer runner.close()
 	runner.client = newClient("localhost", 5557, runner.nodeID)
-	runner.state = stateInit
+	runner.setState(stateInit)
 
 	go func() {
 		// consumes clearStatsChannel
@@ -360,11 +3483,11 @@ func TestOnMessage(t *testing.T) {
 
 	// hatch complete and running
 	time.Sleep(100 * time.Millisecond)
-	if runner.state != stateRunning {
-		t.Error("State of runner is not running after hatch, got", runner.state)
+	if runner.getState() != stateRunning {
+		t.Error("State of runner is not running after hatch, got", runner.getState())
 	}
-	if runner.numClients != 10 {
-		t.Error("Number of goroutines mismatches, expected: 10, current count:", runner.numClients)
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 10 {
+		t.Error("Number of goroutines mismatches, expected: 10, current count:", numClients)
 	}
 	msg = <-runner.client.sendChannel()
 	if msg.Type != "hatch_complete" {
@@ -383,11 +3506,11 @@ func TestOnMessage(t *testing.T) {
 	}
 
 	time.Sleep(100 * time.Millisecond)
-	if runner.state != stateRunning {
-		t.Error("State of runner is not running after hatch, got", runner.state)
+	if runner.getState() != stateRunning {
+		t.Error("State of runner is not running after hatch, got", runner.getState())
 	}
-	if runner.numClients != 20 {
-		t.Error("Number of goroutines mismatches, expected: 20, current count:", runner.numClients)
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 20 {
+		t.Error("Number of goroutines mismatches, expected: 20, current count:", numClients)
 	}
 	msg = <-runner.client.sendChannel()
 	if msg.Type != "hatch_complete" {
@@ -396,8 +3519,8 @@ func TestOnMessage(t *testing.T) {
 
 	// stop all the workers
 	runner.onMessage(newMessage("stop", nil, runner.nodeID))
-	if runner.state != stateInit {
-		t.Error("State of runner is not init, got", runner.state)
+	if runner.getState() != stateInit {
+		t.Error("State of runner is not init, got", runner.getState())
 	}
 	msg = <-runner.client.sendChannel()
 	if msg.Type != "client_stopped" {
@@ -421,11 +3544,11 @@ func TestOnMessage(t *testing.T) {
 
 	// hatch complete and running
 	time.Sleep(100 * time.Millisecond)
-	if runner.state != stateRunning {
-		t.Error("State of runner is not running after hatch, got", runner.state)
+	if runner.getState() != stateRunning {
+		t.Error("State of runner is not running after hatch, got", runner.getState())
 	}
-	if runner.numClients != 10 {
-		t.Error("Number of goroutines mismatches, expected: 10, current count:", runner.numClients)
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 10 {
+		t.Error("Number of goroutines mismatches, expected: 10, current count:", numClients)
 	}
 	msg = <-runner.client.sendChannel()
 	if msg.Type != "hatch_complete" {
@@ -434,19 +3557,53 @@ func TestOnMessage(t *testing.T) {
 
 	// stop all the workers
 	runner.onMessage(newMessage("stop", nil, runner.nodeID))
-	if runner.state != stateInit {
-		t.Error("State of runner is not init, got", runner.state)
+	if runner.getState() != stateInit {
+		t.Error("State of runner is not init, got", runner.getState())
 	}
 	msg = <-runner.client.sendChannel()
 	if msg.Type != "client_stopped" {
 		t.Error("Runner should send client_stopped message, got", msg.Type)
 	}
+	if reason := msg.Data["reason"]; reason != StopReasonMaster {
+		t.Error("The client_stopped message should carry the master reason, got", reason)
+	}
 	msg = <-runner.client.sendChannel()
 	if msg.Type != "client_ready" {
 		t.Error("Runner should send client_ready message, got", msg.Type)
 	}
 }
 
+func TestOnStatsResetMessage(t *testing.T) {
+	runner := newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	defer runner.close()
+	runner.client = newClient("localhost", 5557, runner.nodeID)
+	runner.setState(stateRunning)
+	runner.stats.start()
+
+	runner.stats.requestSuccessChan <- &requestSuccess{
+		requestType:    "http",
+		name:           "foo",
+		responseTime:   10,
+		responseLength: 100,
+	}
+	// wait for the stats goroutine to log the request
+	time.Sleep(10 * time.Millisecond)
+	runner.onMessage(newMessage("stats_reset", nil, runner.nodeID))
+
+	if runner.getState() != stateRunning {
+		t.Error("stats_reset should not change the state of runner, got", runner.getState())
+	}
+
+	data := <-runner.stats.messageToRunnerChan
+	total := data["stats_total"].(map[string]interface{})
+	if total["num_requests"].(int64) != 0 {
+		t.Error("Stats should start from zero after stats_reset, got num_requests:", total["num_requests"].(int64))
+	}
+	if len(data["stats"].([]interface{})) != 0 {
+		t.Error("Stats should start from zero after stats_reset, got", data["stats"])
+	}
+}
+
 func TestGetReady(t *testing.T) {
 	masterHost := "127.0.0.1"
 	masterPort := 6557
@@ -456,7 +3613,7 @@ func TestGetReady(t *testing.T) {
 	server.start()
 
 	rateLimiter := NewStableRateLimiter(100, time.Second)
-	r := newSlaveRunner(masterHost, masterPort, nil, rateLimiter, "asap")
+	r := newSlaveRunner([]masterAddr{{masterHost, masterPort}}, nil, rateLimiter, "asap")
 	defer r.close()
 	defer Events.Unsubscribe("boomer:quit", r.onQuiting)
 
@@ -469,7 +3626,7 @@ func TestGetReady(t *testing.T) {
 
 	r.numClients = 10
 	// it's not really running
-	r.state = stateRunning
+	r.setState(stateRunning)
 	data := make(map[string]interface{})
 	r.stats.messageToRunnerChan <- data
 
@@ -0,0 +1,72 @@
+package boomer
+
+import (
+	"fmt"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// ScheduleWindow is a time window of every day, in which the tasks are weighted by Weights.
+type ScheduleWindow struct {
+	// Start and End are the time of day in local time, as the duration since midnight, like 9 * time.Hour
+	// for 09:00. The window includes Start but not End, it spans midnight if End is before Start.
+	Start time.Duration
+	End   time.Duration
+	// Weights overrides the weights of the tasks by name in the window, the others keep their own weights.
+	Weights map[string]float64
+}
+
+// WeightSchedule swaps the weights of the tasks by the time of day, for a soak test with different
+// mixes of tasks at different times. The first window containing the current time of day applies,
+// the tasks keep their own weights if there is none. It's re-evaluated every second while running,
+// so the running goroutines switch to the new mix without hatching again.
+type WeightSchedule []ScheduleWindow
+
+func (s WeightSchedule) validate() error {
+	for i, window := range s {
+		if window.Start < 0 || window.Start >= day || window.End < 0 || window.End >= day {
+			return fmt.Errorf("the window %d of the schedule should start and end within a day, was %v - %v", i, window.Start, window.End)
+		}
+		if window.Start == window.End {
+			return fmt.Errorf("the window %d of the schedule is empty", i)
+		}
+		for name, weight := range window.Weights {
+			if weight < 0 {
+				return fmt.Errorf("the weight of task %s in the window %d of the schedule is negative", name, i)
+			}
+		}
+	}
+	return nil
+}
+
+// contains returns true if the time of day of t is in the window.
+func (w *ScheduleWindow) contains(t time.Time) bool {
+	hour, minute, sec := t.Clock()
+	timeOfDay := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(sec)*time.Second + time.Duration(t.Nanosecond())
+	if w.Start < w.End {
+		return timeOfDay >= w.Start && timeOfDay < w.End
+	}
+	return timeOfDay >= w.Start || timeOfDay < w.End
+}
+
+// weightsAt returns the weights of the first window containing t, nil if there is none.
+func (s WeightSchedule) weightsAt(t time.Time) map[string]float64 {
+	for i := range s {
+		if s[i].contains(t) {
+			return s[i].Weights
+		}
+	}
+	return nil
+}
+
+// isScheduled returns true if the task is weighted by any of the windows.
+func (s WeightSchedule) isScheduled(task *Task) bool {
+	for _, window := range s {
+		if _, ok := window.Weights[task.Name]; ok {
+			return true
+		}
+	}
+	return false
+}
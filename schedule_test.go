@@ -0,0 +1,97 @@
+package boomer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWeightSchedule(t *testing.T) {
+	a := &Task{Name: "a", Weight: 1, Fn: func() {}}
+	b := &Task{Name: "b", Weight: 1, Fn: func() {}}
+	r := newLocalRunner([]*Task{a, b}, nil, 10, "asap", 10)
+	r.weightSchedule = WeightSchedule{
+		{Start: 10 * time.Hour, End: 11 * time.Hour, Weights: map[string]float64{"a": 0, "b": 3}},
+		// spans midnight
+		{Start: 23 * time.Hour, End: time.Hour, Weights: map[string]float64{"a": 2}},
+	}
+	var now atomic.Value
+	setNow := func(hour, min, sec, nsec int) {
+		now.Store(time.Date(2020, 1, 1, hour, min, sec, nsec, time.Local))
+	}
+	r.clock = func() time.Time {
+		return now.Load().(time.Time)
+	}
+
+	for _, c := range []struct {
+		hour, min, sec, nsec int
+		expected             []float64
+	}{
+		{9, 59, 59, 999999999, []float64{1, 2}},
+		{10, 0, 0, 0, []float64{0, 3}},
+		{10, 59, 59, 999999999, []float64{0, 3}},
+		{11, 0, 0, 0, []float64{1, 2}},
+		{23, 30, 0, 0, []float64{2, 3}},
+		{0, 30, 0, 0, []float64{2, 3}},
+		{1, 0, 0, 0, []float64{1, 2}},
+	} {
+		setNow(c.hour, c.min, c.sec, c.nsec)
+		r.updateActiveWeights()
+		weights := r.getActiveWeights()
+		if weights[0] != c.expected[0] || weights[1] != c.expected[1] {
+			t.Errorf("The weights at %02d:%02d:%02d.%09d should be %v, got %v", c.hour, c.min, c.sec, c.nsec, c.expected, weights)
+		}
+	}
+
+	// the running workers switch to the new weights at the window boundary
+	r.weightRefreshInterval = 10 * time.Millisecond
+	setNow(9, 59, 59, 0)
+	r.updateActiveWeights()
+	quit := make(chan bool)
+	defer close(quit)
+	go r.refreshWeights(quit)
+
+	setNow(10, 0, 0, 0)
+	for i := 0; i < 100 && r.getActiveWeights()[0] != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if weights := r.getActiveWeights(); weights[0] != 0 || weights[1] != 3 {
+		t.Error("The weights should be refreshed to the window, got", weights)
+	}
+}
+
+func TestScheduledTaskHooks(t *testing.T) {
+	started := false
+	a := &Task{Name: "a", Weight: 1, Fn: func() {}}
+	// disabled by its own weight, but enabled by the schedule
+	b := &Task{Name: "b", Fn: func() {}, OnStart: func() error {
+		started = true
+		return nil
+	}}
+	r := newLocalRunner([]*Task{a, b}, nil, 10, "asap", 10)
+	if !r.startUser() || started {
+		t.Fatal("OnStart of the task with zero weight should not be called")
+	}
+
+	r.weightSchedule = WeightSchedule{{Start: 0, End: time.Hour, Weights: map[string]float64{"b": 1}}}
+	if !r.startUser() || !started {
+		t.Error("OnStart of the scheduled task should be called")
+	}
+}
+
+func TestValidateWeightSchedule(t *testing.T) {
+	for _, schedule := range []WeightSchedule{
+		{{Start: -time.Hour, End: time.Hour}},
+		{{Start: time.Hour, End: 24 * time.Hour}},
+		{{Start: time.Hour, End: time.Hour}},
+		{{Start: 0, End: time.Hour, Weights: map[string]float64{"a": -1}}},
+	} {
+		if schedule.validate() == nil {
+			t.Error("The schedule should be invalid", schedule)
+		}
+	}
+	schedule := WeightSchedule{{Start: 23 * time.Hour, End: time.Hour, Weights: map[string]float64{"a": 0}}}
+	if err := schedule.validate(); err != nil {
+		t.Error("The schedule should be valid, got", err)
+	}
+}
@@ -0,0 +1,56 @@
+package boomer
+
+import (
+	"context"
+	"sync"
+)
+
+// sharedKey is the key of the shared resources in the context passed to Task.FnWithContext.
+type sharedKey struct{}
+
+// SharedFromContext returns the shared resources set up by the setup function of Boomer.SetHatchSetupFunc
+// for current hatch, or nil if there is none. The ctx should be the one passed to Task.FnWithContext.
+func SharedFromContext(ctx context.Context) interface{} {
+	return ctx.Value(sharedKey{})
+}
+
+// sharedResources is the result of the hatch setup function.
+type sharedResources struct {
+	lock   sync.RWMutex
+	shared interface{}
+}
+
+func (s *sharedResources) get() interface{} {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.shared
+}
+
+func (s *sharedResources) set(shared interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.shared = shared
+}
+
+// setupShared calls hatchSetupFunc once before the workers of a hatch are spawned, and returns ctx with
+// the shared resources for the workers. hatchTeardownFunc is called after all the workers have returned.
+// It returns false if the setup fails.
+func (r *runner) setupShared(ctx context.Context, wg *sync.WaitGroup, quit chan bool) (context.Context, bool) {
+	if r.hatchSetupFunc == nil {
+		return ctx, true
+	}
+	shared, err := r.hatchSetupFunc()
+	if err != nil {
+		logger.Errorf("Failed to set up the shared resources, no clients are spawned, %v", err)
+		return ctx, false
+	}
+	r.shared.set(shared)
+	if r.hatchTeardownFunc != nil {
+		go func() {
+			<-quit
+			wg.Wait()
+			r.hatchTeardownFunc(shared)
+		}()
+	}
+	return context.WithValue(ctx, sharedKey{}, shared), true
+}
@@ -0,0 +1,94 @@
+package boomer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHatchSetupFunc(t *testing.T) {
+	var setups, wrongShared int32
+	teardowns := make(chan interface{}, 10)
+	task := &Task{
+		Name: "foo",
+		FnWithContext: func(ctx context.Context) {
+			if _, ok := SharedFromContext(ctx).(*http.Client); !ok {
+				atomic.AddInt32(&wrongShared, 1)
+			}
+			time.Sleep(10 * time.Millisecond)
+		},
+	}
+	runner := newLocalRunner([]*Task{task}, nil, 10, "asap", 0)
+	// the workers block on recording the executions without the stats goroutine
+	runner.stats.start()
+	defer runner.stats.close()
+	runner.hatchSetupFunc = func() (interface{}, error) {
+		atomic.AddInt32(&setups, 1)
+		return &http.Client{}, nil
+	}
+	runner.hatchTeardownFunc = func(shared interface{}) {
+		teardowns <- shared
+	}
+
+	for hatch := int32(1); hatch <= 2; hatch++ {
+		quit := make(chan bool)
+		runner.workersWaitGroup = &sync.WaitGroup{}
+		runner.spawnWorkers(10, quit, nil)
+		if n := atomic.LoadInt32(&setups); n != hatch {
+			t.Fatalf("The setup should be called once per hatch, expected: %d, was: %d", hatch, n)
+		}
+		shared := runner.shared.get()
+		if _, ok := shared.(*http.Client); !ok {
+			t.Fatal("The shared resources should be kept by the runner, got", shared)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		select {
+		case <-teardowns:
+			t.Fatal("The teardown should not be called before the workers return")
+		default:
+		}
+		close(quit)
+		select {
+		case torndown := <-teardowns:
+			if torndown != shared {
+				t.Error("The teardown should be called with the shared resources of the hatch")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("The teardown should be called after the workers return")
+		}
+		atomic.StoreInt32(&runner.numClients, 0)
+	}
+	if n := atomic.LoadInt32(&wrongShared); n != 0 {
+		t.Error("The tasks should get the shared resources from the context, failed", n, "times")
+	}
+}
+
+func TestHatchSetupFuncFails(t *testing.T) {
+	runner := newLocalRunner([]*Task{{Name: "foo", Fn: func() {}}}, nil, 10, "asap", 0)
+	runner.hatchSetupFunc = func() (interface{}, error) {
+		return nil, errors.New("no connection")
+	}
+	completed := false
+	quit := make(chan bool)
+	defer close(quit)
+	runner.spawnWorkers(10, quit, func() {
+		completed = true
+	})
+	if numClients := atomic.LoadInt32(&runner.numClients); numClients != 0 {
+		t.Error("No clients should be spawned if the setup fails, got", numClients)
+	}
+	if completed {
+		t.Error("The hatch should not complete if the setup fails")
+	}
+}
+
+func TestSharedFromContextWithoutSetup(t *testing.T) {
+	if shared := SharedFromContext(context.Background()); shared != nil {
+		t.Error("There should be no shared resources without setup, got", shared)
+	}
+}
@@ -0,0 +1,82 @@
+package boomer
+
+import (
+	"sync/atomic"
+)
+
+// The metrics checked by SLA.
+const (
+	SLAMetricP95ResponseTime = "p95_response_time"
+	SLAMetricErrorRate       = "error_rate"
+)
+
+// SLA is checked against the requests of every report, which is sent every 3 seconds,
+// a "boomer:sla_violation" event is published with a SLAViolation for each breached threshold.
+type SLA struct {
+	// MaxP95ResponseTime is the max 95th percentile response time in milliseconds, 0 means no limit.
+	MaxP95ResponseTime int64
+	// MaxErrorRate is the max percentage of failures in all the requests, like 1 for 1%, 0 means no limit.
+	MaxErrorRate float64
+	// FailOnViolation makes Boomer.ExitCode return 1 once the SLA is violated, so the process
+	// can exit with it, to fail a CI pipeline for example.
+	FailOnViolation bool
+}
+
+// SLAViolation is published with the "boomer:sla_violation" event.
+type SLAViolation struct {
+	// Metric is SLAMetricP95ResponseTime or SLAMetricErrorRate.
+	Metric    string
+	Threshold float64
+	Actual    float64
+}
+
+// check returns the violations of the SLA by the stats_total of a report,
+// reports without any requests never violate it.
+func (sla *SLA) check(data map[string]interface{}) (violations []SLAViolation) {
+	statsTotal, ok := data["stats_total"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	numRequests, _ := statsTotal["num_requests"].(int64)
+	numFailures, _ := statsTotal["num_failures"].(int64)
+	if numRequests+numFailures == 0 {
+		return nil
+	}
+
+	if sla.MaxP95ResponseTime > 0 {
+		p95, _ := statsTotal["current_response_time_percentile_95"].(int64)
+		if p95 > sla.MaxP95ResponseTime {
+			violations = append(violations, SLAViolation{
+				Metric:    SLAMetricP95ResponseTime,
+				Threshold: float64(sla.MaxP95ResponseTime),
+				Actual:    float64(p95),
+			})
+		}
+	}
+	if sla.MaxErrorRate > 0 {
+		errorRate := float64(numFailures) * 100 / float64(numRequests+numFailures)
+		if errorRate > sla.MaxErrorRate {
+			violations = append(violations, SLAViolation{
+				Metric:    SLAMetricErrorRate,
+				Threshold: sla.MaxErrorRate,
+				Actual:    errorRate,
+			})
+		}
+	}
+	return violations
+}
+
+// checkSLA publishes the violations of the SLA by a report, it's a no-op if there is no SLA.
+func (r *runner) checkSLA(data map[string]interface{}) {
+	if r.sla == nil {
+		return
+	}
+	violations := r.sla.check(data)
+	for _, violation := range violations {
+		logger.Errorf("SLA is violated, %s is %.2f, exceeding %.2f", violation.Metric, violation.Actual, violation.Threshold)
+		Events.Publish("boomer:sla_violation", violation)
+	}
+	if len(violations) > 0 && r.sla.FailOnViolation {
+		atomic.StoreInt32(&r.slaViolated, 1)
+	}
+}
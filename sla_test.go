@@ -0,0 +1,110 @@
+package boomer
+
+import (
+	"testing"
+	"time"
+)
+
+func newSLAReport(numRequests, numFailures, p95 int64) map[string]interface{} {
+	return map[string]interface{}{
+		"stats_total": map[string]interface{}{
+			"num_requests":                        numRequests,
+			"num_failures":                        numFailures,
+			"current_response_time_percentile_95": p95,
+		},
+	}
+}
+
+func TestSLAViolation(t *testing.T) {
+	var violations []SLAViolation
+	onViolation := func(violation SLAViolation) {
+		violations = append(violations, violation)
+	}
+	Events.Subscribe("boomer:sla_violation", onViolation)
+	defer Events.Unsubscribe("boomer:sla_violation", onViolation)
+
+	r := &runner{sla: &SLA{MaxP95ResponseTime: 100, MaxErrorRate: 5}}
+
+	// 4 failures in 100 requests
+	r.checkSLA(newSLAReport(96, 4, 100))
+	if len(violations) != 0 {
+		t.Error("The SLA should not be violated, got", violations)
+	}
+
+	// 10 failures in 100 requests
+	r.checkSLA(newSLAReport(90, 10, 150))
+	if len(violations) != 2 {
+		t.Fatal("Both of the thresholds should be breached, got", violations)
+	}
+	if v := violations[0]; v.Metric != SLAMetricP95ResponseTime || v.Threshold != 100 || v.Actual != 150 {
+		t.Error("Unexpected violation of p95 response time", v)
+	}
+	if v := violations[1]; v.Metric != SLAMetricErrorRate || v.Threshold != 5 || v.Actual != 10 {
+		t.Error("Unexpected violation of error rate", v)
+	}
+	if r.slaViolated != 0 {
+		t.Error("The violation should not fail the process without FailOnViolation")
+	}
+
+	// reports without requests are skipped
+	violations = nil
+	r.checkSLA(newSLAReport(0, 0, 0))
+	r.checkSLA(map[string]interface{}{})
+	if len(violations) != 0 {
+		t.Error("Reports without requests should not violate the SLA, got", violations)
+	}
+
+	// no limit
+	r.sla = &SLA{}
+	r.checkSLA(newSLAReport(0, 100, 10000))
+	r.sla = nil
+	r.checkSLA(newSLAReport(0, 100, 10000))
+	if len(violations) != 0 {
+		t.Error("The SLA without thresholds should never be violated, got", violations)
+	}
+}
+
+func TestSLAFailOnViolation(t *testing.T) {
+	b := NewStandaloneBoomer(10, 10)
+	b.SetSLA(&SLA{MaxErrorRate: 1, FailOnViolation: true})
+	if b.ExitCode() != 0 {
+		t.Error("The exit code should be 0 before the test is started")
+	}
+
+	b.localRunner = newLocalRunner(nil, nil, 10, "asap", 10)
+	b.localRunner.sla = b.sla
+	b.localRunner.checkSLA(newSLAReport(100, 0, 10))
+	if b.ExitCode() != 0 {
+		t.Error("The exit code should be 0 if the SLA is not violated")
+	}
+
+	b.localRunner.checkSLA(newSLAReport(90, 10, 10))
+	b.localRunner.checkSLA(newSLAReport(100, 0, 10))
+	if b.ExitCode() != 1 {
+		t.Error("The exit code should be 1 once the SLA is violated")
+	}
+}
+
+func TestSLAFailOnViolationInDistributedMode(t *testing.T) {
+	b := NewBoomer("localhost", 5557)
+	b.SetSLA(&SLA{MaxErrorRate: 1, FailOnViolation: true})
+
+	b.slaveRunner = newSlaveRunner([]masterAddr{{"localhost", 5557}}, nil, nil, "asap")
+	b.slaveRunner.sla = b.sla
+	b.slaveRunner.newClient = func(masterHost string, masterPort int, identity string) client {
+		return newFakeClient()
+	}
+	b.slaveRunner.run()
+	defer b.slaveRunner.close()
+	defer Events.Unsubscribe("boomer:quit", b.slaveRunner.onQuiting)
+	b.slaveRunner.setState(stateRunning)
+
+	// every report to master is checked
+	b.slaveRunner.stats.messageToRunnerChan <- newSLAReport(90, 10, 10)
+	for i := 0; i < 100 && b.ExitCode() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.ExitCode() != 1 {
+		t.Error("The exit code should be 1 once the SLA is violated in distributed mode")
+	}
+}
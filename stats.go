@@ -1,6 +1,9 @@
 package boomer
 
 import (
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -9,6 +12,7 @@ type requestSuccess struct {
 	name           string
 	responseTime   int64
 	responseLength int64
+	labels         map[string]string
 }
 
 type requestFailure struct {
@@ -16,32 +20,124 @@ type requestFailure struct {
 	name         string
 	responseTime int64
 	error        string
+	category     string
+	labels       map[string]string
+}
+
+// taskExecution is recorded by the runner each time a Task.Fn returns.
+type taskExecution struct {
+	name         string
+	responseTime int64
+	failed       bool
+	// slow is true if the execution exceeds Task.LatencyBudget.
+	slow bool
+}
+
+// unnamedTask is the bucket of the tasks without a name.
+const unnamedTask = "(unnamed)"
+
+// StatsSnapshot is a copy of the aggregated stats of all the requests, since the test starts
+// or the stats are reset by master. The response times are in milliseconds.
+type StatsSnapshot struct {
+	NumRequests int64
+	NumFailures int64
+	// NumConnectionErrors is the number of failures failing to connect, i.e. the connection is refused
+	// or the DNS lookup fails, which are counted in NumFailures too.
+	NumConnectionErrors int64
+	TotalContentLength  int64
+	// CurrentRPS is the requests per second of the current report interval.
+	CurrentRPS               int64
+	AvgResponseTime          float64
+	MinResponseTime          int64
+	MaxResponseTime          int64
+	MedianResponseTime       int64
+	ResponseTimePercentile95 int64
+	ResponseTimePercentile99 int64
+}
+
+// requestKey identifies the stats of a request by both its type and name like locust does,
+// so GET /foo and POST /foo are counted separately. The requests with different labels are counted
+// separately too.
+type requestKey struct {
+	method string
+	name   string
+	labels string
+}
+
+// defaultMaxLabelSets is the default number of the unique label sets to aggregate the requests by.
+const defaultMaxLabelSets = 100
+
+// labelsKey returns a string identifying the label set, like "shard=1,tenant=foo", or "" if there is no labels.
+func labelsKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// copyLabels returns a copy of labels, so they can be changed by the caller once recorded.
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
 }
 
 type requestStats struct {
-	entries   map[string]*statsEntry
-	errors    map[string]*statsError
-	total     *statsEntry
-	startTime int64
+	entries     map[requestKey]*statsEntry
+	errors      map[string]*statsError
+	taskEntries map[string]*statsEntry
+	total       *statsEntry
+	// when the current hatch is started, it's reset along with the stats by every hatch.
+	startTime time.Time
+
+	// accumulated is never reset by reports, unlike total, it's used for snapshots.
+	accumulated *statsEntry
+
+	// the unique label sets of the requests since the stats are cleared, up to maxLabelSets,
+	// the labels of the other requests are dropped.
+	labelSets         map[string]bool
+	maxLabelSets      int
+	labelSetsExceeded bool
 
 	requestSuccessChan  chan *requestSuccess
 	requestFailureChan  chan *requestFailure
+	taskExecutionChan   chan *taskExecution
 	clearStatsChan      chan bool
+	snapshotChan        chan chan *StatsSnapshot
+	flushChan           chan chan bool
 	messageToRunnerChan chan map[string]interface{}
 	shutdownChan        chan bool
+	// 1 once the stats goroutine is started.
+	started int32
 }
 
 func newRequestStats() (stats *requestStats) {
-	entries := make(map[string]*statsEntry)
+	entries := make(map[requestKey]*statsEntry)
 	errors := make(map[string]*statsError)
 
 	stats = &requestStats{
-		entries: entries,
-		errors:  errors,
+		entries:     entries,
+		errors:      errors,
+		taskEntries: make(map[string]*statsEntry),
+		startTime:   time.Now(),
+		labelSets:   make(map[string]bool),
 	}
 	stats.requestSuccessChan = make(chan *requestSuccess, 100)
 	stats.requestFailureChan = make(chan *requestFailure, 100)
+	stats.taskExecutionChan = make(chan *taskExecution, 100)
 	stats.clearStatsChan = make(chan bool)
+	stats.snapshotChan = make(chan chan *StatsSnapshot)
+	stats.flushChan = make(chan chan bool)
 	stats.messageToRunnerChan = make(chan map[string]interface{}, 10)
 	stats.shutdownChan = make(chan bool)
 
@@ -50,44 +146,124 @@ func newRequestStats() (stats *requestStats) {
 		method: "",
 	}
 	stats.total.reset()
+	stats.accumulated = &statsEntry{
+		name:   "Total",
+		method: "",
+	}
+	stats.accumulated.reset()
 
 	return stats
 }
 
 func (s *requestStats) logRequest(method, name string, responseTime int64, contentLength int64) {
+	s.logLabeledRequest(method, name, nil, responseTime, contentLength)
+}
+
+// logLabeledRequest is like logRequest, but the request is aggregated by its labels too.
+func (s *requestStats) logLabeledRequest(method, name string, labels map[string]string, responseTime int64, contentLength int64) {
 	s.total.log(responseTime, contentLength)
-	s.get(name, method).log(responseTime, contentLength)
+	// the requests per second are not needed by snapshots, skip them to bound the memory
+	s.accumulated.numRequests++
+	s.accumulated.logResponseTime(responseTime)
+	s.accumulated.totalContentLength += contentLength
+	s.getLabeled(name, method, labels).log(responseTime, contentLength)
 }
 
 func (s *requestStats) logError(method, name, err string) {
+	s.logCategorizedError(method, name, err, "")
+}
+
+// logCategorizedError is like logError, but the error is reported with its category if it's not empty.
+// Failures of the connection error categories are counted in num_connection_errors too.
+func (s *requestStats) logCategorizedError(method, name, err, category string) {
+	s.logLabeledError(method, name, nil, err, category)
+}
+
+// logLabeledError is like logCategorizedError, but the failure is aggregated by its labels too.
+// The errors are not aggregated by the labels.
+func (s *requestStats) logLabeledError(method, name string, labels map[string]string, err, category string) {
 	s.total.logError(err)
-	s.get(name, method).logError(err)
+	s.accumulated.logError(err)
+	s.getLabeled(name, method, labels).logError(err)
+	if isConnectionError(category) {
+		s.total.numConnectionErrors++
+		s.accumulated.numConnectionErrors++
+		s.getLabeled(name, method, labels).numConnectionErrors++
+	}
 
 	// store error in errors map
 	key := MD5(method, name, err)
 	entry, ok := s.errors[key]
 	if !ok {
 		entry = &statsError{
-			name:   name,
-			method: method,
-			error:  err,
+			name:     name,
+			method:   method,
+			error:    err,
+			category: category,
 		}
 		s.errors[key] = entry
 	}
 	entry.occured()
 }
 
+func (s *requestStats) logTaskExecution(name string, responseTime int64, failed, slow bool) {
+	if name == "" {
+		name = unnamedTask
+	}
+	entry, ok := s.taskEntries[name]
+	if !ok {
+		entry = &statsEntry{
+			name:   name,
+			method: "task",
+		}
+		entry.reset()
+		s.taskEntries[name] = entry
+	}
+	if failed {
+		entry.logError("")
+	} else {
+		entry.log(responseTime, 0)
+	}
+	if slow {
+		entry.numSlowCalls++
+	}
+}
+
 func (s *requestStats) get(name string, method string) (entry *statsEntry) {
-	entry, ok := s.entries[name+method]
+	return s.getLabeled(name, method, nil)
+}
+
+// getLabeled returns the entry of the request with labels. Once there are maxLabelSets unique label sets,
+// the requests with a new label set are aggregated as if they have no labels.
+func (s *requestStats) getLabeled(name string, method string, labels map[string]string) (entry *statsEntry) {
+	key := requestKey{method: method, name: name, labels: labelsKey(labels)}
+	if key.labels != "" && !s.labelSets[key.labels] {
+		maxLabelSets := s.maxLabelSets
+		if maxLabelSets <= 0 {
+			maxLabelSets = defaultMaxLabelSets
+		}
+		if len(s.labelSets) < maxLabelSets {
+			s.labelSets[key.labels] = true
+		} else {
+			if !s.labelSetsExceeded {
+				logger.Errorf("The number of the label sets exceeds %d, the labels of the new ones are dropped", maxLabelSets)
+				s.labelSetsExceeded = true
+			}
+			key.labels = ""
+			labels = nil
+		}
+	}
+	entry, ok := s.entries[key]
 	if !ok {
 		newEntry := &statsEntry{
 			name:          name,
 			method:        method,
+			labels:        labels,
 			numReqsPerSec: make(map[int64]int64),
 			responseTimes: make(map[int64]int64),
 		}
 		newEntry.reset()
-		s.entries[name+method] = newEntry
+		s.entries[key] = newEntry
 		return newEntry
 	}
 	return entry
@@ -99,10 +275,14 @@ func (s *requestStats) clearAll() {
 		method: "",
 	}
 	s.total.reset()
+	s.accumulated.reset()
 
-	s.entries = make(map[string]*statsEntry)
+	s.entries = make(map[requestKey]*statsEntry)
 	s.errors = make(map[string]*statsError)
-	s.startTime = time.Now().Unix()
+	s.taskEntries = make(map[string]*statsEntry)
+	s.labelSets = make(map[string]bool)
+	s.labelSetsExceeded = false
+	s.startTime = time.Now()
 }
 
 func (s *requestStats) serializeStats() []interface{} {
@@ -115,6 +295,16 @@ func (s *requestStats) serializeStats() []interface{} {
 	return entries
 }
 
+func (s *requestStats) serializeTaskStats() []interface{} {
+	entries := make([]interface{}, 0, len(s.taskEntries))
+	for _, v := range s.taskEntries {
+		if !(v.numRequests == 0 && v.numFailures == 0) {
+			entries = append(entries, v.getStrippedReport())
+		}
+	}
+	return entries
+}
+
 func (s *requestStats) serializeErrors() map[string]map[string]interface{} {
 	errors := make(map[string]map[string]interface{})
 	for k, v := range s.errors {
@@ -123,26 +313,102 @@ func (s *requestStats) serializeErrors() map[string]map[string]interface{} {
 	return errors
 }
 
+func (s *requestStats) snapshot() *StatsSnapshot {
+	a := s.accumulated
+	return &StatsSnapshot{
+		NumRequests:              a.numRequests,
+		NumFailures:              a.numFailures,
+		NumConnectionErrors:      a.numConnectionErrors,
+		TotalContentLength:       a.totalContentLength,
+		CurrentRPS:               getCurrentRps(s.total.numRequests, s.total.numReqsPerSec),
+		AvgResponseTime:          getAvgResponseTime(a.numRequests, a.totalResponseTime),
+		MinResponseTime:          a.minResponseTime,
+		MaxResponseTime:          a.maxResponseTime,
+		MedianResponseTime:       getMedianResponseTime(a.numRequests, a.responseTimes),
+		ResponseTimePercentile95: getResponseTimePercentile(a.numRequests, a.responseTimes, 0.95),
+		ResponseTimePercentile99: getResponseTimePercentile(a.numRequests, a.responseTimes, 0.99),
+	}
+}
+
+// drainRecords logs all the buffered successes, failures and task executions.
+func (s *requestStats) drainRecords() {
+	for {
+		select {
+		case m := <-s.requestSuccessChan:
+			s.logLabeledRequest(m.requestType, m.name, m.labels, m.responseTime, m.responseLength)
+		case n := <-s.requestFailureChan:
+			s.logLabeledError(n.requestType, n.name, n.labels, n.error, n.category)
+		case e := <-s.taskExecutionChan:
+			s.logTaskExecution(e.name, e.responseTime, e.failed, e.slow)
+		default:
+			return
+		}
+	}
+}
+
+// getSnapshot asks the stats goroutine for a snapshot, so it's safe to be called by any goroutine.
+// It returns an empty snapshot if the stats goroutine has quit.
+func (s *requestStats) getSnapshot() StatsSnapshot {
+	reply := make(chan *StatsSnapshot, 1)
+	select {
+	case s.snapshotChan <- reply:
+		return *<-reply
+	case <-s.shutdownChan:
+		return StatsSnapshot{}
+	}
+}
+
+// flush makes the stats goroutine send a report of the stats since the last one to messageToRunnerChan
+// without waiting for the next tick, it returns once the report is queued.
+// It does nothing if the stats goroutine is not started or has quit.
+func (s *requestStats) flush() {
+	if atomic.LoadInt32(&s.started) == 0 {
+		return
+	}
+	done := make(chan bool, 1)
+	select {
+	case s.flushChan <- done:
+		<-done
+	case <-s.shutdownChan:
+	}
+}
+
 func (s *requestStats) collectReportData() map[string]interface{} {
 	data := make(map[string]interface{})
 	data["stats"] = s.serializeStats()
 	data["stats_total"] = s.total.getStrippedReport()
 	data["errors"] = s.serializeErrors()
+	data["tasks"] = s.serializeTaskStats()
+	data["start_time"] = s.startTime.Unix()
+	// in seconds since the current hatch is started
+	data["elapsed"] = time.Since(s.startTime).Seconds()
 	s.errors = make(map[string]*statsError)
 	return data
 }
 
 func (s *requestStats) start() {
+	atomic.StoreInt32(&s.started, 1)
 	go func() {
 		var ticker = time.NewTicker(slaveReportInterval)
 		for {
 			select {
 			case m := <-s.requestSuccessChan:
-				s.logRequest(m.requestType, m.name, m.responseTime, m.responseLength)
+				s.logLabeledRequest(m.requestType, m.name, m.labels, m.responseTime, m.responseLength)
 			case n := <-s.requestFailureChan:
-				s.logError(n.requestType, n.name, n.error)
+				s.logLabeledError(n.requestType, n.name, n.labels, n.error, n.category)
+			case e := <-s.taskExecutionChan:
+				s.logTaskExecution(e.name, e.responseTime, e.failed, e.slow)
 			case <-s.clearStatsChan:
 				s.clearAll()
+			case reply := <-s.snapshotChan:
+				// the records sent before the snapshot may still be buffered
+				s.drainRecords()
+				reply <- s.snapshot()
+			case done := <-s.flushChan:
+				// the records sent before stopping may still be buffered
+				s.drainRecords()
+				s.messageToRunnerChan <- s.collectReportData()
+				done <- true
 			case <-ticker.C:
 				data := s.collectReportData()
 				// send data to channel, no network IO in this goroutine
@@ -162,8 +428,11 @@ func (s *requestStats) close() {
 type statsEntry struct {
 	name                 string
 	method               string
+	labels               map[string]string
 	numRequests          int64
 	numFailures          int64
+	numConnectionErrors  int64
+	numSlowCalls         int64
 	totalResponseTime    int64
 	minResponseTime      int64
 	maxResponseTime      int64
@@ -172,12 +441,19 @@ type statsEntry struct {
 	totalContentLength   int64
 	startTime            int64
 	lastRequestTimestamp int64
+
+	// the response times since the stats are cleared, which are not reset by every report like responseTimes,
+	// so both the current percentiles of the last report interval and the total ones are reported.
+	cumulativeNumRequests   int64
+	cumulativeResponseTimes map[int64]int64
 }
 
 func (s *statsEntry) reset() {
 	s.startTime = time.Now().Unix()
 	s.numRequests = 0
 	s.numFailures = 0
+	s.numConnectionErrors = 0
+	s.numSlowCalls = 0
 	s.totalResponseTime = 0
 	s.responseTimes = make(map[int64]int64)
 	s.minResponseTime = 0
@@ -211,7 +487,8 @@ func (s *statsEntry) logTimeOfRequest() {
 func (s *statsEntry) logResponseTime(responseTime int64) {
 	s.totalResponseTime += responseTime
 
-	if s.minResponseTime == 0 {
+	// the first observation sets min, a min of 0 is a valid observation
+	if len(s.responseTimes) == 0 {
 		s.minResponseTime = responseTime
 	}
 
@@ -245,6 +522,12 @@ func (s *statsEntry) logResponseTime(responseTime int64) {
 	} else {
 		s.responseTimes[roundedResponseTime]++
 	}
+
+	if s.cumulativeResponseTimes == nil {
+		s.cumulativeResponseTimes = make(map[int64]int64)
+	}
+	s.cumulativeNumRequests++
+	s.cumulativeResponseTimes[roundedResponseTime]++
 }
 
 func (s *statsEntry) logError(err string) {
@@ -255,16 +538,27 @@ func (s *statsEntry) serialize() map[string]interface{} {
 	result := make(map[string]interface{})
 	result["name"] = s.name
 	result["method"] = s.method
+	if len(s.labels) > 0 {
+		result["labels"] = s.labels
+	}
 	result["last_request_timestamp"] = s.lastRequestTimestamp
 	result["start_time"] = s.startTime
 	result["num_requests"] = s.numRequests
 	result["num_failures"] = s.numFailures
+	result["num_connection_errors"] = s.numConnectionErrors
+	result["num_slow_calls"] = s.numSlowCalls
 	result["total_response_time"] = s.totalResponseTime
 	result["max_response_time"] = s.maxResponseTime
 	result["min_response_time"] = s.minResponseTime
 	result["total_content_length"] = s.totalContentLength
 	result["response_times"] = s.responseTimes
 	result["num_reqs_per_sec"] = s.numReqsPerSec
+	result["median_response_time"] = getMedianResponseTime(s.numRequests, s.responseTimes)
+	result["current_response_time_percentile_95"] = getResponseTimePercentile(s.numRequests, s.responseTimes, 0.95)
+	result["current_response_time_percentile_99"] = getResponseTimePercentile(s.numRequests, s.responseTimes, 0.99)
+	// the total percentiles since the stats are cleared
+	result["response_time_percentile_95"] = getResponseTimePercentile(s.cumulativeNumRequests, s.cumulativeResponseTimes, 0.95)
+	result["response_time_percentile_99"] = getResponseTimePercentile(s.cumulativeNumRequests, s.cumulativeResponseTimes, 0.99)
 	return result
 }
 
@@ -278,6 +572,7 @@ type statsError struct {
 	name        string
 	method      string
 	error       string
+	category    string
 	occurrences int64
 }
 
@@ -292,6 +587,9 @@ func (err *statsError) toMap() map[string]interface{} {
 	m["name"] = err.name
 	m["error"] = err.error
 	m["occurrences"] = err.occurrences
+	if err.category != "" {
+		m["category"] = err.category
+	}
 
 	// keep compatible with locust
 	// https://github.com/locustio/locust/commit/f0a5f893734faeddb83860b2985010facc910d7d#diff-5d5f310549d6d596beaa43a1282ec49e
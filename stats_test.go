@@ -114,6 +114,51 @@ func TestLogError(t *testing.T) {
 
 }
 
+func TestLogTaskExecution(t *testing.T) {
+	newStats := newRequestStats()
+	// two tasks with the same name are merged into one entry
+	newStats.logTaskExecution("foo", 10, false, false)
+	newStats.logTaskExecution("foo", 30, false, false)
+	newStats.logTaskExecution("foo", 0, true, false)
+	newStats.logTaskExecution("bar", 20, false, false)
+	newStats.logTaskExecution("", 5, false, false)
+
+	if len(newStats.taskEntries) != 3 {
+		t.Error("The number of task entries is wrong, expected: 3, got:", len(newStats.taskEntries))
+	}
+
+	foo := newStats.taskEntries["foo"]
+	if foo.numRequests != 2 {
+		t.Error("numRequests of foo is wrong, expected: 2, got:", foo.numRequests)
+	}
+	if foo.numFailures != 1 {
+		t.Error("numFailures of foo is wrong, expected: 1, got:", foo.numFailures)
+	}
+	if foo.totalResponseTime != 40 {
+		t.Error("totalResponseTime of foo is wrong, expected: 40, got:", foo.totalResponseTime)
+	}
+
+	bar := newStats.taskEntries["bar"]
+	if bar.numRequests != 1 {
+		t.Error("numRequests of bar is wrong, expected: 1, got:", bar.numRequests)
+	}
+
+	unnamed, ok := newStats.taskEntries[unnamedTask]
+	if !ok || unnamed.numRequests != 1 {
+		t.Error("Tasks without a name should be logged as", unnamedTask)
+	}
+
+	// task executions are not requests
+	if newStats.total.numRequests != 0 {
+		t.Error("newStats.total.numRequests is wrong, expected: 0, got:", newStats.total.numRequests)
+	}
+
+	tasks := newStats.collectReportData()["tasks"].([]interface{})
+	if len(tasks) != 3 {
+		t.Error("The length of serialized task stats is wrong, expected: 3, got:", len(tasks))
+	}
+}
+
 func BenchmarkLogError(b *testing.B) {
 	newStats := newRequestStats()
 	for i := 0; i < b.N; i++ {
@@ -170,6 +215,63 @@ func TestSerializeStats(t *testing.T) {
 	}
 }
 
+func TestSerializeStatsPercentiles(t *testing.T) {
+	newStats := newRequestStats()
+	for i := int64(1); i <= 100; i++ {
+		newStats.logRequest("http", "success", i, 0)
+	}
+
+	serialized := newStats.serializeStats()
+	first := serialized[0].(map[string]interface{})
+	if first["median_response_time"].(int64) != 50 {
+		t.Error("The median_response_time is wrong, expected: 50, got:", first["median_response_time"].(int64))
+	}
+	// locust picks the upper boundary, so it is 96 rather than 95
+	if first["current_response_time_percentile_95"].(int64) != 96 {
+		t.Error("The current_response_time_percentile_95 is wrong, expected: 96, got:", first["current_response_time_percentile_95"].(int64))
+	}
+	if first["current_response_time_percentile_99"].(int64) != 100 {
+		t.Error("The current_response_time_percentile_99 is wrong, expected: 100, got:", first["current_response_time_percentile_99"].(int64))
+	}
+}
+
+func TestCurrentAndTotalPercentiles(t *testing.T) {
+	newStats := newRequestStats()
+	percentiles := func(data map[string]interface{}) (current, total int64) {
+		return data["current_response_time_percentile_95"].(int64), data["response_time_percentile_95"].(int64)
+	}
+
+	// the slow window
+	for i := 0; i < 100; i++ {
+		newStats.logRequest("http", "foo", 200, 0)
+	}
+	data := newStats.collectReportData()
+	for _, entry := range []interface{}{data["stats"].([]interface{})[0], data["stats_total"]} {
+		if current, total := percentiles(entry.(map[string]interface{})); current != 200 || total != 200 {
+			t.Errorf("Both percentiles should be 200 in the first window, got current %d and total %d", current, total)
+		}
+	}
+
+	// the fast window, the current percentile is of the last window only
+	for i := 0; i < 100; i++ {
+		newStats.logRequest("http", "foo", 20, 0)
+	}
+	data = newStats.collectReportData()
+	for _, entry := range []interface{}{data["stats"].([]interface{})[0], data["stats_total"]} {
+		if current, total := percentiles(entry.(map[string]interface{})); current != 20 || total != 200 {
+			t.Errorf("The current percentile should be 20 and the total one 200, got current %d and total %d", current, total)
+		}
+	}
+
+	// the total percentiles are cleared by a new hatch
+	newStats.clearAll()
+	newStats.logRequest("http", "foo", 20, 0)
+	data = newStats.collectReportData()
+	if _, total := percentiles(data["stats_total"].(map[string]interface{})); total != 20 {
+		t.Error("The total percentile should be cleared, got", total)
+	}
+}
+
 func TestSerializeErrors(t *testing.T) {
 	newStats := newRequestStats()
 	newStats.logError("http", "failure", "500 error")
@@ -196,6 +298,24 @@ func TestSerializeErrors(t *testing.T) {
 	}
 }
 
+func TestSerializeCategorizedErrors(t *testing.T) {
+	newStats := newRequestStats()
+	newStats.logCategorizedError("http", "failure", "i/o timeout", ErrorCategoryTimeout)
+	newStats.logError("http", "failure", "500 error")
+	serialized := newStats.serializeErrors()
+
+	timeout := serialized[MD5("http", "failure", "i/o timeout")]
+	if category := timeout["category"]; category != ErrorCategoryTimeout {
+		t.Error("expected: timeout, got:", category)
+	}
+	if _, ok := serialized[MD5("http", "failure", "500 error")]["category"]; ok {
+		t.Error("The errors without a category should not be reported with one")
+	}
+	if newStats.total.numFailures != 2 {
+		t.Error("expected: 2 failures, got:", newStats.total.numFailures)
+	}
+}
+
 func TestCollectReportData(t *testing.T) {
 	newStats := newRequestStats()
 	newStats.logRequest("http", "success", 2, 30)
@@ -211,6 +331,42 @@ func TestCollectReportData(t *testing.T) {
 	if _, ok := result["errors"]; !ok {
 		t.Error("Key stats not found")
 	}
+	if _, ok := result["tasks"]; !ok {
+		t.Error("Key tasks not found")
+	}
+}
+
+func TestContentLengthInReportData(t *testing.T) {
+	newStats := newRequestStats()
+	newStats.logRequest("http", "foo", 10, 100)
+	newStats.logRequest("http", "foo", 10, 250)
+	newStats.logRequest("http", "bar", 10, 1024)
+	newStats.logRequest("tcp", "foo", 10, 0)
+	result := newStats.collectReportData()
+
+	contentLengths := map[string]int64{}
+	for _, stat := range result["stats"].([]interface{}) {
+		s := stat.(map[string]interface{})
+		contentLengths[s["method"].(string)+"/"+s["name"].(string)] = s["total_content_length"].(int64)
+	}
+	expected := map[string]int64{
+		"http/foo": 350,
+		"http/bar": 1024,
+		"tcp/foo":  0,
+	}
+	for key, length := range expected {
+		if contentLengths[key] != length {
+			t.Error("total_content_length of", key, "is wrong, expected:", length, "got:", contentLengths[key])
+		}
+	}
+
+	total := result["stats_total"].(map[string]interface{})
+	if total["total_content_length"].(int64) != 1374 {
+		t.Error("total_content_length of stats_total is wrong, expected: 1374, got:", total["total_content_length"])
+	}
+	if total["num_requests"].(int64) != 4 {
+		t.Error("num_requests of stats_total is wrong, expected: 4, got:", total["num_requests"])
+	}
 }
 
 func TestStatsStart(t *testing.T) {
@@ -243,3 +399,277 @@ func TestStatsStart(t *testing.T) {
 	}
 end:
 }
+
+func TestElapsedInReportData(t *testing.T) {
+	newStats := newRequestStats()
+	newStats.start()
+	defer newStats.close()
+
+	var elapsed []float64
+	for i := 0; i < 2; i++ {
+		select {
+		case data := <-newStats.messageToRunnerChan:
+			elapsed = append(elapsed, data["elapsed"].(float64))
+			if startTime := data["start_time"].(int64); startTime != newStats.startTime.Unix() {
+				t.Error("start_time is wrong, expected:", newStats.startTime.Unix(), "got:", startTime)
+			}
+		case <-time.After(slaveReportInterval + time.Second):
+			t.Fatal("Timeout waiting for stats reports to runner")
+		}
+	}
+	if elapsed[0] < slaveReportInterval.Seconds()-0.1 || elapsed[1]-elapsed[0] < slaveReportInterval.Seconds()-0.1 {
+		t.Error("elapsed should increase by the report interval, got", elapsed)
+	}
+
+	// reset by hatching
+	hatched := newRequestStats()
+	hatched.startTime = time.Now().Add(-time.Hour)
+	hatched.clearAll()
+	if data := hatched.collectReportData(); data["elapsed"].(float64) > 1 {
+		t.Error("elapsed should be reset by hatching, got", data["elapsed"])
+	}
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	newStats := newRequestStats()
+	newStats.logRequest("http", "success", 10, 100)
+	newStats.logRequest("http", "success", 20, 100)
+	newStats.logRequest("http", "success", 30, 100)
+	newStats.logError("http", "failure", "500 error")
+
+	// reports don't disturb snapshots
+	newStats.collectReportData()
+	newStats.logRequest("http", "success", 40, 100)
+
+	snapshot := newStats.snapshot()
+	if snapshot.NumRequests != 4 {
+		t.Error("expected: 4 requests, got:", snapshot.NumRequests)
+	}
+	if snapshot.NumFailures != 1 {
+		t.Error("expected: 1 failure, got:", snapshot.NumFailures)
+	}
+	if snapshot.TotalContentLength != 400 {
+		t.Error("expected: 400 content length, got:", snapshot.TotalContentLength)
+	}
+	if snapshot.CurrentRPS != 1 {
+		t.Error("expected: 1 rps in current report interval, got:", snapshot.CurrentRPS)
+	}
+	if snapshot.AvgResponseTime != 25 {
+		t.Error("expected: 25 avg response time, got:", snapshot.AvgResponseTime)
+	}
+	if snapshot.MinResponseTime != 10 || snapshot.MaxResponseTime != 40 {
+		t.Error("expected: 10 min and 40 max response time, got:", snapshot.MinResponseTime, snapshot.MaxResponseTime)
+	}
+	if snapshot.MedianResponseTime != 20 {
+		t.Error("expected: 20 median response time, got:", snapshot.MedianResponseTime)
+	}
+	if snapshot.ResponseTimePercentile99 != 40 {
+		t.Error("expected: 40 as the 99th percentile, got:", snapshot.ResponseTimePercentile99)
+	}
+
+	newStats.clearAll()
+	if snapshot = newStats.snapshot(); snapshot.NumRequests != 0 || snapshot.NumFailures != 0 {
+		t.Error("The snapshot should be cleared with the stats, got", snapshot)
+	}
+}
+
+func TestGetStatsSnapshot(t *testing.T) {
+	newStats := newRequestStats()
+	newStats.start()
+
+	newStats.requestSuccessChan <- &requestSuccess{
+		requestType:  "http",
+		name:         "success",
+		responseTime: 10,
+	}
+	newStats.requestFailureChan <- &requestFailure{
+		requestType: "http",
+		name:        "failure",
+		error:       "500 error",
+	}
+
+	// the records sent before are logged before the snapshot
+	snapshot := newStats.getSnapshot()
+	if snapshot.NumRequests != 1 || snapshot.NumFailures != 1 {
+		t.Error("expected: 1 request and 1 failure, got:", snapshot.NumRequests, snapshot.NumFailures)
+	}
+
+	newStats.close()
+	if snapshot = newStats.getSnapshot(); snapshot.NumRequests != 0 {
+		t.Error("An empty snapshot is expected after the stats goroutine quits, got", snapshot)
+	}
+}
+
+func TestConnectionErrors(t *testing.T) {
+	newStats := newRequestStats()
+	newStats.logRequest("http", "/foo", 10, 100)
+	newStats.logCategorizedError("http", "/foo", "500 Internal Server Error", ErrorCategoryHTTP5xx)
+	newStats.logError("http", "/foo", "unknown error")
+	newStats.logCategorizedError("http", "/foo", "connection refused", ErrorCategoryConnectionRefused)
+	newStats.logCategorizedError("http", "/bar", "no such host", ErrorCategoryDNS)
+
+	snapshot := newStats.snapshot()
+	if snapshot.NumFailures != 4 || snapshot.NumConnectionErrors != 2 {
+		t.Error("expected: 4 failures and 2 connection errors, got:", snapshot.NumFailures, snapshot.NumConnectionErrors)
+	}
+
+	data := newStats.collectReportData()
+	total := data["stats_total"].(map[string]interface{})
+	if total["num_failures"] != int64(4) || total["num_connection_errors"] != int64(2) {
+		t.Error("expected: 4 failures and 2 connection errors in total, got:", total["num_failures"], total["num_connection_errors"])
+	}
+	for _, stat := range data["stats"].([]interface{}) {
+		entry := stat.(map[string]interface{})
+		switch entry["name"] {
+		case "/foo":
+			if entry["num_requests"] != int64(1) || entry["num_failures"] != int64(3) || entry["num_connection_errors"] != int64(1) {
+				t.Error("expected: 1 request, 3 failures and 1 connection error of /foo, got:", entry)
+			}
+		case "/bar":
+			if entry["num_failures"] != int64(1) || entry["num_connection_errors"] != int64(1) {
+				t.Error("expected: 1 failure and 1 connection error of /bar, got:", entry)
+			}
+		}
+	}
+
+	// reset by the report
+	total = newStats.collectReportData()["stats_total"].(map[string]interface{})
+	if total["num_connection_errors"] != int64(0) {
+		t.Error("connection errors should be reset after reported, got:", total["num_connection_errors"])
+	}
+}
+
+func TestStatsKeyedByMethodAndName(t *testing.T) {
+	newStats := newRequestStats()
+	newStats.logRequest("GET", "/foo", 10, 100)
+	newStats.logRequest("GET", "/foo", 20, 100)
+	newStats.logRequest("POST", "/foo", 30, 200)
+	newStats.logError("POST", "/foo", "500 error")
+	// the concatenations of method and name are the same
+	newStats.logRequest("GE", "T/foo", 40, 100)
+
+	stats := newStats.collectReportData()["stats"].([]interface{})
+	if len(stats) != 3 {
+		t.Fatal("expected: 3 entries, got:", len(stats))
+	}
+	for _, stat := range stats {
+		entry := stat.(map[string]interface{})
+		method, name := entry["method"], entry["name"]
+		switch {
+		case method == "GET" && name == "/foo":
+			if entry["num_requests"] != int64(2) || entry["num_failures"] != int64(0) {
+				t.Error("expected: 2 requests of GET /foo, got:", entry)
+			}
+		case method == "POST" && name == "/foo":
+			if entry["num_requests"] != int64(1) || entry["num_failures"] != int64(1) || entry["total_content_length"] != int64(200) {
+				t.Error("expected: 1 request and 1 failure of POST /foo, got:", entry)
+			}
+		case method == "GE" && name == "T/foo":
+			if entry["num_requests"] != int64(1) {
+				t.Error("expected: 1 request of GE T/foo, got:", entry)
+			}
+		default:
+			t.Error("unexpected entry", method, name)
+		}
+	}
+}
+
+func TestStatsKeyedByLabels(t *testing.T) {
+	newStats := newRequestStats()
+	tenantA := map[string]string{"tenant": "a", "shard": "1"}
+	tenantB := map[string]string{"tenant": "b", "shard": "1"}
+	newStats.logLabeledRequest("GET", "/foo", tenantA, 10, 100)
+	newStats.logLabeledRequest("GET", "/foo", map[string]string{"shard": "1", "tenant": "a"}, 20, 100)
+	newStats.logLabeledRequest("GET", "/foo", tenantB, 30, 200)
+	newStats.logLabeledError("GET", "/foo", tenantB, "500 error", "")
+	newStats.logRequest("GET", "/foo", 40, 100)
+
+	stats := newStats.collectReportData()["stats"].([]interface{})
+	if len(stats) != 3 {
+		t.Fatal("expected: 3 entries, got:", len(stats))
+	}
+	for _, stat := range stats {
+		entry := stat.(map[string]interface{})
+		labels, _ := entry["labels"].(map[string]string)
+		switch labelsKey(labels) {
+		case "shard=1,tenant=a":
+			if entry["num_requests"] != int64(2) || entry["num_failures"] != int64(0) || entry["total_response_time"] != int64(30) {
+				t.Error("expected: 2 requests of tenant a, got:", entry)
+			}
+		case "shard=1,tenant=b":
+			if entry["num_requests"] != int64(1) || entry["num_failures"] != int64(1) || entry["total_content_length"] != int64(200) {
+				t.Error("expected: 1 request and 1 failure of tenant b, got:", entry)
+			}
+		case "":
+			if _, ok := entry["labels"]; ok || entry["num_requests"] != int64(1) {
+				t.Error("expected: 1 request without labels, got:", entry)
+			}
+		default:
+			t.Error("unexpected labels", labels)
+		}
+	}
+}
+
+func TestMaxLabelSets(t *testing.T) {
+	newStats := newRequestStats()
+	newStats.maxLabelSets = 2
+	for _, tenant := range []string{"a", "b", "c", "d", "a"} {
+		newStats.logLabeledRequest("GET", "/foo", map[string]string{"tenant": tenant}, 10, 0)
+	}
+
+	stats := newStats.collectReportData()["stats"].([]interface{})
+	if len(stats) != 3 {
+		t.Fatal("expected: 2 labeled entries and 1 for the others, got:", len(stats))
+	}
+	for _, stat := range stats {
+		entry := stat.(map[string]interface{})
+		labels, _ := entry["labels"].(map[string]string)
+		expected := map[string]int64{"a": 2, "b": 1, "": 2}[labels["tenant"]]
+		if entry["num_requests"] != expected {
+			t.Errorf("expected: %d requests of %v, got: %v", expected, labels, entry["num_requests"])
+		}
+	}
+
+	// the label sets are counted again once cleared
+	newStats.clearAll()
+	newStats.logLabeledRequest("GET", "/foo", map[string]string{"tenant": "c"}, 10, 0)
+	stats = newStats.collectReportData()["stats"].([]interface{})
+	if labels, _ := stats[0].(map[string]interface{})["labels"].(map[string]string); labels["tenant"] != "c" {
+		t.Error("expected: the labels of tenant c, got:", labels)
+	}
+}
+
+func TestMinAndMaxResponseTime(t *testing.T) {
+	newStats := newRequestStats()
+	for _, responseTime := range []int64{50, 0, 30, 120, 10} {
+		newStats.logRequest("GET", "/foo", responseTime, 0)
+	}
+	for _, responseTime := range []int64{200, 300, 250} {
+		newStats.logRequest("POST", "/foo", responseTime, 0)
+	}
+
+	data := newStats.collectReportData()
+	for _, stat := range data["stats"].([]interface{}) {
+		entry := stat.(map[string]interface{})
+		expectedMin, expectedMax := int64(0), int64(120)
+		if entry["method"] == "POST" {
+			expectedMin, expectedMax = 200, 300
+		}
+		if entry["min_response_time"] != expectedMin || entry["max_response_time"] != expectedMax {
+			t.Errorf("expected: min %d and max %d of %s, got: %v and %v", expectedMin, expectedMax,
+				entry["method"], entry["min_response_time"], entry["max_response_time"])
+		}
+	}
+	total := data["stats_total"].(map[string]interface{})
+	if total["min_response_time"] != int64(0) || total["max_response_time"] != int64(300) {
+		t.Error("expected: min 0 and max 300 in total, got:", total["min_response_time"], total["max_response_time"])
+	}
+
+	// the first observation after reported sets min again
+	newStats.logRequest("GET", "/foo", 70, 0)
+	newStats.logRequest("GET", "/foo", 90, 0)
+	entry := newStats.collectReportData()["stats"].([]interface{})[0].(map[string]interface{})
+	if entry["min_response_time"] != int64(70) || entry["max_response_time"] != int64(90) {
+		t.Error("expected: min 70 and max 90 after reported, got:", entry["min_response_time"], entry["max_response_time"])
+	}
+}
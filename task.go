@@ -1,13 +1,155 @@
 package boomer
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
 // Task is like the "Locust object" in locust, the python version.
 // When boomer receives a start message from master, it will spawn several goroutines to run Task.Fn.
 // But users can keep some information in the python version, they can't do the same things in boomer.
 // Because Task.Fn is a pure function.
 type Task struct {
-	// The weight is used to distribute goroutines over multiple tasks.
+	// The weight is used to decide how often this task is picked by the goroutines,
+	// each goroutine picks a task with a probability of Weight / sum of all the weights.
+	// A task with zero weight is disabled, it's never picked and its OnStart and OnStop are not called,
+	// unless all the tasks have zero weight, in which case they have the same chance to be picked.
 	Weight int
+	// WeightF is the floating-point version of Weight, it allows fractional weights like 0.5.
+	// If WeightF is not zero, it takes precedence over Weight.
+	WeightF float64
+	// WeightFn returns the weight dynamically, it takes precedence over WeightF and Weight if it's set.
+	// It's called periodically while running, so the mix of tasks can shift over time, negative weights
+	// are treated as zero. It's called by multiple goroutines, so it must be safe for concurrent use.
+	// The task is never disabled by a zero weight returned by WeightFn, its OnStart and OnStop are always called.
+	WeightFn func() int
 	// Fn is called by the goroutines allocated to this task, in a loop.
-	Fn   func()
-	Name string
+	Fn func()
+	// FnWithContext is called instead of Fn if it's set, the context is cancelled
+	// as soon as the runner stops, so long-running calls can return early.
+	FnWithContext func(ctx context.Context)
+	Name          string
+	// MinWait and MaxWait override the wait time of the runner after this task is executed,
+	// if either of them is not zero.
+	MinWait time.Duration
+	MaxWait time.Duration
+	// OnStart is called once by every goroutine before it enters the loop, like on_start in locust.
+	// If it returns an error, the goroutine quits without running any task.
+	OnStart func() error
+	// OnStop is called once by every goroutine when it quits, if OnStart has succeeded.
+	OnStop func()
+	// MaxConcurrency limits how many goroutines can execute this task at the same time, 0 means unlimited.
+	// A goroutine picking this task waits until another one finishes it.
+	MaxConcurrency int
+	// MaxIterations limits how many times this task is executed by all the goroutines together since
+	// the last hatch, or by each goroutine if MaxIterationsPerUser is true. Once it's reached, the task
+	// is not picked any more, 0 means unlimited.
+	MaxIterations        int
+	MaxIterationsPerUser bool
+	// LatencyBudget is the max duration of an execution of this task, the executions exceeding it
+	// are counted as num_slow_calls in the stats of this task, 0 means no budget.
+	LatencyBudget time.Duration
+	// CircuitBreaker stops picking this task after repeated failures recorded with its name, it's optional.
+	CircuitBreaker *CircuitBreaker
+}
+
+// getWeight returns the weight returned by WeightFn or WeightF if it's set, otherwise falls back to Weight.
+func (task *Task) getWeight() float64 {
+	if task.WeightFn != nil {
+		if weight := task.WeightFn(); weight > 0 {
+			return float64(weight)
+		}
+		return 0
+	}
+	if task.WeightF != 0 {
+		return task.WeightF
+	}
+	return float64(task.Weight)
+}
+
+// getWaitTime returns the wait time range of this task, falls back to the given defaults.
+func (task *Task) getWaitTime(defaultMinWait, defaultMaxWait time.Duration) (time.Duration, time.Duration) {
+	if task.MinWait != 0 || task.MaxWait != 0 {
+		return task.MinWait, task.MaxWait
+	}
+	return defaultMinWait, defaultMaxWait
+}
+
+// run calls FnWithContext if it's set, otherwise Fn.
+func (task *Task) run(ctx context.Context) {
+	if task.FnWithContext != nil {
+		task.FnWithContext(ctx)
+		return
+	}
+	task.Fn()
+}
+
+// validate returns an error if the task can't be run by the goroutines.
+func (task *Task) validate() error {
+	if task.Fn == nil && task.FnWithContext == nil {
+		return errors.New("neither Fn nor FnWithContext is set")
+	}
+	if weight := task.getWeight(); weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return fmt.Errorf("invalid weight %v", weight)
+	}
+	if task.MaxConcurrency < 0 {
+		return fmt.Errorf("invalid max concurrency %d", task.MaxConcurrency)
+	}
+	if task.MaxIterations < 0 {
+		return fmt.Errorf("invalid max iterations %d", task.MaxIterations)
+	}
+	if task.CircuitBreaker != nil {
+		if err := task.CircuitBreaker.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// displayName returns the name of the task, or its index in the task list if it's unnamed.
+func (task *Task) displayName(index int) string {
+	if task.Name != "" {
+		return task.Name
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+// validateTasks returns an error describing the first invalid task, or nil if all of them can be run.
+func validateTasks(tasks []*Task) error {
+	if len(tasks) == 0 {
+		return errors.New("no task to run")
+	}
+	for i, task := range tasks {
+		if task == nil {
+			return fmt.Errorf("task #%d is nil", i)
+		}
+		if err := task.validate(); err != nil {
+			return fmt.Errorf("task %s is invalid, %v", task.displayName(i), err)
+		}
+	}
+	return nil
+}
+
+// validatePercentageWeights returns an error if the weights of the tasks don't sum to 100,
+// when the weights are declared as percentages. Dynamic weights of WeightFn are not allowed.
+func validatePercentageWeights(tasks []*Task) error {
+	sum := float64(0)
+	for i, task := range tasks {
+		// nil tasks are reported by validateTasks and DryRun
+		if task == nil {
+			continue
+		}
+		if task.WeightFn != nil {
+			return fmt.Errorf("task %s has a dynamic weight, which can't be a percentage", task.displayName(i))
+		}
+		sum += task.getWeight()
+	}
+	// fractional percentages like 33.3 may not sum to 100 exactly
+	if math.Abs(sum-100) > 1e-9 {
+		return fmt.Errorf("the percentage weights of tasks should sum to 100, got %v", sum)
+	}
+	return nil
 }
@@ -0,0 +1,141 @@
+package boomer
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTaskGetWeight(t *testing.T) {
+	task := &Task{Weight: 10}
+	if task.getWeight() != 10 {
+		t.Error("Expected weight falls back to Weight, expected: 10, was:", task.getWeight())
+	}
+
+	task = &Task{WeightF: 0.5}
+	if task.getWeight() != 0.5 {
+		t.Error("Expected weight is WeightF, expected: 0.5, was:", task.getWeight())
+	}
+
+	task = &Task{Weight: 10, WeightF: 0.5}
+	if task.getWeight() != 0.5 {
+		t.Error("WeightF should take precedence over Weight, expected: 0.5, was:", task.getWeight())
+	}
+
+	weight := 3
+	task = &Task{Weight: 10, WeightF: 0.5, WeightFn: func() int { return weight }}
+	if task.getWeight() != 3 {
+		t.Error("WeightFn should take precedence over WeightF and Weight, expected: 3, was:", task.getWeight())
+	}
+	weight = -1
+	if task.getWeight() != 0 {
+		t.Error("Negative weight of WeightFn should be treated as zero, was:", task.getWeight())
+	}
+}
+
+func TestTaskGetWaitTime(t *testing.T) {
+	task := &Task{}
+	minWait, maxWait := task.getWaitTime(time.Second, 2*time.Second)
+	if minWait != time.Second || maxWait != 2*time.Second {
+		t.Error("Expected wait time falls back to the defaults, was:", minWait, maxWait)
+	}
+
+	task = &Task{MinWait: 100 * time.Millisecond}
+	minWait, maxWait = task.getWaitTime(time.Second, 2*time.Second)
+	if minWait != 100*time.Millisecond || maxWait != 0 {
+		t.Error("The wait time of task should take precedence, was:", minWait, maxWait)
+	}
+}
+
+func TestTaskRun(t *testing.T) {
+	fnCalled, fnWithContextCalled := false, false
+	task := &Task{
+		Fn: func() {
+			fnCalled = true
+		},
+	}
+	task.run(context.Background())
+	if !fnCalled {
+		t.Error("Fn should be called when FnWithContext is not set")
+	}
+
+	fnCalled = false
+	task.FnWithContext = func(ctx context.Context) {
+		fnWithContextCalled = true
+	}
+	task.run(context.Background())
+	if fnCalled || !fnWithContextCalled {
+		t.Error("FnWithContext should be called instead of Fn")
+	}
+}
+
+func TestValidateTasks(t *testing.T) {
+	fn := func() {}
+	cases := []struct {
+		tasks    []*Task
+		expected string
+	}{
+		{nil, "no task to run"},
+		{[]*Task{}, "no task to run"},
+		{[]*Task{{Name: "foo", Fn: fn}, nil}, "task #1 is nil"},
+		{[]*Task{{Name: "foo"}}, "task foo is invalid, neither Fn nor FnWithContext is set"},
+		{[]*Task{{Fn: fn, Weight: -1}}, "task #0 is invalid, invalid weight -1"},
+		{[]*Task{{Fn: fn, WeightF: math.NaN()}}, "task #0 is invalid, invalid weight NaN"},
+		{[]*Task{{Fn: fn, WeightF: math.Inf(1)}}, "task #0 is invalid, invalid weight +Inf"},
+		{[]*Task{{Fn: fn, MaxConcurrency: -1}}, "task #0 is invalid, invalid max concurrency -1"},
+		{[]*Task{{Fn: fn, MaxIterations: -1}}, "task #0 is invalid, invalid max iterations -1"},
+	}
+	for _, c := range cases {
+		err := validateTasks(c.tasks)
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("Expected error %q, got %v", c.expected, err)
+		}
+	}
+
+	valid := []*Task{
+		{Name: "foo", Fn: fn},
+		{Name: "bar", FnWithContext: func(ctx context.Context) {}, Weight: 0},
+		{Name: "baz", Fn: fn, WeightF: 0.5},
+	}
+	if err := validateTasks(valid); err != nil {
+		t.Error("The tasks should be valid, got", err)
+	}
+}
+
+func TestValidatePercentageWeights(t *testing.T) {
+	fn := func() {}
+	valid := []*Task{
+		{Name: "foo", Fn: fn, Weight: 70},
+		{Name: "bar", Fn: fn, WeightF: 20.5},
+		{Name: "baz", Fn: fn, WeightF: 9.5},
+	}
+	if err := validatePercentageWeights(valid); err != nil {
+		t.Error("The weights summing to 100 should be valid, got", err)
+	}
+
+	invalid := []*Task{
+		{Name: "foo", Fn: fn, Weight: 70},
+		{Name: "bar", Fn: fn, Weight: 20},
+	}
+	expected := "the percentage weights of tasks should sum to 100, got 90"
+	if err := validatePercentageWeights(invalid); err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+
+	dynamic := []*Task{
+		{Name: "foo", Fn: fn, Weight: 100},
+		{Name: "bar", Fn: fn, WeightFn: func() int { return 0 }},
+	}
+	if err := validatePercentageWeights(dynamic); err == nil {
+		t.Error("Dynamic weights should not be percentages")
+	}
+
+	withNil := []*Task{
+		{Name: "foo", Fn: fn, Weight: 100},
+		nil,
+	}
+	if err := validatePercentageWeights(withNil); err != nil {
+		t.Error("Nil tasks should be skipped, got", err)
+	}
+}
@@ -1,6 +1,7 @@
 package boomer
 
 import (
+	"context"
 	"math/rand"
 	"sync"
 	"time"
@@ -103,5 +104,68 @@ func (ts *WeighingTaskSet) Run() {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	roll := r.Intn(ts.offset)
 	task := ts.GetTask(roll)
-	task.Fn()
+	task.run(context.Background())
+}
+
+// SequentialTaskSet is a implementation of the TaskSet interface.
+// It runs all of its tasks in the order they are added, like a login -> browse -> checkout flow.
+// The whole sequence counts as one user behavior, so wrap it into a Task to run it in every goroutine.
+//
+//	task := &Task{
+//		Name:          "checkout",
+//		FnWithContext: ts.RunWithContext,
+//	}
+type SequentialTaskSet struct {
+	weight int
+	tasks  []*Task
+	lock   sync.RWMutex
+}
+
+// NewSequentialTaskSet returns a new SequentialTaskSet.
+func NewSequentialTaskSet() *SequentialTaskSet {
+	return &SequentialTaskSet{
+		weight: 0,
+		tasks:  make([]*Task, 0),
+	}
+}
+
+// AddTask appends a Task to the end of the sequence, the task's weight is ignored.
+func (ts *SequentialTaskSet) AddTask(task *Task) {
+	ts.lock.Lock()
+	ts.tasks = append(ts.tasks, task)
+	ts.lock.Unlock()
+}
+
+// SetWeight sets the weight of the task set.
+func (ts *SequentialTaskSet) SetWeight(weight int) {
+	ts.weight = weight
+}
+
+// GetWeight returns the weight of the task set.
+func (ts *SequentialTaskSet) GetWeight() (weight int) {
+	return ts.weight
+}
+
+// Run will run all the tasks in the task set in order.
+// It can be used as a Task.Fn.
+func (ts *SequentialTaskSet) Run() {
+	ts.RunWithContext(context.Background())
+}
+
+// RunWithContext will run all the tasks in the task set in order, it returns
+// between two steps if ctx is done, which happens when the runner stops.
+// It can be used as a Task.FnWithContext.
+func (ts *SequentialTaskSet) RunWithContext(ctx context.Context) {
+	ts.lock.RLock()
+	tasks := ts.tasks
+	ts.lock.RUnlock()
+
+	for _, task := range tasks {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			task.run(ctx)
+		}
+	}
 }
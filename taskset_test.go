@@ -1,6 +1,9 @@
 package boomer
 
-import "testing"
+import (
+	"context"
+	"testing"
+)
 
 func TestWeighingTaskSetWithSingleTask(t *testing.T) {
 	ts := NewWeighingTaskSet()
@@ -90,3 +93,61 @@ func TestWeighingTaskSetGetTaskWithThreeTasks(t *testing.T) {
 		t.Error("Expecting C, but got ", ts.GetTask(5).Name)
 	}
 }
+
+func TestSequentialTaskSet(t *testing.T) {
+	ts := NewSequentialTaskSet()
+	ts.SetWeight(10)
+	if ts.GetWeight() != 10 {
+		t.Error("Expecting 10, but got ", ts.GetWeight())
+	}
+
+	steps := make([]string, 0)
+	for _, name := range []string{"login", "browse", "checkout"} {
+		name := name
+		ts.AddTask(&Task{
+			Name: name,
+			Fn: func() {
+				steps = append(steps, name)
+			},
+		})
+	}
+
+	ts.Run()
+	ts.Run()
+
+	expected := []string{"login", "browse", "checkout", "login", "browse", "checkout"}
+	if len(steps) != len(expected) {
+		t.Fatal("Expecting", expected, "but got", steps)
+	}
+	for i := range expected {
+		if steps[i] != expected[i] {
+			t.Fatal("Expecting", expected, "but got", steps)
+		}
+	}
+}
+
+func TestSequentialTaskSetStopsBetweenSteps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ts := NewSequentialTaskSet()
+
+	steps := make([]string, 0)
+	ts.AddTask(&Task{
+		Name: "login",
+		Fn: func() {
+			steps = append(steps, "login")
+			cancel()
+		},
+	})
+	ts.AddTask(&Task{
+		Name: "browse",
+		Fn: func() {
+			steps = append(steps, "browse")
+		},
+	})
+
+	ts.RunWithContext(ctx)
+
+	if len(steps) != 1 || steps[0] != "login" {
+		t.Error("The remaining steps should be skipped once the context is done, but got", steps)
+	}
+}
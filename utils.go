@@ -4,7 +4,6 @@ import (
 	"crypto/md5"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"os"
 	"runtime/pprof"
@@ -12,6 +11,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/shirou/gopsutil/v3/process"
 )
 
 func round(val float64, roundOn float64, places int) (newVal float64) {
@@ -57,14 +57,14 @@ func StartMemoryProfile(file string, duration time.Duration) (err error) {
 		return err
 	}
 
-	log.Println("Start memory profiling for", duration)
+	logger.Infof("Start memory profiling for %v", duration)
 	time.AfterFunc(duration, func() {
 		err = pprof.WriteHeapProfile(f)
 		if err != nil {
-			log.Println(err)
+			logger.Errorf("%v", err)
 		}
 		f.Close()
-		log.Println("Stop memory profiling after", duration)
+		logger.Infof("Stop memory profiling after %v", duration)
 	})
 	return nil
 }
@@ -76,7 +76,7 @@ func StartCPUProfile(file string, duration time.Duration) (err error) {
 		return err
 	}
 
-	log.Println("Start cpu profiling for", duration)
+	logger.Infof("Start cpu profiling for %v", duration)
 	err = pprof.StartCPUProfile(f)
 	if err != nil {
 		f.Close()
@@ -86,7 +86,31 @@ func StartCPUProfile(file string, duration time.Duration) (err error) {
 	time.AfterFunc(duration, func() {
 		pprof.StopCPUProfile()
 		f.Close()
-		log.Println("Stop CPU profiling after", duration)
+		logger.Infof("Stop CPU profiling after %v", duration)
 	})
 	return nil
 }
+
+// newUsageSampler returns a function that samples the CPU usage of current process in percentage
+// since the last call, and the resident set size in bytes. It only reads /proc or the
+// equivalent of other platforms, so it's cheap enough to be called every second.
+func newUsageSampler() func() (cpuPercent float64, rss uint64) {
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		logger.Errorf("Failed to sample the usage of current process, %v", err)
+		return func() (float64, uint64) {
+			return 0, 0
+		}
+	}
+	return func() (cpuPercent float64, rss uint64) {
+		if cpuPercent, err = proc.Percent(0); err != nil {
+			logger.Debugf("Failed to sample the cpu usage, %v", err)
+		}
+		if mem, err := proc.MemoryInfo(); err == nil {
+			rss = mem.RSS
+		} else {
+			logger.Debugf("Failed to sample the memory usage, %v", err)
+		}
+		return cpuPercent, rss
+	}
+}
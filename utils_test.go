@@ -3,6 +3,7 @@ package boomer
 import (
 	"os"
 	"regexp"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -90,3 +91,22 @@ func TestStartCPUProfile(t *testing.T) {
 		os.Remove("cpu.pprof")
 	}
 }
+
+func TestUsageSampler(t *testing.T) {
+	sample := newUsageSampler()
+	sample()
+
+	// keep the cpu busy for a while
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for time.Now().Before(deadline) {
+	}
+
+	cpuPercent, rss := sample()
+	// the sampling interval is short, allow some jitter above the cpu count
+	if cpuPercent <= 0 || cpuPercent > float64(150*runtime.NumCPU()) {
+		t.Error("The cpu usage is not plausible,", cpuPercent)
+	}
+	if rss == 0 {
+		t.Error("The memory usage should not be zero")
+	}
+}